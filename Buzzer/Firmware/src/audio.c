@@ -16,6 +16,7 @@ These flags can be set by any thread to request an action and are cleared by the
 static volatile bool _audio_start;  // Signal to start playback.
 static volatile bool _audio_stop;  // Signal to stop playback.
 static volatile int _audio_count;  // Count through playback. 0 => not playing back.
+static volatile int _audio_half_period;  // Ticks per half cycle of our tone.
 
 
 // Interrupt tick handler.
@@ -44,7 +45,7 @@ void IRAM_ATTR audio_tick(void)
     if(_audio_count > 0)
     {
         _audio_count--;
-        gpio_set_level(PIN_BUZZER, _audio_count & 1);
+        gpio_set_level(PIN_BUZZER, (_audio_count / _audio_half_period) & 1);
     }
 }
 
@@ -56,6 +57,7 @@ void audio_init(void)
     _audio_start = false;
     _audio_stop = false;
     _audio_count = 0;
+    _audio_half_period = 1;
 }
 
 
@@ -73,3 +75,11 @@ void audio_stop(void)
     // Just set the flag.
     _audio_stop = true;
 }
+
+
+// Set the tone to play, 0 highest.
+void audio_set_tone(int tone)
+{
+    // Just set the period, the change will be picked up by the next tick.
+    _audio_half_period = tone + 1;
+}
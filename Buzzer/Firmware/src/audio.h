@@ -15,6 +15,9 @@ void audio_start(void);
 // Stop audio playback.
 void audio_stop(void);
 
+// Set the tone to play, 0 highest.
+void audio_set_tone(int tone);
+
 // Tick.
 // Should be called every millisecond.
 void IRAM_ATTR audio_tick(void);
@@ -3,6 +3,7 @@
 */
 
 #include "driver/gpio.h"
+#include "driver/adc.h"
 #include "global.h"
 #include "gpio.h"
 
@@ -10,6 +11,10 @@
 #define ID_SIZE 7
 static int _id_pins[ID_SIZE] = {25, 26, 27, 9, 10, 13, 5};
 
+// Battery voltages for empty and full, in mV.
+#define BATTERY_EMPTY_MV 3300
+#define BATTERY_FULL_MV 4200
+
 
 // Configure the specified pin as an input.
 static void configure_input_pin(int pin)
@@ -50,6 +55,10 @@ void gpio_init(void)
     {
         configure_input_pin(_id_pins[i]);
     }
+
+    // Battery voltage, halved by the divider, must fit in the ADC's full 3.3V range.
+    adc1_config_width(ADC_WIDTH_BIT_12);
+    adc1_config_channel_atten(ADC_BATTERY, ADC_ATTEN_DB_11);
 }
 
 
@@ -66,3 +75,18 @@ uint8_t read_module_id(void)
 
     return id;
 }
+
+
+// Read battery charge level, as a percentage.
+// The charge is estimated from the voltage, assuming it falls linearly, which is good enough to spot a dying battery.
+uint8_t read_battery_percent(void)
+{
+    int raw = adc1_get_raw(ADC_BATTERY);
+    if(raw < 0) return 0;
+
+    int mv = raw * 3300 * 2 / 4095;  // Undo the divider.
+    if(mv <= BATTERY_EMPTY_MV) return 0;
+    if(mv >= BATTERY_FULL_MV) return 100;
+
+    return (mv - BATTERY_EMPTY_MV) * 100 / (BATTERY_FULL_MV - BATTERY_EMPTY_MV);
+}
@@ -10,6 +10,7 @@
 #define PIN_LED_BUTTON 16
 #define PIN_BUZZER 12
 #define PIN_BUTTON 17
+#define ADC_BATTERY ADC1_CHANNEL_6  // GPIO34, battery through a 2:1 divider.
 
 // Configure all required pins as inputs/outputs.
 void gpio_init(void);
@@ -17,4 +18,7 @@ void gpio_init(void);
 // Read module ID from GPIOs.
 uint8_t read_module_id(void);
 
+// Read battery charge level, as a percentage.
+uint8_t read_battery_percent(void);
+
 #endif
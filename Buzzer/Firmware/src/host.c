@@ -5,35 +5,58 @@
 #include "lwip/sockets.h"
 #include "global.h"
 #include "host.h"
+#include "audio.h"
 #include "gpio.h"
 #include "state.h"
+#include "wifi.h"
 
 // Hardcode host IP address.
 #define HOST_IP "192.168.2.5"
 
 static volatile int _host_socket;
+static volatile int _udp_socket;  // Socket for sending presses over UDP, 0 until the host offers it.
+static uint8_t _press_seq;
+static volatile bool _frames_offered;  // Whether this connection's host takes framed messages.
 
 // Message values.
-#define MSG_VERSION     0x04
+#define MSG_VERSION     0x0B
 #define MSG_MODE_PREFIX 0x20
 #define MSG_MODE_MASK   0xFC
 #define MSG_MODE_LED    0x01
 #define MSG_MODE_AUDIO  0x02
+#define MSG_TONE_PREFIX 0x40
+#define MSG_TONE_MASK   0xF8
+#define MSG_TONE_VALUE  0x07
 #define MSG_PRESS       0x30
 #define MSG_HEARTBEAT   0x31
+#define MSG_SEQ_PRESS   0x32
+#define MSG_PONG        0x33
+#define MSG_LONG_PRESS  0x34
+#define MSG_RELEASE     0x35
+#define MSG_FRAME       0x36
+#define MSG_TIMED_PONG  0x37
+#define MSG_UDP_OFFER   0x50
+#define MSG_PING        0x51
+#define MSG_FRAME_OFFER 0x52
+#define MSG_TIMED_PING  0x53
 #define MSG_ERR_BAD_MSG 0x7F
 #define MSG_ID_PREFIX   0x80
 
+// Framed message types.
+#define FRAME_BATTERY   0x01
+#define FRAME_RSSI      0x02
 
-// Send the given message to our host.
+// Heartbeats between telemetry reports.
+#define TELEMETRY_HEARTBEATS 10
+
+
+// Send the given message bytes to our host, in one go so other tasks' messages can't come between them.
 // Returns true on success, false on failure.
-static bool host_send(uint8_t message_byte)
+static bool host_send_bytes(const char *msg, int len)
 {
     if(_host_socket == 0) return false;
 
-    // Send message byte.
-    char msg[] = {message_byte};
-    if(send(_host_socket, msg, 1, 0) < 0)
+    if(send(_host_socket, msg, len, 0) < 0)
     {
         // Error sending.
         _host_socket = 0;
@@ -44,13 +67,61 @@ static bool host_send(uint8_t message_byte)
 }
 
 
-// Task to send heartbeats to our host.
+// Send the given message to our host.
+// Returns true on success, false on failure.
+static bool host_send(uint8_t message_byte)
+{
+    char msg[] = {message_byte};
+    return host_send_bytes(msg, 1);
+}
+
+
+// Send a framed message of the given type, with a single byte value, to our host.
+// Returns true on success, false on failure.
+static bool host_send_frame(uint8_t type, uint8_t value)
+{
+    char msg[] = {MSG_FRAME, 2, type, value};
+    return host_send_bytes(msg, sizeof(msg));
+}
+
+
+// Open a UDP socket to send presses to the host on, once it's offered it.
+static void host_open_udp(void)
+{
+    if(_udp_socket != 0) return;  // Already open.
+
+    int sock = socket(AF_INET, SOCK_DGRAM, IPPROTO_IP);
+    if(sock < 0) return;  // Presses still go over TCP, so just carry on.
+
+    struct sockaddr_in host_addr;
+    host_addr.sin_addr.s_addr = inet_addr(HOST_IP);
+    host_addr.sin_family = AF_INET;
+    host_addr.sin_port = htons(9753);
+
+    if(connect(sock, (struct sockaddr *)&host_addr, sizeof(struct sockaddr_in)) != 0) {
+        close(sock);
+        return;
+    }
+
+    _udp_socket = sock;
+}
+
+
+// Task to send heartbeats to our host, with telemetry every so often, once the host has offered frames.
 static void heartbeat_task(void *param)
 {
+    int count = 0;
+
     while(1)
     {
         // We should only try to send if we have an open socket. host_send() handles that for us.
         host_send(MSG_HEARTBEAT);
+
+        if(_frames_offered && ((count++ % TELEMETRY_HEARTBEATS) == 0)) {
+            host_send_frame(FRAME_BATTERY, read_battery_percent());
+            host_send_frame(FRAME_RSSI, (uint8_t)wifi_rssi());
+        }
+
         vTaskDelay(1000 / portTICK_PERIOD_MS);  // Send roughly every 1 second.
     }
 }
@@ -61,6 +132,8 @@ static void heartbeat_task(void *param)
 void host_init(void)
 {
     _host_socket = 0;
+    _udp_socket = 0;
+    _frames_offered = false;
 
     // Start our heartbeat task.
     xTaskCreate(heartbeat_task, "Heartbeat", 2048, NULL, 1, NULL);
@@ -71,6 +144,15 @@ void host_init(void)
 // Returns true on success, false on failure.
 bool host_connect(void)
 {
+    // Only use UDP once this connection's host has offered it.
+    if(_udp_socket != 0) {
+        close(_udp_socket);
+        _udp_socket = 0;
+    }
+
+    // Likewise frames, older hosts only understand single byte messages.
+    _frames_offered = false;
+
     int sock = socket(AF_INET, SOCK_STREAM, IPPROTO_IP);
     if(sock < 0) {
         _host_socket = 0;
@@ -116,6 +198,25 @@ void host_process_messages(void)
             bool led = ((msg & MSG_MODE_LED) != 0);
             bool audio = ((msg & MSG_MODE_AUDIO) != 0);
             state_enable(led, audio);
+        } else if((msg & MSG_TONE_MASK) == MSG_TONE_PREFIX) {
+            // Tone message. Applies from the next time we sound.
+            audio_set_tone(msg & MSG_TONE_VALUE);
+        } else if(msg == MSG_UDP_OFFER) {
+            // Host can take presses over UDP too, which aren't held up by TCP retransmits.
+            host_open_udp();
+        } else if(msg == MSG_PING) {
+            // Host checking our connection still works.
+            host_send(MSG_PONG);
+        } else if(msg == MSG_FRAME_OFFER) {
+            // Host takes framed messages, so we can report telemetry.
+            _frames_offered = true;
+        } else if(msg == MSG_TIMED_PING) {
+            // Host measuring our round trip time, echo its nonce straight back.
+            uint8_t nonce;
+            if(recv(_host_socket, &nonce, 1, 0) != 1) continue;
+
+            char reply[] = {MSG_TIMED_PONG, nonce};
+            host_send_bytes(reply, sizeof(reply));
         } else {
             // Unrecognised message, error.
             host_send(MSG_ERR_BAD_MSG);
@@ -125,7 +226,33 @@ void host_process_messages(void)
 
 
 // Send a button press message to our host.
+// Once the host has offered UDP, the press is sent over both UDP and TCP with a sequence number, so the host can act
+// on whichever arrives first.
 void host_send_press(void)
 {
-    host_send(MSG_PRESS);
+    if(_udp_socket == 0) {
+        host_send(MSG_PRESS);
+        return;
+    }
+
+    _press_seq++;
+    char datagram[] = {MSG_ID_PREFIX | read_module_id(), MSG_SEQ_PRESS, _press_seq};
+    send(_udp_socket, datagram, sizeof(datagram), 0);  // If it's lost, the TCP copy will still get there.
+
+    char msg[] = {MSG_SEQ_PRESS, _press_seq};
+    host_send_bytes(msg, sizeof(msg));
+}
+
+
+// Send a long press message to our host, the button having been held down since the last press.
+void host_send_long_press(void)
+{
+    host_send(MSG_LONG_PRESS);
+}
+
+
+// Send a release message to our host, the button having been let go since the last press.
+void host_send_release(void)
+{
+    host_send(MSG_RELEASE);
 }
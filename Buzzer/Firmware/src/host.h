@@ -21,4 +21,12 @@ void host_process_messages(void);
 // May be called from interrupts.
 void host_send_press(void);
 
+// Send a long press message to our host.
+// May be called from interrupts.
+void host_send_long_press(void);
+
+// Send a release message to our host.
+// May be called from interrupts.
+void host_send_release(void);
+
 #endif
@@ -27,10 +27,15 @@ and main thread is via global bools, which can be written and read atomically.
 static volatile bool _connected;
 static volatile bool _led_on;
 static volatile bool _button_pressed = false;
+static int _held_polls;  // Polls the button has been held down for since its last press, only used by the poll task.
 static volatile bool _status_flashing;
 static volatile int _flash_phase;
 
 
+// Polls the button must be held down for to make a long press, 800ms.
+#define LONG_PRESS_POLLS 80
+
+
 // Check the state of the button and act accordingly.
 static void check_button(void)
 {
@@ -45,6 +50,21 @@ static void check_button(void)
         // audio_start();  // Temp test.
     }
 
+    if(!new_state && _button_pressed && _connected)
+    {
+        // The button is newly released, so the host can tell how long it was held.
+        host_send_release();
+    }
+
+    // Tell the host once the button has been held long enough to be a long press.
+    if(new_state)
+    {
+        _held_polls++;
+        if((_held_polls == LONG_PRESS_POLLS) && _connected) host_send_long_press();
+    } else {
+        _held_polls = 0;
+    }
+
     _button_pressed = new_state;
 
     // Set PCB LED to button state to aid debugging.
@@ -112,3 +112,13 @@ bool wifi_connect(void)
     // Success.
     return true;
 }
+
+
+// Report the signal strength of our access point, in dBm, 0 if we're not connected.
+int8_t wifi_rssi(void)
+{
+    wifi_ap_record_t ap_info;
+    if(esp_wifi_sta_get_ap_info(&ap_info) != ESP_OK) return 0;
+
+    return ap_info.rssi;
+}
@@ -13,4 +13,7 @@ void wifi_init(void);
 // Returns true on success, false on failure.
 bool wifi_connect(void);
 
+// Report the signal strength of our access point, in dBm, 0 if we're not connected.
+int8_t wifi_rssi(void);
+
 #endif
@@ -1,17 +1,56 @@
 package main
 
 import "bufio"
+import "flag"
 import "fmt"
+import "math/rand"
 import "net"
 import "os"
 import "strconv"
+import "strings"
+import "sync"
 import "time"
 
 
+// Firmware quirk profiles we can emulate.
+var profiles = map[string]string{
+    "normal":   "Current firmware",
+    "udploss":  "Current firmware, all UDP presses lost",
+    "halfdead": "Current firmware, connection silently dropped so nothing from the server arrives",
+    "laggy":    "Sustained network latency, round trips slow",
+    "v10":      "v10 firmware, no timed pings",
+    "v9":       "v9 firmware, no frames, so no telemetry",
+    "v8":       "v8 firmware, no releases",
+    "v7":       "v7 firmware, no long presses",
+    "v6":       "v6 firmware, no pings",
+    "v5":       "v5 firmware, no UDP presses",
+    "v4":       "v4 firmware, no tone support",
+    "v3":       "v3 firmware, sends ID before version in handshake",
+    "lowbatt":  "Low battery, heartbeats slow and erratic, battery reports falling",
+    "longhold": "Long button hold, duplicate press messages",
+}
+
+var profile string
+var server string  // Server address to connect to.
+var proxy string  // Address to listen for real buzzers on, in proxy mode, "" for not a proxy.
+
+// UDP connection for presses, once the server has offered it, protected by udpLock.
+var udpConn *net.UDPConn
+var udpLock sync.Mutex
+var pressSeq byte
+var buzzerId byte
+var version byte  // Firmware version we report.
+
+
 func main() {
     id, ok := handleArgs()
     if !ok { return }
 
+    if proxy != "" {
+        runProxy(proxy, server)
+        return
+    }
+
     conn := connect()
     if conn == nil { return }
 
@@ -28,12 +67,26 @@ func main() {
 
 
 func handleArgs() (id byte, ok bool) {
-    if len(os.Args) != 2 {
+    flag.StringVar(&profile, "profile", "normal", "Firmware quirk profile to emulate")
+    flag.StringVar(&server, "server", "localhost:9753", "Server address")
+    flag.StringVar(&proxy, "proxy", "", "Address to listen for real buzzers on, as a protocol sniffing proxy")
+    flag.Usage = func() { usage(os.Args[0]) }
+    flag.Parse()
+
+    if _, ok := profiles[profile]; !ok {
+        fmt.Printf("Unknown profile \"%s\"\n", profile)
+        usage(os.Args[0])
+        return 0, false
+    }
+
+    if (proxy != "") && (flag.NArg() == 0) { return 0, true }
+
+    if flag.NArg() != 1 {
         usage(os.Args[0])
         return 0, false
     }
 
-    id_str := os.Args[1]
+    id_str := flag.Arg(0)
     id_int, err := strconv.Atoi(id_str)
     if (err != nil) || (id_int < 0) || (id_int > 255) {
         fmt.Printf("Invalid ID \"%s\", should be a byte value\n", id_str)
@@ -47,21 +100,31 @@ func handleArgs() (id byte, ok bool) {
 
 func usage(progName string) {
     fmt.Printf("Usage:\n")
-    fmt.Printf("%s <button_id>\n", progName)
+    fmt.Printf("%s [-server <address>] [-profile <profile>] <button_id>\n", progName)
+    fmt.Printf("%s [-server <address>] -proxy <listen_address>\n", progName)
+    fmt.Printf("Press enter to press the button, or enter l for a long press or d for a double press.\n")
+    fmt.Printf("Enter h to press and hold the button, until enter is pressed again.\n")
+    fmt.Printf("As a proxy, passes real buzzers on to the server, logging every message both ways.\n")
+    fmt.Printf("Profiles:\n")
+
+    for _, name := range []string{"normal", "udploss", "halfdead", "laggy", "v10", "v9", "v8", "v7", "v6", "v5", "v4",
+        "v3", "lowbatt", "longhold"} {
+        fmt.Printf("  %-10s %s\n", name, profiles[name])
+    }
 }
 
 
 func connect() *net.TCPConn {
-    serverAddr, err := net.ResolveTCPAddr("tcp", "localhost:9753")
+    serverAddr, err := net.ResolveTCPAddr("tcp", server)
 
     if err != nil {
-        fmt.Printf("ResolveTCPAddr failed:", err.Error())
+        fmt.Printf("ResolveTCPAddr failed: %v\n", err)
         return nil
     }
 
     conn, err := net.DialTCP("tcp", nil, serverAddr)
     if err != nil {
-        fmt.Printf("Dial failed:", err.Error())
+        fmt.Printf("Dial failed: %v\n", err)
         return nil
     }
 
@@ -70,19 +133,33 @@ func connect() *net.TCPConn {
 
 
 func handshake(conn *net.TCPConn, id byte) bool {
-    // First we send the protocol version we're using.
-    _, err := conn.Write([]byte{4})
-    if err != nil {
-        fmt.Printf("Protocol version write failed: %v\n", err)
-        return false
+    versionMsg := []byte{11}
+    msg := []byte{0x80 | id}
+    messages := [][]byte{versionMsg, msg}
+    buzzerId = id
+
+    if profile == "v10" { versionMsg[0] = 10 }
+    if profile == "v9" { versionMsg[0] = 9 }
+    if profile == "v8" { versionMsg[0] = 8 }
+    if profile == "v7" { versionMsg[0] = 7 }
+    if profile == "v6" { versionMsg[0] = 6 }
+    if profile == "v5" { versionMsg[0] = 5 }
+    if profile == "v4" { versionMsg[0] = 4 }
+
+    if profile == "v3" {
+        // Old firmware sent its ID first.
+        versionMsg[0] = 3
+        messages = [][]byte{msg, versionMsg}
     }
 
-    // Next we send our ID.
-    msg := 0x80 | id
-    _, err = conn.Write([]byte{msg})
-    if err != nil {
-        fmt.Printf("Button ID write failed: %v\n", err)
-        return false
+    version = versionMsg[0]
+
+    for _, m := range messages {
+        _, err := conn.Write(m)
+        if err != nil {
+            fmt.Printf("Handshake write failed: %v\n", err)
+            return false
+        }
     }
 
     return true
@@ -100,8 +177,35 @@ func handleRecv(conn *net.TCPConn) {
         }
 
         b := buffer[0]
-        if (b < 0x20) || (b > 0x23) {
+        if profile == "halfdead" {
+            // Lost somewhere in the network, but our heartbeats still get through.
+            continue
+        }
+
+        if (b >= 0x40) && (b <= 0x47) && (profile != "v4") {
+            fmt.Printf("Tone %d\n", b & 7)
+        } else if (b == 0x50) && (profile != "v5") && (profile != "v4") {
+            fmt.Printf("UDP offered\n")
+            startUdp()
+        } else if (b == 0x51) && (profile != "v6") && (profile != "v5") && (profile != "v4") {
+            fmt.Printf("Ping\n")
+            conn.Write([]byte{0x33})
+        } else if (b == 0x52) && (version >= 10) {
+            fmt.Printf("Frames offered\n")
+            go handleTelemetry(conn)
+        } else if (b == 0x53) && (version >= 11) {
+            // Timed ping, the nonce follows.
+            _, err := conn.Read(buffer)
+            if err != nil {
+                fmt.Printf("Read failed: %v\n", err)
+                return
+            }
+
+            go timedPong(conn, buffer[0])
+        } else if (b < 0x20) || (b > 0x23) {
+            // Firmware reports unrecognised messages as errors.
             fmt.Printf("Received unexpected %02x\n", b)
+            conn.Write([]byte{0x7F})
         } else {
             led := (b & 1) != 0
             buzzer := (b & 2) != 0
@@ -113,7 +217,14 @@ func handleRecv(conn *net.TCPConn) {
 
 func handleHeartbeat(conn *net.TCPConn) {
     for {
-        time.Sleep(time.Second)
+        delay := time.Second
+
+        if profile == "lowbatt" {
+            // Struggling radio, heartbeats anywhere from 1 to 4 seconds apart.
+            delay += time.Duration(rand.Intn(3000)) * time.Millisecond
+        }
+
+        time.Sleep(delay)
 
         // Send heartbeat message.
         _, err := conn.Write([]byte{0x31})
@@ -124,17 +235,150 @@ func handleHeartbeat(conn *net.TCPConn) {
 }
 
 
+// Answer a timed ping, echoing its nonce.
+func timedPong(conn *net.TCPConn, nonce byte) {
+    if profile == "laggy" {
+        // Congested network, everything takes a while.
+        time.Sleep(time.Duration(120 + rand.Intn(80)) * time.Millisecond)
+    }
+
+    _, err := conn.Write([]byte{0x37, nonce})
+    if err != nil { fmt.Printf("Timed pong write failed: %v\n", err) }
+}
+
+
+// Report battery level and signal strength every so often, as framed messages.
+func handleTelemetry(conn *net.TCPConn) {
+    battery := 85
+    if profile == "lowbatt" { battery = 22 }
+
+    for {
+        rssi := -55 - rand.Intn(10)
+        if profile == "lowbatt" { rssi -= 20 }
+
+        _, err := conn.Write([]byte{0x36, 2, 0x01, byte(battery), 0x36, 2, 0x02, byte(int8(rssi))})
+        if err != nil {
+            fmt.Printf("Telemetry write failed: %v\n", err)
+            return
+        }
+
+        // A dying battery drains fast.
+        if (profile == "lowbatt") && (battery > 0) { battery-- }
+
+        time.Sleep(2 * time.Second)
+    }
+}
+
+
 func handleSend(conn *net.TCPConn) {
     stdin := bufio.NewReader(os.Stdin)
+    holding := false
 
     for {
-        stdin.ReadString('\n')
+        line, _ := stdin.ReadString('\n')
+        gesture := strings.TrimSpace(line)
+
+        if holding {
+            // Let go of the held button.
+            holding = false
+            if !sendRelease(conn) { return }
+            continue
+        }
 
         // Send button press message.
-        _, err := conn.Write([]byte{0x30})
-        if err != nil {
-            fmt.Printf("Button press write failed: %v\n", err)
-            return
+        if !sendPress(conn) { return }
+
+        if gesture == "h" {
+            // Keep holding until the next line.
+            holding = true
+            continue
+        }
+
+        if gesture == "d" {
+            // Second press of a double press.
+            time.Sleep(100 * time.Millisecond)
+            if !sendRelease(conn) { return }
+            time.Sleep(50 * time.Millisecond)
+            if !sendPress(conn) { return }
         }
+
+        if (gesture == "l") && (version >= 8) {
+            // Button held down, reported once it's been held long enough.
+            time.Sleep(800 * time.Millisecond)
+            _, err := conn.Write([]byte{0x34})
+            if err != nil {
+                fmt.Printf("Long press write failed: %v\n", err)
+                return
+            }
+        }
+
+        if profile == "longhold" {
+            // Contact bounce on a long hold, press reported again.
+            time.Sleep(300 * time.Millisecond)
+            if !sendPress(conn) { return }
+        }
+
+        // Let go of the button.
+        time.Sleep(100 * time.Millisecond)
+        if !sendRelease(conn) { return }
     }
 }
+
+
+// Send a button release, if our firmware version has them.
+func sendRelease(conn *net.TCPConn) bool {
+    if version < 9 { return true }
+
+    _, err := conn.Write([]byte{0x35})
+    if err != nil {
+        fmt.Printf("Release write failed: %v\n", err)
+        return false
+    }
+
+    return true
+}
+
+
+// Send a button press, over both UDP and TCP if the server has offered UDP.
+func sendPress(conn *net.TCPConn) bool {
+    udpLock.Lock()
+    defer udpLock.Unlock()
+
+    msg := []byte{0x30}
+
+    if udpConn != nil {
+        pressSeq++
+        msg = []byte{0x32, pressSeq}
+
+        if profile != "udploss" {
+            _, err := udpConn.Write([]byte{0x80 | buzzerId, 0x32, pressSeq})
+            if err != nil { fmt.Printf("UDP press write failed: %v\n", err) }
+        }
+    }
+
+    _, err := conn.Write(msg)
+    if err != nil {
+        fmt.Printf("Button press write failed: %v\n", err)
+        return false
+    }
+
+    return true
+}
+
+
+// Start sending presses over UDP too.
+func startUdp() {
+    udpLock.Lock()
+    defer udpLock.Unlock()
+
+    if udpConn != nil { return }
+
+    serverAddr, err := net.ResolveUDPAddr("udp", server)
+    if err != nil {
+        fmt.Printf("ResolveUDPAddr failed: %v\n", err)
+        return
+    }
+
+    udpConn, err = net.DialUDP("udp", nil, serverAddr)
+    if err != nil { fmt.Printf("UDP dial failed: %v\n", err) }
+}
@@ -0,0 +1,235 @@
+package main
+
+import "fmt"
+import "net"
+import "sync"
+import "time"
+
+
+// Protocol sniffing proxy. Real buzzers connect to us instead of the server and we pass everything on both ways,
+// logging each message with when it arrived and what it means. Buzzers connect to port 9753 at a fixed address, so the
+// proxy must run there, with the server elsewhere. Presses sent over UDP are passed on too, so as far as the server is
+// concerned the buzzers are all at our address.
+
+// Serialises log lines from all connections.
+var sniffLock sync.Mutex
+
+
+// Accept buzzers on the given address, passing each on to the server at the given address, until we fail.
+func runProxy(listenAddr string, serverAddr string) {
+    listener, err := net.Listen("tcp", listenAddr)
+    if err != nil {
+        fmt.Printf("Listen failed: %v\n", err)
+        return
+    }
+
+    go proxyUdp(listenAddr, serverAddr)
+    fmt.Printf("Proxying buzzers on %s to server %s\n", listenAddr, serverAddr)
+
+    for n := 1; ; n++ {
+        conn, err := listener.Accept()
+        if err != nil {
+            fmt.Printf("Accept failed: %v\n", err)
+            return
+        }
+
+        go proxyConn(conn, serverAddr, fmt.Sprintf("#%d", n))
+    }
+}
+
+
+// Pass the given buzzer connection on to the server, logging everything both ways until either end closes.
+func proxyConn(buzzerConn net.Conn, serverAddr string, label string) {
+    sniffLog(label, time.Now(), "Buzzer connected from %v", buzzerConn.RemoteAddr())
+
+    serverConn, err := net.Dial("tcp", serverAddr)
+    if err != nil {
+        sniffLog(label, time.Now(), "Server dial failed: %v", err)
+        buzzerConn.Close()
+        return
+    }
+
+    done := make(chan bool, 2)
+    go relay(buzzerConn, serverConn, &sniffer{label: label + " buzzer>", fromBuzzer: true}, done)
+    go relay(serverConn, buzzerConn, &sniffer{label: label + " <server"}, done)
+
+    // Once either end has gone, close both, so the other relay finishes too.
+    <-done
+    buzzerConn.Close()
+    serverConn.Close()
+    <-done
+}
+
+
+// Copy everything from one connection to the other, decoding it as it goes, until either fails.
+func relay(from net.Conn, to net.Conn, sniffer *sniffer, done chan bool) {
+    buffer := make([]byte, 256)
+
+    for {
+        n, err := from.Read(buffer)
+        received := time.Now()
+
+        // Bytes read together arrived together, so share a timestamp.
+        for _, b := range buffer[:n] { sniffer.add(b, received) }
+
+        if n > 0 {
+            _, werr := to.Write(buffer[:n])
+            if werr != nil { err = werr }
+        }
+
+        if err != nil {
+            sniffLog(sniffer.label, time.Now(), "Connection ended: %v", err)
+            done <- true
+            return
+        }
+    }
+}
+
+
+// Pass presses sent over UDP on to the server, logging each datagram.
+func proxyUdp(listenAddr string, serverAddr string) {
+    localAddr, err := net.ResolveUDPAddr("udp", listenAddr)
+    if err != nil {
+        fmt.Printf("ResolveUDPAddr failed: %v\n", err)
+        return
+    }
+
+    conn, err := net.ListenUDP("udp", localAddr)
+    if err != nil {
+        fmt.Printf("UDP listen failed: %v\n", err)
+        return
+    }
+
+    remoteAddr, err := net.ResolveUDPAddr("udp", serverAddr)
+    if err != nil {
+        fmt.Printf("ResolveUDPAddr failed: %v\n", err)
+        return
+    }
+
+    out, err := net.DialUDP("udp", nil, remoteAddr)
+    if err != nil {
+        fmt.Printf("UDP dial failed: %v\n", err)
+        return
+    }
+
+    buffer := make([]byte, 64)
+    for {
+        n, from, err := conn.ReadFromUDP(buffer)
+        received := time.Now()
+        if err != nil {
+            fmt.Printf("UDP read failed: %v\n", err)
+            return
+        }
+
+        desc := "Unknown datagram"
+        if (n == 3) && ((buffer[0] & 0x80) != 0) && (buffer[1] == 0x32) {
+            desc = fmt.Sprintf("Sequenced button press, ID %d, seq %d", buffer[0] & 0x7F, buffer[2])
+        }
+
+        sniffLog("udp " + from.String() + " buzzer>", received, "% x  %s", buffer[:n], desc)
+
+        _, err = out.Write(buffer[:n])
+        if err != nil { fmt.Printf("UDP write failed: %v\n", err) }
+    }
+}
+
+
+// Print a log line for the given connection and time.
+func sniffLog(label string, t time.Time, format string, args ...interface{}) {
+    sniffLock.Lock()
+    defer sniffLock.Unlock()
+
+    fmt.Printf("%s %-16s %s\n", t.Format("15:04:05.000000"), label, fmt.Sprintf(format, args...))
+}
+
+
+// Decoder for one direction of a proxied connection, logging each message once all its bytes have arrived.
+type sniffer struct {
+    label string
+    fromBuzzer bool  // Decode messages from a buzzer, otherwise from the server.
+    pending []byte  // Bytes of the message being received.
+    start time.Time  // When its first byte arrived.
+}
+
+
+// Add the given byte, received at the given time, logging the message if it's complete.
+func (this *sniffer) add(b byte, received time.Time) {
+    if len(this.pending) == 0 { this.start = received }
+    this.pending = append(this.pending, b)
+    if len(this.pending) < this.length() { return }
+
+    sniffLog(this.label, this.start, "% x  %s", this.pending, this.describe())
+    this.pending = this.pending[:0]
+}
+
+
+// Report the length of the message being received, as far as we can tell from what's arrived so far.
+func (this *sniffer) length() int {
+    first := this.pending[0]
+
+    if !this.fromBuzzer {
+        if first == 0x53 { return 2 }
+        return 1
+    }
+
+    switch first {
+    case 0x32, 0x37:
+        return 2
+
+    case 0x36:
+        // Frame, its length follows.
+        if len(this.pending) < 2 { return 2 }
+        return 2 + int(this.pending[1])
+    }
+
+    return 1
+}
+
+
+// Describe the complete message received.
+func (this *sniffer) describe() string {
+    b := this.pending[0]
+
+    if !this.fromBuzzer {
+        switch {
+        case (b >= 0x20) && (b <= 0x23): return fmt.Sprintf("Mode, LED %v, sounder %v", (b & 1) != 0, (b & 2) != 0)
+        case (b >= 0x40) && (b <= 0x47): return fmt.Sprintf("Tone %d", b & 7)
+        case b == 0x50:                  return "UDP offer"
+        case b == 0x51:                  return "Ping"
+        case b == 0x52:                  return "Frame offer"
+        case b == 0x53:                  return fmt.Sprintf("Timed ping, nonce %d", this.pending[1])
+        }
+
+        return "Unknown"
+    }
+
+    switch {
+    case b <= 0x1F:  return fmt.Sprintf("Version %d", b)
+    case b == 0x30:  return "Button press"
+    case b == 0x31:  return "Heartbeat"
+    case b == 0x32:  return fmt.Sprintf("Sequenced button press, seq %d", this.pending[1])
+    case b == 0x33:  return "Pong"
+    case b == 0x34:  return "Long press"
+    case b == 0x35:  return "Release"
+    case b == 0x36:  return this.describeFrame()
+    case b == 0x37:  return fmt.Sprintf("Timed pong, nonce %d", this.pending[1])
+    case b == 0x7F:  return "Error"
+    case b >= 0x80:  return fmt.Sprintf("Hello, ID %d, team %d", b & 0x7F, (b & 0x7F) >> 4)
+    }
+
+    return "Unknown"
+}
+
+
+// Describe the complete frame received.
+func (this *sniffer) describeFrame() string {
+    payload := this.pending[2:]
+    if len(payload) == 0 { return "Frame, empty" }
+
+    switch {
+    case (payload[0] == 0x01) && (len(payload) >= 2): return fmt.Sprintf("Frame, battery %d%%", payload[1])
+    case (payload[0] == 0x02) && (len(payload) >= 2): return fmt.Sprintf("Frame, RSSI %ddBm", int8(payload[1]))
+    }
+
+    return fmt.Sprintf("Frame, unknown type 0x%02X", payload[0])
+}
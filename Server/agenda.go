@@ -0,0 +1,243 @@
+/* Functions to keep the quiz to a scheduled agenda.
+
+The agenda is read from a text file, with one item per line, giving the target start time, the type of the item and,
+for rounds, a name, or for intervals, a length in minutes. Lines starting with # are comments. For example:
+
+    # Quiz night.
+    19:30 round General knowledge
+    20:15 interval 15
+    20:30 round Music
+    22:45 end
+
+The end item is the time the quiz must finish by, eg because the venue closes.
+
+Progress through the agenda is followed from the round events published by the engine. As each round starts we report
+how far ahead or behind schedule we are. We also check the agenda periodically, reminding the user when the next item
+is due soon and when we're running behind it.
+
+Agenda times are wall clock times, since that's what the venue cares about. Times earlier than the item before are
+assumed to be after midnight.
+
+All agenda functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "strconv"
+import "strings"
+import "time"
+
+
+// Create an agenda, read from the given file.
+func CreateAgenda(engine *Engine, filename string) (*Agenda, error) {
+    var p Agenda
+    p.engine = engine
+
+    err := p.load(filename)
+    if err != nil { return nil, err }
+
+    fmt.Printf("Read agenda of %d items from %s\n", len(p.items), filename)
+
+    engine.RegisterCmd(p.commandPrint, "Print agenda", 'a')
+    engine.Subscribe(p.event)
+    engine.After(AgendaCheckTime, p.check)
+
+    return &p, nil
+}
+
+
+// Print out the agenda, with our progress through it.
+func (this *Agenda) Print() {
+    for i, item := range this.items {
+        status := ""
+        if i < this.next { status = " (done)" }
+        if i == this.next { status = " (next)" }
+
+        fmt.Printf("  %s  %s%s\n", item.start.Format("15:04"), item.String(), status)
+    }
+}
+
+
+// Quiz agenda.
+type Agenda struct {
+    items []agendaItem
+    next int  // Index of next item not yet started.
+    lastBehindReport time.Time
+    engine *Engine
+}
+
+
+// Internals.
+
+// Types of agenda item.
+const (
+    AgendaRound = iota
+    AgendaInterval
+    AgendaEnd
+)
+
+// A single item in the agenda.
+type agendaItem struct {
+    start time.Time
+    itemType int
+    name string  // Rounds only.
+    length time.Duration  // Intervals only.
+    reminded bool  // Whether we've reminded the user this item is due.
+}
+
+// How often to check our progress against the agenda.
+const AgendaCheckTime = 30 * time.Second
+
+// How long before an item is due to remind the user.
+const AgendaReminderTime = 5 * time.Minute
+
+// How far behind we can be before reporting it, and how often to report it.
+const AgendaBehindTime = 5 * time.Minute
+
+
+// Describe the given agenda item.
+func (this *agendaItem) String() string {
+    switch this.itemType {
+    case AgendaRound:
+        return "Round " + this.name
+
+    case AgendaInterval:
+        return fmt.Sprintf("Interval, %d min", this.length / time.Minute)
+    }
+
+    return "End of quiz"
+}
+
+
+// Read the agenda from the given file.
+func (this *Agenda) load(filename string) error {
+    file, err := os.Open(filename)
+    if err != nil { return err }
+    defer file.Close()
+
+    now := time.Now()
+    last := time.Time{}
+    scanner := bufio.NewScanner(file)
+    lineNo := 0
+
+    for scanner.Scan() {
+        lineNo++
+        line := strings.TrimSpace(scanner.Text())
+        if (line == "") || (line[0] == '#') { continue }
+
+        fields := strings.SplitN(line, " ", 3)
+        if len(fields) < 2 {
+            return fmt.Errorf("%s:%d: expected time and item type", filename, lineNo)
+        }
+
+        // Times are for today, or tomorrow if we've passed midnight.
+        t, err := time.Parse("15:04", fields[0])
+        if err != nil { return fmt.Errorf("%s:%d: bad time %q", filename, lineNo, fields[0]) }
+
+        var item agendaItem
+        item.start = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+        if item.start.Before(last) { item.start = item.start.Add(24 * time.Hour) }
+        last = item.start
+
+        arg := ""
+        if len(fields) > 2 { arg = strings.TrimSpace(fields[2]) }
+
+        switch fields[1] {
+        case "round":
+            item.itemType = AgendaRound
+            item.name = arg
+
+        case "interval":
+            minutes, err := strconv.Atoi(arg)
+            if err != nil { return fmt.Errorf("%s:%d: bad interval length %q", filename, lineNo, arg) }
+            item.itemType = AgendaInterval
+            item.length = time.Duration(minutes) * time.Minute
+
+        case "end":
+            item.itemType = AgendaEnd
+
+        default:
+            return fmt.Errorf("%s:%d: unknown item type %q", filename, lineNo, fields[1])
+        }
+
+        this.items = append(this.items, item)
+    }
+
+    return scanner.Err()
+}
+
+
+// Event handler, following our progress through the agenda.
+func (this *Agenda) event(event *Event) {
+    switch event.Type {
+    case EventRoundStarted:
+        // Move on to the next round in the agenda, skipping any intervals we didn't have.
+        for (this.next < len(this.items)) && (this.items[this.next].itemType == AgendaInterval) { this.next++ }
+
+        if (this.next >= len(this.items)) || (this.items[this.next].itemType != AgendaRound) {
+            fmt.Printf("Agenda: No more rounds scheduled\n")
+            return
+        }
+
+        this.next++
+        this.reportSchedule(time.Since(this.items[this.next - 1].start))
+
+    case EventRoundEnded:
+        if (this.next >= len(this.items)) || (this.items[this.next].itemType != AgendaInterval) { return }
+
+        // An interval is due. We can't tell when it's actually taken, so we assume it's now.
+        interval := &this.items[this.next]
+        this.next++
+        fmt.Printf("Agenda: Interval now, for %d min\n", interval.length / time.Minute)
+        this.reportSchedule(time.Since(interval.start))
+    }
+}
+
+
+// Report how far ahead or behind schedule we are, given how late the current item started.
+func (this *Agenda) reportSchedule(late time.Duration) {
+    minutes := int(late.Round(time.Minute) / time.Minute)
+
+    if minutes > 0 {
+        fmt.Printf("Agenda: Running %d min behind\n", minutes)
+    } else if minutes < 0 {
+        fmt.Printf("Agenda: Running %d min ahead\n", -minutes)
+    } else {
+        fmt.Printf("Agenda: On schedule\n")
+    }
+}
+
+
+// Check our progress, reminding the user as items become due.
+// Reschedules itself, so runs for as long as the program.
+func (this *Agenda) check() {
+    defer this.engine.After(AgendaCheckTime, this.check)
+
+    if this.next >= len(this.items) { return }
+
+    item := &this.items[this.next]
+    now := time.Now()
+    dueIn := item.start.Sub(now)
+
+    if !item.reminded && (dueIn > 0) && (dueIn <= AgendaReminderTime) {
+        item.reminded = true
+        fmt.Printf("Agenda: %s due in %d min\n", item.String(), (dueIn + time.Minute - 1) / time.Minute)
+        return
+    }
+
+    if (-dueIn >= AgendaBehindTime) && (now.Sub(this.lastBehindReport) >= AgendaBehindTime) {
+        this.lastBehindReport = now
+        fmt.Printf("Agenda: Running %d min behind, %s was due at %s\n", -dueIn / time.Minute, item.String(),
+            item.start.Format("15:04"))
+    }
+}
+
+
+// Command handler for printing the agenda.
+func (this *Agenda) commandPrint([]int) {
+    this.Print()
+}
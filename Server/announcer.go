@@ -0,0 +1,160 @@
+/* Functions to announce notable moments in the scores.
+
+The announcer watches the scoreboard and gives the quizmaster ready-made colour commentary when something notable
+happens:
+* A team is the first to reach a milestone score, eg 20. Each milestone is only announced once.
+* A team takes the lead, whether from another team or from a tie.
+* A team draws level with the leader.
+
+Each announcement is printed on the console and published as an event, so displays can show it. Optionally,
+announcements are also spoken on the host by running an external text to speech program, such as espeak, with the
+text as its only argument.
+
+Teams are announced by the names the audience display uses, from the theme.
+
+Announcements mustn't give away hidden scores, see scoreboard.go. While only places are shown, milestones aren't
+announced, and while the scores are hidden completely, nothing is. Milestones and the leader are still tracked, so
+nothing already passed is announced once the scores are revealed.
+
+All announcer functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "os/exec"
+import "strings"
+
+
+// Create an announcer, announcing the first team to reach each of the given milestone scores, in whole marks.
+// Team names are taken from the given theme.
+func CreateAnnouncer(engine *Engine, scoreboard *Scoreboard, theme *Theme, milestones []int) *Announcer {
+    var p Announcer
+    p.engine = engine
+    p.scoreboard = scoreboard
+    p.theme = theme
+    p.reached = make(map[Marks]bool)
+
+    for _, milestone := range milestones {
+        p.milestones = append(p.milestones, WholeMarks(milestone))
+    }
+
+    p.catchUp()
+
+    scoreboard.AddObserver(p.scoreChanged)
+    engine.Subscribe(p.event)
+    return &p
+}
+
+
+// Set the program to speak announcements with, blank for none.
+func (this *Announcer) SetSpeaker(program string) {
+    this.speaker = program
+}
+
+
+// Announce the given text, about the specified team.
+func (this *Announcer) Announce(team int, text string) {
+    fmt.Printf("*** %s\n", text)
+    this.engine.Publish(Event{Type: EventAnnouncement, Team: team, Note: text})
+
+    if this.speaker == "" { return }
+
+    cmd := exec.Command(this.speaker, text)
+    err := cmd.Start()
+    if err != nil {
+        fmt.Printf("Could not speak announcement: %v\n", err)
+        return
+    }
+
+    // Reap the speaker when it's done, without holding anyone up.
+    go cmd.Wait()
+}
+
+
+// Score announcer.
+type Announcer struct {
+    milestones []Marks  // Scores to announce the first team reaching.
+    reached map[Marks]bool  // Milestones already reached.
+    leader int  // Team in the lead on its own, <0 for none.
+    speaker string  // Text to speech program, blank for none.
+    theme *Theme
+    scoreboard *Scoreboard
+    engine *Engine
+}
+
+
+// Internals.
+
+// Scoreboard observer.
+func (this *Announcer) scoreChanged(change *ScoreChange) {
+    name := this.theme.Teams[change.Team].Name
+    visibility := this.scoreboard.Visibility()
+
+    for _, milestone := range this.milestones {
+        if !this.reached[milestone] && (change.Old < milestone) && (change.New >= milestone) {
+            this.reached[milestone] = true
+            if visibility == ScoresShown {
+                this.Announce(change.Team, fmt.Sprintf("%s are the first to reach %v", name, milestone))
+            }
+        }
+    }
+
+    oldLeader := this.leader
+    this.leader = this.soleLeader()
+    if visibility == ScoresHidden { return }
+
+    if (this.leader >= 0) && (this.leader != oldLeader) {
+        if oldLeader >= 0 {
+            this.Announce(this.leader, fmt.Sprintf("%s take the lead from %s", this.theme.Teams[this.leader].Name,
+                this.theme.Teams[oldLeader].Name))
+        } else {
+            this.Announce(this.leader, fmt.Sprintf("%s take the lead", this.theme.Teams[this.leader].Name))
+        }
+
+        return
+    }
+
+    // A team catching up to the leader makes it a tie for first place.
+    places, tied := this.scoreboard.Places()
+    if (oldLeader >= 0) && (this.leader < 0) && (change.New > change.Old) && (places[change.Team] == 1) &&
+        tied[change.Team] {
+        var others []string
+        for team, place := range places {
+            if (team != change.Team) && (place == 1) { others = append(others, this.theme.Teams[team].Name) }
+        }
+
+        this.Announce(change.Team, fmt.Sprintf("%s draw level with %s", name, strings.Join(others, " and ")))
+    }
+}
+
+
+// Catch up with the current scores, without announcing what's already happened, eg when they've been restored.
+func (this *Announcer) catchUp() {
+    this.leader = this.soleLeader()
+
+    for _, milestone := range this.milestones {
+        for _, score := range this.scoreboard.Scores() {
+            if score >= milestone { this.reached[milestone] = true }
+        }
+    }
+}
+
+
+// Event handler.
+func (this *Announcer) event(event *Event) {
+    if event.Type == EventRestored { this.catchUp() }
+}
+
+
+// Report the team in the lead on its own, <0 if none, eg if the lead is tied.
+func (this *Announcer) soleLeader() int {
+    places, tied := this.scoreboard.Places()
+
+    for team, place := range places {
+        if (place == 1) && !tied[team] { return team }
+    }
+
+    return -1
+}
@@ -0,0 +1,100 @@
+/* Functions to let other programs drive and follow the quiz.
+
+Frontends such as a mobile scorer app or a custom display need typed access to the quiz, rather than scraping the
+console. The web server offers, see web.go:
+  /api/command
+            POST a command line, as typed on the console, eg cmd=f2, to run it. A command the engine refuses, eg an
+            unknown command or bad arguments, gets a 400 Bad Request, with the reason on the console as usual.
+  /api/events
+            Quiz events, as Server-Sent Events, each an ApiEvent as JSON, from when the client connects.
+  /state    Game state, a GameState as JSON, for the state to start from.
+Both /api pages need the admin password, since they can change the quiz and events give hidden scores away.
+
+A client that falls more than ApiEventBuffer events behind is disconnected, rather than holding up the quiz, and should
+fetch the state again and reconnect.
+
+All API functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "encoding/json"
+import "fmt"
+import "sync"
+
+
+// Create the API event feed, following the given engine's events.
+func CreateApiFeed(engine *Engine) *ApiFeed {
+    var p ApiFeed
+    p.followers = make(map[chan []byte]bool)
+
+    engine.Subscribe(p.event)
+    return &p
+}
+
+
+// Start following events, each sent as JSON on the returned channel, which is closed if the follower falls behind.
+// May be called from any thread.
+func (this *ApiFeed) Follow() chan []byte {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    follower := make(chan []byte, ApiEventBuffer)
+    this.followers[follower] = true
+    return follower
+}
+
+
+// Stop following events.
+// May be called from any thread.
+func (this *ApiFeed) Unfollow(follower chan []byte) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    delete(this.followers, follower)
+}
+
+
+// API event feed.
+type ApiFeed struct {
+    lock sync.Mutex  // Protects followers.
+    followers map[chan []byte]bool  // Channels to send each event to.
+}
+
+
+// Event, as sent to API clients. Times are in nanoseconds since the engine started, see events.go.
+type ApiEvent struct {
+    Type string  // Event type name, as used for searching, eg "buzz".
+    Desc string  // Description, as shown on the console.
+    Event
+}
+
+
+// Internals.
+
+// Most events a follower may fall behind by before being disconnected.
+const ApiEventBuffer = 100
+
+
+// Event handler, sending each event to every follower.
+func (this *ApiFeed) event(event *Event) {
+    data, err := json.Marshal(ApiEvent{Type: _eventTypeNames[event.Type], Desc: event.String(), Event: *event})
+    if err != nil {
+        fmt.Printf("Error encoding API event: %v\n", err)
+        return
+    }
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    for follower := range this.followers {
+        select {
+        case follower <- data:
+        default:
+            // Follower isn't keeping up, drop it rather than hold up the quiz.
+            delete(this.followers, follower)
+            close(follower)
+        }
+    }
+}
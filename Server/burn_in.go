@@ -0,0 +1,267 @@
+/* Functions to handle buzzer burn-in.
+
+Refurbished and newly built buzzers should be run for a good while before they go into the fleet, to shake out any
+that drop off the network or have flaky buttons. A burn-in controller lives for arbitrarily many burn-in runs.
+
+Operation is as follows:
+1. When burn-in starts, every buzzer connected, including guest buzzers, is enrolled. Buzzers that connect later are enrolled when they do.
+2. Throughout, all buzzers cycle their LEDs, sounding every few cycles, to exercise them.
+3. Each enrolled buzzer must be pressed at least once every press interval. At the end of each interval we report any
+   that weren't, as missed presses.
+4. We watch for enrolled buzzers disconnecting, reporting each as a dropout, and for them coming back.
+5. When the time is up, or the user stops the run, we report each buzzer's presses, missed presses and dropouts, and
+   whether it passed. A buzzer passes if it was pressed, had no dropouts and no missed presses, and is still connected.
+
+All burn-in functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "sort"
+import "time"
+
+
+// Create a burn-in controller.
+func CreateBurnIn(engine *Engine) *BurnIn {
+    var p BurnIn
+    p.engine = engine
+
+    p.states = CreateStateMachine(engine, "burn-in")
+    p.states.AllowTransitions(StateIdle, StateOpen)
+    p.states.AllowTransitions(StateOpen, StateIdle)
+    p.states.RegisterCmd([]string{StateOpen}, p.commandStop, "Stop burn-in and report results", 'q')
+    p.states.RegisterGuestButtons([]string{StateOpen}, p.button)
+
+    engine.RegisterModal(p.commandStart, "burn-in", "Start buzzer burn-in, for given minutes, default 30", 'b',
+        ARG_NUMBER | ARG_OPTIONAL)
+
+    return &p
+}
+
+
+// Start a burn-in run of the given length.
+func (this *BurnIn) Start(length time.Duration) {
+    this.run++
+    this.step = 0
+    this.interval = 0
+    this.units = make(map[int]*burnInUnit)
+    this.endTime = this.engine.Now() + length
+
+    this.states.Change(StateOpen)
+    this.engine.SetModalCancel(this.Stop)
+    this.engine.SetModalState(this.reportState)
+    this.checkConnections()
+
+    fmt.Printf("Burn-in started for %s, %d buzzers enrolled. Press each buzzer at least once every %s\n",
+        durationString(length), len(this.units), durationString(BurnInPressInterval))
+
+    // All timers check they're for the current run, in case it's been stopped since.
+    run := this.run
+    this.engine.After(length, func() {
+        if run == this.run { this.finish(OutcomeCompleted) }
+    })
+
+    this.scheduleCycle(run)
+    this.scheduleInterval(run)
+}
+
+
+// Stop the current burn-in run early, reporting the results so far.
+func (this *BurnIn) Stop() {
+    fmt.Printf("Burn-in stopped early\n")
+    this.finish(OutcomeCancelled)
+}
+
+
+// Burn-in controller.
+type BurnIn struct {
+    run int  // Count of runs, to identify stale timers.
+    step int  // LED cycles so far this run.
+    interval int  // Press intervals completed so far this run.
+    endTime time.Duration  // Engine time the current run ends.
+    units map[int]*burnInUnit  // Enrolled buzzers, indexed by buzzer ID.
+    states *StateMachine
+    engine *Engine
+}
+
+
+// Internals.
+
+const (
+    BurnInDefaultTime = 30 * time.Minute
+    BurnInPressInterval = time.Minute  // Each buzzer must be pressed at least this often.
+    BurnInCycleTime = time.Second  // Time each LED cycle step lasts.
+    BurnInSoundSteps = 10  // Sound every this many cycle steps.
+)
+
+// Burn-in record of a single buzzer.
+type burnInUnit struct {
+    connected bool
+    presses int
+    pressedThisInterval bool
+    missedPresses int
+    dropouts int
+}
+
+
+// Schedule the next LED cycle step.
+func (this *BurnIn) scheduleCycle(run int) {
+    this.engine.After(BurnInCycleTime, func() {
+        if run != this.run { return }
+
+        this.step++
+        this.engine.SetModeAll((this.step % 2) == 0, (this.step % BurnInSoundSteps) == 0)
+        this.checkConnections()
+        this.scheduleCycle(run)
+    })
+}
+
+
+// Schedule the end of the next press interval.
+func (this *BurnIn) scheduleInterval(run int) {
+    this.engine.After(BurnInPressInterval, func() {
+        if run != this.run { return }
+
+        this.endInterval()
+        this.scheduleInterval(run)
+    })
+}
+
+
+// Check for press interval misses and start the next interval.
+func (this *BurnIn) endInterval() {
+    this.interval++
+    missed := ""
+
+    for _, id := range this.sortedIds() {
+        unit := this.units[id]
+        if !unit.pressedThisInterval {
+            unit.missedPresses++
+            missed += " " + BuzzerIdToNamedString(id)
+        }
+
+        unit.pressedThisInterval = false
+    }
+
+    if missed == "" {
+        fmt.Printf("Burn-in interval %d: all %d buzzers pressed\n", this.interval, len(this.units))
+    } else {
+        fmt.Printf("Burn-in interval %d: MISSED PRESSES from%s\n", this.interval, missed)
+    }
+}
+
+
+// Enrol any newly connected buzzers and report any that have dropped out or come back.
+func (this *BurnIn) checkConnections() {
+    connected := make(map[int]bool)
+    for team := 0; team < TeamCount; team++ {
+        for _, id := range this.engine.TeamBuzzers(team) { connected[id] = true }
+    }
+
+    for _, id := range this.engine.TeamBuzzers(GuestTeam) { connected[id] = true }
+
+    for id := range connected {
+        unit, ok := this.units[id]
+        if !ok {
+            // New buzzer, enrol it. If it's joined part way through the interval, don't penalise it for that.
+            late := (this.step > 0)
+            this.units[id] = &burnInUnit{connected: true, pressedThisInterval: late}
+            if late { fmt.Printf("Burn-in: %s enrolled\n", BuzzerIdToNamedString(id)) }
+            continue
+        }
+
+        if !unit.connected {
+            unit.connected = true
+            fmt.Printf("Burn-in: %s reconnected\n", BuzzerIdToNamedString(id))
+        }
+    }
+
+    for id, unit := range this.units {
+        if unit.connected && !connected[id] {
+            unit.connected = false
+            unit.dropouts++
+            fmt.Printf("Burn-in: %s DROPPED OUT\n", BuzzerIdToNamedString(id))
+        }
+    }
+}
+
+
+// Report the IDs of all enrolled buzzers, in order.
+func (this *BurnIn) sortedIds() []int {
+    ids := []int{}
+    for id := range this.units { ids = append(ids, id) }
+    sort.Ints(ids)
+    return ids
+}
+
+
+// Report the results and finish the current run.
+func (this *BurnIn) finish(outcome ModalOutcome) {
+    fmt.Printf("Burn-in results after %d intervals:\n", this.interval)
+    fmt.Printf("Buzzer  Presses  Missed  Dropouts\n")
+    failed := ""
+
+    for _, id := range this.sortedIds() {
+        unit := this.units[id]
+        result := "pass"
+        if (unit.presses == 0) || (unit.missedPresses > 0) || (unit.dropouts > 0) || !unit.connected {
+            result = "FAIL"
+            failed += " " + BuzzerIdToNamedString(id)
+        }
+
+        fmt.Printf("%6s  %7d  %6d  %8d  %s\n", BuzzerIdToString(id), unit.presses, unit.missedPresses, unit.dropouts,
+            result)
+    }
+
+    if failed == "" {
+        fmt.Printf("All %d buzzers passed\n", len(this.units))
+    } else {
+        fmt.Printf("Failed:%s\n", failed)
+    }
+
+    // Unregister everything we temporarily registered.
+    this.run++  // Invalidate any pending timers.
+    this.states.Change(StateIdle)
+    this.engine.ModalComplete(CreateModalResult(outcome))
+
+    // De-illuminate all buzzers.
+    this.engine.SetModeAll(false, false)
+}
+
+
+// Add our details to the given game state.
+func (this *BurnIn) reportState(state *GameState) {
+    state.AddTimer("Burn-in end", this.endTime, this.engine.Now())
+}
+
+
+// Button press handler.
+func (this *BurnIn) button(press Press) {
+    id := press.Buzzer
+    unit, ok := this.units[id]
+    if !ok {
+        // Pressed before we'd noticed it connect.
+        unit = &burnInUnit{connected: true}
+        this.units[id] = unit
+    }
+
+    unit.presses++
+    unit.pressedThisInterval = true
+}
+
+
+// Command handler for starting burn-in.
+func (this *BurnIn) commandStart(values []int) {
+    length := BurnInDefaultTime
+    if values[0] > 0 { length = time.Duration(values[0]) * time.Minute }
+
+    this.Start(length)
+}
+
+
+// Command handler for stopping burn-in.
+func (this *BurnIn) commandStop(values []int) {
+    this.Stop()
+}
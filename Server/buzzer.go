@@ -2,42 +2,122 @@
 
 Each Buzzer object represents one physical buzzer.
 
+A buzzer whose ID specifies a team we don't support, and isn't a guest, is quarantined. We keep its connection open, so
+it doesn't keep reconnecting, but ignore everything it sends us and never report it to the swarm.
+
+Buzzers are on the network, so we must cope with anything at all being sent to us. A connection that fails the
+handshake is closed, as is one that sends too many invalid messages in a row, or one from a buzzer the swarm has
+banned. Nothing a buzzer sends may bring down the server.
+
+Buzzers that have accepted a UDP offer send their presses with sequence numbers, so they can be matched up with the
+copies sent over UDP. See udp.go.
+
+Buzzers from version 7 answer a ping with a pong, so we can check the connection works in both directions.
+
+Buzzers from version 8 send a long press message when the button has been held down for a while, after the press
+itself, so the swarm can tell long presses from short ones.
+
+Buzzers from version 9 also send a release message when the button is let go, so the swarm can tell how long it was
+held down for and spot buttons that are stuck down.
+
+Buzzers from version 10 can send framed messages, each a length followed by that many bytes, so they can carry more
+than a single byte's worth. We offer frames at connect time and the buzzer only sends them once offered, so an older
+server never sees them. Frames carry telemetry, such as battery level and WiFi signal strength, which we pass on to the
+swarm's stats. Frames of types we don't know are skipped, so new types can be added without breaking us.
+
+Buzzers from version 11 answer a timed ping, carrying a nonce, with a timed pong echoing it, so the swarm can measure
+round trip times. Pongs are timestamped as soon as they arrive, as presses are.
+
 */
 
 package main
 
 import "fmt"
 import "net"
+import "time"
 
 
 // External interface.
 
 // Create a Buzzer object based on the given connection and start processing incoming messages.
 func HandleNode(conn net.Conn, swarm *Swarm) {
-    var p Buzzer
-    p.conn = conn
-    p.swarm = swarm
-    p.id = 0xFF
-    p.sends = make(chan []byte, 100)
+    handleNode(conn, swarm, false)
+}
 
-    // Since all messages are single bytes, we only read 1 byte at a time from our connection.
-    p.buffer = make([]byte, 1)
 
-    go p.processIncoming()
-    go p.processOutgoing()
+// Create a Buzzer object based on the given virtual buzzer connection and start processing incoming messages.
+// Virtual buzzers may only claim IDs no other buzzer has, see Swarm.NewBuzzer().
+func HandleVirtualNode(conn net.Conn, swarm *Swarm) {
+    handleNode(conn, swarm, true)
 }
 
 
 // Send a mode message to this Buzzer.
-// This may be slow, call as a Go routine if appropriate.
-func (this *Buzzer) SetMode(ledOn bool, buzzerOn bool) {
+// The fanOut parameter is the mode change to many buzzers that this is part of, nil for none.
+func (this *Buzzer) SetMode(ledOn bool, buzzerOn bool, fanOut *FanOut) {
     var b byte = 0x20
 
     if ledOn { b |= 1 }
     if buzzerOn { b |= 2 }
 
     // fmt.Printf("Set buzzer %s mode %x\n", this.ID(), b)
-    this.sends <- []byte{b}
+    this.swarm.sender.Send(this, []byte{b}, fanOut)
+}
+
+
+// Send a tone message to this Buzzer, setting the pitch of its sounder, 0 highest.
+// Buzzers with firmware too old to support tones are left alone.
+func (this *Buzzer) SetTone(tone int) {
+    if this.buzzerVersion < BuzzerToneVersion { return }
+
+    this.swarm.sender.Send(this, []byte{0x40 | byte(tone & 7)}, nil)
+}
+
+
+// Send a UDP offer to this Buzzer, telling it to send presses over UDP too.
+// Buzzers with firmware too old to support UDP are left alone.
+func (this *Buzzer) OfferUdp() {
+    if this.buzzerVersion < BuzzerUdpVersion { return }
+
+    this.swarm.sender.Send(this, []byte{0x50}, nil)
+}
+
+
+// Send a frame offer to this Buzzer, telling it that it can send framed messages.
+// Buzzers with firmware too old to support frames are left alone.
+func (this *Buzzer) OfferFrames() {
+    if this.buzzerVersion < BuzzerFrameVersion { return }
+
+    this.swarm.sender.Send(this, []byte{0x52}, nil)
+}
+
+
+// Send a ping to this Buzzer, which it should answer with a pong.
+// Returns false, sending nothing, if the buzzer's firmware is too old to answer.
+func (this *Buzzer) Ping() bool {
+    if this.buzzerVersion < BuzzerPingVersion { return false }
+
+    this.swarm.sender.Send(this, []byte{0x51}, nil)
+    return true
+}
+
+
+// Send a timed ping with the given nonce to this Buzzer, which it should echo in a timed pong.
+// Returns false, sending nothing, if the buzzer's firmware is too old to answer.
+func (this *Buzzer) TimedPing(nonce byte) bool {
+    if this.buzzerVersion < BuzzerLatencyVersion { return false }
+
+    this.swarm.sender.Send(this, []byte{0x53, nonce}, nil)
+    return true
+}
+
+
+// Report the IP address this buzzer is connected from.
+func (this *Buzzer) IP() net.IP {
+    addr, ok := this.conn.RemoteAddr().(*net.TCPAddr)
+    if !ok { return nil }
+
+    return addr.IP
 }
 
 
@@ -61,10 +141,10 @@ func BuzzerIdToString(id int) string {
 }
 
 
-// Convert the given team ID to a string.
-// TODO: Move team count, names and ID conversions to another file.
-func TeamIdToString(id int) string {
-    return _teamLetters[id]
+// Convert the given buzzer ID to a string, coloured for display on a terminal.
+func BuzzerIdToColourString(id int) string {
+    team, _ := BuzzerIdToTeam(id)
+    return teamTerminalColour(team) + BuzzerIdToNamedString(id) + _colourReset
 }
 
 
@@ -85,12 +165,14 @@ func TeamToBuzzerId(team int, index int) int {
 // Object to represent a physical buzzer with which we're communicating.
 type Buzzer struct {
     conn net.Conn
-    // controller *Controller
     id int
     swarm *Swarm
     buzzerVersion byte
+    quarantined bool  // ID is unusable, ignore this buzzer.
+    virtual bool  // Connected from a phone, see virtual.go.
     buffer []byte  // Storage for incoming messages.
-    sends chan []byte  // Bytes to send, which should be synchronised.
+    worker int  // Sender worker that sends all our messages.
+    sendFailed bool  // Only used by our sender worker.
 }
 
 
@@ -98,42 +180,94 @@ type Buzzer struct {
 
 // We always expect all buzzers contacted to be on the latest firmware version.
 const (
-    BuzzerExpectedVersion = 4
+    BuzzerExpectedVersion = 11
+    BuzzerToneVersion = 5  // First version supporting tone messages.
+    BuzzerUdpVersion = 6  // First version supporting UDP presses.
+    BuzzerPingVersion = 7  // First version supporting pings.
+    BuzzerLongPressVersion = 8  // First version sending long press messages.
+    BuzzerReleaseVersion = 9  // First version sending release messages.
+    BuzzerFrameVersion = 10  // First version sending framed messages.
+    BuzzerLatencyVersion = 11  // First version supporting timed pings.
 )
 
-// Team letters for printing buzzer IDs.
-// TODO: Use this same definition for command parsing buzzer IDs.
-var _teamLetters = []string{"B", "G", "R", "Y", "x", "x", "x", "x"}
+// Framed message types.
+const (
+    FrameBattery = 0x01  // Battery charge level, as a percentage.
+    FrameRssi = 0x02  // WiFi signal strength, in dBm, signed.
+)
 
+// Number of invalid messages in a row after which we give up on a buzzer.
+const GarbageLimit = 20
 
-// Handle outgoing messages.
-// Only returns on connection error. Should be called as a Go routine.
-func (this *Buzzer) processOutgoing() {
-    // Now process outgoing messages forever.
-    for {
-        b := <-this.sends
-        _, err := this.conn.Write(b)
-        if err != nil {
-            this.swarm.Log("Failure to send mode message to buzzer %d, disconnecting\n", this.id)
-            this.Disconnect()
-            return
-        }
+
+// Write the given bytes to this buzzer, disconnecting on failure, including taking longer than SendTimeout.
+// Should only be called by our sender worker.
+func (this *Buzzer) write(b []byte) {
+    // Once a send has failed we've disconnected, so don't keep trying.
+    if this.sendFailed { return }
+
+    this.conn.SetWriteDeadline(time.Now().Add(SendTimeout))
+    _, err := this.conn.Write(b)
+    if err != nil {
+        this.swarm.Log("Failure to send mode message to buzzer %d, disconnecting\n", this.id)
+        this.sendFailed = true
+        this.Disconnect()
     }
 }
 
 
+// Create a Buzzer object based on the given connection, virtual or not, and start processing incoming messages.
+func handleNode(conn net.Conn, swarm *Swarm, virtual bool) {
+    var p Buzzer
+    p.conn = conn
+    p.swarm = swarm
+    p.id = 0xFF
+    p.virtual = virtual
+    p.worker = swarm.sender.Assign()
+
+    // Since all messages are single bytes, we only read 1 byte at a time from our connection.
+    p.buffer = make([]byte, 1)
+
+    go p.processIncoming()
+}
+
+
 // Handles incoming requests.
 // Only returns on connection error. Should be called as a Go routine.
 func (this *Buzzer) processIncoming() {
+    // Whatever a buzzer sends us, it mustn't be able to bring down the server.
+    defer func() {
+        if r := recover(); r != nil {
+            this.swarm.Log("Internal error handling buzzer %s, disconnecting: %v\n", this.ID(), r)
+            this.Disconnect()
+        }
+    }()
+
     // First get handshake out of the way.
-    if !this.processHandshake() { return }
+    if !this.processHandshake() {
+        this.conn.Close()
+        return
+    }
+
+    if this.quarantined {
+        // Just swallow messages until the connection dies.
+        for {
+            _, ok := this.getMessageByte()
+            if !ok { return }
+        }
+    }
 
     // Now process incoming messages forever.
+    garbage := 0  // Invalid messages in a row.
+
     for {
         // Get the next message byte.
         b, ok := this.getMessageByte()
         if !ok { return }
 
+        // Timestamp the message straight away, in case it's a press or a timed pong.
+        now := this.swarm.engine.Now()
+        received := time.Now()
         this.swarm.Received(this.id)
         msg, _ := this.decodeMessage(b)
 
@@ -143,17 +277,99 @@ func (this *Buzzer) processIncoming() {
 
         case MsgButtonPress:
             // Button press. This needs to be reported.
-            this.swarm.ButtonPress(this.id)
+            this.swarm.ButtonPress(this.id, now)
+
+        case MsgSequencedPress:
+            // Button press, which may already have reached us over UDP. The sequence number follows.
+            seq, ok := this.getMessageByte()
+            if !ok { return }
+
+            this.swarm.SequencedPress(this.id, this, seq, now)
+
+        case MsgPong:
+            // Answer to our ping.
+            this.swarm.Pong(this.id, this)
+
+        case MsgTimedPong:
+            // Answer to our timed ping. The nonce follows.
+            nonce, ok := this.getMessageByte()
+            if !ok { return }
+
+            this.swarm.TimedPong(this.id, this, nonce, received)
+
+        case MsgLongPress:
+            // Button still held down after a press.
+            this.swarm.LongPress(this.id, this)
+
+        case MsgRelease:
+            // Button let go after a press.
+            this.swarm.Release(this.id, this, now)
+
+        case MsgFrame:
+            // Framed message. The length follows, then the frame itself.
+            length, ok := this.getMessageByte()
+            if !ok { return }
+
+            frame := make([]byte, length)
+            for i := range frame {
+                frame[i], ok = this.getMessageByte()
+                if !ok { return }
+            }
+
+            this.processFrame(frame)
 
         case MsgError:
             // Error message. This needs to be reported.
-            // TODO
             this.swarm.Log("Error message received from %s\n", this.ID())
+            this.swarm.Error(this.id)
 
         default:
+            // Anything else is invalid, including handshake messages once the handshake is done.
             this.swarm.Log("Unrecognised message 0x%02X received from %s\n", b, this.ID())
+            this.swarm.Error(this.id)
+
+            garbage++
+            if garbage > GarbageLimit {
+                this.swarm.Log("Too many unrecognised messages from %s, disconnecting\n", this.ID())
+                this.Disconnect()
+                return
+            }
+
+            continue
         }
+
+        garbage = 0
+    }
+}
+
+
+// Handle the given framed message from this buzzer.
+func (this *Buzzer) processFrame(frame []byte) {
+    if len(frame) == 0 {
+        this.swarm.Log("Empty frame received from %s\n", this.ID())
+        this.swarm.Error(this.id)
+        return
+    }
+
+    switch frame[0] {
+    case FrameBattery:
+        if len(frame) < 2 { break }
+        this.swarm.Battery(this.id, this, int(frame[1]))
+        return
+
+    case FrameRssi:
+        if len(frame) < 2 { break }
+        this.swarm.Rssi(this.id, this, int(int8(frame[1])))
+        return
+
+    default:
+        // Presumably from newer firmware, so not an error.
+        this.swarm.Log("Unknown frame type 0x%02X from %s, skipped\n", frame[0], this.ID())
+        return
     }
+
+    this.swarm.Log("Frame type 0x%02X too short from %s\n", frame[0], this.ID())
+    this.swarm.Error(this.id)
 }
 
 
@@ -185,19 +401,37 @@ func (this *Buzzer) processHandshake() bool {
 
     this.id = int(value)
 
+    team, _ := BuzzerIdToTeam(this.id)
+    if (team >= TeamCount) && !IsGuestBuzzer(this.id) {
+        this.swarm.Log("Protocol error, buzzer ID 0x%02X has unsupported team %d, quarantined\n", this.id, team)
+        this.swarm.engine.Errorf("Warning: Buzzer with unsupported ID 0x%02X connected, ignoring it", this.id)
+        this.quarantined = true
+        return true
+    }
+
+    // With several rooms, the buzzer belongs to its own room's swarm, which may not be the one it connected to.
+    if swarm := this.swarm.RoomSwarm(this.id); swarm != this.swarm {
+        this.swarm = swarm
+        this.worker = swarm.sender.Assign()
+    }
+
+    if this.swarm.Banned(this.id, this.IP()) {
+        this.swarm.Log("Turning away banned buzzer %s from %v\n", this.ID(), this.IP())
+        return false
+    }
+
     if this.buzzerVersion == BuzzerExpectedVersion {
         this.swarm.Log("Found buzzer %s (v:%d)\n", this.ID(), this.buzzerVersion)
     } else {
         this.swarm.Log("Found buzzer %s with unexpected version %d\n", this.ID(), this.buzzerVersion)
     }
 
-    this.swarm.NewBuzzer(this.id, this)
-
-    return true
+    return this.swarm.NewBuzzer(this.id, this)
 }
 
 
 // Decode the given received message byte.
+// Any byte may be given, unrecognised ones are decoded as MsgUnknown. Has no side effects.
 func (this *Buzzer) decodeMessage(b byte) (msg MsgTypeEnum, param byte) {
     // Check for known messages.
     switch {
@@ -218,12 +452,35 @@ func (this *Buzzer) decodeMessage(b byte) (msg MsgTypeEnum, param byte) {
         // Heartbeat.
         return MsgHeartbeat, 0
 
+    case b == 0x32:
+        // Button press message with sequence number.
+        return MsgSequencedPress, 0
+
+    case b == 0x33:
+        // Pong.
+        return MsgPong, 0
+
+    case b == 0x34:
+        // Long press.
+        return MsgLongPress, 0
+
+    case b == 0x35:
+        // Release.
+        return MsgRelease, 0
+
+    case b == 0x36:
+        // Framed message.
+        return MsgFrame, 0
+
+    case b == 0x37:
+        // Timed pong.
+        return MsgTimedPong, 0
+
     case b == 0x7F:
         // Error message.
         return MsgError, 0
 
     default:
-        this.swarm.Log("Unrecognised message 0x%02X from buzzer %s\n", b, this.ID())
         return MsgUnknown, b
     }
 }
@@ -233,6 +490,12 @@ const (
     MsgId
     MsgHeartbeat
     MsgButtonPress
+    MsgSequencedPress
+    MsgPong
+    MsgLongPress
+    MsgRelease
+    MsgFrame
+    MsgTimedPong
     MsgError
     MsgUnknown
 )
@@ -242,8 +505,11 @@ type MsgTypeEnum int
 
 // Get the next incoming message, waiting until one is received.
 func (this *Buzzer) getMessageByte() (b byte, ok bool) {
-    // Get the next message byte.
-    _, err := this.conn.Read(this.buffer)
+    // Get the next message byte. A read may give us nothing without an error, so keep going until we get something.
+    n := 0
+    var err error
+    for (n == 0) && (err == nil) { n, err = this.conn.Read(this.buffer) }
+
     if err != nil {
         this.swarm.Log("Failure receiving from %s\n", this.ID())
         this.Disconnect()
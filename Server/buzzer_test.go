@@ -0,0 +1,120 @@
+package main
+
+import "strings"
+import "testing"
+
+
+// Fuzz buzzer IDs, including those of unsupported teams and guests, through the handshake and every press path, with
+// a quick fire question open so the presses reach a game mode.
+func FuzzBuzzerId(f *testing.F) {
+    for _, id := range []byte{0x00, 0x13, 0x3F, 0x40, 0x5A, 0x6F, 0x70, 0x7F} { f.Add(id, byte(0)) }
+
+    harness := createTestHarness(f)
+    harness.createQuickFire()
+
+    f.Fuzz(func(t *testing.T, id byte, seq byte) {
+        id &= 0x7F
+        harness.t = t
+        harness.engine.processCommand("f1")
+        harness.engine.processCommand("g")
+        defer harness.engine.processCommand("q")
+
+        buzzer := harness.connectId(int(id))
+        if buzzer == nil { t.Fatalf("Buzzer 0x%02X refused", id) }
+
+        team, _ := BuzzerIdToTeam(int(id))
+        usable := (team < TeamCount) || IsGuestBuzzer(int(id))
+        if harness.connected(int(id)) != usable {
+            t.Fatalf("Buzzer 0x%02X connected %v, expected %v", id, !usable, usable)
+        }
+
+        // The user is warned about quarantined buzzers, through the engine.
+        warned := false
+        for _, err := range harness.takeErrors() { warned = warned || strings.Contains(err, "unsupported ID") }
+        if warned == usable { t.Fatalf("Buzzer 0x%02X warned about %v, expected %v", id, warned, !usable) }
+
+        buzzer.send(0x30)
+        buzzer.send(0x32, seq)
+        buzzer.send(0x34, 0x35)
+        harness.swarm.UdpPress(int(id), nil, seq + 1, harness.engine.Now())
+        harness.settle()
+        harness.checkLog()
+    })
+}
+
+
+// Fuzz message decoding. Every byte must decode, to a message of the right type whose parameter matches it.
+func FuzzDecodeMessage(f *testing.F) {
+    for _, b := range []byte{0x00, 0x1F, 0x20, 0x30, 0x37, 0x7F, 0x80, 0xFF} { f.Add(b) }
+
+    var buzzer Buzzer
+    f.Fuzz(func(t *testing.T, b byte) {
+        msg, param := buzzer.decodeMessage(b)
+
+        switch {
+        case (msg == MsgVersion) != (b < 0x20):
+            t.Fatalf("0x%02X decoded as %v, expected version only below 0x20", b, msg)
+
+        case (msg == MsgId) != ((b & 0x80) != 0):
+            t.Fatalf("0x%02X decoded as %v, expected ID only with top bit set", b, msg)
+
+        case (msg == MsgId) && (param != (b & 0x7F)):
+            t.Fatalf("0x%02X decoded as ID 0x%02X", b, param)
+
+        case ((msg == MsgVersion) || (msg == MsgUnknown)) && (param != b):
+            t.Fatalf("0x%02X decoded as %v with parameter 0x%02X", b, msg, param)
+
+        case (msg != MsgVersion) && (msg != MsgId) && (msg != MsgUnknown) && (param != 0):
+            t.Fatalf("0x%02X decoded as %v with parameter 0x%02X", b, msg, param)
+        }
+    })
+}
+
+
+// Fuzz the handshake, and whatever follows it, sent over a fresh connection. Nothing sent may cause an internal error.
+func FuzzHandshake(f *testing.F) {
+    f.Add([]byte{BuzzerExpectedVersion, 0x80})
+    f.Add([]byte{BuzzerExpectedVersion, 0x93, 0x31, 0x30, 0x35})
+    f.Add([]byte{BuzzerExpectedVersion, 0xF0, 0x30})
+    f.Add([]byte{BuzzerExpectedVersion, 0x81, 0x36, 0x02, 0x01, 0x40})
+    f.Add([]byte{BuzzerExpectedVersion, 0x82, 0x37})
+    f.Add([]byte{0x31, 0x80})
+    f.Add([]byte{BuzzerExpectedVersion, 0x05})
+
+    harness := createTestHarness(f)
+    harness.createQuickFire()
+
+    f.Fuzz(func(t *testing.T, data []byte) {
+        harness.t = t
+        buzzer := harness.connect(data...)
+        harness.checkLog()
+        if buzzer != nil { buzzer.conn.Close() }
+    })
+}
+
+
+// Check a buzzer sending too many invalid messages in a row is disconnected, even if they decode, but one sending
+// the odd invalid message isn't.
+func TestGarbageLimit(t *testing.T) {
+    harness := createTestHarness(t)
+
+    // Invalid messages broken up by valid ones are tolerated.
+    buzzer := harness.connectId(0x13)
+    for i := 0; i < 3; i++ {
+        for j := 0; j < GarbageLimit; j++ { buzzer.send(0x7E) }
+        buzzer.send(0x31)
+    }
+
+    harness.settle()
+    if !harness.connected(0x13) { t.Fatalf("Buzzer disconnected for the odd invalid message") }
+
+    // Unknown messages, and repeated handshake messages, are both invalid.
+    for _, garbage := range []byte{0x7E, 0x93, BuzzerExpectedVersion} {
+        buzzer = harness.connectId(0x13)
+        for i := 0; i <= GarbageLimit; i++ {
+            if !buzzer.send(garbage) { t.Fatalf("Buzzer disconnected after %d invalid 0x%02X", i, garbage) }
+        }
+
+        if buzzer.send(0x31) { t.Fatalf("Buzzer not disconnected after sending 0x%02X repeatedly", garbage) }
+    }
+}
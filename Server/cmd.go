@@ -9,21 +9,38 @@ Each command is made up of:
  1. A single lead character, which specifies which command to run. This character must be unique, if it matches the
     user input, then the whole command must match.
  2. Some number of arguments. The number and type of arguments is specified by the command. Each argument is a fixed
-    length of either 1 or 2 characters, depending on the argument type.
+    length of either 1 or 2 characters, depending on the argument type, except where noted below.
 
 The argument types are:
-  * Marks. Single character 0..9.
+  * Marks. One or more characters 0..9, optionally followed by .5 or h for an extra half mark, eg 2.5 or 2h, and
+    optionally ended by a separator, a comma. The value is in half marks, see Marks. The h form is only recognised
+    when there's no team H, since teams may follow marks. All the digits are taken as the marks, so where the next
+    argument may start with a digit a separator is needed between them, eg "f10,3" is 10 marks and 3 attempts, whereas
+    "f103" is 103 marks.
   * Team identifier. Single character B, G, R or Y, case insensitive.
   * Multiple choice answer. Single character A..E, case insensitive.
   * Buzzer identifier. Double character, team identifier followed by unsigned integer.
+  * Number. One or more characters 0..9, ending at the first non-digit.
+  * Team list. Zero or more team identifiers, which must be the last argument. The value is a bit mask of the teams
+    given, or of all teams if none are given.
+  * Text. The rest of the command, which must be the last argument and may contain spaces. The text is passed to the
+    command handler separately, the value is its length.
 
-Only ASCII characters are permitted. Whitespace and extra leading/trailing characters are not permitted.
+Any argument type may be marked as optional, in which case it may be omitted from the end of the command. An omitted
+argument is given the value -1.
+
+Only ASCII characters are permitted. Whitespace, except within text, and extra leading/trailing characters are not
+permitted.
+
+Parsing problems are returned as errors, for the caller to report to the user.
 
 */
 
 package main
 
+import "errors"
 import "fmt"
+import "strings"
 
 
 // Extract the leading command character from the given user input.
@@ -44,16 +61,25 @@ const (
     ARG_TEAM
     ARG_MULTIPLE_CHOICE
     ARG_BUZ_ID
-    // TODO: How to handle half marks?
+    ARG_TEAMS
+    ARG_NUMBER
+    ARG_TEXT
 )
 
+// Flag to mark an argument as optional. May be combined with any argument type.
+const ARG_OPTIONAL ArgType = 0x100
+
+// Separator that may end marks, so more than one digit can be followed by a digit, eg "10,5".
+const MarksSeparator = ","
+
 type ArgType int
 
 
 // Parse the given user input string, expecting the specified list of arguments.
 // The leading command character will already have been processed before this call, but should still be present in the
 // given input.
-func ParseUserArgs(userInput string, argTypes []ArgType) (argValues []int, ok bool) {
+// Any text argument is returned separately.
+func ParseUserArgs(userInput string, argTypes []ArgType) (argValues []int, text string, err error) {
     argValues = []int{}
 
     // Ditch the lead character from the given input.
@@ -61,44 +87,87 @@ func ParseUserArgs(userInput string, argTypes []ArgType) (argValues []int, ok bo
 
     // Run through the defined argument types.
     for _, argType := range argTypes {
+        if (argType & ARG_OPTIONAL) != 0 {
+            if len(userInput) == 0 {
+                // Optional argument omitted.
+                argValues = append(argValues, -1)
+                continue
+            }
+
+            argType &^= ARG_OPTIONAL
+        }
+
         switch argType {
         case ARG_MARKS:
-            value, ok := expectChar(&userInput, "marks", '0', '9', false)
-            if !ok { return argValues, false }
+            value, err := expectMarks(&userInput)
+            if err != nil { return argValues, text, err }
 
             argValues = append(argValues, int(value))
 
         case ARG_TEAM:
-            value, ok := expectTeam(&userInput, "team")
-            if !ok { return argValues, false }
+            value, err := expectTeam(&userInput, "team")
+            if err != nil { return argValues, text, err }
 
             argValues = append(argValues, int(value))
 
         case ARG_MULTIPLE_CHOICE:
-            value, ok := expectChar(&userInput, "multiple choice", 'A', 'E', true)
-            if !ok { return argValues, false }
+            value, err := expectChar(&userInput, "multiple choice", 'A', 'E', true)
+            if err != nil { return argValues, text, err }
 
             argValues = append(argValues, int(value))
 
         case ARG_BUZ_ID:
-            team, ok := expectTeam(&userInput, "button")
-            if !ok { return argValues, false }
+            team, err := expectBuzzerTeam(&userInput, "button")
+            if err != nil { return argValues, text, err }
 
-            index, ok := expectChar(&userInput, "button", '0', '9', false)
-            if !ok { return argValues, false }
+            index, err := expectChar(&userInput, "button", '0', '9', false)
+            if err != nil { return argValues, text, err }
 
             value := TeamToBuzzerId(team, int(index))
             argValues = append(argValues, int(value))
+
+        case ARG_TEAMS:
+            // Teams may be followed by a number, so stop at any digit.
+            mask := 0
+            for (len(userInput) > 0) && ((userInput[0] < '0') || (userInput[0] > '9')) {
+                team, err := expectTeam(&userInput, "team")
+                if err != nil { return argValues, text, err }
+
+                mask |= 1 << team
+            }
+
+            if mask == 0 { mask = AllTeamsMask }
+            argValues = append(argValues, mask)
+
+        case ARG_NUMBER:
+            value, err := expectChar(&userInput, "number", '0', '9', false)
+            if err != nil { return argValues, text, err }
+
+            number := int(value)
+            for (len(userInput) > 0) && (userInput[0] >= '0') && (userInput[0] <= '9') {
+                number = (number * 10) + int(userInput[0] - '0')
+                userInput = userInput[1:]
+            }
+
+            argValues = append(argValues, number)
+
+        case ARG_TEXT:
+            if len(userInput) == 0 {
+                return argValues, text, errors.New("Expected text, found end of input")
+            }
+
+            text = userInput
+            userInput = ""
+            argValues = append(argValues, len(text))
         }
     }
 
     // Check there's no extra input.
     if len(userInput) != 0 {
-        fmt.Printf("Unexpected input found: %s\n", userInput)
-        return argValues, false
+        return argValues, text, fmt.Errorf("Unexpected input found: %s", userInput)
     }
 
-    return argValues, true
+    return argValues, text, nil
 }
 
 
@@ -107,86 +176,205 @@ func ArgUsage(argTypes []ArgType) string {
     s := ""
 
     for _, argType := range argTypes {
-        switch argType {
-        case ARG_MARKS:             s += "<marks>"
-        case ARG_TEAM:              s += "<team>"
-        case ARG_MULTIPLE_CHOICE:   s += "<answer>"
-        case ARG_BUZ_ID:            s += "<button>"
+        arg := ""
+
+        switch argType &^ ARG_OPTIONAL {
+        case ARG_MARKS:             arg = "<marks>"
+        case ARG_TEAM:              arg = "<team>"
+        case ARG_MULTIPLE_CHOICE:   arg = "<answer>"
+        case ARG_BUZ_ID:            arg = "<button>"
+        case ARG_TEAMS:             arg = "[<teams>]"
+        case ARG_NUMBER:            arg = "<number>"
+        case ARG_TEXT:              arg = "<text>"
         }
+
+        if (argType & ARG_OPTIONAL) != 0 { arg = "[" + arg + "]" }
+        s += arg
     }
 
     return s
 }
 
 
+// Return an example of the given argument type list, as the user would type it.
+func ArgExample(argTypes []ArgType) string {
+    s := ""
+    team := TeamIdToString(TeamCount - 1)  // Teams are configurable, so use one that exists.
+
+    for _, argType := range argTypes {
+        switch argType &^ ARG_OPTIONAL {
+        case ARG_MARKS:             s += "2"
+        case ARG_TEAM:              s += team
+        case ARG_MULTIPLE_CHOICE:   s += "B"
+        case ARG_BUZ_ID:            s += team + "3"
+        case ARG_TEAMS:             s += TeamIdToString(0) + team
+        case ARG_NUMBER:            s += "10"
+        case ARG_TEXT:              s += "spare"
+        }
+    }
+
+    return s
+}
+
+
+// Return the edit distance between the given strings, ignoring case.
+// Insertions, deletions, substitutions and transpositions of adjacent characters each count as a single edit.
+func EditDistance(a string, b string) int {
+    a = strings.ToLower(a)
+    b = strings.ToLower(b)
+
+    // Classic dynamic programming approach, d[i][j] is the distance between a[:i] and b[:j].
+    d := make([][]int, len(a) + 1)
+    for i := range d {
+        d[i] = make([]int, len(b) + 1)
+        d[i][0] = i
+    }
+
+    for j := range d[0] { d[0][j] = j }
+
+    for i := 1; i <= len(a); i++ {
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i - 1] == b[j - 1] { cost = 0 }
+
+            d[i][j] = minInt(d[i - 1][j] + 1, minInt(d[i][j - 1] + 1, d[i - 1][j - 1] + cost))
+
+            if (i > 1) && (j > 1) && (a[i - 1] == b[j - 2]) && (a[i - 2] == b[j - 1]) {
+                d[i][j] = minInt(d[i][j], d[i - 2][j - 2] + 1)
+            }
+        }
+    }
+
+    return d[len(a)][len(b)]
+}
+
+
 // Internals.
 
+// Return the smaller of the given values.
+func minInt(a int, b int) int {
+    if a < b { return a }
+    return b
+}
+
+
+// Return the larger of the given values.
+func maxInt(a int, b int) int {
+    if a > b { return a }
+    return b
+}
+
+
 // Extract a single character from the start of the given string, which must be in the specified range (inclusive).
 // The character will be removed from the given string.
 // The expected argument is used for reporting errors and should be "value" or similar.
 // If caseInsensitive is set to true, the character found will be forced to upper case before being compared to the
 // given range.
 // The value returned is the index into the given range.
-func expectChar(cmdLine *string, expected string, min byte, max byte, caseInsensitive bool) (index byte, ok bool) {
-    char, ok := extractChar(cmdLine, expected)
-    if !ok { return 0, false }
+func expectChar(cmdLine *string, expected string, min byte, max byte, caseInsensitive bool) (index byte, err error) {
+    char, err := extractChar(cmdLine, expected)
+    if err != nil { return 0, err }
 
     charOrig := char
     if caseInsensitive { char &= 0xDF }
 
     if (char < min) || (char > max) {
-        fmt.Printf("Bad command, expected %s, got \"%c\"\n", expected, charOrig)
-        return 0, false
+        return 0, fmt.Errorf("Bad command, expected %s, got \"%c\"", expected, charOrig)
     }
 
-    return char - min, true
+    return char - min, nil
+}
+
+
+// Extract marks from the start of the given string, digits optionally followed by a half, and optionally ended by a
+// separator.
+// The marks will be removed from the given string.
+func expectMarks(cmdLine *string) (marks Marks, err error) {
+    value, err := expectChar(cmdLine, "marks", '0', '9', false)
+    if err != nil { return 0, err }
+
+    number := int(value)
+    rest := *cmdLine
+
+    for (len(rest) > 0) && (rest[0] >= '0') && (rest[0] <= '9') {
+        number = (number * 10) + int(rest[0] - '0')
+        rest = rest[1:]
+    }
+
+    marks = WholeMarks(number)
+    halfLen, err := marksHalfLength(rest)
+    if err != nil { return 0, err }
+
+    if halfLen > 0 {
+        marks += HalfMark
+        rest = rest[halfLen:]
+    }
+
+    *cmdLine = strings.TrimPrefix(rest, MarksSeparator)
+    return marks, nil
+}
+
+
+// Report the length of the half mark at the start of the given string, 0 for none.
+func marksHalfLength(s string) (int, error) {
+    if strings.HasPrefix(s, ".") {
+        if !strings.HasPrefix(s, ".5") { return 0, errors.New("Bad command, marks may only have a half, eg 2.5") }
+
+        return 2, nil
+    }
+
+    if (len(s) > 0) && ((s[0] == 'h') || (s[0] == 'H')) {
+        if _, isTeam := decodeTeam('H'); !isTeam { return 1, nil }
+    }
+
+    return 0, nil
 }
 
 
 // Extract a team number from the start of the given string and decode it.
 // The team ID will be removed from the given string.
 // The expected argument is used for reporting errors and should be "team" or similar.
-func expectTeam(cmdLine *string, expected string) (team int, ok bool) {
-    id, ok := extractChar(cmdLine, expected)
-    if !ok { return 0, false }
+func expectTeam(cmdLine *string, expected string) (team int, err error) {
+    id, err := extractChar(cmdLine, expected)
+    if err != nil { return 0, err }
 
-    team, ok = decodeTeam(id)
+    team, ok := decodeTeam(id)
 
     if !ok {
-        fmt.Printf("Bad command, expected %s, got \"%c\"\n", expected, id)
-        return 0, false
+        return 0, fmt.Errorf("Bad command, expected %s, got \"%c\"", expected, id)
     }
 
-    return team, true
+    return team, nil
 }
 
 
-// Decode the given character into a team number.
-func decodeTeam(id byte) (team int, ok bool) {
-    switch id {
-    case 'b', 'B':  return 0, true  // Blue.
-    case 'g', 'G':  return 1, true  // Green.
-    case 'r', 'R':  return 2, true  // Red.
-    case 'y', 'Y':  return 3, true  // Yellow.
+// Extract the team of a buzzer ID from the given command line, which may be a guest.
+// Other details are as expectTeam().
+func expectBuzzerTeam(cmdLine *string, expected string) (team int, err error) {
+    id, err := extractChar(cmdLine, expected)
+    if err != nil { return 0, err }
 
-    default:
-        // Unrecognised team ID.
-        return 0, false
+    team, ok := decodeBuzzerTeam(id)
+
+    if !ok {
+        return 0, fmt.Errorf("Bad command, expected %s, got \"%c\"", expected, id)
     }
+
+    return team, nil
 }
 
 
+
 // Extract the next character from the given command line.
 // The character will be removed from the given string.
 // The expected argument is used for reporting errors and should be "value" or similar.
 // The value returned is the index into the given range.
-func extractChar(cmdLine *string, expected string) (char byte, ok bool) {
+func extractChar(cmdLine *string, expected string) (char byte, err error) {
     if len(*cmdLine) == 0 {
-        fmt.Printf("Bad command, expected %s not found\n", expected)
-        return 0, false
+        return 0, fmt.Errorf("Bad command, expected %s not found", expected)
     }
 
     char = (*cmdLine)[0]
     *cmdLine = (*cmdLine)[1:]
-    return char, true
+    return char, nil
 }
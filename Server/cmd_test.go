@@ -0,0 +1,38 @@
+package main
+
+import "reflect"
+import "testing"
+
+
+// Check multi-digit marks are read whole, needing a separator only where a digit follows them.
+func TestParseMarks(t *testing.T) {
+    quickFire := []ArgType{ARG_MARKS, ARG_TEAMS, ARG_NUMBER | ARG_OPTIONAL}
+    parallel := []ArgType{ARG_MARKS, ARG_MARKS}
+    correct := []ArgType{ARG_MARKS | ARG_OPTIONAL}
+    allTeams := (1 << TeamCount) - 1
+
+    tests := []struct {
+        input string
+        argTypes []ArgType
+        expected []int  // nil for an error.
+    }{
+        {"f10", quickFire, []int{20, allTeams, -1}},
+        {"f10,3", quickFire, []int{20, allTeams, 3}},
+        {"f103", quickFire, []int{206, allTeams, -1}},
+        {"f2.5B3", quickFire, []int{5, 1, 3}},
+        {"p10,5", parallel, []int{20, 10}},
+        {"p105", parallel, nil},
+        {"y10", correct, []int{20}},
+        {"y", correct, []int{-1}},
+        {"y1.2", correct, nil},
+    }
+
+    for _, test := range tests {
+        values, _, err := ParseUserArgs(test.input, test.argTypes)
+        if test.expected == nil {
+            if err == nil { t.Errorf("%q parsed as %v, expected an error", test.input, values) }
+        } else if (err != nil) || !reflect.DeepEqual(values, test.expected) {
+            t.Errorf("%q parsed as %v, %v, expected %v", test.input, values, err, test.expected)
+        }
+    }
+}
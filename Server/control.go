@@ -0,0 +1,74 @@
+/* Functions to let the quizmaster drive the quiz from a buzzer.
+
+A quizmaster away from the console, eg on stage, can use one buzzer as a control device. Its presses never count as
+buzzes. Instead, each pattern of presses runs a console command, exactly as if the user had typed it. The patterns are
+given by the number of presses in quick succession, since older buzzers only report presses, not how long the button
+was held, so there's no telling a long press from a short one. The mapping from patterns to commands is given as a
+comma separated list of press counts and command lines, eg "1=y,2=n,3=k" to run y for a single press, n for a double
+press and k for a triple press.
+
+Commands not valid at the time, eg judging an answer with no question open, are reported as for the console. Patterns
+with no command are ignored.
+
+A guest buzzer, see teams.go, makes a good control buzzer, since it's outside the team structure anyway.
+
+All control buzzer functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "strconv"
+import "strings"
+
+
+// Create a control buzzer, using the specified buzzer, with the given press pattern to command mapping.
+func CreateControlBuzzer(engine *Engine, swarm *Swarm, buzzerId int, mapping string) (*ControlBuzzer, error) {
+    var p ControlBuzzer
+    p.engine = engine
+    p.commands = make(map[int]string)
+
+    for _, pair := range strings.Split(mapping, ",") {
+        fields := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+        if (len(fields) != 2) || (fields[1] == "") {
+            return nil, fmt.Errorf("bad control pattern %q, expected <presses>=<command>", pair)
+        }
+
+        presses, err := strconv.Atoi(fields[0])
+        if (err != nil) || (presses < 1) { return nil, fmt.Errorf("bad press count in control pattern %q", pair) }
+
+        p.commands[presses] = fields[1]
+    }
+
+    swarm.SetControl(buzzerId, p.pattern)
+    fmt.Printf("Using buzzer %s as control buzzer\n", BuzzerIdToNamedString(buzzerId))
+
+    return &p, nil
+}
+
+
+// Control buzzer.
+type ControlBuzzer struct {
+    commands map[int]string  // Command line for each press count.
+    engine *Engine
+}
+
+
+// Internals.
+
+// Default mapping of press patterns to commands: judge correct, judge incorrect and continue the quiz script.
+const DefaultControlCommands = "1=y,2=n,3=k"
+
+
+// Handle a complete press pattern of the given number of presses.
+func (this *ControlBuzzer) pattern(presses int) {
+    cmd, ok := this.commands[presses]
+    if !ok {
+        fmt.Printf("Control buzzer %d press pattern, nothing to do\n", presses)
+        return
+    }
+
+    fmt.Printf("Control buzzer %d press pattern\n", presses)
+    this.engine.RunCommand(cmd)
+}
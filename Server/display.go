@@ -0,0 +1,112 @@
+/* Functions to decide what the audience display shows.
+
+Most of the time the audience display shows the scores. When someone's buzz is accepted, the display is taken over by
+the buzzing team's colour, with the player's name and how quickly they buzzed, until their answer is judged or the
+question is closed. This is all driven by the events published by the game modes, so they need do nothing for it.
+
+Score announcements, such as a team taking the lead, are shown until the next question opens.
+
+Players may be given names for the display, which are saved to storage so they survive restarts. Players without a name
+are shown by their name on the roster, if any, see roster.go, otherwise by their buzzer ID.
+
+What the display should currently show is added to the game state, which the display page follows.
+
+All display functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+
+
+// Create the audience display manager.
+func CreateDisplay(engine *Engine) *Display {
+    var p Display
+    p.engine = engine
+    p.names = make(map[int]string)
+
+    _, err := engine.Storage().Load(PlayerNamesRecord, &p.names)
+    if err != nil { fmt.Printf("Could not load player names: %v\n", err) }
+
+    engine.Subscribe(p.event)
+    engine.AddStateReporter(p.reportState)
+    engine.RegisterTextCmd(p.commandName, "Name a player for the audience display, or clear their name", 'W',
+        ARG_BUZ_ID, ARG_TEXT | ARG_OPTIONAL)
+
+    return &p
+}
+
+
+// Report the name to show for the specified player.
+func (this *Display) PlayerName(buzzerId int) string {
+    name, ok := this.names[buzzerId]
+    if ok { return name }
+
+    if name := RosterName(buzzerId); name != "" { return name }
+    return BuzzerIdToString(buzzerId)
+}
+
+
+// Audience display manager.
+type Display struct {
+    names map[int]string  // Player names, indexed by buzzer ID.
+    buzz *BuzzState  // Current takeover, nil for none.
+    announcement string  // Blank for none.
+    engine *Engine
+}
+
+
+// Details of a player's buzz, for the audience display to show.
+type BuzzState struct {
+    Player string  // Name, or buzzer ID if not named.
+    Buzzer string
+    Team int
+    ReactionMs int64  // Time from the question opening to the buzz.
+}
+
+
+// Internals.
+
+const PlayerNamesRecord string = "players"  // Storage record name.
+
+
+// Event handler, taking over the display when a buzz is accepted and releasing it when it's done with.
+func (this *Display) event(event *Event) {
+    switch event.Type {
+    case EventBuzz:
+        this.buzz = &BuzzState{Player: this.PlayerName(event.Buzzer), Buzzer: BuzzerIdToString(event.Buzzer),
+            Team: event.Team, ReactionMs: event.Duration.Milliseconds()}
+
+    case EventJudged, EventQuestionClosed:
+        this.buzz = nil
+
+    case EventAnnouncement:
+        this.announcement = event.Note
+
+    case EventQuestionOpened:
+        this.announcement = ""
+    }
+}
+
+
+// State reporter, adding any display takeover and announcement.
+func (this *Display) reportState(state *GameState) {
+    state.Buzz = this.buzz
+    state.Announcement = this.announcement
+}
+
+
+// Command handler for naming a player.
+func (this *Display) commandName(values []int, text string) {
+    if text == "" {
+        delete(this.names, values[0])
+        fmt.Printf("Player %s no longer named\n", BuzzerIdToString(values[0]))
+    } else {
+        this.names[values[0]] = text
+        fmt.Printf("Player %s is %s\n", BuzzerIdToString(values[0]), text)
+    }
+
+    err := this.engine.Storage().Save(PlayerNamesRecord, this.names)
+    if err != nil { fmt.Printf("Could not save player names: %v\n", err) }
+}
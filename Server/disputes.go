@@ -0,0 +1,94 @@
+/* Functions to track disputed questions.
+
+The user can flag the latest question as disputed at any time, with a note of what the dispute is. Disputes are
+listed at the end of the quiz, each with the timeline of everything that happened during the question, so they can be
+settled afterwards.
+
+Disputes are recorded in the engine's event history, so we don't need to keep any state of our own.
+
+All dispute functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+
+
+// Create a dispute tracker.
+func CreateDisputes(engine *Engine) *Disputes {
+    var p Disputes
+    p.engine = engine
+
+    engine.RegisterTextCmd(p.commandDispute, "Flag latest question as disputed, with a note", '!', ARG_TEXT)
+    engine.RegisterCmd(p.commandReport, "Print disputes report", '#')
+
+    return &p
+}
+
+
+// Flag the latest question as disputed, with the given note.
+func (this *Disputes) Dispute(note string) {
+    this.engine.Publish(Event{Type: EventDisputed, Note: note})
+
+    // The engine fills in the question number for us.
+    history := this.engine.History()
+    fmt.Printf("Question %d flagged as disputed\n", history[len(history) - 1].Question)
+}
+
+
+// Print out all disputes so far, each with the timeline of its question.
+func (this *Disputes) Report() {
+    history := this.engine.History()
+    count := 0
+
+    for _, dispute := range history {
+        if dispute.Type != EventDisputed { continue }
+
+        count++
+        fmt.Printf("Dispute %d, question %d: %s\n", count, dispute.Question, dispute.Note)
+
+        // Find the start of the question, to give times relative to.
+        var start *Event
+        for i := range history {
+            if (history[i].Type == EventQuestionOpened) && (history[i].Question == dispute.Question) {
+                start = &history[i]
+                break
+            }
+        }
+
+        if start == nil {
+            fmt.Printf("  No question timeline\n")
+            continue
+        }
+
+        for i := range history {
+            event := &history[i]
+            if (event.Question != dispute.Question) || (event.Type == EventDisputed) { continue }
+
+            fmt.Printf("  %+8.3fs  %s\n", (event.Time - start.Time).Seconds(), event.String())
+        }
+    }
+
+    if count == 0 { fmt.Printf("No disputes\n") }
+}
+
+
+// Dispute tracker.
+type Disputes struct {
+    engine *Engine
+}
+
+
+// Internals.
+
+// Command handler for flagging a dispute.
+func (this *Disputes) commandDispute(values []int, text string) {
+    this.Dispute(text)
+}
+
+
+// Command handler for printing the disputes report.
+func (this *Disputes) commandReport([]int) {
+    this.Report()
+}
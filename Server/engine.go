@@ -6,9 +6,55 @@ buzzers.
 
 Any given command may be specified as "modal" when it is registered. Only one modal command may be run at a time. This
 is intended for relatively long lived operations that maintain state on the buzzers, such as test mode and multiple
-choice questions. Modal commands must inform the engine when they are complete.
+choice questions. Modal commands must inform the engine when they are complete, giving their result, such as who won
+and what marks were awarded. The result is published as an event, so anything that needs to follow the quiz, such as
+the session log, can act on it without scraping the console. A modal command may also give the engine a way to cancel
+it, eg so a round can be closed when it runs out of time, in which case its result records that it timed out.
 
-All engine functions and methods must be called only in the main thread, unless otherwise stated.
+Commands registered while a modal command is in operation are assumed to belong to that modal. The help command uses
+this to show only the commands that are currently useful. A modal may register a command with the same character as a
+global command, in which case the global command is shadowed, until the modal's command is deregistered.
+
+When all else fails, the user can panic. This cancels any modal command, clears everything registered for it and
+turns off every buzzer, getting us back to a safe state from any tangle.
+
+Each registration returns a token, which must be given to deregister it. This stops one entity deregistering another's
+command or button handler, eg if they unexpectedly share a command character.
+
+Commands and button handlers that are only needed for a while, eg for the duration of a question, may be registered
+against a scope. Closing the scope then deregisters everything registered against it, so nothing can be forgotten.
+
+Commands are normally typed a line at a time. Hotkey mode, which the user can toggle at any time, puts the terminal into
+raw mode instead, so judging a buzz-in is a single keystroke. A key for a command with no arguments runs it at once. A
+key for any other command starts a line with it, for the user to finish and enter, and Enter on its own starts a blank
+line, eg to quit. The terminal is put back as it was when hotkey mode is turned off or we exit normally. If we're killed
+in hotkey mode, "stty sane" will restore it.
+
+With several rooms, see rooms.go, there's an engine for each room but only one console. Whichever engine has taken the
+console gets everything typed.
+
+User facing errors are reported through the engine, see Errorf().
+
+Each button press is timestamped as soon as it's received from the buzzer. Presses from different buzzers arrive over
+different connections, so near simultaneous presses can overtake each other on their way to us. Any presses queued up
+together are therefore handled in the order they were received, and handlers are given each press's receipt time, so
+game modes can resolve who pressed first fairly.
+
+Once each press is complete, the swarm classifies it as a gesture, a short press, long press or double press, see
+swarm.go. Gestures are given to a gesture handler, registered separately from the button handler, since most game
+modes act on the press itself and don't care. Gestures arrive some time after their presses, so are no use for
+deciding who pressed first. Gestures of guest buzzers are ignored.
+
+Buzzers from version 9 also report when their button is released, which the swarm passes on with how long it was held
+down for. Releases are given to a release handler, also registered separately, eg so a player can be made to hold
+their button down while they answer. Releases of guest buzzers are ignored too.
+
+Presses of guest buzzers, which are outside the team structure, see teams.go, are never given to ordinary button
+handlers, so they can't affect the scores. Only handlers registered specifically for guests, such as test mode's, get
+them. Otherwise they're given to any guest hooks, eg to play a sound.
+
+All engine functions and methods must be called only in the main thread, unless otherwise stated. In debug mode,
+calls from other threads are caught, see SetThreadChecks().
 
 */
 
@@ -17,23 +63,42 @@ package main
 import "bufio"
 import "fmt"
 import "os"
+import "os/exec"
 import "sort"
+import "path/filepath"
 import "strings"
+import "sync"
+import "sync/atomic"
+import "time"
 
 
 // Create the engine and associated swarm.
-func CreateEngine() (*Engine, *Swarm) {
+// The given storage is used for anything that needs to persist. Log files are written to the given directory, blank for
+// the current directory.
+func CreateEngine(storage Storage, logDir string) (*Engine, *Swarm) {
     var p Engine
+    p.storage = storage
+    p.logDir = logDir
     p.rawCmdLines = make(chan string, 10)
-    p.pressIds = make(chan int, 100)
+    p.presses = make(chan Press, 100)
+    p.calls = make(chan func(), 100)
     p.commands = make(map[byte]*cmdInfo)
+    p.shadowed = make(map[byte]*cmdInfo)
+    p.commandCounts = make(map[byte]int)
+    p.startTime = time.Now()
+    p.AddErrorOutput(consoleErrorOutput)
 
     swarm := CreateSwarm(&p)
     p.swarm = swarm
+    p.AddErrorOutput(func(msg string) { swarm.Log("%s\n", msg) })
 
     p.RegisterCmd(p.usage, "Help", '?')
     p.RegisterCmd(p.commandReportModal, "Report current modal", 'd')
     p.RegisterCmd(p.commandForceModalClear, "Force clear current modal", 'c')
+    p.RegisterCmd(p.commandState, "Print current state", 'i')
+    p.RegisterCmd(p.commandPanic, "Panic, cancel everything and turn off all buzzers", '*')
+    p.RegisterTextCmd(p.commandSearch, "Search event history by event type, buzzer, player or team", 'v', ARG_TEXT)
+    p.RegisterCmd(p.commandHotkeysToggle, "Toggle hotkey mode, single keystroke commands", 'z')
 
     return &p, swarm
 }
@@ -42,8 +107,11 @@ func CreateEngine() (*Engine, *Swarm) {
 // Start processing requests.
 // Only returns on program exit.
 func (this *Engine) Run() {
-    // Start inputting command lines from stdin.
-    go this.processStdin()
+    // Start inputting command lines from stdin, unless another engine already has.
+    _consoleStart.Do(func() {
+        if _console.Load() == nil { this.TakeConsole() }
+        go processStdin()
+    })
 
     // Process incoming messages until exit.
     for {
@@ -52,44 +120,90 @@ func (this *Engine) Run() {
             // Command line received.
             if cmd == ExitCommand {
                 // Quit command given.
+                if this.Hotkeys() { this.SetHotkeys(false) }
                 return
             }
 
             this.processCommand(cmd)
 
-        case buttonId := <-this.pressIds:
-            // A button has been pressed.
-            if this.buttonHandler != nil {
-                // Tell our registered handler about it.
-                this.buttonHandler(buttonId)
+        case press := <-this.presses:
+            // A button has been pressed. Any other presses that have caught up with it go in receipt order.
+            presses := []Press{press}
+            for more := true; more; {
+                select {
+                case press := <-this.presses:   presses = append(presses, press)
+                default:                        more = false
+                }
             }
+
+            SortPresses(presses)
+            for _, press := range presses { this.handlePress(press) }
+
+        case call := <-this.calls:
+            // A delayed function is due.
+            call()
         }
     }
 }
 
 
+// Stop processing requests, as if the user had quit, making Run() return.
+// May be called from any thread.
+func (this *Engine) Stop() {
+    this.rawCmdLines <- ExitCommand
+}
+
+
+// Take the console, so everything typed comes to us, eg when the user switches rooms.
+// May be called from any thread.
+func (this *Engine) TakeConsole() {
+    _console.Store(this)
+}
+
+
 // Register the given command handler.
 // The command is specified as a single leading character of the command line. There can only ever be one handler for
 // and given command character at a time.
 // All command handler callbacks will occur within the main engine thread.
-func (this *Engine) RegisterCmd(handler CmdHandler, help string, cmd byte, args ...ArgType) {
-    this.RegisterModal(handler, "", help, cmd, args...)
+// Returns a token to deregister the command with.
+func (this *Engine) RegisterCmd(handler CmdHandler, help string, cmd byte, args ...ArgType) RegToken {
+    return this.RegisterModal(handler, "", help, cmd, args...)
 }
 
 // Function to handle a specific command.
 type CmdHandler func (argValues []int)
 
 
+// Register the given command handler, for a command with a text argument.
+// As RegisterCmd(), except the text argument is passed to the handler.
+func (this *Engine) RegisterTextCmd(handler TextCmdHandler, help string, cmd byte, args ...ArgType) RegToken {
+    token := this.RegisterCmd(nil, help, cmd, args...)
+    this.commands[cmd].textHandler = handler
+    return token
+}
+
+// Function to handle a specific command with a text argument.
+type TextCmdHandler func (argValues []int, text string)
+
+
 // Register the given modal command handler.
 // The command is specified as a single leading character of the command line. There can only ever be one handler for
 // and given command character at a time.
 // The desc parameter is used for error reporting and must not be blank.
 // When the modal command completes, ModalComplete() must be called.
 // All command handler callbacks will occur within the main engine thread.
-func (this *Engine) RegisterModal(handler CmdHandler, desc string, help string, cmd byte, args ...ArgType) {
-    _, ok := this.commands[cmd]
+// Returns a token to deregister the command with.
+func (this *Engine) RegisterModal(handler CmdHandler, desc string, help string, cmd byte, args ...ArgType) RegToken {
+    this.CheckMainThread()
+
+    existing, ok := this.commands[cmd]
     if ok {
-        fmt.Printf("Error: Request to register already registered command %v\n", cmd)
+        if (this.modalDesc != "") && !existing.modalLocal && (this.shadowed[cmd] == nil) {
+            // Modal local command, hide the global one until this is deregistered.
+            this.shadowed[cmd] = existing
+        } else {
+            this.Errorf("Error: Request to register already registered command %v", cmd)
+        }
     }
 
     var p cmdInfo
@@ -98,58 +212,445 @@ func (this *Engine) RegisterModal(handler CmdHandler, desc string, help string,
     p.helpText = help
     p.initialChar = cmd
     p.argTypes = args
+    p.modalLocal = (this.modalDesc != "")
+    this.nextToken++
+    p.token = this.nextToken
     this.commands[cmd] = &p
+    return p.token
+}
+
+// Token identifying a single registration.
+type RegToken int
+
+
+// Report how many times each command has been run, indexed by leading char.
+func (this *Engine) CommandCounts() map[byte]int {
+    this.CheckMainThread()
+
+    counts := make(map[byte]int)
+    for cmd, count := range this.commandCounts { counts[cmd] = count }
+    return counts
 }
 
 
 // Deregister the given, previously registered command handler.
-func (this *Engine) DeregisterCmd(handler CmdHandler, cmd byte) {
-    _, ok := this.commands[cmd]
+// The token must be the one returned when the command was registered.
+func (this *Engine) DeregisterCmd(token RegToken, cmd byte) {
+    this.CheckMainThread()
+
+    existing, ok := this.commands[cmd]
     if !ok {
-        fmt.Printf("Error: Request to deregister undefined command %v\n", cmd)
+        this.Errorf("Error: Request to deregister undefined command %v", cmd)
+        return
+    }
+
+    if existing.token != token {
+        // The caller may own the global command currently shadowed.
+        if global := this.shadowed[cmd]; (global != nil) && (global.token == token) {
+            delete(this.shadowed, cmd)
+            return
+        }
+
+        this.Errorf("Error: Request to deregister command %v not owned by caller", cmd)
         return
     }
 
     delete(this.commands, cmd)
+
+    // Restore any global command this one was shadowing.
+    if global := this.shadowed[cmd]; global != nil {
+        this.commands[cmd] = global
+        delete(this.shadowed, cmd)
+    }
+}
+
+
+// Create a registration scope.
+// Everything registered against the scope is deregistered when it is closed.
+func (this *Engine) CreateScope() *Scope {
+    var p Scope
+    p.engine = this
+    return &p
+}
+
+
+// Register the given command handler against this scope.
+// As Engine.RegisterCmd().
+func (this *Scope) RegisterCmd(handler CmdHandler, help string, cmd byte, args ...ArgType) {
+    token := this.engine.RegisterCmd(handler, help, cmd, args...)
+    this.cmds = append(this.cmds, cmdRegistration{token, cmd})
+}
+
+
+// Register the given text command handler against this scope.
+// As Engine.RegisterTextCmd().
+func (this *Scope) RegisterTextCmd(handler TextCmdHandler, help string, cmd byte, args ...ArgType) {
+    token := this.engine.RegisterTextCmd(handler, help, cmd, args...)
+    this.cmds = append(this.cmds, cmdRegistration{token, cmd})
+}
+
+
+// Register the given button press handler against this scope.
+// As Engine.RegisterButtons().
+func (this *Scope) RegisterButtons(handler ButtonHandler) {
+    this.buttonToken = this.engine.RegisterButtons(handler)
+}
+
+
+// Register the given button press handler, which is also given guest presses, against this scope.
+// As Engine.RegisterGuestButtons().
+func (this *Scope) RegisterGuestButtons(handler ButtonHandler) {
+    this.buttonToken = this.engine.RegisterGuestButtons(handler)
+}
+
+
+// Register the given gesture handler against this scope.
+// As Engine.RegisterGestures().
+func (this *Scope) RegisterGestures(handler GestureHandler) {
+    this.gestureToken = this.engine.RegisterGestures(handler)
+}
+
+
+// Register the given release handler against this scope.
+// As Engine.RegisterReleases().
+func (this *Scope) RegisterReleases(handler ReleaseHandler) {
+    this.releaseToken = this.engine.RegisterReleases(handler)
+}
+
+
+// Deregister everything registered against this scope.
+// The scope may be reused afterwards.
+func (this *Scope) Close() {
+    for _, reg := range this.cmds {
+        this.engine.DeregisterCmd(reg.token, reg.cmd)
+    }
+
+    if this.buttonToken != 0 { this.engine.DeregisterButtons(this.buttonToken) }
+    if this.gestureToken != 0 { this.engine.DeregisterGestures(this.gestureToken) }
+    if this.releaseToken != 0 { this.engine.DeregisterReleases(this.releaseToken) }
+
+    this.cmds = nil
+    this.buttonToken = 0
+    this.gestureToken = 0
+    this.releaseToken = 0
+}
+
+
+// Registration scope.
+type Scope struct {
+    cmds []cmdRegistration
+    buttonToken RegToken  // 0 for none.
+    gestureToken RegToken  // 0 for none.
+    releaseToken RegToken  // 0 for none.
+    engine *Engine
 }
 
 
-// Signify that the current modal command is complete.
-func (this *Engine) ModalComplete() {
-    // Just clear the current modal description.
+// Signify that the current modal command is complete, with the given result.
+// The result's mode is filled in by this call.
+func (this *Engine) ModalComplete(result ModalResult) {
+    this.CheckMainThread()
+
     if this.modalDesc == "" {
-        fmt.Printf("Error: Request to complete current modal, while not in a modal\n")
+        this.Errorf("Error: Request to complete current modal, while not in a modal")
     }
 
+    // A modal cancelled because it ran out of time doesn't know that's why.
+    if (result.Outcome == OutcomeCancelled) && this.modalTimingOut { result.Outcome = OutcomeTimedOut }
+
+    result.Mode = this.modalDesc
     this.modalDesc = ""
+    this.modalCancel = nil
+    this.modalState = nil
+    this.modalTimingOut = false
+
+    this.Publish(Event{Type: EventResult, Mode: result.Mode, Team: result.Winner, Result: &result})
+}
+
+
+// Result of a modal command, such as a question, given when it completes.
+type ModalResult struct {
+    Mode string
+    Outcome ModalOutcome
+    Winner int  // Team that won outright, <0 for none.
+    Awards []Marks  // Marks actually awarded to each team, indexed by team, nil for none.
+}
+
+// How a modal command ended.
+const (
+    OutcomeCompleted ModalOutcome = iota
+    OutcomeCancelled
+    OutcomeTimedOut
+)
+
+type ModalOutcome int
+
+// Outcome names, for reporting.
+var _outcomeNames = []string{"completed", "cancelled", "timed out"}
+
+
+// Create a modal result for the given outcome, with no winner and no awards yet.
+func CreateModalResult(outcome ModalOutcome) ModalResult {
+    return ModalResult{Outcome: outcome, Winner: -1}
+}
+
+
+// Record that the given team was awarded the given marks.
+func (this *ModalResult) Award(team int, marks Marks) {
+    if this.Awards == nil { this.Awards = make([]Marks, TeamCount) }
+    this.Awards[team] += marks
+}
+
+
+// Describe this result for the user, eg "quick fire completed, won by R, awarded R:2".
+func (this *ModalResult) String() string {
+    s := this.Mode + " " + _outcomeNames[this.Outcome]
+    if this.Winner >= 0 { s += ", won by " + TeamIdToString(this.Winner) }
+
+    awards := ""
+    for team, marks := range this.Awards {
+        if marks != 0 { awards += fmt.Sprintf(" %s:%v", TeamIdToString(team), marks) }
+    }
+
+    if awards != "" { s += ", awarded" + awards }
+    return s
+}
+
+
+// Set the function to call to cancel the current modal command.
+// The cancel function must call ModalComplete(), as for normal completion.
+func (this *Engine) SetModalCancel(cancel func()) {
+    this.CheckMainThread()
+
+    this.modalCancel = cancel
+}
+
+
+// Cancel the current modal command, because it's run out of time.
+// Its result is reported as timed out.
+func (this *Engine) TimeOutModal() {
+    this.CheckMainThread()
+
+    if this.modalDesc == "" { return }
+
+    this.modalTimingOut = true
+    this.CancelModal()
+    this.modalTimingOut = false
+}
+
+
+// Cancel the current modal command, if any.
+func (this *Engine) CancelModal() {
+    this.CheckMainThread()
+
+    if this.modalDesc == "" { return }
+
+    if this.modalCancel == nil {
+        this.Errorf("Error: Modal %s cannot be cancelled", this.modalDesc)
+        return
+    }
+
+    fmt.Printf("Cancelling %s\n", this.modalDesc)
+    this.modalCancel()
+}
+
+
+// Register the given function to be called when the user panics, to get back to a safe state.
+// Called after any modal has been cancelled, but before its registrations are cleared.
+func (this *Engine) AddPanicHandler(handler func()) {
+    this.CheckMainThread()
+
+    this.panicHandlers = append(this.panicHandlers, handler)
+}
+
+
+// Get back to a safe state, from whatever tangle we're in.
+// Cancels any modal command, clears all modal registrations and turns off all buzzers.
+func (this *Engine) Panic() {
+    this.CheckMainThread()
+
+    fmt.Printf("PANIC\n")
+
+    if this.modalCancel != nil { this.CancelModal() }
+
+    for _, handler := range this.panicHandlers {
+        handler()
+    }
+
+    // Forcibly remove anything left over from the modal.
+    for cmd, info := range this.commands {
+        if info.modalLocal { this.DeregisterCmd(info.token, cmd) }
+    }
+
+    this.buttonHandler = nil
+    this.buttonToken = 0
+    this.buttonGuests = false
+    this.gestureHandler = nil
+    this.gestureToken = 0
+    this.releaseHandler = nil
+    this.releaseToken = 0
+    if this.questionOpen { this.QuestionClosed(this.modalDesc) }
+    this.commandForceModalClear(nil)
+
+    this.SetModeAll(false, false)
+    this.printState()
 }
 
 
 // Register the given button press handler.
 // There can only be a single receiver registered at a time.
 // All button press handler callbacks will occur within the main engine thread.
-func (this *Engine) RegisterButtons(handler ButtonHandler) {
+// Returns a token to deregister the handler with.
+func (this *Engine) RegisterButtons(handler ButtonHandler) RegToken {
+    this.CheckMainThread()
+
     if this.buttonHandler != nil {
-        fmt.Printf("Error: Clashing button handler. Have %v, want to reg %v\n",
-            this.buttonHandler, handler)
+        this.Errorf("Error: Clashing button handler. Have %v, want to reg %v", this.buttonHandler, handler)
     }
 
     this.buttonHandler = handler
+    this.buttonGuests = false
+    this.nextToken++
+    this.buttonToken = this.nextToken
+    return this.buttonToken
+}
+
+
+// Register the given button press handler, which is also given presses of guest buzzers.
+// Otherwise as RegisterButtons().
+func (this *Engine) RegisterGuestButtons(handler ButtonHandler) RegToken {
+    token := this.RegisterButtons(handler)
+    this.buttonGuests = true
+    return token
+}
+
+
+// Add a hook to be called for every press of a guest buzzer, for the life of the program.
+// Hooks aren't called while the current button handler takes guest presses itself.
+func (this *Engine) AddGuestHook(hook ButtonHandler) {
+    this.CheckMainThread()
+
+    this.guestHooks = append(this.guestHooks, hook)
 }
 
 // Function to handle button press events.
-type ButtonHandler func (id int)
+type ButtonHandler func (press Press)
+
+// A button press.
+type Press struct {
+    Buzzer int
+    Time time.Duration  // Engine time the press was received, less any latency compensation.
+}
+
+
+// Sort the given presses into the order they were received, earliest first.
+// Presses received at the same time are ordered by buzzer ID, so the order is always the same.
+// May be called from any thread.
+func SortPresses(presses []Press) {
+    sort.SliceStable(presses, func(i, j int) bool {
+        if presses[i].Time != presses[j].Time { return presses[i].Time < presses[j].Time }
+        return presses[i].Buzzer < presses[j].Buzzer
+    })
+}
+
+
+// Register the given gesture handler.
+// Only one gesture handler may be registered at once. Returns a token to deregister the handler with.
+func (this *Engine) RegisterGestures(handler GestureHandler) RegToken {
+    this.CheckMainThread()
+
+    if this.gestureHandler != nil { this.Errorf("Error: Clashing gesture handler") }
+
+    this.gestureHandler = handler
+    this.nextToken++
+    this.gestureToken = this.nextToken
+    return this.gestureToken
+}
+
+// Function to handle gestures, given the buzzer that made it.
+type GestureHandler func (buzzerId int, gesture Gesture)
+
+// Gestures a player can make with a single button.
+const (
+    GestureShort Gesture = iota
+    GestureLong  // Button held down, only reported by buzzers from version 8.
+    GestureDouble  // 2 presses in quick succession.
+)
+
+type Gesture int
+
+// Gesture names, for reporting.
+var _gestureNames = []string{"short press", "long press", "double press"}
+
+
+// Deregister the given, previously registered gesture handler.
+// The token must be the one returned when the handler was registered.
+func (this *Engine) DeregisterGestures(token RegToken) {
+    this.CheckMainThread()
+
+    if token != this.gestureToken {
+        this.Errorf("Error: Request to deregister gesture handler not owned by caller")
+        return
+    }
+
+    this.gestureHandler = nil
+    this.gestureToken = 0
+}
+
+
+// Register the given release handler.
+// Only one release handler may be registered at once. Returns a token to deregister the handler with.
+func (this *Engine) RegisterReleases(handler ReleaseHandler) RegToken {
+    this.CheckMainThread()
+
+    if this.releaseHandler != nil { this.Errorf("Error: Clashing release handler") }
+
+    this.releaseHandler = handler
+    this.nextToken++
+    this.releaseToken = this.nextToken
+    return this.releaseToken
+}
+
+// Function to handle button releases, given the buzzer released and how long its button was held down for.
+type ReleaseHandler func (buzzerId int, held time.Duration)
+
+
+// Deregister the given, previously registered release handler.
+// The token must be the one returned when the handler was registered.
+func (this *Engine) DeregisterReleases(token RegToken) {
+    this.CheckMainThread()
+
+    if token != this.releaseToken {
+        this.Errorf("Error: Request to deregister release handler not owned by caller")
+        return
+    }
+
+    this.releaseHandler = nil
+    this.releaseToken = 0
+}
 
 
 // Deregister the given, previously registered button press handler.
-func (this *Engine) DeregisterButtons(handler ButtonHandler) {
+// The token must be the one returned when the handler was registered.
+func (this *Engine) DeregisterButtons(token RegToken) {
+    this.CheckMainThread()
+
+    if token != this.buttonToken {
+        this.Errorf("Error: Request to deregister button handler not owned by caller")
+        return
+    }
+
     this.buttonHandler = nil
+    this.buttonToken = 0
+    this.buttonGuests = false
 }
 
 
 // Send a mode message to the specified buzzer.
 // Returns false if the specified buzzer cannot be found.
 func (this *Engine) SetMode(buzzerId int, ledOn bool, buzzerOn bool) bool {
+    this.CheckMainThread()
+
     // Just forward to our Swarm.
     return this.swarm.SetMode(buzzerId, ledOn, buzzerOn)
 }
@@ -157,36 +658,203 @@ func (this *Engine) SetMode(buzzerId int, ledOn bool, buzzerOn bool) bool {
 
 // Send a mode message to all connected buzzers.
 func (this *Engine) SetModeAll(ledOn bool, buzzerOn bool) {
+    this.CheckMainThread()
+
     // Just forward to our Swarm.
     this.swarm.SetModeAll(ledOn, buzzerOn)
 }
 
 
-// Handle a button press event from the specified buzzer.
+// Check all connected buzzers are alive, reconnecting any that aren't, and report the result on the console.
+// The check takes a few seconds, so this returns before it completes.
+func (this *Engine) CheckLiveness() {
+    this.CheckMainThread()
+
+    // Just forward to our Swarm.
+    this.swarm.CheckLiveness()
+}
+
+
+// Report our persistent storage.
+// May be called from any thread.
+func (this *Engine) Storage() Storage {
+    return this.storage
+}
+
+
+// Turn hotkey mode on or off, changing the terminal mode to match.
+// Stays in line mode if the terminal can't be changed, eg because stdin isn't a terminal.
+func (this *Engine) SetHotkeys(on bool) {
+    this.CheckMainThread()
+
+    args := []string{"icanon", "echo"}
+    if on { args = []string{"-icanon", "-echo", "min", "1"} }
+
+    cmd := exec.Command("stty", args...)
+    cmd.Stdin = os.Stdin
+    err := cmd.Run()
+    if err != nil {
+        this.Errorf("Cannot change terminal mode: %v", err)
+        if on { return }
+    }
+
+    this.typing = false
+    if !on {
+        atomic.StoreInt32(&this.hotkeys, 0)
+        fmt.Printf("Hotkeys off\n")
+        return
+    }
+
+    atomic.StoreInt32(&this.hotkeys, 1)
+    fmt.Printf("Hotkeys on, press Enter to type a whole command\n")
+}
+
+
+// Report whether we're in hotkey mode.
+// May be called from any thread.
+func (this *Engine) Hotkeys() bool {
+    return atomic.LoadInt32(&this.hotkeys) != 0
+}
+
+
+// Report the IDs of the currently connected buzzers in the specified team, in ID order.
+func (this *Engine) TeamBuzzers(team int) []int {
+    this.CheckMainThread()
+
+    // Just forward to our Swarm.
+    return this.swarm.TeamBuzzers(team)
+}
+
+
+// Report the path to write the log file with the given name to.
+// May be called from any thread.
+func (this *Engine) LogPath(name string) string {
+    if this.logDir == "" { return name }
+
+    return filepath.Join(this.logDir, name)
+}
+
+
+// Call the given function in the main thread after the given delay.
+// May be called from any thread.
+func (this *Engine) After(delay time.Duration, call func()) {
+    time.AfterFunc(delay, func() {
+        this.calls <- call
+    })
+}
+
+
+// Call the given function in the main thread and wait for it to return.
+// May be called from any thread, except the main thread, which would deadlock.
+func (this *Engine) CallAndWait(call func()) {
+    this.CheckNotMainThread()
+
+    done := make(chan bool)
+
+    this.calls <- func() {
+        call()
+        done <- true
+    }
+
+    <-done
+}
+
+
+// Run the given command line, as if the user had entered it, eg so a script can start questions.
+func (this *Engine) RunCommand(cmdLine string) {
+    this.CheckMainThread()
+
+    fmt.Printf("> %s\n", cmdLine)
+    this.processCommand(cmdLine)
+}
+
+
+// Handle a button press event from the specified buzzer, received at the given engine time.
+// May be called from any thread.
+func (this *Engine) ButtonPress(buzzerId int, pressTime time.Duration) {
+    // Just add the press to our incoming list.
+    this.presses <- Press{buzzerId, pressTime}
+}
+
+
+// Handle a gesture made by the specified buzzer.
+// May be called from any thread.
+func (this *Engine) ButtonGesture(buzzerId int, gesture Gesture) {
+    this.calls <- func() {
+        if IsGuestBuzzer(buzzerId) || (this.gestureHandler == nil) { return }
+
+        this.gestureHandler(buzzerId, gesture)
+    }
+}
+
+
+// Handle the release of the specified buzzer's button, after being held down for the given time.
 // May be called from any thread.
-func (this *Engine) ButtonPress(buzzerId int) {
-    // Just add the button ID to our incoming list.
-    this.pressIds <- buzzerId
+func (this *Engine) ButtonRelease(buzzerId int, held time.Duration) {
+    this.calls <- func() {
+        if IsGuestBuzzer(buzzerId) || (this.releaseHandler == nil) { return }
+
+        this.releaseHandler(buzzerId, held)
+    }
 }
 
 
 // Quiz engine.
 type Engine struct {
     rawCmdLines chan string
-    pressIds chan int  // Button ID for each press event.
+    presses chan Press  // Incoming press events.
+    calls chan func()  // Functions to call in the main thread.
     buttonHandler ButtonHandler
+    buttonToken RegToken  // For current button handler.
+    buttonGuests bool  // Current button handler takes guest presses.
+    gestureHandler GestureHandler
+    gestureToken RegToken  // For current gesture handler.
+    releaseHandler ReleaseHandler
+    releaseToken RegToken  // For current release handler.
+    guestHooks []ButtonHandler
+    nextToken RegToken  // Last registration token given out.
     modalDesc string
+    modalCancel func()  // Cancels current modal, nil if not possible.
+    modalState StateReporter  // For current modal, nil for none.
+    modalTimingOut bool  // Current modal is being cancelled for running out of time.
+    stateReporters []StateReporter
+    panicHandlers []func()
     swarm *Swarm
+    storage Storage
+    logDir string  // Blank for the current directory.
     commands map[byte]*cmdInfo  // Indexed by leading char.
+    commandCounts map[byte]int  // Times each command has been run, indexed by leading char.
+    shadowed map[byte]*cmdInfo  // Global commands hidden by modal local ones, indexed by leading char.
+    startTime time.Time  // For event timestamps.
+    subscribers []EventHandler
+    history []Event
+    questionCount int
+    questionOpenTime time.Duration
+    questionOpen bool
+    threadCheck ThreadCheck
+    mainThread uint64  // Go routine ID of the main thread, only set if checking threads.
+    errorOutputs []ErrorOutput
+    hotkeys int32  // Non-zero in hotkey mode. Also read by the stdin Go routine, so only accessed atomically.
+    typing bool  // In hotkey mode, the user is typing a whole command.
+    typed []byte  // Whole command typed so far.
+}
+
+// A command registered against a scope.
+type cmdRegistration struct {
+    token RegToken
+    cmd byte
 }
 
 // Info needed for a single command.
 type cmdInfo struct {
     handler CmdHandler
+    textHandler TextCmdHandler  // Used instead of handler, if set.
     desc string
     helpText string
     initialChar byte
     argTypes []ArgType
+    modalLocal bool  // Registered by the modal currently in operation.
+    token RegToken
 }
 
 
@@ -194,8 +862,36 @@ type cmdInfo struct {
 
 const (
     ExitCommand string = "quit"
+    MaxSuggestions = 3  // More suggestions than this for an unrecognised command are unhelpful.
 )
 
+// Engine that has the console, nil until one takes it.
+var _console atomic.Value
+var _consoleStart sync.Once
+
+
+// Pass the given button press on to whoever wants it.
+func (this *Engine) handlePress(press Press) {
+    team, _ := BuzzerIdToTeam(press.Buzzer)
+    this.Publish(Event{Type: EventPress, Buzzer: press.Buzzer, Team: team})
+
+    if IsGuestBuzzer(press.Buzzer) {
+        if (this.buttonHandler != nil) && this.buttonGuests {
+            this.buttonHandler(press)
+            return
+        }
+
+        fmt.Printf("Guest %s pressed\n", BuzzerIdToPlayerString(press.Buzzer))
+        for _, hook := range this.guestHooks { hook(press) }
+        return
+    }
+
+    if this.buttonHandler != nil {
+        // Tell our registered handler about it.
+        this.buttonHandler(press)
+    }
+}
+
 
 // Parse the given command line and call the registered handler.
 func (this *Engine) processCommand(cmdLine string) {
@@ -204,52 +900,200 @@ func (this *Engine) processCommand(cmdLine string) {
 
     cmd, ok := this.commands[cmdChar]
     if !ok {
-        fmt.Printf("Unrecognised command, ? for help: %s\n", cmdLine)
+        this.suggest(cmdLine)
         return
     }
 
-    argValues, ok := ParseUserArgs(cmdLine, cmd.argTypes)
-    if !ok {
-        // Error has already been reported.
+    argValues, text, err := ParseUserArgs(cmdLine, cmd.argTypes)
+    if err != nil {
+        this.Errorf("%v", err)
         return
     }
 
     // Check modals.
     if cmd.desc != "" {
         if this.modalDesc != "" {
-            fmt.Printf("Cannot start modal %s, %s already in operation\n", cmd.desc, this.modalDesc)
+            this.Errorf("Cannot start modal %s, %s already in operation", cmd.desc, this.modalDesc)
             return
         }
 
         this.modalDesc = cmd.desc
     }
 
+    this.commandCounts[cmdChar]++
+    this.Publish(Event{Type: EventCommand, Note: cmdLine})
+
+    if cmd.textHandler != nil {
+        cmd.textHandler(argValues, text)
+        return
+    }
+
     cmd.handler(argValues)
 }
 
 
-// Read stdin and report all resulting command lines to the main thread.
+// Report an unrecognised command line, suggesting the closest commands to it.
+func (this *Engine) suggest(cmdLine string) {
+    // Compare the given command line to both the bare command and an example of its use, since the user could have got
+    // either wrong.
+    best := []*cmdInfo{}
+    bestDistance := (len(cmdLine) + 1) / 2  // Any further away isn't worth suggesting.
+    if bestDistance < 1 { bestDistance = 1 }
+
+    for _, cmd := range this.commands {
+        distance := minInt(EditDistance(cmdLine, string(cmd.initialChar)),
+            EditDistance(cmdLine, string(cmd.initialChar) + ArgExample(cmd.argTypes)))
+
+        if distance < bestDistance {
+            best = []*cmdInfo{}
+            bestDistance = distance
+        }
+
+        if distance == bestDistance {
+            best = append(best, cmd)
+        }
+    }
+
+    if EditDistance(cmdLine, ExitCommand) <= 1 {
+        // Looks like a botched exit. We don't guess at that.
+        this.Errorf("Unrecognised command %s, to exit use %s", cmdLine, ExitCommand)
+        return
+    }
+
+    if (len(best) == 0) || (len(best) > MaxSuggestions) {
+        // Nothing usefully close.
+        this.Errorf("Unrecognised command, ? for help: %s", cmdLine)
+        return
+    }
+
+    sort.Slice(best, func(i, j int) bool {
+        return best[i].initialChar < best[j].initialChar
+    })
+
+    this.Errorf("Unrecognised command %s, did you mean:", cmdLine)
+    for _, cmd := range best {
+        this.printCommand(cmd)
+    }
+}
+
+
+// Read stdin and report all resulting command lines, or keystrokes in hotkey mode, to the main thread of the engine
+// that has the console.
 // Never returns. Should be called as a Go routine.
-func (this *Engine) processStdin() {
+func processStdin() {
     stdin := bufio.NewReader(os.Stdin)
+    line := []byte{}
 
     for {
-        text, _ := stdin.ReadString('\n')
-        text = strings.TrimSpace(text)
+        b, err := stdin.ReadByte()
+        if err != nil { continue }
+
+        engine := _console.Load().(*Engine)
+        if engine.Hotkeys() {
+            engine.calls <- func() { engine.hotkey(b) }
+            continue
+        }
+
+        if b != '\n' {
+            line = append(line, b)
+            continue
+        }
+
+        text := strings.TrimSpace(string(line))
+        line = line[:0]
 
         // Ignore blank lines.
         if text != "" {
-            this.rawCmdLines <- text
+            engine.rawCmdLines <- text
+        }
+    }
+}
+
+
+// Handle the given keystroke in hotkey mode, running its command or adding it to the command being typed.
+// The terminal isn't echoing for us, so we echo what the user types.
+func (this *Engine) hotkey(key byte) {
+    if this.typing {
+        switch key {
+        case '\r', '\n':
+            fmt.Printf("\n")
+            this.typing = false
+            text := strings.TrimSpace(string(this.typed))
+            if text == ExitCommand {
+                // Only our main loop can quit. We're running in it, so queue the quit from elsewhere rather than wait.
+                go this.Stop()
+            } else if text != "" {
+                this.processCommand(text)
+            }
+
+        case 0x7F, '\b':
+            // Backspace.
+            if len(this.typed) > 0 {
+                this.typed = this.typed[:len(this.typed) - 1]
+                fmt.Printf("\b \b")
+            }
+
+        case 0x1B:
+            // Escape, abandon the command.
+            fmt.Printf("\n")
+            this.typing = false
+
+        default:
+            if (key >= ' ') && (key <= '~') {
+                this.typed = append(this.typed, key)
+                fmt.Printf("%c", key)
+            }
         }
+
+        return
+    }
+
+    if (key == '\r') || (key == '\n') {
+        // Start a whole command.
+        this.typing = true
+        this.typed = this.typed[:0]
+        fmt.Printf("> ")
+        return
+    }
+
+    if (key <= ' ') || (key > '~') { return }
+
+    cmd, ok := this.commands[key]
+    if ok && (len(cmd.argTypes) > 0) {
+        // Needs arguments, let the user type them.
+        this.typing = true
+        this.typed = append(this.typed[:0], key)
+        fmt.Printf("> %c", key)
+        return
     }
+
+    fmt.Printf("%c\n", key)
+    this.processCommand(string(key))
 }
 
 
 // Print a usage message for our commands.
+// While a modal is in operation, only the commands that can currently be used are shown.
 func (this *Engine) usage([]int) {
-    fmt.Printf("Usage:\n")
-    fmt.Printf("  %-16s  Exit\n", ExitCommand)
+    if this.modalDesc == "" {
+        fmt.Printf("Usage:\n")
+        fmt.Printf("  %-20s  Exit\n", ExitCommand)
+        this.printCommands(func(cmd *cmdInfo) bool { return true })
+        return
+    }
+
+    fmt.Printf("Usage during %s:\n", this.modalDesc)
+    this.printCommands(func(cmd *cmdInfo) bool { return cmd.modalLocal })
+
+    // Other modals can't be started, so don't show them.
+    fmt.Printf("Other commands:\n")
+    fmt.Printf("  %-20s  Exit\n", ExitCommand)
+    this.printCommands(func(cmd *cmdInfo) bool { return !cmd.modalLocal && (cmd.desc == "") })
+}
 
+
+// Print usage info for those of our commands that pass the given filter.
+func (this *Engine) printCommands(filter func(cmd *cmdInfo) bool) {
     // Before printing commands, sort by command char.
     keys := make([]byte, 0, len(this.commands))
     for key := range this.commands {
@@ -263,12 +1107,21 @@ func (this *Engine) usage([]int) {
     // Now we can print our commands.
     for _, key := range keys {
         cmd := this.commands[key]
+        if filter(cmd) { this.printCommand(cmd) }
+    }
+}
 
-        // Get usage info for arguments, if any.
-        args := ArgUsage(cmd.argTypes)
 
-        fmt.Printf("  %c%-15s  %s\n", cmd.initialChar, args, cmd.helpText)
+// Print usage info for the given command.
+func (this *Engine) printCommand(cmd *cmdInfo) {
+    // Get usage info for arguments, if any.
+    args := ArgUsage(cmd.argTypes)
+    example := ""
+    if len(cmd.argTypes) > 0 {
+        example = fmt.Sprintf(", eg %c%s", cmd.initialChar, ArgExample(cmd.argTypes))
     }
+
+    fmt.Printf("  %c%-19s  %s%s\n", cmd.initialChar, args, cmd.helpText, example)
 }
 
 
@@ -282,7 +1135,21 @@ func (this *Engine) commandReportModal([]int) {
 }
 
 
+// Command handler for panicking.
+func (this *Engine) commandPanic([]int) {
+    this.Panic()
+}
+
+
+// Command handler for toggling hotkey mode.
+func (this *Engine) commandHotkeysToggle([]int) {
+    this.SetHotkeys(!this.Hotkeys())
+}
+
+
 // Force the current modal command state to clear.
 func (this *Engine) commandForceModalClear([]int) {
     this.modalDesc = ""
+    this.modalCancel = nil
+    this.modalState = nil
 }
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+import "time"
+
+
+// Check commands typed in hotkey mode run even when the main loop's command lines are backed up, and quitting is
+// queued behind them rather than lost.
+func TestHotkeyCommands(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire()
+
+    for len(harness.engine.rawCmdLines) < cap(harness.engine.rawCmdLines) { harness.engine.rawCmdLines <- "?" }
+
+    for _, line := range []string{"\rf2\r", "\rquit\r"} {
+        for i := range line { harness.engine.hotkey(line[i]) }
+    }
+
+    if mode := harness.engine.State().Mode; mode != "quick fire" { t.Fatalf("Typed command not run, in %q", mode) }
+
+    var lines []string
+    for (len(lines) == 0) || (lines[len(lines) - 1] != ExitCommand) {
+        select {
+        case line := <-harness.engine.rawCmdLines:    lines = append(lines, line)
+        case <-time.After(time.Second):                 t.Fatalf("Quit lost, got %v", lines)
+        }
+    }
+
+    if len(lines) != cap(harness.engine.rawCmdLines) + 1 { t.Fatalf("Quit not queued behind %v", lines) }
+    harness.checkErrors()
+}
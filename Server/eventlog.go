@@ -0,0 +1,214 @@
+/* Functions to keep a structured log of everything that happens during the quiz.
+
+The event log is a JSON lines file, with one record per line, so disputes after the quiz, such as who actually buzzed
+first on Q7, can be settled from the facts, by eye or with a script, rather than by scraping the console. Each record
+gives the wall clock time, the engine time in seconds, see events.go, and its type, plus whatever fields the type
+needs. For example:
+
+    {"time":"2026-10-16T20:15:03.512+01:00","engine":312.004,"type":"press","buzzer":"B3"}
+
+Records are written for:
+  * Buzzers connecting and disconnecting.
+  * Button presses, as the swarm receives them. These are timed by their receipt, rather than when the game mode handled
+    them, so the log shows who really pressed first, even when near simultaneous presses arrived out of order.
+  * Mode changes sent to buzzers, whether to one or to all.
+  * Commands, whether typed by the user or run by a script or the control buzzer.
+  * Errors reported to the user.
+  * Every other quiz event the engine publishes, see events.go, such as questions opening and closing, buzzes,
+    judgements and score changes.
+
+Each record is written as soon as it's made, a whole line at a time, so a crash loses nothing that's already happened.
+
+Records may be written from any thread.
+
+*/
+
+package main
+
+import "encoding/json"
+import "fmt"
+import "net"
+import "os"
+import "sync"
+import "time"
+
+
+// Create the event log for the given engine and swarm, and start recording.
+func CreateEventLog(engine *Engine, swarm *Swarm) *EventLog {
+    var p EventLog
+    p.engine = engine
+
+    path := engine.LogPath(EventLogFile)
+    file, err := os.Create(path)
+    if err != nil {
+        fmt.Printf("Could not open %s for writing, no event log: %v\n", path, err)
+        return &p
+    }
+
+    fmt.Printf("Writing event log to %s\n", path)
+    p.file = file
+
+    engine.Subscribe(p.event)
+    engine.AddErrorOutput(p.error)
+    swarm.SetEventLog(&p)
+
+    return &p
+}
+
+
+// Record the given buzzer connecting from the given address, with the given firmware version.
+// May be called from any thread.
+func (this *EventLog) Connected(buzzerId int, ip net.IP, version byte) {
+    record := this.record("connect")
+    record.Buzzer = BuzzerIdToString(buzzerId)
+    if ip != nil { record.Address = ip.String() }
+    record.Version = int(version)
+    this.write(record)
+}
+
+
+// Record the given buzzer disconnecting.
+// May be called from any thread.
+func (this *EventLog) Disconnected(buzzerId int) {
+    record := this.record("disconnect")
+    record.Buzzer = BuzzerIdToString(buzzerId)
+    this.write(record)
+}
+
+
+// Record a press of the given physical buzzer, standing in for the given logical one, received at the given engine
+// time. The reason it was ignored is given, blank if it wasn't.
+// May be called from any thread.
+func (this *EventLog) Pressed(buzzerId int, logicalId int, pressTime time.Duration, ignored string) {
+    record := this.record("press")
+    record.Engine = pressTime.Seconds()
+    record.Buzzer = BuzzerIdToString(buzzerId)
+    if logicalId != buzzerId { record.StandingInFor = BuzzerIdToString(logicalId) }
+    record.Text = ignored
+    this.write(record)
+}
+
+
+// Record a mode change sent to the given buzzer, or to all of them for a buzzer ID < 0.
+// May be called from any thread.
+func (this *EventLog) ModeChanged(buzzerId int, ledOn bool, buzzerOn bool) {
+    record := this.record("mode")
+    record.Buzzer = "all"
+    if buzzerId >= 0 { record.Buzzer = BuzzerIdToString(buzzerId) }
+    record.Led = onOff(ledOn)
+    record.Sound = onOff(buzzerOn)
+    this.write(record)
+}
+
+
+// Event log.
+type EventLog struct {
+    engine *Engine
+    file *os.File  // Nil if we couldn't open it.
+    lock sync.Mutex  // Protects file, so records aren't interleaved.
+}
+
+// A single record in the event log.
+// Only the fields relevant to the record's type are filled in.
+type EventRecord struct {
+    Time time.Time `json:"time"`
+    Engine float64 `json:"engine"`  // Engine time in seconds.
+    Type string `json:"type"`
+    Question int `json:"question,omitempty"`  // Number of latest question.
+    Round int `json:"round,omitempty"`
+    Mode string `json:"mode,omitempty"`  // Game mode.
+    Buzzer string `json:"buzzer,omitempty"`
+    StandingInFor string `json:"for,omitempty"`  // Logical ID a swapped in spare is standing in for.
+    Team string `json:"team,omitempty"`
+    Result string `json:"result,omitempty"`  // For judgements, correct or incorrect.
+    Marks Marks `json:"marks,omitempty"`  // For scores, the change.
+    Duration float64 `json:"duration,omitempty"`  // In seconds.
+    Led string `json:"led,omitempty"`  // For mode changes, on or off.
+    Sound string `json:"sound,omitempty"`  // For mode changes, on or off.
+    Address string `json:"address,omitempty"`  // IP address of a connection.
+    Version int `json:"version,omitempty"`  // Firmware version of a connection.
+    Text string `json:"text,omitempty"`  // Command line, note, error message and so on.
+}
+
+
+// Internals.
+
+const EventLogFile string = "events.jsonl"
+
+
+// Start a record of the given type, timed now.
+func (this *EventLog) record(recordType string) *EventRecord {
+    return &EventRecord{Time: time.Now(), Engine: this.engine.Now().Seconds(), Type: recordType}
+}
+
+
+// Write the given record to the log.
+func (this *EventLog) write(record *EventRecord) {
+    if this.file == nil { return }
+
+    data, err := json.Marshal(record)
+    if err != nil {
+        fmt.Printf("Could not encode event log record: %v\n", err)
+        return
+    }
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    _, err = this.file.Write(append(data, '\n'))
+    if err != nil {
+        fmt.Printf("Could not write event log, no more records: %v\n", err)
+        this.file = nil
+    }
+}
+
+
+// Event handler.
+// Presses are recorded by the swarm, timed by their receipt, so are skipped here.
+func (this *EventLog) event(event *Event) {
+    if event.Type == EventPress { return }
+
+    record := this.record(_eventTypeNames[event.Type])
+    record.Engine = event.Time.Seconds()
+    record.Question = event.Question
+    record.Round = event.Round
+    record.Mode = event.Mode
+    record.Marks = event.Marks
+    record.Duration = event.Duration.Seconds()
+    record.Text = event.Note
+
+    switch event.Type {
+    case EventBuzz:
+        record.Buzzer = BuzzerIdToString(event.Buzzer)
+        record.Team = TeamIdToString(event.Team)
+
+    case EventJudged:
+        record.Buzzer = BuzzerIdToString(event.Buzzer)
+        record.Team = TeamIdToString(event.Team)
+        record.Result = "incorrect"
+        if event.Correct { record.Result = "correct" }
+
+    case EventScore, EventAnnouncement:
+        record.Team = TeamIdToString(event.Team)
+
+    case EventResult:
+        record.Text = event.Result.String()
+    }
+
+    this.write(record)
+}
+
+
+// Error output.
+func (this *EventLog) error(msg string) {
+    record := this.record("error")
+    record.Text = msg
+    this.write(record)
+}
+
+
+// Convert the given flag to "on" or "off".
+func onOff(on bool) string {
+    if on { return "on" }
+    return "off"
+}
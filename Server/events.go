@@ -0,0 +1,239 @@
+/* Quiz events.
+
+The engine publishes events for anything of interest that happens during the quiz, which any number of subscribers can
+receive. This allows displays, logs and the like to follow the quiz without the game modes knowing about them.
+
+Every event is timestamped by the engine with a monotonic time, measured from when the engine was created. Clients that
+need to stay in step with the quiz, such as displays running countdowns, should use these times and Engine.Now(),
+rather than wall clock time.
+
+The engine also keeps a history of all events published, so reports can be compiled from what actually happened. The
+user can search this history, eg to check whether a team actually pressed their button.
+
+All event functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "strings"
+import "time"
+
+
+// Subscribe to all events.
+// All event handler callbacks will occur within the main engine thread.
+func (this *Engine) Subscribe(handler EventHandler) {
+    this.CheckMainThread()
+
+    this.subscribers = append(this.subscribers, handler)
+}
+
+// Function to handle events.
+type EventHandler func (event *Event)
+
+
+// Publish the given event to all subscribers.
+// The event's time and question number are filled in by this call.
+func (this *Engine) Publish(event Event) {
+    this.CheckMainThread()
+
+    event.Time = this.Now()
+    event.Question = this.questionCount
+    this.history = append(this.history, event)
+
+    for _, handler := range this.subscribers {
+        handler(&event)
+    }
+}
+
+
+// Report all events published so far, oldest first.
+// The returned slice must not be modified.
+func (this *Engine) History() []Event {
+    this.CheckMainThread()
+
+    return this.history
+}
+
+
+// Print out all events in the history matching the given search terms.
+// Each term may be an event type, a buzzer or a team, all terms must match. Case is ignored.
+func (this *Engine) Search(terms string) {
+    this.CheckMainThread()
+
+    count := 0
+
+    for i := range this.history {
+        event := &this.history[i]
+        if !event.matches(strings.Fields(terms)) { continue }
+
+        fmt.Printf("%9.3fs  %s\n", event.Time.Seconds(), event.String())
+        count++
+    }
+
+    fmt.Printf("%d events found\n", count)
+}
+
+
+// Report the current time, relative to engine creation.
+// May be called from any thread.
+func (this *Engine) Now() time.Duration {
+    return time.Since(this.startTime)
+}
+
+
+// Report that a game mode has opened a question.
+func (this *Engine) QuestionOpened(mode string, marks Marks) {
+    this.CheckMainThread()
+
+    this.questionCount++
+    this.questionOpenTime = this.Now()
+    this.questionOpen = true
+    fmt.Printf("%s\n", this.swarm.HealthSummary())
+    this.Publish(Event{Type: EventQuestionOpened, Mode: mode, Marks: marks})
+}
+
+
+// Report how long the current question has been open for, 0 if there's no question open.
+func (this *Engine) QuestionTime() time.Duration {
+    this.CheckMainThread()
+
+    if !this.questionOpen { return 0 }
+
+    return this.Now() - this.questionOpenTime
+}
+
+
+// Report that a game mode has closed its question.
+func (this *Engine) QuestionClosed(mode string) {
+    this.CheckMainThread()
+
+    this.questionOpen = false
+    this.Publish(Event{Type: EventQuestionClosed, Mode: mode, Duration: this.Now() - this.questionOpenTime})
+}
+
+
+// Event types.
+const (
+    EventQuestionOpened EventType = iota
+    EventQuestionClosed
+    EventBuzz  // A player's buzz has been accepted and they're answering.
+    EventJudged  // A player's answer has been judged.
+    EventScore  // A team's score has changed.
+    EventRoundStarted
+    EventRoundEnded
+    EventPress  // A button has been pressed, whether or not it counted.
+    EventDisputed  // The user has flagged a question as disputed.
+    EventResult  // A modal command, such as a question, has completed.
+    EventAnnouncement  // Something notable has happened to the scores, see announcer.go.
+    EventRestored  // Scores and round have been restored from those saved by a previous server.
+    EventVisibility  // Scores have been hidden or revealed.
+    EventCommand  // A command has been run, whether typed by the user or not.
+)
+
+type EventType int
+
+// Event type names, as used for searching.
+var _eventTypeNames = []string{"opened", "closed", "buzz", "judged", "score", "roundstart", "roundend", "press",
+    "disputed", "result", "announce", "restore", "visibility", "command"}
+
+
+// Something that happened during the quiz.
+// Only the fields relevant to the event type are filled in.
+type Event struct {
+    Type EventType
+    Time time.Duration  // Since engine creation.
+    Mode string  // Game mode.
+    Question int  // Number of latest question, counting from 1.
+    Round int  // Round number, counting from 1.
+    InRound bool  // For restores, whether the round was still in progress.
+    Buzzer int
+    Team int
+    Correct bool
+    Marks Marks  // For scores this is the change.
+    Duration time.Duration  // How long the question was open for, or for buzzes, was open before the buzz.
+    Note string  // User's note, reason for score change, announcement text or command line.
+    Result *ModalResult  // For results.
+}
+
+
+// Describe this event for the user.
+func (this *Event) String() string {
+    switch this.Type {
+    case EventQuestionOpened:
+        return fmt.Sprintf("Q%d opened, %s for %v marks", this.Question, this.Mode, this.Marks)
+
+    case EventQuestionClosed:
+        return fmt.Sprintf("Q%d closed after %.1fs", this.Question, this.Duration.Seconds())
+
+    case EventBuzz:
+        return fmt.Sprintf("Q%d buzz %s after %.2fs", this.Question, BuzzerIdToPlayerString(this.Buzzer),
+            this.Duration.Seconds())
+
+    case EventJudged:
+        result := "incorrect"
+        if this.Correct { result = "correct" }
+        return fmt.Sprintf("Q%d %s %s", this.Question, BuzzerIdToPlayerString(this.Buzzer), result)
+
+    case EventScore:
+        return fmt.Sprintf("Q%d score team %s %s, %s", this.Question, TeamIdToString(this.Team),
+            signedMarks(this.Marks), this.Note)
+
+    case EventRoundStarted:
+        return fmt.Sprintf("Round %d started", this.Round)
+
+    case EventRoundEnded:
+        return fmt.Sprintf("Round %d ended", this.Round)
+
+    case EventPress:
+        return fmt.Sprintf("Q%d press %s", this.Question, BuzzerIdToPlayerString(this.Buzzer))
+
+    case EventDisputed:
+        return fmt.Sprintf("Q%d disputed: %s", this.Question, this.Note)
+
+    case EventResult:
+        return fmt.Sprintf("Q%d %s", this.Question, this.Result.String())
+
+    case EventAnnouncement:
+        return fmt.Sprintf("Q%d announce: %s", this.Question, this.Note)
+
+    case EventRestored:
+        return fmt.Sprintf("Restored saved scores, round %d", this.Round)
+
+    case EventVisibility:
+        return fmt.Sprintf("Scores %s", this.Note)
+
+    case EventCommand:
+        return fmt.Sprintf("Command %s", this.Note)
+    }
+
+    return fmt.Sprintf("Unknown event %d", this.Type)
+}
+
+
+// Internals.
+
+// Report whether this event matches all of the given search terms.
+func (this *Event) matches(terms []string) bool {
+    hasBuzzer := (this.Type == EventBuzz) || (this.Type == EventJudged) || (this.Type == EventPress)
+    hasTeam := hasBuzzer || (this.Type == EventScore) || (this.Type == EventAnnouncement) ||
+        ((this.Type == EventResult) && (this.Team >= 0))
+
+    for _, term := range terms {
+        match := strings.EqualFold(term, _eventTypeNames[this.Type]) ||
+            (hasBuzzer && strings.EqualFold(term, BuzzerIdToString(this.Buzzer))) ||
+            (hasBuzzer && RosterNameHas(this.Buzzer, term)) ||
+            (hasTeam && strings.EqualFold(term, TeamIdToString(this.Team)))
+
+        if !match { return false }
+    }
+
+    return true
+}
+
+
+// Command handler for searching the event history.
+func (this *Engine) commandSearch(values []int, text string) {
+    this.Search(text)
+}
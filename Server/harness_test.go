@@ -0,0 +1,272 @@
+/* In-process test harness.
+
+Tests run a real engine and swarm, with simulated buzzers connected over in-memory pipes, so they go through the same
+code as a real quiz, from the buzzer protocol up. The test's own Go routine stands in for the engine's main thread,
+running whatever the engine would have run, so tests don't depend on the console or on timing.
+
+*/
+
+package main
+
+import "io"
+import "net"
+import "strings"
+import "sync"
+import "testing"
+import "time"
+
+
+// Create a test harness, keeping its data and logs in a temporary directory.
+func createTestHarness(t testing.TB) *testHarness {
+    dir := t.TempDir()
+    storage, err := CreateFileStorage(dir)
+    if err != nil { t.Fatalf("Could not create storage: %v", err) }
+
+    var p testHarness
+    p.t = t
+    p.engine, p.swarm = CreateEngine(storage, dir)
+    p.engine.AddErrorOutput(p.errorOutput)
+    return &p
+}
+
+
+// Set up a scoreboard, second judge and quick fire controller, as a real quiz would.
+func (this *testHarness) createQuickFire() *QuickFire {
+    this.scoreboard = CreateScoreboard(this.engine)
+    this.judge = CreateJudge(this.engine)
+    this.quickFire = CreateQuickFire(this.engine, this.scoreboard, this.judge)
+    return this.quickFire
+}
+
+
+// Set up a multiple choice controller, with a scoreboard if we don't have one yet.
+func (this *testHarness) createMultipleChoice() *MultipleChoice {
+    if this.scoreboard == nil { this.scoreboard = CreateScoreboard(this.engine) }
+    return CreateMultipleChoice(this.engine, this.scoreboard)
+}
+
+
+// Connect a simulated buzzer, sending the given handshake, and wait for the swarm to hear about it.
+// Returns nil if the handshake was refused.
+func (this *testHarness) connect(handshake ...byte) *testBuzzer {
+    return this.connectWith(HandleNode, handshake...)
+}
+
+
+// Connect a simulated buzzer, handing its connection to the given function, as connect() does.
+func (this *testHarness) connectWith(handle func(net.Conn, *Swarm), handshake ...byte) *testBuzzer {
+    server, client := net.Pipe()
+    this.t.Cleanup(func() { client.Close() })
+
+    // Throw away everything sent to the buzzer, so the swarm's senders never block.
+    go io.Copy(io.Discard, client)
+    handle(server, this.swarm)
+
+    p := &testBuzzer{harness: this, conn: client}
+    if !p.send(handshake...) { return nil }
+
+    this.buzzers = append(this.buzzers, p)
+    this.settle()
+    return p
+}
+
+
+// Connect a simulated buzzer with the given ID and the expected firmware version.
+func (this *testHarness) connectId(id int) *testBuzzer {
+    return this.connect(BuzzerExpectedVersion, 0x80 | byte(id))
+}
+
+
+// Connect a simulated virtual buzzer with the given ID, as connectId() does.
+func (this *testHarness) connectVirtualId(id int) *testBuzzer {
+    return this.connectWith(HandleVirtualNode, BuzzerExpectedVersion, 0x80 | byte(id))
+}
+
+
+// Wait for everything the simulated buzzers have sent so far to be handled, then run whatever that gave the engine's
+// main thread to do.
+func (this *testHarness) settle() {
+    // A buzzer's messages are read one at a time, so once a heartbeat has been read everything before it has been
+    // passed on to the swarm, which handles requests in order. Buzzers that have been disconnected are dropped.
+    connected := []*testBuzzer{}
+    for _, buzzer := range this.buzzers {
+        if buzzer.send(0x31) { connected = append(connected, buzzer) }
+    }
+
+    this.buzzers = connected
+    this.swarm.ConnectedBuzzers()
+    this.runMain()
+}
+
+
+// Run whatever the engine's main thread has waiting, as Engine.Run() would, without waiting for anything more.
+func (this *testHarness) runMain() {
+    for {
+        select {
+        case press := <-this.engine.presses:
+            this.engine.handlePress(press)
+
+        case call := <-this.engine.calls:
+            call()
+
+        default:
+            return
+        }
+    }
+}
+
+
+// Run the given work in another Go routine, running the engine's main thread meanwhile, as Engine.Run() would, so the
+// work can't be held up waiting for the main thread.
+func (this *testHarness) runMainWhile(work func()) {
+    done := make(chan bool)
+    go func() {
+        work()
+        done <- true
+    }()
+
+    for {
+        select {
+        case press := <-this.engine.presses:
+            this.engine.handlePress(press)
+
+        case call := <-this.engine.calls:
+            call()
+
+        case <-done:
+            return
+        }
+    }
+}
+
+
+// Run the engine's main thread for the given time, so timers can fire.
+func (this *testHarness) wait(delay time.Duration) {
+    timeout := time.After(delay)
+
+    for {
+        select {
+        case press := <-this.engine.presses:
+            this.engine.handlePress(press)
+
+        case call := <-this.engine.calls:
+            call()
+
+        case <-timeout:
+            return
+        }
+    }
+}
+
+
+// Report whether the specified buzzer is connected, as the swarm sees it.
+func (this *testHarness) connected(id int) bool {
+    for _, connected := range this.swarm.ConnectedBuzzers() {
+        if connected == id { return true }
+    }
+
+    return false
+}
+
+
+// Report whether the specified buzzer's LED should be on, as the swarm sees it.
+func (this *testHarness) ledOn(id int) bool {
+    response := make(chan bool, 1)
+    this.swarm.requests <- func() { response <- this.swarm.currentMode(id).ledOn }
+    return <-response
+}
+
+
+// Fail the test unless the given game mode is in operation, blank for none, with each of the given command characters
+// registered. With no mode, nothing the last mode registered may be left behind.
+func (this *testHarness) checkMode(mode string, commands string) {
+    if state := this.engine.State(); state.Mode != mode { this.t.Fatalf("In %q, expected %q", state.Mode, mode) }
+
+    for _, cmd := range []byte(commands) {
+        if _, ok := this.engine.commands[cmd]; ok != (mode != "") {
+            this.t.Fatalf("Command %c registered %v in %q", cmd, ok, mode)
+        }
+    }
+
+    if (mode == "") &&
+        ((this.engine.buttonHandler != nil) || (this.engine.gestureHandler != nil) ||
+            (this.engine.releaseHandler != nil)) {
+        this.t.Fatalf("Handlers left registered after mode ended")
+    }
+}
+
+
+// Fail the test unless the given buzzers' LEDs are all on, or all off, as given.
+func (this *testHarness) checkLeds(on bool, ids ...int) {
+    for _, id := range ids {
+        if this.ledOn(id) != on { this.t.Fatalf("Buzzer %s LED on %v, expected %v", BuzzerIdToString(id), !on, on) }
+    }
+}
+
+
+// Fail the test if anything a buzzer sent has caused an internal error.
+func (this *testHarness) checkLog() {
+    for _, line := range this.swarm.Tail(RecentLogLines) {
+        if strings.Contains(line, "Internal error") { this.t.Fatalf("Buzzer log: %s", line) }
+    }
+}
+
+
+// Fail the test if any errors have been reported to the user.
+func (this *testHarness) checkErrors() {
+    this.errorLock.Lock()
+    defer this.errorLock.Unlock()
+
+    if len(this.errors) > 0 { this.t.Fatalf("Errors reported: %v", this.errors) }
+}
+
+
+// Report the errors reported to the user so far, forgetting them.
+func (this *testHarness) takeErrors() []string {
+    this.errorLock.Lock()
+    defer this.errorLock.Unlock()
+
+    errors := this.errors
+    this.errors = nil
+    return errors
+}
+
+
+// Error output, collecting errors reported to the user.
+// May be called from any thread.
+func (this *testHarness) errorOutput(msg string) {
+    this.errorLock.Lock()
+    defer this.errorLock.Unlock()
+
+    this.errors = append(this.errors, msg)
+}
+
+
+// Test harness.
+type testHarness struct {
+    t testing.TB
+    engine *Engine
+    swarm *Swarm
+    scoreboard *Scoreboard  // Nil until a game mode is created.
+    judge *Judge  // Nil until a game mode is created.
+    quickFire *QuickFire  // Nil until created.
+    buzzers []*testBuzzer
+    errors []string  // Errors reported to the user.
+    errorLock sync.Mutex  // Protects errors.
+}
+
+// Simulated buzzer.
+type testBuzzer struct {
+    harness *testHarness
+    conn net.Conn  // Our end of the connection.
+}
+
+
+// Send the given bytes from this buzzer, running the engine's main thread until they've all been read, since the
+// server may need the main thread to handle some of them before it reads the rest.
+// Returns false if the server has closed the connection.
+func (this *testBuzzer) send(data ...byte) bool {
+    var err error
+    this.harness.runMainWhile(func() { _, err = this.conn.Write(data) })
+    return err == nil
+}
@@ -0,0 +1,105 @@
+/* Functions to play buzz sounds on the host's speakers.
+
+Some buzzers have weak sounders, which can't be heard in a noisy venue. Optionally, whenever a buzz is accepted we
+play a sound for the buzzing team on the host, which can be connected to the venue PA.
+
+Each team has its own sound file, specified as a comma separated list of team and file pairs, eg
+"B=blue.wav,R=red.wav". Teams without a file are silent. Guest buzzers, see teams.go, may be given a sound too, with
+the guest letter X, which is played whenever a guest presses outside test mode. Sounds are played by running an external player program,
+such as aplay, with the file as its only argument.
+
+All host audio functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "os"
+import "os/exec"
+import "strings"
+
+
+// Create a host audio player, with the given player program and team sound file mapping.
+func CreateHostAudio(engine *Engine, player string, mapping string) (*HostAudio, error) {
+    var p HostAudio
+    p.player = player
+    p.files = make([]string, GuestTeam + 1)
+    p.enabled = true
+
+    for _, pair := range strings.Split(mapping, ",") {
+        fields := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+        if (len(fields) != 2) || (len(fields[0]) != 1) {
+            return nil, fmt.Errorf("bad team sound %q, expected <team>=<file>", pair)
+        }
+
+        team, ok := decodeBuzzerTeam(fields[0][0])
+        if !ok { return nil, fmt.Errorf("bad team in sound %q", pair) }
+
+        // Missing files aren't fatal, the sound system may be sorted out later.
+        if _, err := os.Stat(fields[1]); err != nil {
+            fmt.Printf("Warning: Sound file for team %s: %v\n", TeamIdToString(team), err)
+        }
+
+        p.files[team] = fields[1]
+    }
+
+    engine.RegisterCmd(p.commandToggle, "Toggle buzz sounds on host speakers", 's')
+    engine.Subscribe(p.event)
+    if p.files[GuestTeam] != "" { engine.AddGuestHook(p.guestPress) }
+
+    fmt.Printf("Playing buzz sounds with %s\n", player)
+    return &p, nil
+}
+
+
+// Play the sound for the specified team, if it has one.
+// May be called from any thread.
+func (this *HostAudio) Play(team int) {
+    file := this.files[team]
+    if file == "" { return }
+
+    cmd := exec.Command(this.player, file)
+    err := cmd.Start()
+    if err != nil {
+        fmt.Printf("Could not play sound %s: %v\n", file, err)
+        return
+    }
+
+    // Reap the player when it's done, without holding anyone up.
+    go cmd.Wait()
+}
+
+
+// Host audio player.
+type HostAudio struct {
+    player string  // Program to play sound files.
+    files []string  // Sound file for each team, and for guests, blank for none.
+    enabled bool
+}
+
+
+// Internals.
+
+// Event handler, playing sounds for accepted buzzes.
+func (this *HostAudio) event(event *Event) {
+    if this.enabled && (event.Type == EventBuzz) { this.Play(event.Team) }
+}
+
+
+// Guest hook, playing the guest sound.
+func (this *HostAudio) guestPress(press Press) {
+    if this.enabled { this.Play(GuestTeam) }
+}
+
+
+// Command handler for toggling buzz sounds.
+func (this *HostAudio) commandToggle([]int) {
+    this.enabled = !this.enabled
+
+    if this.enabled {
+        fmt.Printf("Host buzz sounds on\n")
+    } else {
+        fmt.Printf("Host buzz sounds off\n")
+    }
+}
@@ -0,0 +1,95 @@
+/* Functions to read the buzzer inventory.
+
+To manage the physical fleet, an inventory can be kept, recording the hardware behind each buzzer ID. It is read from a
+text file with one buzzer per line, giving the buzzer ID, its serial number, its purchase date and any notes, such as
+repairs. Lines starting with # are comments. For example:
+
+    # QuizTronic fleet.
+    B1 QT-0001 2021-03-14
+    B2 QT-0002 2021-03-14 New button fitted 2023-01
+    R3 QT-0107 2022-11-02 Sounder weak
+
+The inventory is shown alongside the buzzer stats, on the console and the admin page, so a buzzer that keeps giving
+trouble can be traced back to the hardware.
+
+Inventories are read only at startup, so may be used from any thread.
+
+*/
+
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "strconv"
+import "strings"
+import "time"
+
+
+// Read a buzzer inventory from the given file.
+func LoadInventory(filename string) (Inventory, error) {
+    inventory := make(Inventory)
+
+    file, err := os.Open(filename)
+    if err != nil { return nil, err }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    lineNo := 0
+
+    for scanner.Scan() {
+        lineNo++
+        line := strings.TrimSpace(scanner.Text())
+        if (line == "") || (line[0] == '#') { continue }
+
+        fields := strings.SplitN(line, " ", 4)
+        if len(fields) < 3 {
+            return nil, fmt.Errorf("%s:%d: expected buzzer, serial number and purchase date", filename, lineNo)
+        }
+
+        id, err := parseBuzzerId(fields[0])
+        if err != nil { return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err) }
+
+        var item InventoryItem
+        item.Serial = fields[1]
+        item.Purchased, err = time.Parse("2006-01-02", fields[2])
+        if err != nil { return nil, fmt.Errorf("%s:%d: bad purchase date %q", filename, lineNo, fields[2]) }
+
+        if len(fields) > 3 { item.Notes = strings.TrimSpace(fields[3]) }
+
+        inventory[id] = item
+    }
+
+    err = scanner.Err()
+    if err != nil { return nil, err }
+
+    fmt.Printf("Read inventory of %d buzzers from %s\n", len(inventory), filename)
+    return inventory, nil
+}
+
+
+// Buzzer inventory, indexed by physical buzzer ID.
+type Inventory map[int]InventoryItem
+
+// Inventory details of a single buzzer.
+type InventoryItem struct {
+    Serial string
+    Purchased time.Time
+    Notes string  // Repairs and the like, blank for none.
+}
+
+
+// Internals.
+
+// Parse the given buzzer ID as the user would write it, eg "R3".
+func parseBuzzerId(s string) (int, error) {
+    if len(s) < 2 { return 0, fmt.Errorf("bad buzzer %q", s) }
+
+    team, ok := decodeBuzzerTeam(s[0])
+    if !ok { return 0, fmt.Errorf("bad team in buzzer %q", s) }
+
+    index, err := strconv.Atoi(s[1:])
+    if (err != nil) || (index < 0) || (index > 15) { return 0, fmt.Errorf("bad index in buzzer %q", s) }
+
+    return TeamToBuzzerId(team, index), nil
+}
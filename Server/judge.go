@@ -0,0 +1,134 @@
+/* Functions to have judgements confirmed by a second judge.
+
+For competitive quizzes, judgements entered at the console can be made to require confirmation from a second judge,
+using the web judge page, before they take effect. If the second judge rejects a judgement, or doesn't respond in
+time, the judgement is dropped and the user must enter it again.
+
+Only one judgement may await confirmation at a time. If confirmation is not enabled, judgements take effect
+immediately.
+
+All judge functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "sync"
+import "time"
+
+
+// Create a judge confirmation handler, initially with confirmation disabled.
+func CreateJudge(engine *Engine) *Judge {
+    var p Judge
+    p.engine = engine
+
+    engine.AddStateReporter(p.reportState)
+    return &p
+}
+
+
+// Set how long the second judge has to confirm each judgement.
+// A timeout of 0 disables confirmation.
+func (this *Judge) SetConfirmation(timeout time.Duration) {
+    this.timeout = timeout
+}
+
+
+// Submit a judgement, with the given description, to be confirmed by the second judge.
+// The given commit function is called once the judgement is confirmed, or immediately if confirmation is disabled.
+func (this *Judge) Submit(desc string, commit func()) {
+    if this.timeout == 0 {
+        commit()
+        return
+    }
+
+    if this.commit != nil {
+        fmt.Printf("Judgement \"%s\" still awaiting confirmation\n", this.PendingDesc())
+        return
+    }
+
+    this.count++
+    this.commit = commit
+    this.setPendingDesc(desc)
+    fmt.Printf("Awaiting confirmation from second judge: %s\n", desc)
+
+    count := this.count
+    this.expiryTime = this.engine.Now() + this.timeout
+    this.engine.After(this.timeout, func() {
+        if (count == this.count) && (this.commit != nil) {
+            fmt.Printf("Second judge did not confirm \"%s\" in time, enter judgement again\n", this.PendingDesc())
+            this.Cancel()
+        }
+    })
+}
+
+
+// Drop any judgement awaiting confirmation, eg because the question has been cancelled.
+func (this *Judge) Cancel() {
+    this.commit = nil
+    this.setPendingDesc("")
+}
+
+
+// Confirm or reject the judgement awaiting confirmation.
+// May be called from any thread.
+func (this *Judge) Resolve(confirm bool) {
+    this.engine.After(0, func() {
+        if this.commit == nil { return }
+
+        commit := this.commit
+        desc := this.PendingDesc()
+        this.Cancel()
+
+        if !confirm {
+            fmt.Printf("Second judge rejected \"%s\", enter judgement again\n", desc)
+            return
+        }
+
+        fmt.Printf("Second judge confirmed \"%s\"\n", desc)
+        commit()
+    })
+}
+
+
+// Report the description of the judgement awaiting confirmation, blank for none.
+// May be called from any thread.
+func (this *Judge) PendingDesc() string {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    return this.pendingDesc
+}
+
+
+// Judge confirmation handler.
+type Judge struct {
+    timeout time.Duration  // 0 for confirmation disabled.
+    commit func()  // Commit function for pending judgement, nil for none.
+    count int  // Count of judgements submitted, to identify stale timers.
+    expiryTime time.Duration  // Engine time pending judgement expires.
+    lock sync.Mutex  // Protects pendingDesc.
+    pendingDesc string
+    engine *Engine
+}
+
+
+// Internals.
+
+// Add our details to the given game state.
+func (this *Judge) reportState(state *GameState) {
+    if this.commit == nil { return }
+
+    state.PendingJudgement = this.PendingDesc()
+    state.AddTimer("Judgement confirmation expiry", this.expiryTime, this.engine.Now())
+}
+
+
+// Set the description of the judgement awaiting confirmation.
+func (this *Judge) setPendingDesc(desc string) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    this.pendingDesc = desc
+}
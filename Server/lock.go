@@ -0,0 +1,118 @@
+/* Functions to make sure only one quiz server runs at a time.
+
+Two servers can't both listen for buzzers, and would fight over the persistent data if they could. So each server takes
+a lock file in the storage directory when it starts, recording its PID and session name, and removes it when it exits.
+
+If the lock is already held by a running process, we report which one, so the user can find it, rather than failing
+later with a confusing "address in use". A lock left behind by a server that crashed is simply taken over.
+
+So to recover from a server that's stuck, the user stops it, by its PID if need be, then starts a new one, with -adopt
+to carry on with its scores. -adopt only picks up the saved scores, it never takes a lock from a running server.
+
+*/
+
+package main
+
+import "encoding/json"
+import "errors"
+import "fmt"
+import "os"
+import "path/filepath"
+import "syscall"
+import "time"
+
+
+// Take the lock in the given directory for this process, with the given session name.
+// Fails, describing the holder, if another running server already has it.
+func AcquireLock(dir string, session string) (*Lock, error) {
+    var p Lock
+    p.path = filepath.Join(dir, LockFile)
+    p.info = lockInfo{Pid: os.Getpid(), Session: session, Started: time.Now()}
+
+    data, err := json.Marshal(&p.info)
+    if err != nil { return nil, err }
+
+    // If we find a stale lock we remove it and try again, but only once, in case we're racing another server.
+    for attempt := 0; attempt < 2; attempt++ {
+        file, err := os.OpenFile(p.path, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+        if err == nil {
+            _, err = file.Write(data)
+            file.Close()
+            if err != nil { return nil, err }
+
+            return &p, nil
+        }
+
+        if !errors.Is(err, os.ErrExist) { return nil, err }
+
+        holder, err := readLock(p.path)
+        if err != nil { return nil, fmt.Errorf("lock file %s exists but can't be read: %v", p.path, err) }
+
+        if processAlive(holder.Pid) {
+            return nil, fmt.Errorf("%w, PID %d, session \"%s\", started %s", ErrLockHeld, holder.Pid,
+                holder.Session, holder.Started.Format("2006-01-02 15:04:05"))
+        }
+
+        fmt.Printf("Removing stale lock left by PID %d, session \"%s\"\n", holder.Pid, holder.Session)
+        err = os.Remove(p.path)
+        if err != nil { return nil, err }
+    }
+
+    return nil, fmt.Errorf("could not take lock file %s", p.path)
+}
+
+
+// Release the lock, so another server can start.
+func (this *Lock) Release() {
+    err := os.Remove(this.path)
+    if err != nil { fmt.Printf("Could not remove lock file: %v\n", err) }
+}
+
+
+// Error reported when another running server holds the lock.
+var ErrLockHeld = errors.New("another quiz server is already running")
+
+
+// Lock ensuring only one server runs at a time.
+type Lock struct {
+    path string
+    info lockInfo
+}
+
+
+// Internals.
+
+const LockFile = "quiz.lock"
+
+
+// Details of the server holding a lock, as written to the lock file.
+type lockInfo struct {
+    Pid int
+    Session string
+    Started time.Time
+}
+
+
+// Read the lock file at the given path.
+func readLock(path string) (lockInfo, error) {
+    var info lockInfo
+
+    data, err := os.ReadFile(path)
+    if err != nil { return info, err }
+
+    err = json.Unmarshal(data, &info)
+    return info, err
+}
+
+
+// Report whether the process with the given PID is running.
+func processAlive(pid int) bool {
+    if pid == os.Getpid() { return false }  // Must be left over from a previous run that had our PID.
+
+    process, err := os.FindProcess(pid)
+    if err != nil { return false }
+
+    // Signal 0 checks the process exists without disturbing it. We may not be allowed to signal it, but then it exists.
+    err = process.Signal(syscall.Signal(0))
+    return (err == nil) || errors.Is(err, syscall.EPERM)
+}
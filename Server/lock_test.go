@@ -0,0 +1,30 @@
+package main
+
+import "encoding/json"
+import "errors"
+import "os"
+import "os/exec"
+import "path/filepath"
+import "testing"
+
+
+// Check a lock held by a running server is refused, but one left by a server that's gone is taken over.
+func TestLockTakeover(t *testing.T) {
+    dir := t.TempDir()
+
+    // A process that's been and gone.
+    gone := exec.Command("true")
+    err := gone.Run()
+    if err != nil { t.Skipf("Can't run a process to leave a stale lock: %v", err) }
+
+    for _, test := range []struct { pid int; held bool }{{1, true}, {gone.Process.Pid, false}} {
+        data, _ := json.Marshal(lockInfo{Pid: test.pid, Session: "other"})
+        err = os.WriteFile(filepath.Join(dir, LockFile), data, 0644)
+        if err != nil { t.Fatalf("Could not write lock file: %v", err) }
+
+        lock, err := AcquireLock(dir, "test")
+        if errors.Is(err, ErrLockHeld) != test.held { t.Fatalf("Lock held by PID %d gave %v", test.pid, err) }
+        if !test.held && (err != nil) { t.Fatalf("Stale lock not taken over: %v", err) }
+        if lock != nil { lock.Release() }
+    }
+}
@@ -0,0 +1,177 @@
+/* Functions to export server metrics, for graphing buzzer health over a long quiz night.
+
+Metrics are served at /metrics, see web.go, in the Prometheus text format, so Prometheus can scrape them and anything
+that reads Prometheus can graph them. Everything is taken from what the swarm, sender and engine track anyway, as
+follows:
+* Buzzers known and connected.
+* Per buzzer, whether it's connected, its slow messages, disconnects, errors and presses, its latest battery level and
+  signal strength, if reported, and a histogram of its round trip times, see swarm.go.
+* Requests waiting for the swarm, and sends waiting for each sender worker, so a backlog shows up.
+* Commands run, per command, and questions opened.
+
+Counters are for this run of the server, except slow messages, disconnects and errors, which are saved totals. Press
+rates, for example, come from Prometheus's rate() of the presses counter.
+
+All metrics functions may be called from any thread, except the main thread.
+
+*/
+
+package main
+
+import "fmt"
+import "io"
+import "sort"
+import "time"
+
+
+// Write all metrics to the given writer, in the Prometheus text format.
+func WriteMetrics(w io.Writer, engine *Engine, swarm *Swarm) {
+    var commandCounts map[byte]int
+    var questions int
+    engine.CallAndWait(func() {
+        commandCounts = engine.CommandCounts()
+        questions = engine.State().Question
+    })
+
+    stats := swarm.Stats()
+    connected := 0
+    for _, s := range stats {
+        if s.Connected { connected++ }
+    }
+
+    writeMetric(w, "buzzers_known", "gauge", "Buzzers seen since the server started.",
+        metricSample{"", float64(len(stats))})
+    writeMetric(w, "buzzers_connected", "gauge", "Buzzers currently connected.", metricSample{"", float64(connected)})
+
+    perBuzzer := func(name string, kind string, help string, value func(s BuzzerStats) (float64, bool)) {
+        samples := []metricSample{}
+        for _, s := range stats {
+            if v, ok := value(s); ok { samples = append(samples, metricSample{buzzerLabel(s.Id), v}) }
+        }
+
+        writeMetric(w, name, kind, help, samples...)
+    }
+
+    perBuzzer("buzzer_connected", "gauge", "Whether the buzzer is connected.",
+        func(s BuzzerStats) (float64, bool) { return boolMetric(s.Connected), true })
+    perBuzzer("buzzer_presses_total", "counter", "Button presses this run.",
+        func(s BuzzerStats) (float64, bool) { return float64(s.PressesRun), true })
+    perBuzzer("buzzer_disconnects_total", "counter", "Disconnects, in total.",
+        func(s BuzzerStats) (float64, bool) { return float64(s.DisconnectsTotal), true })
+    perBuzzer("buzzer_errors_total", "counter", "Error and unrecognised messages, in total.",
+        func(s BuzzerStats) (float64, bool) { return float64(s.ErrorsTotal), true })
+    perBuzzer("buzzer_battery_percent", "gauge", "Latest battery level reported.",
+        func(s BuzzerStats) (float64, bool) { return float64(s.Battery), s.Battery >= 0 })
+    perBuzzer("buzzer_rssi_dbm", "gauge", "Latest WiFi signal strength reported.",
+        func(s BuzzerStats) (float64, bool) { return float64(s.Rssi), s.Rssi != 0 })
+    perBuzzer("buzzer_round_trips_lost_total", "counter", "Timed pings unanswered this run.",
+        func(s BuzzerStats) (float64, bool) { return float64(s.RttsLost), true })
+
+    // Slow messages are split by how slow.
+    slow := []metricSample{}
+    for _, s := range stats {
+        slow = append(slow, metricSample{buzzerLabel(s.Id) + `,gap="2s-3s"`, float64(s.Slow2sTotal)},
+            metricSample{buzzerLabel(s.Id) + `,gap="over3s"`, float64(s.Slow3sTotal)})
+    }
+
+    writeMetric(w, "buzzer_slow_messages_total", "counter",
+        "Messages arriving 2s to 3s, or over 3s, after the previous one, in total.", slow...)
+
+    rtts := []histogramSample{}
+    for _, s := range stats {
+        rtts = append(rtts, histogramSample{buzzerLabel(s.Id), s.Rtts, s.RttTotal.Seconds()})
+    }
+
+    writeHistogram(w, "buzzer_round_trip_seconds", "Round trip times of timed pings this run.", LatencyBuckets, rtts)
+
+    writeMetric(w, "swarm_queue_depth", "gauge", "Requests waiting for the swarm.",
+        metricSample{"", float64(swarm.QueueDepth())})
+
+    depths := []metricSample{}
+    for worker, depth := range swarm.sender.QueueDepths() {
+        depths = append(depths, metricSample{fmt.Sprintf(`worker="%d"`, worker), float64(depth)})
+    }
+
+    writeMetric(w, "send_queue_depth", "gauge", "Sends waiting for each sender worker.", depths...)
+
+    commands := []metricSample{}
+    for cmd, count := range commandCounts {
+        commands = append(commands, metricSample{fmt.Sprintf(`command=%q`, string(cmd)), float64(count)})
+    }
+
+    sort.Slice(commands, func(i, j int) bool { return commands[i].labels < commands[j].labels })
+    writeMetric(w, "commands_total", "counter", "Commands run this run, per command.", commands...)
+    writeMetric(w, "questions_total", "counter", "Questions opened this run.", metricSample{"", float64(questions)})
+    writeMetric(w, "uptime_seconds", "gauge", "Time since the server started.",
+        metricSample{"", engine.Now().Seconds()})
+}
+
+
+// Internals.
+
+// Prefix for all our metric names.
+const MetricPrefix = "quiztronic_"
+
+// A single sample of a metric.
+type metricSample struct {
+    labels string  // Comma separated name="value" pairs, without braces, blank for none.
+    value float64
+}
+
+
+// Write the given metric, with its header, to the given writer.
+func writeMetric(w io.Writer, name string, kind string, help string, samples ...metricSample) {
+    name = MetricPrefix + name
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+
+    for _, sample := range samples {
+        if sample.labels == "" {
+            fmt.Fprintf(w, "%s %v\n", name, sample.value)
+        } else {
+            fmt.Fprintf(w, "%s{%s} %v\n", name, sample.labels, sample.value)
+        }
+    }
+}
+
+
+// A single sample of a histogram.
+type histogramSample struct {
+    labels string  // As for metricSample, but never blank.
+    counts []int  // Observations in each bucket, not cumulative, then a final bucket for anything above the last bound.
+    sum float64  // Of all observations.
+}
+
+
+// Write the given histogram, with its header, to the given writer.
+// The buckets' upper bounds are given as durations, and written in seconds.
+func writeHistogram(w io.Writer, name string, help string, bounds []time.Duration, samples []histogramSample) {
+    name = MetricPrefix + name
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+    for _, sample := range samples {
+        // Prometheus buckets are cumulative.
+        count := 0
+        for bucket, n := range sample.counts {
+            count += n
+            le := "+Inf"
+            if bucket < len(bounds) { le = fmt.Sprint(bounds[bucket].Seconds()) }
+            fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, sample.labels, le, count)
+        }
+
+        fmt.Fprintf(w, "%s_sum{%s} %v\n%s_count{%s} %d\n", name, sample.labels, sample.sum, name, sample.labels, count)
+    }
+}
+
+
+// Return the label identifying the given buzzer.
+func buzzerLabel(id int) string {
+    return fmt.Sprintf(`buzzer="%s"`, BuzzerIdToString(id))
+}
+
+
+// Convert the given flag to a metric value.
+func boolMetric(b bool) float64 {
+    if b { return 1 }
+    return 0
+}
+
@@ -8,9 +8,17 @@ Operation is as follows:
    team's others are de-illuminated.
 3. If a team presses a different multiple choice button, that is recorded and the illuminations are updated
    accordingly.
-4. When the user tells the controller to continue, any team with the correct answer gets a mark. All buttons are
+4. A team may confirm its choice with a long press of the chosen button, after which its choice can't be changed, so
+   an accidental press can't spoil a considered answer. Only buzzers from version 8 report long presses.
+5. While the question is open, the number of teams that have chosen, but not what they chose, is added to the game
+   state, so the user and the audience display can see when everyone has answered. The user is told when they have.
+6. When the user tells the controller to continue, any team with the correct answer gets a mark. All buttons are
    de-illuminated.
 
+Instead of completing the question in one go, the user may reveal the results in stages, for dramatic effect. The first
+step locks the choices and shows each team's choice on the display. The next shows the correct answer. The last awards
+the marks and completes the question, as above. The user can complete the question at any point.
+
 All multiple choice functions and methods must be called only in the main thread, unless otherwise stated.
 
 */
@@ -26,6 +34,21 @@ func CreateMultipleChoice(engine *Engine, scoreboard *Scoreboard) *MultipleChoic
     p.engine = engine
     p.scoreboard = scoreboard
 
+    p.states = CreateStateMachine(engine, "multiple choice")
+    p.states.AllowTransitions(StateIdle, StateOpen)
+    p.states.AllowTransitions(StateOpen, MultipleChoiceShowingChoices, StateIdle)
+    p.states.AllowTransitions(MultipleChoiceShowingChoices, MultipleChoiceShowingAnswer, StateIdle)
+    p.states.AllowTransitions(MultipleChoiceShowingAnswer, StateIdle)
+
+    open := []string{StateOpen}
+    question := []string{StateOpen, MultipleChoiceShowingChoices, MultipleChoiceShowingAnswer}
+    p.states.RegisterCmd(question, p.commandComplete, "Complete current question", 'y')
+    p.states.RegisterCmd(question, p.commandCancel, "Cancel current question", 'q')
+    p.states.RegisterCmd(question, p.commandReveal,
+        "Reveal next stage of results, team choices, then correct answer, then scores", 'u')
+    p.states.RegisterButtons(open, p.button)
+    p.states.RegisterGestures(open, p.gesture)
+
     engine.RegisterModal(p.commandNewQuestion, "multiple choice", "Start a multiple choice question", 'm',
         ARG_MULTIPLE_CHOICE, ARG_MARKS)
 
@@ -34,28 +57,40 @@ func CreateMultipleChoice(engine *Engine, scoreboard *Scoreboard) *MultipleChoic
 
 
 // Start a new multiple choice question.
-func (this *MultipleChoice) NewQuestion(answer int, marks int) {
+func (this *MultipleChoice) NewQuestion(answer int, marks Marks) {
     this.correctAnswer = answer
     this.marks = marks
-    // TODO: Remove embedded team count.
-    this.teamChoices = make([]int, 4)
+    this.teamChoices = make([]int, TeamCount)
     for i := range this.teamChoices { this.teamChoices[i] = -1 }
+    this.teamsConfirmed = make([]bool, TeamCount)
 
-    // Illuminate all multiple choice buzzers.
+    // Illuminate all connected multiple choice buzzers.
     this.engine.SetModeAll(false, false)
 
-    for team := 0; team < 4; team++ {
-        // TODO: Remove embedded multiple choice answer count.
-        for i := 0; i < 5; i++ {
-            buzzer := TeamToBuzzerId(team, i)
-            this.engine.SetMode(buzzer, true, false)
+    this.teamsPlaying = 0
+    for team := 0; team < TeamCount; team++ {
+        count := 0
+
+        for _, buzzer := range this.engine.TeamBuzzers(team) {
+            _, i := BuzzerIdToTeam(buzzer)
+            if i < MultipleChoiceCount {
+                this.engine.SetMode(buzzer, true, false)
+                count++
+            }
+        }
+
+        if count == 0 {
+            fmt.Printf("Warning: Team %s has no multiple choice buzzers connected\n", TeamIdToString(team))
+        } else {
+            this.teamsPlaying++
         }
     }
 
     // Register for needed inputs for duration of question.
-    this.engine.RegisterCmd(this.commandComplete, "Complete current question", 'y')
-    this.engine.RegisterCmd(this.commandCancel, "Cancel current question", 'q')
-    this.engine.RegisterButtons(this.button)
+    this.states.Change(StateOpen)
+    this.engine.SetModalCancel(this.Cancel)
+    this.engine.SetModalState(this.reportState)
+    this.engine.QuestionOpened("multiple choice", marks)
 }
 
 
@@ -63,37 +98,66 @@ func (this *MultipleChoice) NewQuestion(answer int, marks int) {
 func (this *MultipleChoice) Complete() {
     // Check if any team had the correct answer.
     correctTeams := ""
+    result := CreateModalResult(OutcomeCompleted)
+    correctCount := 0
 
     for team, choice := range this.teamChoices {
         if choice == this.correctAnswer {
-            this.scoreboard.Add(team, this.marks)
+            reason := fmt.Sprintf("multiple choice, answered %c", 'A' + rune(choice))
+            result.Award(team, this.scoreboard.Award(team, this.marks, reason))
             correctTeams += " " + TeamIdToString(team)
+            correctCount++
+            result.Winner = team
         }
     }
 
+    // Only a team that was alone in getting it right has won outright.
+    if correctCount != 1 { result.Winner = -1 }
+
     if correctTeams != "" {
         fmt.Printf("Teams who got it right:%s\n", correctTeams)
-        this.scoreboard.Print()
     } else {
         fmt.Printf("No teams got it right\n")
     }
 
-    this.finish()
+    this.finish(result)
+}
+
+
+// Reveal the next stage of the results.
+func (this *MultipleChoice) Reveal() {
+    switch this.states.State() {
+    case StateOpen:
+        // Choices can no longer be changed once they're shown.
+        this.states.Change(MultipleChoiceShowingChoices)
+        fmt.Printf("Showing choices, choices locked\n")
+        this.printChoices()
+
+    case MultipleChoiceShowingChoices:
+        this.states.Change(MultipleChoiceShowingAnswer)
+        fmt.Printf("Showing correct answer %c\n", 'A' + rune(this.correctAnswer))
+
+    case MultipleChoiceShowingAnswer:
+        this.Complete()
+    }
 }
 
 
 // Cancel the current question.
 func (this *MultipleChoice) Cancel() {
     // Nothing special to do.
-    this.finish()
+    this.finish(CreateModalResult(OutcomeCancelled))
 }
 
 
 // Multiple choice controller.
 type MultipleChoice struct {
     correctAnswer int
-    marks int
+    marks Marks
     teamChoices []int
+    teamsConfirmed []bool  // Teams whose choices can no longer change.
+    teamsPlaying int  // Teams with multiple choice buzzers connected when the question started.
+    states *StateMachine
     scoreboard *Scoreboard
     engine *Engine
 }
@@ -101,11 +165,60 @@ type MultipleChoice struct {
 
 // Internals.
 
+// Number of multiple choice answers, the first buzzers in each team are used for them.
+const MultipleChoiceCount = 5
+
+// States, other than idle and open.
+const (
+    MultipleChoiceShowingChoices = "showing choices"
+    MultipleChoiceShowingAnswer = "showing answer"
+)
+
+
+// Add our details to the given game state.
+// Teams can change their choices until the question is complete.
+func (this *MultipleChoice) reportState(state *GameState) {
+    state.Marks = this.marks
+    state.Armed = this.states.In(StateOpen)
+
+    if state.Armed {
+        for team := 0; team < TeamCount; team++ {
+            state.TeamsAllowed = append(state.TeamsAllowed, TeamIdToString(team))
+        }
+
+        // Teams whose buzzers connect during the question can still choose.
+        state.ChoicesMade = this.choicesMade()
+        state.ChoicesExpected = maxInt(this.teamsPlaying, state.ChoicesMade)
+    }
+
+    if this.states.In(MultipleChoiceShowingChoices, MultipleChoiceShowingAnswer) {
+        for _, choice := range this.teamChoices {
+            letter := ""
+            if choice >= 0 { letter = string('A' + rune(choice)) }
+            state.Choices = append(state.Choices, letter)
+        }
+    }
+
+    if this.states.In(MultipleChoiceShowingAnswer) { state.CorrectChoice = string('A' + rune(this.correctAnswer)) }
+}
+
+
+// Report how many teams have chosen an answer.
+func (this *MultipleChoice) choicesMade() int {
+    count := 0
+    for _, choice := range this.teamChoices {
+        if choice >= 0 { count++ }
+    }
+
+    return count
+}
+
+
 // Button press handler.
-func (this *MultipleChoice) button(id int) {
-    team, choice := BuzzerIdToTeam(id)
+func (this *MultipleChoice) button(press Press) {
+    team, choice := BuzzerIdToTeam(press.Buzzer)
 
-    if choice > 4 {
+    if choice >= MultipleChoiceCount {
         // Not a valid multiple choice button, ignore press.
         return
     }
@@ -115,8 +228,15 @@ func (this *MultipleChoice) button(id int) {
         return
     }
 
+    if this.teamsConfirmed[team] {
+        fmt.Printf("Team %s has confirmed %c, change to %c ignored\n", TeamIdToString(team),
+            'A' + rune(this.teamChoices[team]), 'A' + rune(choice))
+        return
+    }
+
     // Report choice, then record it.
-    if this.teamChoices[team] < 0 {
+    first := this.teamChoices[team] < 0
+    if first {
         // TODO: Add choiceToRune() function?
         fmt.Printf("Team %s selected %c    ", TeamIdToString(team), 'A' + rune(choice))
     } else {
@@ -126,17 +246,29 @@ func (this *MultipleChoice) button(id int) {
     this.teamChoices[team] = choice
     this.printChoices()
 
+    if first && (this.choicesMade() >= this.teamsPlaying) { fmt.Printf("All teams have chosen\n") }
+
     // Adjust illuminated buzzers accordingly.
-    for i := 0; i < 5; i++ {
+    for i := 0; i < MultipleChoiceCount; i++ {
         ledOn := (i == choice)
         this.engine.SetMode(TeamToBuzzerId(team, i), ledOn, false)
     }
 }
 
 
+// Gesture handler, confirming a team's choice on a long press of the chosen button.
+func (this *MultipleChoice) gesture(buzzerId int, gesture Gesture) {
+    team, choice := BuzzerIdToTeam(buzzerId)
+    if (gesture != GestureLong) || (choice != this.teamChoices[team]) || this.teamsConfirmed[team] { return }
+
+    this.teamsConfirmed[team] = true
+    fmt.Printf("Team %s confirmed %c\n", TeamIdToString(team), 'A' + rune(choice))
+}
+
+
 // Command handler for starting a new question.
 func (this *MultipleChoice) commandNewQuestion(values []int) {
-    this.NewQuestion(values[0], values[1])
+    this.NewQuestion(values[0], Marks(values[1]))
 }
 
 
@@ -146,6 +278,12 @@ func (this *MultipleChoice) commandComplete(values []int) {
 }
 
 
+// Command handler for revealing the next stage of the results.
+func (this *MultipleChoice) commandReveal(values []int) {
+    this.Reveal()
+}
+
+
 // Command handler for cancelling the current question.
 func (this *MultipleChoice) commandCancel(values []int) {
     this.Cancel()
@@ -167,13 +305,12 @@ func (this *MultipleChoice) printChoices() {
 }
 
 
-// Finish the current question.
-func (this *MultipleChoice) finish() {
+// Finish the current question, with the given result.
+func (this *MultipleChoice) finish(result ModalResult) {
     // Unregister everything we temporarily registered.
-    this.engine.DeregisterCmd(this.commandComplete, 'y')
-    this.engine.DeregisterCmd(this.commandCancel, 'q')
-    this.engine.DeregisterButtons(this.button)
-    this.engine.ModalComplete()
+    this.states.Change(StateIdle)
+    this.engine.QuestionClosed("multiple choice")
+    this.engine.ModalComplete(result)
 
     // De-illuminate all multiple choice buzzers.
     this.engine.SetModeAll(false, false)
@@ -0,0 +1,82 @@
+package main
+
+import "reflect"
+import "testing"
+
+
+// Check teams' choices light only the chosen buzzer, can be changed until revealed, and are marked once complete.
+func TestMultipleChoice(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createMultipleChoice()
+    blueA := harness.connectId(0x00)
+    harness.connectId(0x01)
+    blueC := harness.connectId(0x02)
+    harness.connectId(0x05)  // Not a multiple choice buzzer.
+    harness.connectId(0x10)
+    greenB := harness.connectId(0x11)
+
+    harness.engine.processCommand("mB2")
+    harness.checkMode("multiple choice", "yqu")
+    harness.checkLeds(true, 0x00, 0x01, 0x02, 0x10, 0x11)
+    harness.checkLeds(false, 0x05)
+
+    // Changing a choice moves the light.
+    harness.press(blueC)
+    harness.checkLeds(true, 0x02)
+    harness.checkLeds(false, 0x00, 0x01)
+
+    harness.press(blueA)
+    harness.press(greenB)
+    harness.checkLeds(true, 0x00, 0x11)
+    harness.checkLeds(false, 0x01, 0x02, 0x10)
+    if made := harness.engine.State().ChoicesMade; made != 2 { t.Fatalf("%d choices made, expected 2", made) }
+
+    // Once shown, choices are locked.
+    harness.engine.processCommand("u")
+    harness.press(blueC)
+    if choices := harness.engine.State().Choices[:2]; !reflect.DeepEqual(choices, []string{"A", "B"}) {
+        t.Fatalf("Choices %v, expected A B", choices)
+    }
+
+    harness.engine.processCommand("u")
+    if answer := harness.engine.State().CorrectChoice; answer != "B" { t.Fatalf("Answer %q shown, expected B", answer) }
+
+    harness.engine.processCommand("u")
+    harness.checkScores(0, WholeMarks(2))
+    harness.checkMode("", "yqu")
+    harness.checkLeds(false, 0x00, 0x11)
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check a team can confirm its choice with a long press, after which it can't be changed, and that cancelling a
+// question marks no one.
+func TestMultipleChoiceConfirm(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createMultipleChoice()
+    blueA := harness.connectId(0x00)
+    blueB := harness.connectId(0x01)
+    greenA := harness.connectId(0x10)
+
+    harness.engine.processCommand("mA1")
+    harness.press(blueA)
+    blueA.send(0x34)
+    harness.settle()
+    harness.press(blueB)
+    harness.checkLeds(true, 0x00)
+    harness.checkLeds(false, 0x01)
+
+    harness.engine.processCommand("y")
+    harness.checkScores(WholeMarks(1), 0)
+    harness.checkMode("", "yqu")
+
+    harness.engine.processCommand("mA1")
+    harness.press(greenA)
+    harness.engine.processCommand("q")
+    harness.checkScores(WholeMarks(1), 0)
+    harness.checkMode("", "yqu")
+    harness.checkLeds(false, 0x00, 0x01, 0x10)
+    harness.checkErrors()
+    harness.checkLog()
+}
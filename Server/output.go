@@ -0,0 +1,46 @@
+/* Functions to report errors to the user.
+
+User facing errors, such as a mistyped command, are reported through the engine rather than printed directly. The
+engine passes each error on to every registered output, eg the console, the buzzer log and any remote consoles, so an
+error is seen wherever the user happens to be looking and ends up in the log.
+
+Components that can fail, such as the command parser, return errors to the engine to report, so they never print
+anything themselves.
+
+All output functions and methods may be called from any thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+
+
+// Register the given function to be given every error reported to the user.
+// The function may be called from any thread.
+// Must be called only in the main thread, before anything else is started.
+func (this *Engine) AddErrorOutput(output ErrorOutput) {
+    this.errorOutputs = append(this.errorOutputs, output)
+}
+
+// Function to report a single error message, which has no trailing newline.
+type ErrorOutput func (msg string)
+
+
+// Report an error to the user, on all registered outputs.
+// No trailing newline is needed.
+func (this *Engine) Errorf(format string, args ...interface{}) {
+    msg := fmt.Sprintf(format, args...)
+
+    for _, output := range this.errorOutputs {
+        output(msg)
+    }
+}
+
+
+// Internals.
+
+// Error output writing to the console.
+func consoleErrorOutput(msg string) {
+    fmt.Println(msg)
+}
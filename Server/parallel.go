@@ -0,0 +1,268 @@
+/* Functions to handle parallel team challenges.
+
+In a parallel challenge all teams work on the same question at once, such as solving an anagram, and write down their
+answers.
+
+A parallel challenge controller lives for arbitrarily many questions.
+
+Operation is as follows:
+1. When each question starts all of the buzzers are de-illuminated.
+2. When a team has finished they press any of their buttons. That button is illuminated and buzzes, and the team's
+   finishing position and time are recorded. Further presses from that team are ignored.
+3. The user marks each team's written answer as correct or incorrect, in any order, at any time.
+4. When every team has been marked, each correct team gets the question's marks. Correct teams also get a speed bonus,
+   according to the order they finished in. The fastest correct team gets the full bonus, the next 1 less, and so on.
+   Teams that never pressed their button get no bonus.
+
+All parallel challenge functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "time"
+
+
+// Create a parallel challenge controller.
+func CreateParallelChallenge(engine *Engine, scoreboard *Scoreboard, judge *Judge) *ParallelChallenge {
+    var p ParallelChallenge
+    p.engine = engine
+    p.scoreboard = scoreboard
+    p.judge = judge
+
+    p.states = CreateStateMachine(engine, "parallel challenge")
+    p.states.AllowTransitions(StateIdle, StateOpen)
+    p.states.AllowTransitions(StateOpen, StateIdle)
+
+    open := []string{StateOpen}
+    p.states.RegisterCmd(open, p.commandCorrect, "Team answered correctly", 'y', ARG_TEAM)
+    p.states.RegisterCmd(open, p.commandIncorrect, "Team answered incorrectly", 'n', ARG_TEAM)
+    p.states.RegisterCmd(open, p.commandCancel, "Cancel current question", 'q')
+    p.states.RegisterButtons(open, p.button)
+
+    engine.RegisterModal(p.commandNewQuestion, "parallel challenge",
+        "Start a parallel challenge with marks and fastest correct bonus", 'p', ARG_MARKS, ARG_MARKS)
+
+    return &p
+}
+
+
+// Start a new parallel challenge question.
+func (this *ParallelChallenge) NewQuestion(marks Marks, bonus Marks) {
+    this.marks = marks
+    this.bonus = bonus
+    this.finishOrder = []int{}
+    this.finishTimes = make([]time.Duration, TeamCount)
+    this.judgements = make([]int, TeamCount)
+    for i := range this.judgements { this.judgements[i] = JudgementNone }
+
+    // De-illuminate all buzzers.
+    this.engine.SetModeAll(false, false)
+
+    // Register for needed inputs for duration of question.
+    this.states.Change(StateOpen)
+    this.engine.SetModalCancel(this.Cancel)
+    this.engine.SetModalState(this.reportState)
+    this.engine.QuestionOpened("parallel challenge", marks)
+    this.startTime = this.engine.Now()
+
+    fmt.Printf("Parallel challenge for %v marks, fastest correct bonus %v\n", marks, bonus)
+}
+
+
+// Mark the specified team's answer.
+func (this *ParallelChallenge) Judge(team int, correct bool) {
+    if correct {
+        this.judgements[team] = JudgementCorrect
+    } else {
+        this.judgements[team] = JudgementIncorrect
+    }
+
+    this.printJudgements()
+
+    // Check if every team has been marked.
+    for _, judgement := range this.judgements {
+        if judgement == JudgementNone { return }
+    }
+
+    this.complete()
+}
+
+
+// Cancel the current question.
+func (this *ParallelChallenge) Cancel() {
+    // Nothing special to do.
+    this.finish(CreateModalResult(OutcomeCancelled))
+}
+
+
+// Parallel challenge controller.
+type ParallelChallenge struct {
+    marks Marks
+    bonus Marks
+    startTime time.Duration
+    finishOrder []int  // Teams in the order they finished.
+    finishTimes []time.Duration  // Indexed by team, 0 for not finished.
+    judgements []int  // Indexed by team.
+    states *StateMachine
+    scoreboard *Scoreboard
+    judge *Judge
+    engine *Engine
+}
+
+
+// Internals.
+
+// Judgements of a team's answer.
+const (
+    JudgementNone = iota
+    JudgementCorrect
+    JudgementIncorrect
+)
+
+
+// Add our details to the given game state.
+func (this *ParallelChallenge) reportState(state *GameState) {
+    state.Marks = this.marks
+    state.Armed = true
+
+    for team, finishTime := range this.finishTimes {
+        if finishTime == 0 { state.TeamsAllowed = append(state.TeamsAllowed, TeamIdToString(team)) }
+    }
+}
+
+
+// Button press handler.
+func (this *ParallelChallenge) button(press Press) {
+    id := press.Buzzer
+    team, _ := BuzzerIdToTeam(id)
+
+    for _, t := range this.finishOrder {
+        if t == team {
+            // This team has already finished, ignore press.
+            return
+        }
+    }
+
+    // This is the first press for this team.
+    elapsed := press.Time - this.startTime
+    this.finishOrder = append(this.finishOrder, team)
+    this.finishTimes[team] = elapsed
+    this.engine.SetMode(id, true, true)
+
+    fmt.Printf("Team %s finished %s after %.1fs (%s)\n", TeamIdToString(team), placeString(len(this.finishOrder)),
+        elapsed.Seconds(), BuzzerIdToPlayerString(id))
+}
+
+
+// Award marks and bonuses, then finish the current question.
+func (this *ParallelChallenge) complete() {
+    // Give marks and bonuses to correct teams, in finishing order.
+    bonus := this.bonus
+    awards := ""
+    result := CreateModalResult(OutcomeCompleted)
+
+    for _, team := range this.finishOrder {
+        if this.judgements[team] != JudgementCorrect { continue }
+
+        // The first correct team to finish has won.
+        if result.Winner < 0 { result.Winner = team }
+
+        marks := this.scoreboard.Award(team, this.marks + bonus,
+            fmt.Sprintf("parallel challenge, correct with bonus %v", bonus))
+        result.Award(team, marks)
+        awards += fmt.Sprintf(" %s:%v+%v", TeamIdToString(team), this.marks, bonus)
+
+        bonus -= WholeMarks(1)
+        if bonus < 0 { bonus = 0 }
+    }
+
+    // Teams that didn't finish still get their marks.
+    for team, judgement := range this.judgements {
+        if (judgement != JudgementCorrect) || (this.finishTimes[team] != 0) { continue }
+
+        result.Award(team, this.scoreboard.Award(team, this.marks, "parallel challenge, correct without finishing"))
+        awards += fmt.Sprintf(" %s:%v", TeamIdToString(team), this.marks)
+    }
+
+    if awards == "" {
+        fmt.Printf("No teams got it right\n")
+    } else {
+        fmt.Printf("Marks awarded:%s\n", awards)
+    }
+
+    this.finish(result)
+}
+
+
+// Command handler for starting a new question.
+func (this *ParallelChallenge) commandNewQuestion(values []int) {
+    this.NewQuestion(Marks(values[0]), Marks(values[1]))
+}
+
+
+// Command handler for a team's answer being correct.
+func (this *ParallelChallenge) commandCorrect(values []int) {
+    this.judge.Submit("Team " + TeamIdToString(values[0]) + " correct", func() { this.Judge(values[0], true) })
+}
+
+
+// Command handler for a team's answer being incorrect.
+func (this *ParallelChallenge) commandIncorrect(values []int) {
+    this.judge.Submit("Team " + TeamIdToString(values[0]) + " incorrect", func() { this.Judge(values[0], false) })
+}
+
+
+// Command handler for cancelling the current question.
+func (this *ParallelChallenge) commandCancel(values []int) {
+    this.Cancel()
+}
+
+
+// Print current judgements.
+func (this *ParallelChallenge) printJudgements() {
+    s := ""
+
+    for team, judgement := range this.judgements {
+        letter := '-'
+        switch judgement {
+        case JudgementCorrect:      letter = 'y'
+        case JudgementIncorrect:    letter = 'n'
+        }
+
+        s += fmt.Sprintf(" %s:%c", TeamIdToString(team), letter)
+    }
+
+    fmt.Printf("Judged:%s\n", s)
+}
+
+
+// Finish the current question, with the given result.
+func (this *ParallelChallenge) finish(result ModalResult) {
+    this.judge.Cancel()
+
+    // Unregister everything we temporarily registered.
+    this.states.Change(StateIdle)
+    this.engine.QuestionClosed("parallel challenge")
+    this.engine.ModalComplete(result)
+
+    // De-illuminate all buzzers.
+    this.engine.SetModeAll(false, false)
+}
+
+
+// Return the given 1 based place as a string, eg "1st".
+func placeString(place int) string {
+    suffix := "th"
+
+    if (place % 100 < 11) || (place % 100 > 13) {
+        switch place % 10 {
+        case 1: suffix = "st"
+        case 2: suffix = "nd"
+        case 3: suffix = "rd"
+        }
+    }
+
+    return fmt.Sprintf("%d%s", place, suffix)
+}
@@ -3,7 +3,8 @@
 A quick fire controller lives for arbitrarily many questions.
 
 Operation is as follows:
-1. When each question starts all of the buzzers are de-illuminated.
+1. When each question starts all of the buzzers are de-illuminated. Button presses are ignored while the question is
+   being read out, until the user arms the question, either immediately or after a given delay.
 2. When the first player presses their button, it is illuminated and buzzers.
 3. We wait for input from the user. While waiting, no further illumination changes occur, but we record relevant
    button presses.
@@ -14,6 +15,50 @@ Operation is as follows:
 6. We continue in this fashion until a player gets the right answer, all teams have had an incorrect guess or the user
    indicates to stop.
 
+Optionally, a question may be limited to a number of attempts, to keep the pace brisk. Once that many answers have
+been judged incorrect, the question closes with no winner and the user is prompted to give the correct answer.
+
+Optionally, arming the question starts a 3-2-1-go count in. All buzzers flash for each count, then flash and buzz for
+go, at which point the question is armed. This gives every team the same visual start signal.
+
+Optionally, when the first player presses their button we wait for a short adjudication window before acknowledging
+them, collecting any other presses that arrive within it. If the first two presses are within a near tie margin, we
+report this to the user, who may give the buzz to any of the tied players instead of the one whose press arrived
+first.
+
+Optionally, players must hold their button down to answer. A player who lets go before their answer is judged
+withdraws their buzz, without it counting as an attempt, and their team may buzz again. A player whose press is pending
+must keep holding too, or they're skipped when their turn comes. Only buzzers that report releases can withdraw.
+
+While the question is being read out, a player may double press their button to ask for it to be repeated. We tell
+the user, who decides whether to.
+
+Before any buttons are pressed, including before the question is armed, the user may specify one team to play double
+for the question. That team's buzzers flash to show this, the team is shown with the game state for the rest of the
+question, so displays can show it too, and a correct answer from that team gets double marks.
+
+Optionally, wrong answers are penalised, to discourage reckless buzzing. Each incorrect answer deducts the penalty from
+the answering team's score as soon as it's judged, whatever the question's marks and whether or not the team is playing
+double. Penalties are never multiplied for catch-up, and are included in the question's result.
+
+Optionally, answers are on a shot clock. Once a player's buzz is acknowledged, they must be judged within the time
+limit, or their answer is treated as incorrect, exactly as if the user had said so, without needing confirming. The time
+left is shown with the game state. Optionally, the rest of the answering team's buzzers flash for the final
+ShotClockWarningTime, as a warning, and are turned off when it runs out. The answering player's own buzzer stays lit.
+If the clock runs out part way through a question in several parts, the parts already got right still count, as if the
+rest had been answered wrongly.
+
+A question may be in several parts, each with its own marks and answer. The answering player is taken through the
+parts in turn, each judged correct or incorrect separately, and the marks for the parts they got right are added up.
+If they got any right, their subtotal is awarded as a correct answer, otherwise their answer as a whole is incorrect
+and the next team may buzz. A tie break or withdrawn buzz starts the next player from the first part again.
+
+Optionally, marks roll over. A question that's armed but then closed without a correct answer, because no one knew or
+everyone got it wrong, puts its marks into a pot, which is added to the next question's marks. A correct answer wins
+the pot along with the question's own marks, even if those are overridden. The pot is shown with the game state
+between questions, so everyone can see what's at stake, and the user can adjust it, eg after a question is abandoned
+for being wrong.
+
 All quick fire functions and methods must be called only in the main thread, unless otherwise stated.
 
 */
@@ -21,53 +66,248 @@ All quick fire functions and methods must be called only in the main thread, unl
 package main
 
 import "fmt"
+import "time"
 
 
 // Create a quick fire controller.
-func CreateQuickFire(engine *Engine, scoreboard *Scoreboard) *QuickFire {
+func CreateQuickFire(engine *Engine, scoreboard *Scoreboard, judge *Judge) *QuickFire {
     var p QuickFire
     p.engine = engine
     p.scoreboard = scoreboard
-
-    engine.RegisterModal(p.commandNewQuestion, "quick fire", "Start a quick fire question", 'f', ARG_MARKS)
+    p.judge = judge
+
+    // Each question moves through our states, back to idle.
+    p.states = CreateStateMachine(engine, "quick fire")
+    p.states.AllowTransitions(StateIdle, QuickFireReading)
+    p.states.AllowTransitions(QuickFireReading, QuickFireCountIn, QuickFireWaiting, StateIdle)
+    p.states.AllowTransitions(QuickFireCountIn, QuickFireWaiting, StateIdle)
+    p.states.AllowTransitions(QuickFireWaiting, QuickFireAdjudicating, QuickFireAnswering, StateIdle)
+    p.states.AllowTransitions(QuickFireAdjudicating, QuickFireAnswering, QuickFireWaiting, StateIdle)
+    p.states.AllowTransitions(QuickFireAnswering, QuickFireTied, QuickFireWaiting, StateIdle)
+    p.states.AllowTransitions(QuickFireTied, QuickFireWaiting, StateIdle)
+
+    question := []string{QuickFireReading, QuickFireCountIn, QuickFireWaiting, QuickFireAdjudicating,
+        QuickFireAnswering, QuickFireTied}
+    answering := []string{QuickFireAnswering, QuickFireTied}
+    p.states.RegisterCmd(question, p.commandCancel, "Cancel current question", 'q')
+    p.states.RegisterCmd(question, p.commandDouble, "Team plays double for current question", 'x', ARG_TEAM)
+    p.states.RegisterCmd([]string{QuickFireReading}, p.commandArm, "Arm question, optionally after given seconds", 'g',
+        ARG_NUMBER | ARG_OPTIONAL)
+    p.states.RegisterCmd(answering, p.commandCorrect, "Player answered correctly, optionally overriding marks", 'y',
+        ARG_MARKS | ARG_OPTIONAL)
+    p.states.RegisterCmd(answering, p.commandIncorrect, "Player answered incorrectly", 'n')
+    p.states.RegisterCmd([]string{QuickFireTied}, p.commandTieBreak, "Give buzz to another near tied player", 'w',
+        ARG_BUZ_ID)
+    p.states.RegisterButtons(question[2:], p.button)
+    p.states.RegisterGestures([]string{QuickFireReading}, p.gesture)
+    p.states.RegisterReleases(question[2:], p.release)
+
+    engine.AddStateReporter(p.reportPot)
+    engine.RegisterCmd(p.commandSetPot, "Set quick fire rollover pot, default clearing it", 'o',
+        ARG_NUMBER | ARG_OPTIONAL)
+
+    engine.RegisterModal(p.commandNewQuestion, "quick fire",
+        "Start a quick fire question, optionally for some teams and limited attempts", 'f', ARG_MARKS, ARG_TEAMS,
+        ARG_NUMBER | ARG_OPTIONAL)
 
     return &p
 }
 
 
 // Start a new quick fire question.
-func (this *QuickFire) NewQuestion(marks int) {
-    this.marks = marks
+// Only the teams in the given mask may answer the question, and only the given number of attempts are allowed, 0 for
+// no limit.
+func (this *QuickFire) NewQuestion(marks Marks, teamMask int, attempts int) {
+    this.question++
+    this.marks = marks + this.pot
+    this.teamMask = teamMask
+    this.attempts = attempts
+    this.attemptsMade = 0
+    this.doubleTeam = -1
+    this.armTime = 0
     this.ackedPlayer = -1
-    // TODO: Remove embedded team counts.
-    this.haveTeamsBuzzed = make([]bool, 4)
-    this.pendingPresses = make([]int, 0, 4)
+    this.haveTeamsBuzzed = make([]bool, TeamCount)
+    this.haveTeamsAnswered = make([]bool, TeamCount)
+    this.pendingPresses = make([]int, 0, TeamCount)
+    this.windowPresses = nil
+    this.tiedPlayers = nil
+    this.releasedPlayers = make(map[int]bool)
+    this.penalties = make([]Marks, TeamCount)
+    this.parts = nil
+
+    // Teams not allowed to answer are treated as if they've already buzzed.
+    for team := range this.haveTeamsBuzzed {
+        this.haveTeamsBuzzed[team] = (teamMask & (1 << team)) == 0
+    }
+
+    if this.pot > 0 {
+        fmt.Printf("Quick fire question for %v marks, plus %v in the pot, open to:%s\n", marks, this.pot,
+            TeamMaskToString(teamMask))
+    } else {
+        fmt.Printf("Quick fire question for %v marks, open to:%s\n", marks, TeamMaskToString(teamMask))
+    }
+
+    if attempts > 0 { fmt.Printf("Limited to %d attempts\n", attempts) }
+
+    for team := 0; team < TeamCount; team++ {
+        if ((teamMask & (1 << team)) != 0) && (len(this.engine.TeamBuzzers(team)) == 0) {
+            fmt.Printf("Warning: Team %s has no buzzers connected\n", TeamIdToString(team))
+        }
+    }
 
     // De-illuminate all buzzers.
     this.engine.SetModeAll(false, false)
 
     // Register for needed inputs for duration of question.
-    this.engine.RegisterCmd(this.commandCancel, "Cancel current question", 'q')
-    this.engine.RegisterButtons(this.button)
+    this.states.Change(QuickFireReading)
+    this.engine.SetModalCancel(this.Cancel)
+    this.engine.SetModalState(this.reportState)
+    fmt.Printf("Presses ignored until question armed\n")
+}
+
+
+// Arm the current question, so button presses count.
+// If a delay is given the question is armed after that long, otherwise it's armed immediately, or after the count in if
+// that's enabled.
+func (this *QuickFire) Arm(delay time.Duration) {
+    if !this.states.In(QuickFireReading) {
+        fmt.Printf("Question already armed\n")
+        return
+    }
+
+    if delay > 0 {
+        fmt.Printf("Arming in %v\n", delay)
+        question := this.question
+        this.armTime = this.engine.Now() + delay
+
+        this.engine.After(delay, func() {
+            // Check the question hasn't gone, or been armed already, since.
+            if (question == this.question) && this.states.In(QuickFireReading) {
+                this.Arm(0)
+            }
+        })
+
+        return
+    }
+
+    if this.countIn {
+        this.startCountIn()
+        return
+    }
+
+    this.states.Change(QuickFireWaiting)
+    this.engine.QuestionOpened("quick fire", this.marks)
     this.printWaiting()
 }
 
 
+// Set whether arming a question starts a count in.
+func (this *QuickFire) SetCountIn(countIn bool) {
+    this.countIn = countIn
+}
+
+
+// Set whether players must hold their button down while they answer.
+func (this *QuickFire) SetHoldToAnswer(hold bool) {
+    this.holdToAnswer = hold
+}
+
+
+// Specify that the given team is playing double for the current question.
+// Only valid before any buttons have been pressed.
+func (this *QuickFire) Double(team int) {
+    if (this.teamMask & (1 << team)) == 0 {
+        fmt.Printf("Team %s cannot play double, not in this question\n", TeamIdToString(team))
+        return
+    }
+
+    for t, haveBuzzed := range this.haveTeamsBuzzed {
+        if haveBuzzed && ((this.teamMask & (1 << t)) != 0) {
+            fmt.Printf("Too late to play double, buttons already pressed\n")
+            return
+        }
+    }
+
+    this.doubleTeam = team
+    fmt.Printf("Team %s playing double\n", TeamIdToString(team))
+
+    // Flash the team's buzzers, to show everyone.
+    buzzers := this.engine.TeamBuzzers(team)
+    for _, id := range buzzers {
+        this.engine.SetMode(id, true, false)
+    }
+
+    question := this.question
+    this.engine.After(DoubleFlashTime, func() {
+        if question != this.question { return }  // Question is long gone.
+
+        for _, id := range buzzers {
+            if id != this.ackedPlayer { this.engine.SetMode(id, false, false) }
+        }
+    })
+}
+
+
+// Set the parts of the current question, which should add up to its marks.
+// Only valid while the question is being read out.
+func (this *QuickFire) SetParts(parts []QuestionPart) {
+    if !this.states.In(QuickFireReading) {
+        this.engine.Errorf("Error: Too late to set question parts")
+        return
+    }
+
+    this.parts = parts
+    fmt.Printf("Question in %d parts\n", len(parts))
+}
+
+
+// A part of a multi-part question.
+type QuestionPart struct {
+    Text string
+    Answer string
+    Marks Marks
+}
+
+
 // The last acknowledge player gave the correct answer.
-func (this *QuickFire) Correct() {
+// The marks given override the question's own marks for this answer, eg for a partially correct answer, with any pot
+// still won on top. Specify <0 to use the question's marks.
+func (this *QuickFire) Correct(marks Marks) {
     if this.ackedPlayer < 0 {
         // This shouldn't be possible, but paranoia is better than a segfault.
         fmt.Printf("Error: No currently acked player\n")
         return
     }
 
+    // Our marks already include the pot.
+    if marks < 0 {
+        marks = this.marks
+    } else {
+        marks += this.pot
+    }
+
     // Just give the marks to the currently acked player.
     team, _ := BuzzerIdToTeam(this.ackedPlayer)
-    this.scoreboard.Add(team, this.marks)
-    this.scoreboard.Print()
-    fmt.Printf("Player %s won\n", BuzzerIdToString(this.ackedPlayer))
+    double := ""
+    if team == this.doubleTeam {
+        marks *= 2
+        double = " (double)"
+    }
+
+    this.engine.Publish(Event{Type: EventJudged, Buzzer: this.ackedPlayer, Team: team, Correct: true})
+    reason := "quick fire, " + BuzzerIdToPlayerString(this.ackedPlayer) + " correct" + double
+    marks = this.scoreboard.Award(team, marks, reason)
+    fmt.Printf("Player %s won %v marks%s\n", BuzzerIdToPlayerString(this.ackedPlayer), marks, double)
+
+    if this.pot > 0 {
+        fmt.Printf("Team %s won the pot\n", TeamIdToString(team))
+        this.pot = 0
+    }
 
-    this.finish()
+    result := CreateModalResult(OutcomeCompleted)
+    result.Winner = team
+    result.Award(team, marks)
+    this.finish(result)
 }
 
 
@@ -79,48 +319,212 @@ func (this *QuickFire) Incorrect() {
         return
     }
 
+    team, _ := BuzzerIdToTeam(this.ackedPlayer)
+    this.engine.Publish(Event{Type: EventJudged, Buzzer: this.ackedPlayer, Team: team, Correct: false})
+
+    if this.penalty > 0 {
+        reason := "quick fire, " + BuzzerIdToPlayerString(this.ackedPlayer) + " incorrect, penalty"
+        this.scoreboard.Add(team, -this.penalty, reason)
+        this.penalties[team] += this.penalty
+        fmt.Printf("Team %s loses %v marks for a wrong answer\n", TeamIdToString(team), this.penalty)
+    }
+
     // De-illuminated acked player.
     this.engine.SetMode(this.ackedPlayer, false, false)
-    this.ackedPlayer = -1
-    this.engine.DeregisterCmd(this.commandCorrect, 'y')
-    this.engine.DeregisterCmd(this.commandIncorrect, 'n')
+    this.unack()
 
-    // Check for any pending presses.
-    if len(this.pendingPresses) > 0 {
-        newPress := this.pendingPresses[0]
-        this.pendingPresses = this.pendingPresses[1:]
-        this.handlePress(newPress)
+    this.attemptsMade++
+    if (this.attempts > 0) && (this.attemptsMade >= this.attempts) {
+        fmt.Printf("No winner, %d attempts made. Give the correct answer\n", this.attemptsMade)
+        this.rollOver()
+        this.finish(CreateModalResult(OutcomeCompleted))
         return
     }
 
-    // We need to wait for the next legal button press.
-    this.printWaiting()
+    this.nextPress()
+}
+
+
+// Set the marks deducted from a team for each incorrect answer, 0 for none.
+func (this *QuickFire) SetPenalty(marks Marks) {
+    this.penalty = marks
+}
+
+
+// Set the time limit for judging each answer, 0 for none, and whether to flash the answering team's buzzers as the
+// limit approaches.
+func (this *QuickFire) SetShotClock(limit time.Duration, flash bool) {
+    this.shotClock = limit
+    this.shotClockFlash = flash
+}
+
+
+// Set whether marks for questions closed without a correct answer roll over into the next question.
+func (this *QuickFire) SetRollover(rollover bool) {
+    this.rollover = rollover
+}
+
+
+// Set the rollover pot to the given number of marks.
+// Only valid between questions.
+func (this *QuickFire) SetPot(marks Marks) {
+    if !this.states.In(StateIdle) {
+        fmt.Printf("Cannot change the pot during a question\n")
+        return
+    }
+
+    this.pot = marks
+    fmt.Printf("Quick fire pot now %v marks\n", marks)
+}
+
+
+// Set the adjudication window and near tie margin.
+// A window of 0 disables adjudication, presses are then acknowledged immediately.
+func (this *QuickFire) SetAdjudication(window time.Duration, nearTie time.Duration) {
+    this.window = window
+    this.nearTie = nearTie
+}
+
+
+// Give the buzz to the specified player, who must be one of the near tied players.
+func (this *QuickFire) TieBreak(id int) {
+    found := false
+    for _, tied := range this.tiedPlayers {
+        if tied == id { found = true }
+    }
+
+    if !found {
+        fmt.Printf("Player %s is not tied\n", BuzzerIdToPlayerString(id))
+        return
+    }
+
+    if id == this.ackedPlayer {
+        fmt.Printf("Player %s already has the buzz\n", BuzzerIdToPlayerString(id))
+        return
+    }
+
+    // The current player goes back to the front of the queue, in place of the specified one.
+    pending := []int{this.ackedPlayer}
+    for _, p := range this.pendingPresses {
+        if p != id { pending = append(pending, p) }
+    }
+
+    this.engine.SetMode(this.ackedPlayer, false, false)
+    this.unack()
+    this.pendingPresses = pending
+    this.handlePress(id)
 }
 
 
 // Cancel the current question.
 func (this *QuickFire) Cancel() {
-    // Nothing special to do.
-    this.finish()
+    // Once the question's been armed, no one getting it right rolls the marks over.
+    if !this.states.In(QuickFireReading, QuickFireCountIn) { this.rollOver() }
+
+    this.finish(CreateModalResult(OutcomeCancelled))
 }
 
 
 // Quick fire controller.
 type QuickFire struct {
-    marks int
+    question int  // Count of questions, to identify stale timers.
+    marks Marks  // Including any pot.
+    rollover bool  // Whether unanswered questions roll over into the pot.
+    pot Marks  // Marks rolled over from previous questions.
+    penalty Marks  // Deducted for each incorrect answer, 0 for none.
+    penalties []Marks  // Deducted so far this question, indexed by team.
+    window time.Duration  // Adjudication window, 0 for none.
+    nearTie time.Duration  // Presses closer than this are near ties.
+    countIn bool  // Whether to count in before arming.
+    armTime time.Duration  // Engine time question is due to be armed, 0 for not delayed.
+    teamMask int  // Teams allowed to answer.
+    attempts int  // Incorrect answers allowed before the question closes, 0 for no limit.
+    attemptsMade int  // Incorrect answers so far.
+    doubleTeam int  // <0 for none.
     ackedPlayer int  // <0 for none.
+    ackCount int  // Count of players acknowledged, to identify stale shot clocks.
+    shotClock time.Duration  // Time limit for judging each answer, 0 for none.
+    shotClockFlash bool  // Whether to flash the answering team's buzzers as the shot clock runs out.
+    shotClockEnd time.Duration  // Engine time the current answer's shot clock runs out, 0 for none running.
+    parts []QuestionPart  // Nil for a question in one part.
+    part int  // Index of the part the acked player is answering.
+    partMarks Marks  // Marks the acked player has won for the parts so far.
     haveTeamsBuzzed []bool
+    haveTeamsAnswered []bool  // Teams we've reported as buzzing to the engine.
     pendingPresses []int
+    windowPresses []Press  // Presses in current adjudication window, nil for no window open.
+    tiedPlayers []int  // Players tied for the current buzz, nil for no tie.
+    holdToAnswer bool  // Whether players must hold their button down while they answer.
+    releasedPlayers map[int]bool  // Players who've let go since they last pressed, indexed by buzzer ID.
+    states *StateMachine
     scoreboard *Scoreboard
+    judge *Judge
     engine *Engine
 }
 
 
 // Internals.
 
+// Quick fire states, as well as idle.
+const (
+    QuickFireReading = "reading"  // Question being read out, presses ignored until armed.
+    QuickFireCountIn = "counting in"  // Counting in to arming.
+    QuickFireWaiting = "waiting"  // Waiting for a button press.
+    QuickFireAdjudicating = "adjudicating"  // Adjudication window open.
+    QuickFireAnswering = "answering"  // Waiting for judgement of acked player's answer.
+    QuickFireTied = "tied"  // As answering, but acked player was in a near tie.
+)
+
+// How long to flash a team's buzzers for, when they play double.
+const DoubleFlashTime = time.Second
+
+// Shot clock warning flashes.
+const (
+    ShotClockWarningTime = 3 * time.Second  // How long before the shot clock runs out to start flashing.
+    ShotClockFlashTime = 250 * time.Millisecond  // How long each flash, and each gap between flashes, lasts.
+)
+
+// Count in before arming.
+const (
+    CountInSteps = 3  // Counts before go.
+    CountInStepTime = time.Second
+    CountInFlashTime = 300 * time.Millisecond  // How long buzzers flash for each count and go.
+)
+
+// Gesture handler, passing on requests to repeat the question.
+// Only registered while the question is being read out.
+func (this *QuickFire) gesture(buzzerId int, gesture Gesture) {
+    team, _ := BuzzerIdToTeam(buzzerId)
+    if (gesture != GestureDouble) || ((this.teamMask & (1 << team)) == 0) { return }
+
+    fmt.Printf("Player %s asks for the question to be repeated\n", BuzzerIdToColourString(buzzerId))
+}
+
+
+// Release handler, withdrawing the buzz of a player who lets go before they're judged.
+// Only registered once the question is armed.
+func (this *QuickFire) release(buzzerId int, held time.Duration) {
+    if !this.holdToAnswer { return }
+
+    this.releasedPlayers[buzzerId] = true
+    if buzzerId != this.ackedPlayer { return }
+
+    // The answering player has let go.
+    team, _ := BuzzerIdToTeam(buzzerId)
+    this.haveTeamsBuzzed[team] = false
+    this.engine.SetMode(buzzerId, false, false)
+    this.unack()
+    fmt.Printf("Player %s let go of their button, buzz withdrawn\n", BuzzerIdToPlayerString(buzzerId))
+    this.nextPress()
+}
+
+
 // Button press handler.
-func (this *QuickFire) button(id int) {
+// Only registered once the question is armed.
+func (this *QuickFire) button(press Press) {
+    id := press.Buzzer
     team, _ := BuzzerIdToTeam(id)
+    delete(this.releasedPlayers, id)
 
     if this.haveTeamsBuzzed[team] {
         // This team has already buzzed, ignore press.
@@ -129,10 +533,107 @@ func (this *QuickFire) button(id int) {
 
     // This is the first press for this team.
     this.haveTeamsBuzzed[team] = true
+
+    if this.windowPresses != nil {
+        // Adjudication window in progress, add this press to it.
+        this.windowPresses = append(this.windowPresses, press)
+        return
+    }
+
+    if (this.window > 0) && (this.ackedPlayer < 0) {
+        // Open an adjudication window, to see who else pressed.
+        this.windowPresses = []Press{press}
+        this.states.Change(QuickFireAdjudicating)
+        question := this.question
+        this.engine.After(this.window, func() {
+            if question == this.question { this.adjudicate() }
+        })
+
+        return
+    }
+
     this.handlePress(id)
 }
 
 
+// Start counting in, arming the question at the end.
+// All timings are from now, so the engine's timers keep the counts evenly spaced.
+func (this *QuickFire) startCountIn() {
+    this.states.Change(QuickFireCountIn)
+    question := this.question
+    this.armTime = this.engine.Now() + (CountInSteps * CountInStepTime)
+
+    for i := 0; i <= CountInSteps; i++ {
+        count := CountInSteps - i
+
+        this.engine.After(time.Duration(i) * CountInStepTime, func() {
+            if question != this.question { return }  // Question is long gone.
+
+            if count > 0 {
+                fmt.Printf("%d...\n", count)
+                this.engine.SetModeAll(true, false)
+            } else {
+                // Go, buzz as well and arm the question.
+                fmt.Printf("Go\n")
+                this.engine.SetModeAll(true, true)
+                this.states.Change(QuickFireWaiting)
+                this.engine.QuestionOpened("quick fire", this.marks)
+                this.printWaiting()
+            }
+
+            this.engine.After(CountInFlashTime, func() {
+                if question != this.question { return }
+
+                // Someone may have won the buzz already during go.
+                this.engine.SetModeAll(false, false)
+                if this.ackedPlayer >= 0 { this.engine.SetMode(this.ackedPlayer, true, false) }
+            })
+        })
+    }
+}
+
+
+// Close the current adjudication window and acknowledge the first press in it.
+func (this *QuickFire) adjudicate() {
+    // Presses can overtake each other on the way to us, so go by when they were received.
+    presses := this.windowPresses
+    this.windowPresses = nil
+    SortPresses(presses)
+
+    // All but the first press are queued up, in order, ahead of anything pending.
+    pending := []int{}
+    for _, press := range presses[1:] {
+        pending = append(pending, press.Buzzer)
+    }
+
+    this.pendingPresses = append(pending, this.pendingPresses...)
+    this.handlePress(presses[0].Buzzer)
+    if this.ackedPlayer != presses[0].Buzzer { return }  // First player let go before they were acknowledged.
+
+    // Check for near ties with the first press.
+    this.tiedPlayers = []int{presses[0].Buzzer}
+    for _, press := range presses[1:] {
+        if press.Time - presses[0].Time <= this.nearTie {
+            this.tiedPlayers = append(this.tiedPlayers, press.Buzzer)
+        }
+    }
+
+    if len(this.tiedPlayers) < 2 {
+        this.tiedPlayers = nil
+        return
+    }
+
+    s := ""
+    for _, press := range presses[1:len(this.tiedPlayers)] {
+        gap := press.Time - presses[0].Time
+        s += fmt.Sprintf(" %s(+%dms)", BuzzerIdToNamedString(press.Buzzer), gap.Milliseconds())
+    }
+
+    fmt.Printf("NEAR TIE: %s first, then%s\n", BuzzerIdToNamedString(presses[0].Buzzer), s)
+    this.states.Change(QuickFireTied)
+}
+
+
 // Handle the given button press, which may have been pended.
 func (this *QuickFire) handlePress(id int) {
     if this.ackedPlayer >= 0 {
@@ -141,30 +642,271 @@ func (this *QuickFire) handlePress(id int) {
         return
     }
 
+    if this.holdToAnswer && this.releasedPlayers[id] {
+        // This player let go while waiting their turn.
+        team, _ := BuzzerIdToTeam(id)
+        this.haveTeamsBuzzed[team] = false
+        fmt.Printf("Player %s let go of their button, skipped\n", BuzzerIdToPlayerString(id))
+        this.nextPress()
+        return
+    }
+
     // Indicate pressed buzzer and await instruction from the user.
+    // Tie breaks can bring a player here twice, only report their buzz to the engine once.
+    team, _ := BuzzerIdToTeam(id)
+    if !this.haveTeamsAnswered[team] {
+        this.haveTeamsAnswered[team] = true
+        this.engine.Publish(Event{Type: EventBuzz, Buzzer: id, Team: team, Duration: this.engine.QuestionTime()})
+    }
+
     this.engine.SetMode(id, true, true)
     this.ackedPlayer = id
-    this.engine.RegisterCmd(this.commandCorrect, "Player answered correctly", 'y')
-    this.engine.RegisterCmd(this.commandIncorrect, "Player answered incorrectly", 'n')
-    fmt.Printf("Player %s pressed their button\n", BuzzerIdToString(id))
+    this.ackCount++
+    this.states.Change(QuickFireAnswering)
+    fmt.Printf("Player %s pressed their button\n", BuzzerIdToPlayerString(id))
+    this.part = 0
+    this.partMarks = 0
+    this.printPart()
+    this.startShotClock()
+}
+
+
+// Print the part of the question the acked player is answering, if it has parts.
+func (this *QuickFire) printPart() {
+    if this.part >= len(this.parts) { return }
+
+    part := &this.parts[this.part]
+    fmt.Printf("Part %d of %d, for %v marks: %s\n", this.part + 1, len(this.parts), part.Marks, part.Text)
+    if part.Answer != "" { fmt.Printf("Answer: %s\n", part.Answer) }
+}
+
+
+// The acked player's answer to the current part of the question is judged as given.
+// The marks given override the part's marks if correct, specify <0 to use the part's marks. Once every part is
+// judged, the player's answer as a whole is correct if they won any marks for it.
+func (this *QuickFire) judgePart(correct bool, marks Marks) {
+    if marks < 0 { marks = this.parts[this.part].Marks }
+
+    if correct {
+        this.partMarks += marks
+        fmt.Printf("Part %d correct, %v marks so far\n", this.part + 1, this.partMarks)
+    } else {
+        fmt.Printf("Part %d incorrect, %v marks so far\n", this.part + 1, this.partMarks)
+    }
+
+    this.part++
+    if this.part < len(this.parts) {
+        this.printPart()
+        return
+    }
+
+    if this.partMarks > 0 {
+        this.Correct(this.partMarks)
+    } else {
+        this.Incorrect()
+    }
+}
+
+
+// Start the shot clock for the newly acknowledged player, if we have one.
+func (this *QuickFire) startShotClock() {
+    this.shotClockEnd = 0
+    if this.shotClock <= 0 { return }
+
+    this.shotClockEnd = this.engine.Now() + this.shotClock
+    ack := this.ackCount
+    player := this.ackedPlayer
+
+    this.engine.After(this.shotClock, func() {
+        if ack != this.ackCount { return }  // Already judged, or moved on.
+
+        fmt.Printf("Player %s ran out of time\n", BuzzerIdToPlayerString(player))
+
+        // Parts already answered correctly still count, as if the rest were answered wrongly.
+        if this.partMarks > 0 {
+            this.Correct(this.partMarks)
+        } else {
+            this.Incorrect()
+        }
+    })
+
+    if !this.shotClockFlash { return }
+
+    // Flash the rest of the team, on and off, until the clock runs out.
+    team, _ := BuzzerIdToTeam(player)
+    buzzers := this.engine.TeamBuzzers(team)
+    question := this.question
+    start := this.shotClock - ShotClockWarningTime
+    if start < 0 { start = 0 }
+
+    flash := func(on bool) {
+        if question != this.question { return }  // Question over, all buzzers are already off.
+        if ack != this.ackCount { on = false }  // Moved on, don't leave anyone lit.
+
+        for _, id := range buzzers {
+            if id != this.ackedPlayer { this.engine.SetMode(id, on, false) }
+        }
+    }
+
+    for t := start; t < this.shotClock; t += ShotClockFlashTime {
+        on := ((t - start) / ShotClockFlashTime) % 2 == 0
+        this.engine.After(t, func() { flash(on) })
+    }
+
+    // However the flashes fall, the team isn't left lit once the clock runs out.
+    this.engine.After(this.shotClock, func() { flash(false) })
+}
+
+
+// Move on to any pending press, otherwise wait for the next legal button press.
+func (this *QuickFire) nextPress() {
+    if len(this.pendingPresses) > 0 {
+        newPress := this.pendingPresses[0]
+        this.pendingPresses = this.pendingPresses[1:]
+        this.handlePress(newPress)
+        return
+    }
+
+    // Everyone in an adjudication window may have let go before their turn.
+    if this.states.In(QuickFireAdjudicating) { this.states.Change(QuickFireWaiting) }
+
+    this.printWaiting()
+}
+
+
+// Add our details to the given game state.
+func (this *QuickFire) reportState(state *GameState) {
+    state.Marks = this.marks
+    state.Armed = !this.states.In(QuickFireReading, QuickFireCountIn)
+
+    for team, haveBuzzed := range this.haveTeamsBuzzed {
+        if !haveBuzzed { state.TeamsAllowed = append(state.TeamsAllowed, TeamIdToString(team)) }
+    }
+
+    if this.ackedPlayer >= 0 { state.AckedPlayer = BuzzerIdToString(this.ackedPlayer) }
+    if this.doubleTeam >= 0 { state.DoubleTeam = TeamIdToString(this.doubleTeam) }
+
+    for _, press := range this.windowPresses {
+        state.PendingPresses = append(state.PendingPresses, BuzzerIdToString(press.Buzzer))
+    }
+
+    for _, id := range this.pendingPresses {
+        state.PendingPresses = append(state.PendingPresses, BuzzerIdToString(id))
+    }
+
+    if !state.Armed && (this.armTime > 0) { state.AddTimer("Arm", this.armTime, this.engine.Now()) }
+    if this.shotClockEnd > 0 { state.AddTimer("Answer", this.shotClockEnd, this.engine.Now()) }
+    if (this.ackedPlayer >= 0) && (this.part < len(this.parts)) {
+        state.Part = fmt.Sprintf("%d/%d", this.part + 1, len(this.parts))
+    }
+}
+
+
+// State reporter for the rollover pot, which is kept between questions.
+func (this *QuickFire) reportPot(state *GameState) {
+    state.Pot = this.pot
+}
+
+
+// Put the current question's marks into the pot, if marks roll over, since no one got it right.
+func (this *QuickFire) rollOver() {
+    if !this.rollover { return }
+
+    this.pot = this.marks
+    fmt.Printf("%v marks roll over into the pot\n", this.pot)
+}
+
+
+// Stop waiting for a judgement on the currently acked player.
+func (this *QuickFire) unack() {
+    this.ackedPlayer = -1
+    this.ackCount++  // Stop any shot clock.
+    this.shotClockEnd = 0
+    this.tiedPlayers = nil
+    this.states.Change(QuickFireWaiting)
 }
 
 
 // Command handler for starting a new question.
 func (this *QuickFire) commandNewQuestion(values []int) {
-    this.NewQuestion(values[0])
+    attempts := 0
+    if values[2] > 0 { attempts = values[2] }
+
+    this.NewQuestion(Marks(values[0]), values[1], attempts)
 }
 
 
-// Command handler for the last acknowledge player gave the correct answer.
-func (this *QuickFire) commandCorrect([]int) {
-    this.Correct()
+// Command handler for the last acknowledge player gave the correct answer, or part of it for a question in parts.
+// The judgement may need confirming, by which time the question may have moved on.
+func (this *QuickFire) commandCorrect(values []int) {
+    question := this.question
+    player := this.ackedPlayer
+
+    if len(this.parts) > 0 {
+        part := this.part
+        this.judge.Submit(fmt.Sprintf("%s part %d correct", BuzzerIdToPlayerString(player), part + 1), func() {
+            if (question == this.question) && (player == this.ackedPlayer) && (part == this.part) {
+                this.judgePart(true, Marks(values[0]))
+            }
+        })
+        return
+    }
+
+    this.judge.Submit(BuzzerIdToPlayerString(player) + " correct", func() {
+        if (question == this.question) && (player == this.ackedPlayer) { this.Correct(Marks(values[0])) }
+    })
 }
 
 
-// Command handler for the last acknowledge player gave the incorrect answer.
+// Command handler for the last acknowledge player gave the incorrect answer, or part of it for a question in parts.
+// The judgement may need confirming, by which time the question may have moved on.
 func (this *QuickFire) commandIncorrect([]int) {
-    this.Incorrect()
+    question := this.question
+    player := this.ackedPlayer
+
+    if len(this.parts) > 0 {
+        part := this.part
+        this.judge.Submit(fmt.Sprintf("%s part %d incorrect", BuzzerIdToPlayerString(player), part + 1), func() {
+            if (question == this.question) && (player == this.ackedPlayer) && (part == this.part) {
+                this.judgePart(false, 0)
+            }
+        })
+        return
+    }
+
+    this.judge.Submit(BuzzerIdToPlayerString(player) + " incorrect", func() {
+        if (question == this.question) && (player == this.ackedPlayer) { this.Incorrect() }
+    })
+}
+
+
+// Command handler for arming the current question.
+func (this *QuickFire) commandArm(values []int) {
+    delay := 0
+    if values[0] > 0 { delay = values[0] }
+
+    this.Arm(time.Duration(delay) * time.Second)
+}
+
+
+// Command handler for a tie break.
+func (this *QuickFire) commandTieBreak(values []int) {
+    this.TieBreak(values[0])
+}
+
+
+// Command handler for setting the rollover pot.
+func (this *QuickFire) commandSetPot(values []int) {
+    marks := 0
+    if values[0] > 0 { marks = values[0] }
+
+    this.SetPot(WholeMarks(marks))
+}
+
+
+// Command handler for a team playing double.
+func (this *QuickFire) commandDouble(values []int) {
+    this.Double(values[0])
 }
 
 
@@ -184,22 +926,39 @@ func (this *QuickFire) printWaiting() {
         }
     }
 
+    if this.doubleTeam >= 0 {
+        s += fmt.Sprintf(" (%s playing double)", TeamIdToString(this.doubleTeam))
+    }
+
+    if this.attempts > 0 {
+        s += fmt.Sprintf(" (%d of %d attempts left)", this.attempts - this.attemptsMade, this.attempts)
+    }
+
     fmt.Printf("Waiting for button press from:%s\n", s)
 }
 
 
-// Finish the current question.
-func (this *QuickFire) finish() {
-    // Unregister everything we temporarily registered.
-    this.engine.DeregisterCmd(this.commandCancel, 'q')
-    this.engine.DeregisterButtons(this.button)
+// Finish the current question, with the given result.
+func (this *QuickFire) finish(result ModalResult) {
+    this.judge.Cancel()
 
-    if this.ackedPlayer >= 0 {
-        this.engine.DeregisterCmd(this.commandCorrect, 'y')
-        this.engine.DeregisterCmd(this.commandIncorrect, 'n')
+    for team, marks := range this.penalties {
+        if marks > 0 { result.Award(team, -marks) }
     }
 
-    this.engine.ModalComplete()
+    // Unregister everything we temporarily registered.
+    armed := !this.states.In(QuickFireReading, QuickFireCountIn)
+    this.states.Change(StateIdle)
+    this.ackedPlayer = -1
+    this.ackCount++
+    this.shotClockEnd = 0
+    this.tiedPlayers = nil
+    this.windowPresses = nil
+
+    if armed { this.engine.QuestionClosed("quick fire") }
+
+    this.question++  // Invalidate any pending timers.
+    this.engine.ModalComplete(result)
 
     // De-illuminate all buzzers.
     this.engine.SetModeAll(false, false)
@@ -0,0 +1,245 @@
+package main
+
+import "reflect"
+import "testing"
+import "time"
+
+
+// Start a quick fire question with the given command arguments, eg "2,BG", and arm it.
+func (this *testHarness) startQuickFire(args string, parts ...QuestionPart) {
+    this.engine.processCommand("f" + args)
+    if len(parts) > 0 { this.quickFire.SetParts(parts) }
+    this.engine.processCommand("g")
+}
+
+
+// Press the given buzzer's button and wait for the engine to handle it.
+func (this *testHarness) press(buzzer *testBuzzer) {
+    buzzer.send(0x30)
+    this.settle()
+}
+
+
+// Fail the test unless the scores are as given, for as many teams as given.
+func (this *testHarness) checkScores(expected ...Marks) {
+    scores := this.scoreboard.Scores()[:len(expected)]
+    if !reflect.DeepEqual(scores, expected) { this.t.Fatalf("Scores %v, expected %v", scores, expected) }
+}
+
+
+// Fail the test unless the given player is answering, with the given players queued up behind them.
+func (this *testHarness) checkAnswering(player string, pending ...string) {
+    state := this.engine.State()
+    if pending == nil { pending = []string{} }
+
+    if (state.AckedPlayer != player) || !reflect.DeepEqual(state.PendingPresses, pending) {
+        this.t.Fatalf("%q answering, with %v pending, expected %q with %v", state.AckedPlayer, state.PendingPresses,
+            player, pending)
+    }
+}
+
+
+// Check presses are taken in order, one per team, with each wrong answer passing the buzz on.
+func TestQuickFirePresses(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire()
+    blue := harness.connectId(0x00)
+    blue2 := harness.connectId(0x01)
+    green := harness.connectId(0x10)
+    red := harness.connectId(0x20)
+
+    harness.startQuickFire("2")
+    harness.press(blue)
+    harness.press(blue2)
+    harness.press(green)
+    harness.press(red)
+    harness.checkAnswering("B0", "G0", "R0")
+
+    harness.engine.processCommand("n")
+    harness.checkAnswering("G0", "R0")
+
+    harness.engine.processCommand("y")
+    harness.checkScores(0, WholeMarks(2), 0)
+
+    // Once the question's over, presses go nowhere and the next question starts cleanly.
+    harness.press(red)
+    harness.startQuickFire("1")
+    harness.press(red)
+    harness.checkAnswering("R0")
+    harness.engine.processCommand("y")
+    harness.checkScores(0, WholeMarks(2), WholeMarks(1))
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check presses in an adjudication window are taken in the order they were made, not received, with near ties
+// reported so the user can give the buzz to another tied player.
+func TestQuickFireAdjudication(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire().SetAdjudication(50 * time.Millisecond, 20 * time.Millisecond)
+    harness.connectId(0x00)
+    harness.connectId(0x10)
+    harness.connectId(0x20)
+
+    // Green's press arrives first, but blue pressed before them, and red well after.
+    harness.startQuickFire("2")
+    now := harness.engine.Now()
+    harness.engine.ButtonPress(0x10, now + 10 * time.Millisecond)
+    harness.engine.ButtonPress(0x00, now)
+    harness.engine.ButtonPress(0x20, now + 40 * time.Millisecond)
+    harness.wait(100 * time.Millisecond)
+    harness.checkAnswering("B0", "G0", "R0")
+
+    // Blue and green are near tied, so the user can give green the buzz instead.
+    harness.engine.processCommand("wG0")
+    harness.checkAnswering("G0", "B0", "R0")
+
+    harness.engine.processCommand("y")
+    harness.checkScores(0, WholeMarks(2), 0)
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check a question carries on if everyone in an adjudication window lets go before their turn.
+func TestQuickFireHoldSkip(t *testing.T) {
+    harness := createTestHarness(t)
+    quickFire := harness.createQuickFire()
+    quickFire.SetAdjudication(50 * time.Millisecond, 0)
+    quickFire.SetHoldToAnswer(true)
+    blue := harness.connectId(0x00)
+    green := harness.connectId(0x10)
+    red := harness.connectId(0x20)
+
+    harness.startQuickFire("2")
+    harness.press(blue)
+    harness.press(green)
+    blue.send(0x35)
+    green.send(0x35)
+    harness.settle()
+    harness.wait(100 * time.Millisecond)
+    harness.checkAnswering("")
+    if !quickFire.states.In(QuickFireWaiting) { t.Fatalf("Quick fire %s, expected waiting", quickFire.states.state) }
+
+    // The teams that let go may buzz again.
+    harness.press(red)
+    harness.press(blue)
+    harness.wait(100 * time.Millisecond)
+    harness.checkAnswering("R0", "B0")
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check multi-part questions are judged part by part, with the parts got right added up.
+func TestQuickFireParts(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire()
+    blue := harness.connectId(0x00)
+    green := harness.connectId(0x10)
+    parts := []QuestionPart{{Text: "First", Marks: WholeMarks(1)}, {Text: "Second", Marks: WholeMarks(2)}}
+
+    // Some parts right wins those parts' marks.
+    harness.startQuickFire("3", parts...)
+    harness.press(blue)
+    harness.engine.processCommand("y")
+    if harness.engine.State().Part != "2/2" { t.Fatalf("Part %q after first judged", harness.engine.State().Part) }
+
+    harness.engine.processCommand("n")
+    harness.checkScores(WholeMarks(1), 0)
+    if harness.engine.State().Mode != "" { t.Fatalf("Question still open, in %s", harness.engine.State().Mode) }
+
+    // No parts right is a wrong answer, so another team can buzz, starting from the first part.
+    harness.startQuickFire("3", parts...)
+    harness.press(blue)
+    harness.engine.processCommand("n")
+    harness.engine.processCommand("n")
+    if harness.engine.State().AckedPlayer != "" { t.Fatalf("Player still answering after every part wrong") }
+
+    harness.press(green)
+    if harness.engine.State().Part != "1/2" { t.Fatalf("Next player starts at part %q", harness.engine.State().Part) }
+
+    harness.engine.processCommand("y")
+    harness.engine.processCommand("y")
+    harness.checkScores(WholeMarks(1), WholeMarks(3))
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check the pot is won on top of a correct answer's marks, whether or not they're overridden.
+func TestQuickFirePot(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire().SetRollover(true)
+    blue := harness.connectId(0x00)
+    green := harness.connectId(0x10)
+
+    // Unanswered questions roll over into the pot.
+    harness.startQuickFire("2")
+    harness.engine.processCommand("q")
+    harness.startQuickFire("3")
+    harness.press(blue)
+    harness.engine.processCommand("n")
+    harness.engine.processCommand("q")
+    if harness.engine.State().Pot != WholeMarks(5) { t.Fatalf("Pot %v, expected 5", harness.engine.State().Pot) }
+
+    // An overridden answer wins the pot as well.
+    harness.startQuickFire("2")
+    harness.press(green)
+    harness.engine.processCommand("y1")
+    harness.checkScores(0, WholeMarks(6))
+    if harness.engine.State().Pot != 0 { t.Fatalf("Pot %v left after it was won", harness.engine.State().Pot) }
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check the team playing double is shown with the game state for the question, and wins double marks.
+func TestQuickFireDouble(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire()
+    green := harness.connectId(0x10)
+
+    harness.engine.processCommand("f2")
+    harness.engine.processCommand("xG")
+    harness.engine.processCommand("g")
+    if team := harness.engine.State().DoubleTeam; team != "G" { t.Fatalf("Double team %q, expected G", team) }
+
+    harness.press(green)
+    harness.engine.processCommand("y")
+    harness.checkScores(0, WholeMarks(4))
+    if team := harness.engine.State().DoubleTeam; team != "" { t.Fatalf("Double team %q after question", team) }
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check the shot clock times answers out, leaving the flashing team unlit whatever the clock's length, and keeping the
+// marks for parts already got right.
+func TestQuickFireShotClock(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire().SetShotClock(600 * time.Millisecond, true)
+    blue := harness.connectId(0x00)
+    harness.connectId(0x01)
+    green := harness.connectId(0x10)
+
+    // The flashes would end with the team lit, after the clock runs out.
+    harness.startQuickFire("2")
+    harness.press(blue)
+    harness.wait(800 * time.Millisecond)
+    harness.checkAnswering("")
+    if harness.ledOn(0x01) { t.Fatalf("Answering team left lit after the shot clock ran out") }
+
+    harness.engine.processCommand("q")
+
+    // Running out of time part way through keeps the parts got right.
+    parts := []QuestionPart{{Text: "First", Marks: WholeMarks(1)}, {Text: "Second", Marks: WholeMarks(2)}}
+    harness.startQuickFire("3", parts...)
+    harness.press(green)
+    harness.engine.processCommand("y")
+    harness.wait(800 * time.Millisecond)
+    harness.checkScores(0, WholeMarks(1))
+    harness.checkErrors()
+    harness.checkLog()
+}
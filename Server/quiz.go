@@ -46,31 +46,393 @@ Q3 ...
 
 package main
 
+import "errors"
+import "flag"
 import "fmt"
 import "net"
 import "os"
+import "strconv"
+import "strings"
+import "time"
 
 
 func main() {
-    engine, swarm := CreateEngine()
-    scoreboard := CreateScoreboard(engine)
-    scoreboard.Print()
+    window := flag.Duration("window", 0, "Quick fire adjudication window, 0 to acknowledge first press immediately")
+    rollover := flag.Bool("rollover", false, "Roll marks for unanswered quick fire questions over into the next")
+    sweepBonus := flag.Int("sweep", 0, "Bonus marks for the only team to win every question in a round, 0 for none")
+    shotClock := flag.Duration("shotclock", 0, "Time limit for judging each quick fire answer, 0 for none")
+    shotClockFlash := flag.Bool("shotclockflash", false,
+        "Flash the answering team's buzzers for the last few seconds of the shot clock")
+    roundLights := flag.Bool("roundlights", false, "Run a light along the round winner's buzzers as each round ends")
+    penalty := flag.Int("penalty", 0, "Marks deducted for each wrong quick fire answer, 0 for none")
+    holdToAnswer := flag.Bool("hold", false, "Quick fire players must hold their button down while answering")
+    countIn := flag.Bool("countin", false, "Count in 3-2-1-go on all buzzers when arming quick fire questions")
+    compensate := flag.Bool("compensate", false,
+        "Compensate quick fire presses for each buzzer's network latency, needs an adjudication window")
+    nearTie := flag.Duration("neartie", 20 * time.Millisecond, "Quick fire presses this close are reported as ties")
+    checkpoints := flag.String("checkpoints", "5m,1m", "Time remaining at which to warn during time limited rounds")
+    judgeTimeout := flag.Duration("judge", 0, "Time for second judge to confirm judgements, 0 for no second judge")
+    sounds := flag.String("sounds", "", "Team sound files to play on host when a buzz is accepted, eg B=blue.wav,R=red.wav")
+    soundPlayer := flag.String("player", "aplay", "Program to play host sound files")
+    tieBreaks := flag.String("tiebreaks", DefaultTieBreaks,
+        "Policies to break ties in the final standings with, in order: headtohead, correct, speed, suddendeath")
+    scoresShown := flag.String("scores", "shown",
+        "How much of the scores to show until revealed: shown, places or hidden")
+    milestones := flag.String("milestones", "20,50,100", "Scores to announce the first team reaching, eg 20,50")
+    speaker := flag.String("speak", "", "Text to speech program to speak score announcements with, eg espeak")
+    agendaFile := flag.String("agenda", "", "Agenda file to keep the quiz to")
+    scriptFile := flag.String("script", "", "Quiz script file of questions to run through")
+    simulate := flag.Bool("simulate", false, "Simulate the quiz script with bot teams to check its pacing, then exit")
+    bots := flag.String("bots", "",
+        "Bot teams to simulate with, as skill and reaction time, eg B=0.8@1.5s,G=0.5@3s, default every team 0.5@3s")
+    control := flag.String("control", "", "Buzzer for the quizmaster to drive the quiz with, eg X1")
+    controlCmds := flag.String("controlcmds", DefaultControlCommands,
+        "Commands run by control buzzer press patterns, by number of presses")
+    udp := flag.Bool("udp", false, "Offer buzzers a UDP channel for button presses, to cut latency on lossy WiFi")
+    virtual := flag.Bool("virtual", false, "Let players without a buzzer buzz from their phones, at /buzzer")
+    teamsFile := flag.String("teams", "", "Team definitions file, giving letters, colours and names, default 4 teams")
+    inventoryFile := flag.String("inventory", "", "Buzzer inventory file, giving serial numbers and repair notes")
+    rosterFile := flag.String("roster", "", "Player roster file, naming the player at each buzzer")
+    venueFile := flag.String("venue", "", "Venue layout file, placing buzzers at tables, for the buzzing heatmap")
+    roomRanges := flag.String("rooms", "",
+        "Buzzer numbers in each room, to run an independent quiz in each, eg 0-7,8-15, default one room")
+    themeFile := flag.String("theme", "", "Theme file to brand the audience display with")
+    replicate := flag.String("replicate", "", "Standby server address to replicate quiz state to, eg standby:9754")
+    standby := flag.String("standby", "", "Address to follow a primary server on as a standby, eg :9754")
+    disconnectTime := flag.Duration("disconnect", DefaultDisconnectTime,
+        "Base time after which quiet buzzers are disconnected, extended for jittery buzzers")
+    keepWarm := flag.Duration("keepwarm", DefaultKeepWarmTime,
+        "How often to ping buzzers, so idle connections aren't dropped by the network, 0 for never")
+    flapQuarantine := flag.Int("flapquarantine", 0,
+        "Quarantine buzzers that disconnect this many times in a minute, 0 for never")
+    hotkeys := flag.Bool("hotkeys", false, "Start in hotkey mode, running commands with single keystrokes")
+    storageBackend := flag.String("storage", "file", "Backend to keep scores and stats in: file or sqlite")
+    adminPassword := flag.String("adminpass", "", "Password for the admin web page, default generated at startup")
+    judgePassword := flag.String("judgepass", "",
+        "Password for the second judge web page, default generated at startup if there's a second judge")
+    hostPassword := flag.String("hostpass", "",
+        "Password for the host web page, default generated at startup if there's a quiz script")
+    session := flag.String("session", "", "Name of this quiz session, to identify this server, default start time")
+    adopt := flag.Bool("adopt", false,
+        "Carry on with the saved scores and round of a previous server, eg one that crashed")
+    threadCheckName := flag.String("threadcheck", "off",
+        "Debug check for main thread only calls from other threads: off, log or panic")
+    flag.Parse()
 
-    CreateTestMode(engine)
-    CreateMultipleChoice(engine, scoreboard)
-    CreateQuickFire(engine, scoreboard)
+    // Everything else depends on the teams, so set them up first.
+    if *teamsFile != "" {
+        err := LoadTeams(*teamsFile)
+        if err != nil {
+            fmt.Println("Error reading teams:", err.Error())
+            os.Exit(1)
+        }
+    }
+
+    // A simulation needs only the script and the teams, not a quiz server.
+    if *simulate {
+        simulator, err := CreateSimulator(*scriptFile, *bots)
+        if err != nil {
+            fmt.Println("Error setting up simulation:", err.Error())
+            os.Exit(1)
+        }
+
+        simulator.Report(SimulationRuns)
+        return
+    }
+
+    // A late press can only win if it arrives within the adjudication window.
+    if *compensate && (*window < MaxLatencyCompensation) {
+        *window = MaxLatencyCompensation
+        fmt.Printf("Latency compensation needs an adjudication window, using %v\n", *window)
+    }
+
+    threadCheck, err := ParseThreadCheck(*threadCheckName)
+    if err != nil {
+        fmt.Println("Error parsing thread check:", err.Error())
+        os.Exit(1)
+    }
+
+    checkpointTimes, err := parseDurations(*checkpoints)
+    if err != nil {
+        fmt.Println("Error parsing checkpoints:", err.Error())
+        os.Exit(1)
+    }
+
+    milestoneScores, err := parseInts(*milestones)
+    if err != nil {
+        fmt.Println("Error parsing milestones:", err.Error())
+        os.Exit(1)
+    }
+
+    tieBreakPolicies, err := ParseTieBreaks(*tieBreaks)
+    if err != nil {
+        fmt.Println("Error parsing tie-breaks:", err.Error())
+        os.Exit(1)
+    }
+
+    scoreVisibility, err := ParseScoreVisibility(*scoresShown)
+    if err != nil {
+        fmt.Println("Error parsing scores:", err.Error())
+        os.Exit(1)
+    }
+
+    rooms, err := CreateRooms(*roomRanges, threadCheck)
+    if err != nil {
+        fmt.Println("Error parsing rooms:", err.Error())
+        os.Exit(1)
+    }
+
+    if (rooms.Count() > 1) && ((*replicate != "") || (*standby != "")) {
+        fmt.Println("Error: replication isn't supported with more than one room")
+        os.Exit(1)
+    }
+
+    controlId := -1
+    if *control != "" {
+        controlId, err = parseBuzzerId(*control)
+        if err != nil {
+            fmt.Println("Error setting up control buzzer:", err.Error())
+            os.Exit(1)
+        }
+    }
+
+    var inventory Inventory
+    if *inventoryFile != "" {
+        inventory, err = LoadInventory(*inventoryFile)
+        if err != nil {
+            fmt.Println("Error reading inventory:", err.Error())
+            os.Exit(1)
+        }
+    }
+
+    var venue Venue
+    if *venueFile != "" {
+        venue, err = LoadVenue(*venueFile)
+        if err != nil {
+            fmt.Println("Error reading venue layout:", err.Error())
+            os.Exit(1)
+        }
+    }
+
+    theme := DefaultTheme()
+    if *themeFile != "" {
+        theme, err = LoadTheme(*themeFile)
+        if err != nil {
+            fmt.Println("Error reading theme:", err.Error())
+            os.Exit(1)
+        }
+    }
+
+    if *session == "" { *session = time.Now().Format("2006-01-02 15:04") }
+
+    // Every room's web pages share the same passwords.
+    var passwords WebPasswords
+    passwords.Admin = *adminPassword
+    if passwords.Admin == "" {
+        passwords.Admin = RandomPassword()
+        fmt.Printf("Admin web page password: %s\n", passwords.Admin)
+    }
+
+    passwords.Judge = *judgePassword
+    if (passwords.Judge == "") && (*judgeTimeout > 0) {
+        passwords.Judge = RandomPassword()
+        fmt.Printf("Judge web page password: %s\n", passwords.Judge)
+    }
+
+    passwords.Host = *hostPassword
+    if (passwords.Host == "") && (*scriptFile != "") {
+        passwords.Host = RandomPassword()
+        fmt.Printf("Host web page password: %s\n", passwords.Host)
+    }
+
+    // Each room runs its own quiz, see rooms.go.
+    var allDisputes []*Disputes
+    var locks []*Lock
+
+    for room := 1; room <= rooms.Count(); room++ {
+        if rooms.Count() > 1 { fmt.Printf("Setting up room %d\n", room) }
+
+        storage, err := CreateStorage(*storageBackend, rooms.StorageDir(room))
+        if err != nil {
+            fmt.Println("Error creating storage:", err.Error())
+            os.Exit(1)
+        }
+
+        lock, err := AcquireLock(rooms.StorageDir(room), *session)
+        if err != nil {
+            fmt.Println("Error:", err.Error())
+            if errors.Is(err, ErrLockHeld) {
+                fmt.Println("Stop it first, then start again with -adopt to carry on with its scores.")
+            }
+            os.Exit(1)
+        }
+
+        locks = append(locks, lock)
 
-    go listen(swarm)
+        engine, swarm := CreateEngine(storage, rooms.LogDir(room))
+        engine.SetThreadChecks(threadCheck)
+        swarm.SetDisconnectTime(*disconnectTime)
+        swarm.SetFlapQuarantine(*flapQuarantine)
+        swarm.SetKeepWarm(*keepWarm)
+        swarm.SetLatencyCompensation(*compensate)
+        if inventory != nil { swarm.SetInventory(inventory) }
+        CreateEventLog(engine, swarm)
+        rooms.Add(engine, swarm)
 
-    engine.Run()
+        if *rosterFile != "" {
+            _, err := CreateRoster(engine, *rosterFile)
+            if err != nil {
+                fmt.Println("Error reading roster:", err.Error())
+                os.Exit(1)
+            }
+        }
+
+        scoreboard := CreateScoreboard(engine)
+        scoreboard.SetTieBreaks(tieBreakPolicies)
+        scoreboard.SetVisibility(scoreVisibility)
+
+        if *replicate != "" { CreateReplicator(engine, *replicate) }
+        if *standby != "" {
+            _, err := CreateStandby(engine, scoreboard, *standby)
+            if err != nil {
+                fmt.Println("Error starting standby:", err.Error())
+                os.Exit(1)
+            }
+        }
+
+        rounds := CreateRounds(engine, scoreboard)
+        rounds.SetCheckpoints(checkpointTimes)
+        rounds.SetSweepBonus(WholeMarks(*sweepBonus))
+        rounds.SetWinnerSweep(*roundLights)
+
+        if *agendaFile != "" {
+            _, err := CreateAgenda(engine, *agendaFile)
+            if err != nil {
+                fmt.Println("Error reading agenda:", err.Error())
+                os.Exit(1)
+            }
+        }
+
+        allDisputes = append(allDisputes, CreateDisputes(engine))
+        judge := CreateJudge(engine)
+        judge.SetConfirmation(*judgeTimeout)
+        if *sounds != "" {
+            _, err := CreateHostAudio(engine, *soundPlayer, *sounds)
+            if err != nil {
+                fmt.Println("Error setting up host sounds:", err.Error())
+                os.Exit(1)
+            }
+        }
+
+        CreateTestMode(engine)
+        CreateBurnIn(engine)
+        CreateMultipleChoice(engine, scoreboard)
+        quickFire := CreateQuickFire(engine, scoreboard, judge)
+        quickFire.SetAdjudication(*window, *nearTie)
+        quickFire.SetCountIn(*countIn)
+        quickFire.SetRollover(*rollover)
+        quickFire.SetPenalty(WholeMarks(*penalty))
+        quickFire.SetHoldToAnswer(*holdToAnswer)
+        quickFire.SetShotClock(*shotClock, *shotClockFlash)
+        CreateParallelChallenge(engine, scoreboard, judge)
+
+        // The control buzzer drives the quiz in its own room.
+        if (controlId >= 0) && (rooms.Room(controlId) == room) {
+            _, err := CreateControlBuzzer(engine, swarm, controlId, *controlCmds)
+            if err != nil {
+                fmt.Println("Error setting up control buzzer:", err.Error())
+                os.Exit(1)
+            }
+        }
+
+        var script *QuizScript
+        if *scriptFile != "" {
+            script, err = CreateQuizScript(engine, quickFire, *scriptFile)
+            if err != nil {
+                fmt.Println("Error reading quiz script:", err.Error())
+                os.Exit(1)
+            }
+        }
+
+        CreateDisplay(engine)
+
+        announcer := CreateAnnouncer(engine, scoreboard, theme, milestoneScores)
+        announcer.SetSpeaker(*speaker)
+        spectators := CreateSpectators(engine, scoreboard, theme)
+        web := CreateWebServer(engine, swarm, scoreboard, judge, spectators, theme, passwords,
+            rooms.WebPort(room))
+        if *virtual { web.EnableVirtualBuzzers() }
+        if venue != nil { web.SetHeatmap(CreateHeatmap(engine, venue)) }
+        if script != nil { web.SetQuizScript(script) }
+
+        // Restore once everything that follows the quiz's progress is ready to catch up.
+        if *adopt && !scoreboard.Restore() { fmt.Printf("No saved scores to adopt, starting from zero\n") }
+        scoreboard.Print()
+
+        if *hotkeys && (room == 1) { engine.SetHotkeys(true) }
+    }
+
+    go listen(rooms, *udp)
+    rooms.Run()
+
+    // End of quiz report.
+    for room, disputes := range allDisputes {
+        if rooms.Count() > 1 { fmt.Printf("Room %d:\n", room + 1) }
+        disputes.Report()
+    }
+
+    for _, lock := range locks { lock.Release() }
 }
 
 
-func listen(swarm *Swarm) {
+// Directory to keep persistent data in.
+const StorageDir = "quizdata"
+
+
+// Parse the given comma separated list of durations, eg "5m,30s".
+func parseDurations(s string) ([]time.Duration, error) {
+    var durations []time.Duration
+    if s == "" { return durations, nil }
+
+    for _, field := range strings.Split(s, ",") {
+        d, err := time.ParseDuration(strings.TrimSpace(field))
+        if err != nil { return nil, err }
+
+        durations = append(durations, d)
+    }
+
+    return durations, nil
+}
+
+
+// Parse the given comma separated list of integers, eg "20,50".
+func parseInts(s string) ([]int, error) {
+    var values []int
+    if s == "" { return values, nil }
+
+    for _, field := range strings.Split(s, ",") {
+        v, err := strconv.Atoi(strings.TrimSpace(field))
+        if err != nil { return nil, err }
+
+        values = append(values, v)
+    }
+
+    return values, nil
+}
+
+
+func listen(rooms *Rooms, udp bool) {
+    if udp {
+        // Without UDP presses still get through over TCP, so carry on.
+        err := ListenUdp(":9753", rooms)
+        if err != nil { fmt.Println("Error listening for UDP presses, using TCP only:", err.Error()) }
+    }
+
     // Listen for incoming connections.
     listener, err := net.Listen("tcp", ":9753")
     if err != nil {
-        fmt.Println("Error listening:", err.Error())
+        fmt.Println("Error listening for buzzers:", err.Error())
+        fmt.Println("Something else is using the buzzer port, maybe a quiz server using a different directory")
         os.Exit(1)
     }
 
@@ -88,6 +450,6 @@ func listen(swarm *Swarm) {
         }
 
         // Handle connections in a new goroutine.
-        HandleNode(conn, swarm)
+        HandleNode(conn, rooms.Lobby())
     }
 }
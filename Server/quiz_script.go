@@ -0,0 +1,470 @@
+/* Functions to run a quiz from a script of questions.
+
+Rather than the user typing in each question's command, the questions may be read from a JSON script file at startup.
+Each question gives its type, text and answer, which are printed for the quizmaster, plus whatever the type needs to
+start the question. For example:
+
+    {"questions": [
+        {"round": "General knowledge", "type": "quickfire", "text": "What is the capital of France?", "answer": "Paris",
+            "alternatives": ["Paree"], "marks": 2,
+            "bonuses": [
+                {"topic": "Sport", "type": "quickfire", "text": "Who won in 1966?", "answer": "England", "marks": 1},
+                {"topic": "Music", "type": "choice", "text": "Who sang Waterloo?",
+                    "options": ["Abba", "Queen", "Blur", "Oasis"], "answer": "A", "marks": 1}
+            ]},
+        {"type": "choice", "text": "How many legs has a spider?", "options": ["6", "8", "10"], "answer": "B",
+            "marks": 1, "media": "spider.jpg"},
+        {"type": "parallel", "text": "Name 3 planets", "answer": "Any 3", "marks": 2, "fastest": 1},
+        {"round": "Music", "type": "quickfire", "text": "Name these Beatles songs", "parts": [
+            {"text": "Yesterday all my troubles...", "answer": "Yesterday", "marks": 1},
+            {"text": "Picture yourself in a boat on a river...", "answer": "Lucy in the Sky", "marks": 2}
+        ]}
+    ]}
+
+The question types are:
+* quickfire, a buzz-in question, optionally for only some teams ("teams": "BG") and limited attempts ("attempts": 2).
+* choice, a multiple choice question, giving the options and the letter of the correct one.
+* parallel, a parallel challenge, giving the bonus for the fastest correct team ("fastest": 1).
+
+Marks may include a half mark, eg "marks": 2.5. Any question may list alternative answers that are also accepted.
+
+A question may name the round it starts, which is printed when it's asked and groups the questions for the pacing
+simulation, see simulator.go. Rounds themselves are still started by the user, see rounds.go.
+
+A quick fire question may be in parts, each with its own text, answer and marks, which make up the question's marks.
+The player who buzzes is judged on each part in turn, see quick_fire.go.
+
+The user moves on to each question in turn with the continue command, which prints the question and starts it with the
+right controller, exactly as if the user had typed its command. Everything else, such as judging, is done with the
+controller's usual commands.
+
+A question may have bonus questions, each on a different topic. When a team wins the question outright, the topics are
+listed and the user chooses one by entering its number. A quick fire bonus is open only to the winning team, other
+types are open to all. Continuing without choosing skips the bonus.
+
+A question may show a picture, or play audio or video, on the audience display, given as a URL or as a file in the
+media directory next to the script file, which is served at /media/, see web.go. The kind of media is told from its
+extension, see _mediaKinds. The media is added to the game state, for the display to show, from when the question
+opens until it closes.
+
+The latest question asked, with its answer and alternatives, is also shown on the host page, see web.go, so the
+quizmaster can read from a tablet while the operator keeps the console.
+
+Our place in the script is saved to storage as we go, so when the scores are restored from a previous server running
+the same script, see Scoreboard, we carry on from where it got to.
+
+All quiz script functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "encoding/json"
+import "fmt"
+import "net/url"
+import "os"
+import "path"
+import "path/filepath"
+import "strings"
+
+
+// Create a quiz script runner, with the questions read from the given file.
+func CreateQuizScript(engine *Engine, quickFire *QuickFire, filename string) (*QuizScript, error) {
+    var p QuizScript
+    p.engine = engine
+    p.quickFire = quickFire
+    p.mediaDir = filepath.Join(filepath.Dir(filename), MediaDir)
+    p.filename = filename
+    p.choices = engine.CreateScope()
+
+    err := p.load(filename)
+    if err != nil { return nil, err }
+
+    fmt.Printf("Read quiz script of %d questions from %s\n", len(p.questions), filename)
+
+    engine.RegisterCmd(p.commandContinue, "Continue quiz script with the next question", 'k')
+    engine.Subscribe(p.event)
+    engine.AddStateReporter(p.reportState)
+
+    return &p, nil
+}
+
+
+// Move on to the next question in the script, skipping any bonus not yet chosen.
+func (this *QuizScript) Continue() {
+    if mode := this.engine.State().Mode; mode != "" {
+        this.engine.Errorf("Finish the current %s before continuing", mode)
+        return
+    }
+
+    if this.choosing {
+        fmt.Printf("Bonus skipped\n")
+        this.choices.Close()
+        this.choosing = false
+    }
+
+    if this.next >= len(this.questions) {
+        fmt.Printf("End of quiz script\n")
+        return
+    }
+
+    this.current = &this.questions[this.next]
+    this.next++
+    this.inBonus = false
+    this.save()
+    if this.current.Round != "" { fmt.Printf("Round: %s\n", this.current.Round) }
+    this.ask(fmt.Sprintf("Q%d", this.next), this.current, -1)
+}
+
+
+// Report the latest question asked, for the host to read out.
+func (this *QuizScript) HostPrompt() HostPrompt {
+    if this.asked == nil { return HostPrompt{} }
+
+    return HostPrompt{Label: this.askedLabel, Text: this.asked.Text, Options: this.asked.Options,
+        Answer: this.asked.Answer, Alternatives: this.asked.Alternatives, Parts: this.asked.Parts,
+        Media: this.asked.media()}
+}
+
+
+// Report the directory media files are served from.
+// May be called from any thread.
+func (this *QuizScript) MediaDir() string {
+    return this.mediaDir
+}
+
+
+// Media for the audience display to show.
+type MediaState struct {
+    Url string
+    Kind string  // image, audio or video.
+}
+
+
+// Question for the host to read out.
+type HostPrompt struct {
+    Label string  // Eg "Q3" or "Q3B", blank for no question asked yet.
+    Text string
+    Options []string  // Multiple choice only.
+    Answer string
+    Alternatives []string  // Other answers also accepted.
+    Parts []QuestionPart  // Nil for a question in one part.
+    Media *MediaState  // Nil for none.
+}
+
+
+// Quiz script runner.
+type QuizScript struct {
+    questions []scriptQuestion
+    filename string
+    next int  // Index of next question to ask.
+    current *scriptQuestion  // Latest main question asked, nil for none yet.
+    asked *scriptQuestion  // Latest question asked, main or bonus, nil for none yet.
+    askedLabel string  // Label of the latest question asked.
+    inBonus bool  // Latest question asked is a bonus.
+    choosing bool  // Waiting for the user to choose a bonus topic.
+    bonusTeam int  // Team the bonus is for.
+    choices *Scope  // Bonus topic choice commands.
+    mediaDir string
+    pendingMedia *MediaState  // Media to show once the question being started opens, nil for none.
+    media *MediaState  // Media the audience display should show, nil for none.
+    quickFire *QuickFire
+    engine *Engine
+}
+
+
+// Internals.
+
+// Script file contents.
+type scriptFile struct {
+    Questions []scriptQuestion
+}
+
+// A single question in the script.
+type scriptQuestion struct {
+    Round string  // Name of the round this question starts, blank to carry on the current round.
+    Type string  // One of the _scriptTypes.
+    Topic string  // Bonuses only.
+    Text string
+    Answer string  // Letter of the correct option for multiple choice.
+    Alternatives []string  // Other answers also accepted.
+    Options []string  // Multiple choice only.
+    Marks Marks
+    Teams string  // Quick fire only, team letters, blank for all.
+    Attempts int  // Quick fire only, 0 for no limit.
+    Fastest Marks  // Parallel challenge only, bonus for the fastest correct team.
+    Parts []QuestionPart  // Quick fire only, nil for a question in one part.
+    Media string  // URL, or file in the media directory, of media to show, blank for none.
+    Bonuses []scriptQuestion  // Bonus questions to choose from, one per topic.
+}
+
+// Question types, and the command each starts its question with.
+var _scriptTypes = map[string]byte{
+    "quickfire": 'f',
+    "choice": 'm',
+    "parallel": 'p',
+}
+
+// Media kinds, by file extension.
+var _mediaKinds = map[string]string{
+    ".gif": "image",
+    ".jpeg": "image",
+    ".jpg": "image",
+    ".png": "image",
+    ".svg": "image",
+    ".webp": "image",
+    ".m4a": "audio",
+    ".mp3": "audio",
+    ".ogg": "audio",
+    ".wav": "audio",
+    ".mp4": "video",
+    ".webm": "video",
+}
+
+// Directory media files are kept in, next to the script file.
+const MediaDir = "media"
+
+// Most bonus topics a question may have, since each is chosen by a single digit.
+const MaxBonusTopics = 9
+
+// Our place in the script, as saved to storage.
+type scriptPlace struct {
+    Filename string
+    Next int
+}
+
+const ScriptRecord string = "script"  // Storage record name.
+
+
+// Read the script from the given file.
+func (this *QuizScript) load(filename string) error {
+    data, err := os.ReadFile(filename)
+    if err != nil { return err }
+
+    var file scriptFile
+    err = json.Unmarshal(data, &file)
+    if err != nil { return fmt.Errorf("%s: %v", filename, err) }
+
+    if len(file.Questions) == 0 { return fmt.Errorf("%s: no questions", filename) }
+
+    for i := range file.Questions {
+        question := &file.Questions[i]
+        label := fmt.Sprintf("Q%d", i + 1)
+        err = question.check(label)
+        if err != nil { return fmt.Errorf("%s: %v", filename, err) }
+
+        if len(question.Bonuses) > MaxBonusTopics {
+            return fmt.Errorf("%s: %s has more than %d bonuses", filename, label, MaxBonusTopics)
+        }
+
+        for j := range question.Bonuses {
+            bonus := &question.Bonuses[j]
+            err = bonus.check(fmt.Sprintf("%s bonus %d", label, j + 1))
+            if err != nil { return fmt.Errorf("%s: %v", filename, err) }
+
+            if bonus.Topic == "" { return fmt.Errorf("%s: %s bonus %d has no topic", filename, label, j + 1) }
+            if bonus.Round != "" { return fmt.Errorf("%s: %s bonus %d starts a round", filename, label, j + 1) }
+            if len(bonus.Bonuses) > 0 {
+                return fmt.Errorf("%s: %s bonus %d has bonuses of its own", filename, label, j + 1)
+            }
+        }
+    }
+
+    this.questions = file.Questions
+    return nil
+}
+
+
+// Check this question can be asked, reporting problems against the given label.
+func (this *scriptQuestion) check(label string) error {
+    if _, ok := _scriptTypes[this.Type]; !ok { return fmt.Errorf("%s has unknown type %q", label, this.Type) }
+
+    if (this.Marks < 0) || (this.Fastest < 0) { return fmt.Errorf("%s has negative marks", label) }
+
+    if this.Attempts < 0 { return fmt.Errorf("%s has negative attempts", label) }
+
+    if this.Media != "" {
+        media, err := url.Parse(this.Media)
+        if err != nil { return fmt.Errorf("%s has bad media %q: %v", label, this.Media, err) }
+
+        if _, ok := _mediaKinds[strings.ToLower(path.Ext(media.Path))]; !ok {
+            return fmt.Errorf("%s has media %q of unknown kind", label, this.Media)
+        }
+    }
+
+    if len(this.Parts) > 0 {
+        if this.Type != "quickfire" { return fmt.Errorf("%s has parts, only quick fire questions may", label) }
+
+        var total Marks
+        for i := range this.Parts {
+            if this.Parts[i].Marks < 0 { return fmt.Errorf("%s part %d has negative marks", label, i + 1) }
+            total += this.Parts[i].Marks
+        }
+
+        // The question's marks may be left out, to be made up from its parts.
+        if this.Marks == 0 { this.Marks = total }
+        if this.Marks != total {
+            return fmt.Errorf("%s has %v marks, but its parts add up to %v", label, this.Marks, total)
+        }
+    }
+
+    for i := range this.Teams {
+        if _, ok := decodeTeam(this.Teams[i]); !ok {
+            return fmt.Errorf("%s has unknown team %q", label, this.Teams[i])
+        }
+    }
+
+    if this.Type == "choice" {
+        answer := strings.ToUpper(this.Answer)
+        if (len(answer) != 1) || (answer[0] < 'A') || (answer[0] >= 'A' + MultipleChoiceCount) {
+            return fmt.Errorf("%s answer must be a letter A to %c", label, 'A' + MultipleChoiceCount - 1)
+        }
+    }
+
+    return nil
+}
+
+
+// Report the media this question shows, nil for none.
+func (this *scriptQuestion) media() *MediaState {
+    if this.Media == "" { return nil }
+
+    // Media not given as a full URL is in our media directory.
+    mediaUrl, _ := url.Parse(this.Media)
+    if !mediaUrl.IsAbs() { mediaUrl = &url.URL{Path: "/media/" + strings.TrimPrefix(this.Media, "/")} }
+
+    return &MediaState{Url: mediaUrl.String(), Kind: _mediaKinds[strings.ToLower(path.Ext(mediaUrl.Path))]}
+}
+
+
+// Build the command line that starts this question. A team >= 0 restricts a quick fire question to that team.
+func (this *scriptQuestion) command(team int) string {
+    cmd := string(_scriptTypes[this.Type])
+
+    switch this.Type {
+    case "quickfire":
+        teams := this.Teams
+        if team >= 0 { teams = TeamIdToString(team) }
+
+        // Marks are ended by a separator, since attempts may follow them.
+        cmd += fmt.Sprintf("%v%s%s", this.Marks, MarksSeparator, teams)
+        if this.Attempts > 0 { cmd += fmt.Sprintf("%d", this.Attempts) }
+
+    case "choice":
+        cmd += fmt.Sprintf("%s%v", strings.ToUpper(this.Answer), this.Marks)
+
+    case "parallel":
+        cmd += fmt.Sprintf("%v%s%v", this.Marks, MarksSeparator, this.Fastest)
+    }
+
+    return cmd
+}
+
+
+// Print the given question for the host, labelled as given, and start it.
+// A team >= 0 is the team a bonus question is for.
+func (this *QuizScript) ask(label string, question *scriptQuestion, team int) {
+    fmt.Printf("%s %s\n", label, question.Text)
+
+    for i, option := range question.Options {
+        fmt.Printf("  %c. %s\n", 'A' + i, option)
+    }
+
+    if question.Answer != "" { fmt.Printf("Answer: %s\n", question.Answer) }
+    if len(question.Alternatives) > 0 { fmt.Printf("Also accept: %s\n", strings.Join(question.Alternatives, ", ")) }
+
+    for i, part := range question.Parts {
+        fmt.Printf("  Part %d, for %v marks: %s\n", i + 1, part.Marks, part.Text)
+        if part.Answer != "" { fmt.Printf("    Answer: %s\n", part.Answer) }
+    }
+
+    this.asked = question
+    this.askedLabel = label
+    this.pendingMedia = question.media()
+    this.engine.RunCommand(question.command(team))
+    if len(question.Parts) > 0 { this.quickFire.SetParts(question.Parts) }
+}
+
+
+// Save our place in the script to storage.
+func (this *QuizScript) save() {
+    err := this.engine.Storage().Save(ScriptRecord, scriptPlace{Filename: this.filename, Next: this.next})
+    if err != nil { fmt.Printf("Could not save place in quiz script: %v\n", err) }
+}
+
+
+// Carry on from the place in the script saved by a previous server, if it was running the same script.
+func (this *QuizScript) restore() {
+    var place scriptPlace
+    found, err := this.engine.Storage().Load(ScriptRecord, &place)
+    if err != nil { fmt.Printf("Could not load place in quiz script: %v\n", err) }
+    if !found || (err != nil) || (place.Filename != this.filename) { return }
+    if (place.Next == 0) || (place.Next > len(this.questions)) { return }
+
+    this.next = place.Next
+    this.current = nil
+    this.asked = nil
+    this.choosing = false
+    this.choices.Close()
+    fmt.Printf("Carrying on from quiz script Q%d, continue for the next question\n", this.next)
+}
+
+
+// Event handler, offering the bonus topics when a question with bonuses is won.
+func (this *QuizScript) event(event *Event) {
+    switch event.Type {
+    case EventRestored:
+        this.restore()
+        return
+
+    case EventQuestionOpened:
+        this.media = this.pendingMedia
+        this.pendingMedia = nil
+
+    case EventQuestionClosed:
+        this.media = nil
+
+    case EventResult:
+        // A question may be cancelled before it opens.
+        this.pendingMedia = nil
+    }
+
+    if (event.Type != EventResult) || (this.current == nil) || this.inBonus || this.choosing { return }
+    if (event.Result.Outcome != OutcomeCompleted) || (event.Result.Winner < 0) || (len(this.current.Bonuses) == 0) {
+        return
+    }
+
+    this.bonusTeam = event.Result.Winner
+    this.choosing = true
+
+    fmt.Printf("Select bonus topic for team %s from:\n", TeamIdToString(this.bonusTeam))
+    for i := range this.current.Bonuses {
+        fmt.Printf("%d. %s\n", i + 1, this.current.Bonuses[i].Topic)
+
+        choice := i
+        this.choices.RegisterCmd(func([]int) { this.choose(choice) },
+            "Choose bonus topic " + this.current.Bonuses[i].Topic, byte('1' + i))
+    }
+}
+
+
+// State reporter for the media the audience display should show.
+func (this *QuizScript) reportState(state *GameState) {
+    state.Media = this.media
+}
+
+
+// Ask the bonus question on the specified topic, counting from 0.
+func (this *QuizScript) choose(topic int) {
+    this.choices.Close()
+    this.choosing = false
+    this.inBonus = true
+
+    this.ask(fmt.Sprintf("Q%dB", this.next), &this.current.Bonuses[topic], this.bonusTeam)
+}
+
+
+// Command handler for continuing the script.
+func (this *QuizScript) commandContinue([]int) {
+    this.Continue()
+}
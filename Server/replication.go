@@ -0,0 +1,218 @@
+/* Functions to replicate quiz state to a standby server.
+
+For high stakes events a second machine can run as a standby, ready to take over if the primary laptop dies. The
+primary streams every event it publishes to the standby over TCP, one JSON encoded event per line. The standby replays
+these events, so its scores and event history follow the primary's.
+
+Replication is differential. Whenever the primary connects, the standby first tells it how many events it already has,
+so only the events it's missing are sent. The primary keeps retrying until the standby is reachable, so either machine
+can be started first, or restarted.
+
+To take over, the buzzers must be pointed at the standby, eg by moving the primary's address to it. The standby is a
+normal server in every other respect, so buzzers simply reconnect to it and the quiz carries on.
+
+All replication functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "bufio"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "net"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+
+// Create a replicator, streaming our events to the standby server at the given address.
+func CreateReplicator(engine *Engine, address string) *Replicator {
+    var p Replicator
+    p.address = address
+    p.cond = sync.NewCond(&p.lock)
+
+    engine.Subscribe(p.event)
+    go p.run()
+
+    return &p
+}
+
+
+// Replicator, streaming events to a standby server.
+type Replicator struct {
+    address string
+    lock sync.Mutex  // Protects events, and closed flags of connections.
+    cond *sync.Cond  // Signalled when events are added.
+    events []Event  // All events published, oldest first.
+}
+
+
+// Create a standby, following the primary server that connects to the given address.
+// Score changes are applied to the given scoreboard.
+func CreateStandby(engine *Engine, scoreboard *Scoreboard, address string) (*Standby, error) {
+    var p Standby
+    p.engine = engine
+    p.scoreboard = scoreboard
+
+    listener, err := net.Listen("tcp", address)
+    if err != nil { return nil, err }
+
+    fmt.Printf("Standing by for primary on %s\n", address)
+    go p.listen(listener)
+
+    return &p, nil
+}
+
+
+// Standby, following a primary server.
+type Standby struct {
+    received int  // Count of events received from the primary. Only used in the listening Go routine.
+    scoreboard *Scoreboard
+    engine *Engine
+}
+
+
+// Internals.
+
+// How long to wait before retrying an unreachable standby.
+const ReplicationRetryTime = 5 * time.Second
+
+
+// Event handler, recording the event to send on.
+func (this *Replicator) event(event *Event) {
+    this.lock.Lock()
+    this.events = append(this.events, *event)
+    this.cond.Broadcast()
+    this.lock.Unlock()
+}
+
+
+// Connect to the standby and stream events to it, reconnecting as needed.
+// Never returns. Should be called as a Go routine.
+func (this *Replicator) run() {
+    reported := false  // Whether we've reported the standby as unreachable, to avoid repeating ourselves.
+
+    for {
+        conn, err := net.Dial("tcp", this.address)
+        if err != nil {
+            if !reported { fmt.Printf("Standby %s unreachable, will keep trying: %v\n", this.address, err) }
+            reported = true
+            time.Sleep(ReplicationRetryTime)
+            continue
+        }
+
+        fmt.Printf("Replicating to standby %s\n", this.address)
+        reported = false
+        err = this.stream(conn)
+        conn.Close()
+        fmt.Printf("Lost standby %s: %v\n", this.address, err)
+    }
+}
+
+
+// Stream events to the standby on the given connection.
+// Only returns on error.
+func (this *Replicator) stream(conn net.Conn) error {
+    // The standby tells us how many events it already has.
+    reader := bufio.NewReader(conn)
+    line, err := reader.ReadString('\n')
+    if err != nil { return err }
+
+    sent, err := strconv.Atoi(strings.TrimSpace(line))
+    if err != nil { return err }
+
+    // Notice the standby going away, even while we've nothing to send.
+    closed := false
+    go func() {
+        io.Copy(io.Discard, reader)
+        this.lock.Lock()
+        closed = true
+        this.cond.Broadcast()
+        this.lock.Unlock()
+    }()
+
+    encoder := json.NewEncoder(conn)
+
+    for {
+        this.lock.Lock()
+        for (sent >= len(this.events)) && !closed { this.cond.Wait() }
+        batch := this.events[sent:]
+        this.lock.Unlock()
+
+        if closed { return errors.New("connection closed by standby") }
+
+        for i := range batch {
+            err := encoder.Encode(&batch[i])
+            if err != nil { return err }
+
+            sent++
+        }
+    }
+}
+
+
+// Accept connections from the primary, following one at a time.
+// Only returns on error. Should be called as a Go routine.
+func (this *Standby) listen(listener net.Listener) {
+    defer listener.Close()
+
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            fmt.Printf("Error accepting primary: %v\n", err)
+            return
+        }
+
+        this.follow(conn)
+    }
+}
+
+
+// Follow the primary on the given connection, until it goes away.
+func (this *Standby) follow(conn net.Conn) {
+    defer conn.Close()
+    fmt.Printf("Following primary %s\n", conn.RemoteAddr())
+
+    // Tell the primary what we already have, so it only sends what's new.
+    _, err := fmt.Fprintf(conn, "%d\n", this.received)
+    if err != nil { return }
+
+    scanner := bufio.NewScanner(conn)
+    for scanner.Scan() {
+        var event Event
+        err := json.Unmarshal(scanner.Bytes(), &event)
+        if err != nil {
+            fmt.Printf("Bad event from primary: %v\n", err)
+            break
+        }
+
+        this.received++
+        this.engine.After(0, func() { this.apply(&event) })
+    }
+
+    fmt.Printf("Lost primary %s, ready to take over\n", conn.RemoteAddr())
+}
+
+
+// Apply the given event from the primary.
+// Our event history is built up as we go, with the event times being when we received them.
+func (this *Standby) apply(event *Event) {
+    switch event.Type {
+    case EventQuestionOpened:
+        this.engine.QuestionOpened(event.Mode, event.Marks)
+
+    case EventQuestionClosed:
+        this.engine.QuestionClosed(event.Mode)
+
+    case EventScore:
+        // The scoreboard publishes the event for us.
+        this.scoreboard.Add(event.Team, event.Marks, event.Note)
+
+    default:
+        this.engine.Publish(*event)
+    }
+}
@@ -0,0 +1,270 @@
+/* Functions to run several independent quizzes on one server, in rooms.
+
+Two events at once can share one machine by partitioning the buzzers into rooms. Each room runs its own quiz, with its
+own engine, swarm, scoreboard and game modes, so nothing done in one room affects another. Buzzers still all connect to
+the one buzzer port, and are handed to their room's swarm once they've told us their ID.
+
+Rooms are given as ranges of buzzer numbers, the same for every team, eg "0-7,8-15" puts B0 to B7, G0 to G7 and so on
+in room 1, and B8 to B15 etc in room 2. Buzzers outside every range go in room 1. A buzzer can also be moved to another
+room with a command, eg when one is borrowed from the other event. Moving a connected buzzer disconnects it, so it
+reconnects into its new room.
+
+Every room runs with the same options. With more than one room, each keeps its data and logs in its own subdirectory of
+the storage directory, eg quizdata/room2, and serves its web pages on its own port, room 1 on 8080, room 2 on 8081 and
+so on.
+
+There's only one console, which starts in room 1. Commands go to the room the console is in, and the user switches it
+between rooms with a command, taking hotkey mode with it. Output from every room goes to the console. Quitting in any
+room quits them all.
+
+Room assignments may be looked up from any thread. All other room functions and methods must be called only in the main
+thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "sync"
+
+
+// Create rooms for the given ranges of buzzer numbers, eg "0-7,8-15", blank for a single room with every buzzer.
+// Thread checks, see threads.go, are redone in each room's main thread.
+func CreateRooms(ranges string, threadCheck ThreadCheck) (*Rooms, error) {
+    var p Rooms
+    p.threadCheck = threadCheck
+    p.moved = make(map[int]int)
+    p.ranges = []roomRange{{0, MaxBuzzerNumber}}
+
+    if ranges != "" {
+        p.ranges = nil
+        for _, field := range strings.Split(ranges, ",") {
+            r, err := parseRoomRange(strings.TrimSpace(field))
+            if err != nil { return nil, err }
+
+            for i, other := range p.ranges {
+                if (r.first <= other.last) && (other.first <= r.last) {
+                    return nil, fmt.Errorf("room %d buzzers overlap room %d", len(p.ranges) + 1, i + 1)
+                }
+            }
+
+            p.ranges = append(p.ranges, r)
+        }
+    }
+
+    return &p, nil
+}
+
+
+// Report the number of rooms.
+// May be called from any thread.
+func (this *Rooms) Count() int {
+    return len(this.ranges)
+}
+
+
+// Report the directory the specified room, numbered from 1, should keep its data in.
+// May be called from any thread.
+func (this *Rooms) StorageDir(room int) string {
+    if this.Count() == 1 { return StorageDir }
+
+    return filepath.Join(StorageDir, fmt.Sprintf("room%d", room))
+}
+
+
+// Report the directory the specified room should write its logs to, blank for the current directory.
+// May be called from any thread.
+func (this *Rooms) LogDir(room int) string {
+    if this.Count() == 1 { return "" }
+
+    return this.StorageDir(room)
+}
+
+
+// Report the port the specified room should serve its web pages on.
+// May be called from any thread.
+func (this *Rooms) WebPort(room int) int {
+    return DefaultWebPort + room - 1
+}
+
+
+// Add the quiz of the next room, run by the given engine and swarm.
+// Rooms must be added in order.
+func (this *Rooms) Add(engine *Engine, swarm *Swarm) {
+    room := &Room{number: len(this.rooms) + 1, engine: engine, swarm: swarm}
+    this.rooms = append(this.rooms, room)
+
+    if this.Count() == 1 { return }
+
+    swarm.SetRooms(this)
+    engine.RegisterCmd(func(argValues []int) { this.commandSwitch(room, argValues) },
+        "Switch the console to another room", '>', ARG_NUMBER)
+    engine.RegisterCmd(func(argValues []int) { this.commandMove(room, argValues) },
+        "Move 1 buzzer to another room", '=', ARG_BUZ_ID, ARG_NUMBER)
+}
+
+
+// Report the number of the room the specified buzzer is in.
+// May be called from any thread.
+func (this *Rooms) Room(buzzerId int) int {
+    this.lock.Lock()
+    room, ok := this.moved[buzzerId]
+    this.lock.Unlock()
+    if ok { return room }
+
+    _, number := BuzzerIdToTeam(buzzerId)
+    for i, r := range this.ranges {
+        if (number >= r.first) && (number <= r.last) { return i + 1 }
+    }
+
+    return 1
+}
+
+
+// Report the swarm of the room the specified buzzer is in.
+// May be called from any thread.
+func (this *Rooms) Swarm(buzzerId int) *Swarm {
+    return this.rooms[this.Room(buzzerId) - 1].swarm
+}
+
+
+// Report the swarm every buzzer connects to first, room 1's, which hands each on to its own room.
+// May be called from any thread.
+func (this *Rooms) Lobby() *Swarm {
+    return this.rooms[0].swarm
+}
+
+
+// Report every room's swarm, in room order.
+// May be called from any thread.
+func (this *Rooms) Swarms() []*Swarm {
+    swarms := []*Swarm{}
+    for _, room := range this.rooms { swarms = append(swarms, room.swarm) }
+    return swarms
+}
+
+
+// Run every room's quiz.
+// Only returns once the user has quit.
+func (this *Rooms) Run() {
+    // Room 1 runs in our thread, the others in their own.
+    var running sync.WaitGroup
+    this.rooms[0].engine.TakeConsole()
+
+    for _, room := range this.rooms[1:] {
+        running.Add(1)
+        go func(room *Room) {
+            room.engine.SetThreadChecks(this.threadCheck)
+            room.engine.Run()
+
+            // Quitting in any room quits them all.
+            this.rooms[0].engine.Stop()
+            running.Done()
+        }(room)
+    }
+
+    this.rooms[0].engine.Run()
+
+    for _, room := range this.rooms[1:] { room.engine.Stop() }
+    running.Wait()
+}
+
+
+// Set of rooms.
+type Rooms struct {
+    ranges []roomRange  // Indexed by room number - 1.
+    rooms []*Room  // Indexed by room number - 1.
+    moved map[int]int  // Rooms of buzzers moved by the user, indexed by buzzer ID, protected by lock.
+    lock sync.Mutex
+    threadCheck ThreadCheck
+}
+
+// A single room's quiz.
+type Room struct {
+    number int
+    engine *Engine
+    swarm *Swarm
+}
+
+
+// Internals.
+
+// Highest buzzer number in a team.
+const MaxBuzzerNumber = 15
+
+
+// Range of buzzer numbers in a room.
+type roomRange struct {
+    first int
+    last int
+}
+
+
+// Parse the given range of buzzer numbers, eg "0-7", or a single number.
+func parseRoomRange(s string) (roomRange, error) {
+    fields := strings.SplitN(s, "-", 2)
+    if len(fields) == 1 { fields = append(fields, fields[0]) }
+
+    first, err := strconv.Atoi(fields[0])
+    if err != nil { return roomRange{}, fmt.Errorf("bad room buzzers %q", s) }
+
+    last, err := strconv.Atoi(fields[1])
+    if err != nil { return roomRange{}, fmt.Errorf("bad room buzzers %q", s) }
+
+    if (first < 0) || (last > MaxBuzzerNumber) || (first > last) {
+        return roomRange{}, fmt.Errorf("room buzzers %q must be from 0 to %d", s, MaxBuzzerNumber)
+    }
+
+    return roomRange{first, last}, nil
+}
+
+
+// Command handler for switching the console from the given room to another.
+// Hotkey mode goes with the console.
+func (this *Rooms) commandSwitch(from *Room, argValues []int) {
+    number := argValues[0]
+    if (number < 1) || (number > len(this.rooms)) {
+        from.engine.Errorf("No room %d, there are %d", number, len(this.rooms))
+        return
+    }
+
+    if number == from.number {
+        fmt.Printf("Console already in room %d\n", number)
+        return
+    }
+
+    hotkeys := from.engine.Hotkeys()
+    if hotkeys { from.engine.SetHotkeys(false) }
+
+    to := this.rooms[number - 1]
+    to.engine.TakeConsole()
+    to.engine.After(0, func() {
+        fmt.Printf("Console now in room %d\n", number)
+        if hotkeys { to.engine.SetHotkeys(true) }
+    })
+}
+
+
+// Command handler for moving a buzzer to another room, given in the given room.
+func (this *Rooms) commandMove(from *Room, argValues []int) {
+    id := argValues[0]
+    number := argValues[1]
+    if (number < 1) || (number > len(this.rooms)) {
+        from.engine.Errorf("No room %d, there are %d", number, len(this.rooms))
+        return
+    }
+
+    this.lock.Lock()
+    this.moved[id] = number
+    this.lock.Unlock()
+
+    // Any other room it's connected to lets it go, so it reconnects into its new room.
+    for _, room := range this.rooms {
+        if room.number != number { room.swarm.Disconnect(id) }
+    }
+
+    fmt.Printf("Buzzer %s moved to room %d\n", BuzzerIdToNamedString(id), number)
+}
@@ -0,0 +1,161 @@
+/* Functions to keep the player roster.
+
+A roster may be given, naming the player at each buzzer, so the console can say who buzzed rather than which buzzer did.
+It is read from a text file with one player per line, giving the buzzer ID and the player's name. Lines starting with #
+are comments. For example:
+
+    # Friday night quiz.
+    B1 Alice
+    B2 Bob Smith
+    G1 Carol
+
+Wherever the quiz talks about players, such as who buzzed and who won marks, rostered players are shown by name and
+team, eg "Alice (Blue)". Wherever the buzzer itself matters, such as the buzzer stats and log, the buzzer ID is kept and
+the player's name added, eg "B1 (Alice)", since commands still take buzzer IDs. Buzzers not on the roster are shown by
+ID as usual. Names given for the audience display, see display.go, take precedence there.
+
+Players come and go between rounds, so the roster file can be reloaded mid-quiz. A file with errors is rejected whole,
+leaving the current roster in place.
+
+The roster is only loaded in the main thread, but names may be looked up from any thread.
+
+*/
+
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "strings"
+import "sync"
+
+
+// Load the player roster from the given file, and let the user reload it.
+func CreateRoster(engine *Engine, filename string) (*Roster, error) {
+    var p Roster
+    p.filename = filename
+    p.engine = engine
+
+    err := p.Reload()
+    if err != nil { return nil, err }
+
+    engine.RegisterCmd(p.commandReload, "Reload the player roster file", 'j')
+    return &p, nil
+}
+
+
+// Read the roster file again, replacing the current roster.
+// On error the current roster is kept.
+func (this *Roster) Reload() error {
+    names, err := readRoster(this.filename)
+    if err != nil { return err }
+
+    _rosterLock.Lock()
+    _rosterNames = names
+    _rosterLock.Unlock()
+
+    fmt.Printf("Read roster of %d players from %s\n", len(names), this.filename)
+    return nil
+}
+
+
+// Player roster.
+type Roster struct {
+    filename string
+    engine *Engine
+}
+
+
+// Report the rostered name of the player at the specified buzzer, blank for none.
+// May be called from any thread.
+func RosterName(buzzerId int) string {
+    _rosterLock.RLock()
+    defer _rosterLock.RUnlock()
+
+    return _rosterNames[buzzerId]
+}
+
+
+// Report whether the given word is part of the rostered name of the player at the specified buzzer, ignoring case.
+// May be called from any thread.
+func RosterNameHas(buzzerId int, word string) bool {
+    for _, w := range strings.Fields(RosterName(buzzerId)) {
+        if strings.EqualFold(w, word) { return true }
+    }
+
+    return false
+}
+
+
+// Convert the given buzzer ID to a string naming its player, eg "Alice (Blue)", or just the ID if they're not
+// rostered.
+// May be called from any thread.
+func BuzzerIdToPlayerString(id int) string {
+    name := RosterName(id)
+    if name == "" { return BuzzerIdToString(id) }
+
+    team, _ := BuzzerIdToTeam(id)
+    if team >= TeamCount { return name + " (guest)" }
+
+    return name + " (" + TeamName(team) + ")"
+}
+
+
+// Convert the given buzzer ID to a string, adding its player's name if they're rostered, eg "B1 (Alice)".
+// May be called from any thread.
+func BuzzerIdToNamedString(id int) string {
+    name := RosterName(id)
+    if name == "" { return BuzzerIdToString(id) }
+
+    return BuzzerIdToString(id) + " (" + name + ")"
+}
+
+
+// Internals.
+
+// Current roster, indexed by buzzer ID, protected by _rosterLock.
+var _rosterNames map[int]string
+var _rosterLock sync.RWMutex
+
+
+// Read a roster from the given file.
+func readRoster(filename string) (map[int]string, error) {
+    names := make(map[int]string)
+
+    file, err := os.Open(filename)
+    if err != nil { return nil, err }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    lineNo := 0
+
+    for scanner.Scan() {
+        lineNo++
+        line := strings.TrimSpace(scanner.Text())
+        if (line == "") || (line[0] == '#') { continue }
+
+        fields := strings.SplitN(line, " ", 2)
+        if len(fields) < 2 { return nil, fmt.Errorf("%s:%d: expected buzzer and player name", filename, lineNo) }
+
+        id, err := parseBuzzerId(fields[0])
+        if err != nil { return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err) }
+
+        if _, ok := names[id]; ok {
+            return nil, fmt.Errorf("%s:%d: buzzer %s rostered twice", filename, lineNo, BuzzerIdToString(id))
+        }
+
+        names[id] = strings.TrimSpace(fields[1])
+    }
+
+    err = scanner.Err()
+    if err != nil { return nil, err }
+
+    return names, nil
+}
+
+
+// Command handler for reloading the roster.
+func (this *Roster) commandReload([]int) {
+    err := this.Reload()
+    if err != nil { this.engine.Errorf("Could not reload roster, keeping the old one: %v", err) }
+}
@@ -0,0 +1,356 @@
+/* Functions to track quiz rounds.
+
+A quiz is made up of a number of rounds, which the user starts and ends explicitly. Starting a new round ends the
+current one, if any. A round may be started as a catch-up round, in which trailing teams get extra marks, see
+Scoreboard.
+
+A round may be given a time budget, in minutes, when it starts. We warn the user as each checkpoint time remaining
+is reached and, when the budget runs out, close the current question and the round.
+
+Optionally, a team that sweeps a round, being the only team to win marks on every question in it, is given a bonus
+when the round ends. Questions cancelled once open don't count, but one closed with no winner does, so no one sweeps
+it. A round needs at least SweepMinQuestions questions to be swept. The sweep is worked out from the event history, so
+it follows whichever game modes were played, whether run by hand or by the quiz script.
+
+Optionally, when each round ends, a light runs along the buzzers of the team that gained the most marks in it, a few
+times over, so the room can see who won the round. There's no light sweep if the round was tied, or if a new question
+starts before the sweep is done.
+
+At the end of each round we print a summary of each team's buzzing in that round, compiled from the event history.
+
+When the scores are restored from a previous server, we carry on from its round, see Scoreboard. Its time budget and
+buzzing so far are lost.
+
+All round functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "time"
+
+
+// Create a round tracker.
+func CreateRounds(engine *Engine, scoreboard *Scoreboard) *Rounds {
+    var p Rounds
+    p.engine = engine
+    p.scoreboard = scoreboard
+
+    engine.AddStateReporter(p.reportState)
+    engine.Subscribe(p.event)
+    engine.RegisterCmd(p.commandStart, "Start a new round, optionally with time budget in minutes", 'r',
+        ARG_NUMBER | ARG_OPTIONAL)
+    engine.RegisterCmd(p.commandStartCatchUp, "Start a new catch-up round, trailing teams get extra marks", 'h',
+        ARG_NUMBER | ARG_OPTIONAL)
+    engine.RegisterCmd(p.commandEnd, "End current round", 'e')
+
+    return &p
+}
+
+
+// Set the times remaining at which to warn the user during rounds with a time budget.
+func (this *Rounds) SetCheckpoints(checkpoints []time.Duration) {
+    this.checkpoints = checkpoints
+}
+
+
+// Set the bonus for a team sweeping a round, 0 for none.
+func (this *Rounds) SetSweepBonus(marks Marks) {
+    this.sweepBonus = marks
+}
+
+
+// Set whether to run a light sweep on the winning team's buzzers at the end of each round.
+func (this *Rounds) SetWinnerSweep(on bool) {
+    this.winnerSweep = on
+}
+
+
+// Start a new round, ending the current one first.
+// A budget of 0 means the round is not time limited.
+func (this *Rounds) Start(budget time.Duration) {
+    if this.inRound { this.End() }
+
+    this.round++
+    this.inRound = true
+    this.startEvent = len(this.engine.History())
+    this.engine.Publish(Event{Type: EventRoundStarted, Round: this.round})
+    this.engine.CheckLiveness()
+    this.endTime = 0
+
+    if budget <= 0 {
+        fmt.Printf("Round %d started\n", this.round)
+        return
+    }
+
+    fmt.Printf("Round %d started, with %s\n", this.round, durationString(budget))
+
+    // Schedule warnings and the end of the round, checking the round hasn't already ended when they fire.
+    this.endTime = this.engine.Now() + budget
+    round := this.round
+    for _, checkpoint := range this.checkpoints {
+        if checkpoint >= budget { continue }
+
+        remaining := checkpoint
+        this.engine.After(budget - checkpoint, func() {
+            if this.inRound && (this.round == round) {
+                fmt.Printf("Round %d: %s remaining\n", round, durationString(remaining))
+            }
+        })
+    }
+
+    this.engine.After(budget, func() {
+        if this.inRound && (this.round == round) { this.timeUp() }
+    })
+}
+
+
+// Start a new catch-up round, ending the current one first.
+func (this *Rounds) StartCatchUp(budget time.Duration) {
+    this.Start(budget)
+    this.scoreboard.StartCatchUp()
+}
+
+
+// End the current round.
+func (this *Rounds) End() {
+    if !this.inRound {
+        fmt.Printf("Not in a round\n")
+        return
+    }
+
+    if team := this.sweepTeam(); (team >= 0) && (this.sweepBonus > 0) {
+        fmt.Printf("Team %s swept round %d, bonus %v marks\n", TeamIdToString(team), this.round, this.sweepBonus)
+        this.scoreboard.Add(team, this.sweepBonus, fmt.Sprintf("round %d sweep bonus", this.round))
+    }
+
+    this.inRound = false
+    this.scoreboard.EndCatchUp()
+    this.engine.Publish(Event{Type: EventRoundEnded, Round: this.round})
+    fmt.Printf("Round %d ended\n", this.round)
+    this.printStats()
+
+    if team := this.roundWinner(); (team >= 0) && this.winnerSweep {
+        fmt.Printf("Team %s won round %d\n", TeamIdToString(team), this.round)
+        this.lightSweep(team)
+    }
+}
+
+
+// Round tracker.
+type Rounds struct {
+    round int  // Current or last round, counting from 1, 0 for none yet.
+    inRound bool
+    startEvent int  // Index in event history of start of current round.
+    endTime time.Duration  // Engine time current round's budget runs out, 0 for no budget.
+    checkpoints []time.Duration  // Times remaining at which to warn the user.
+    sweepBonus Marks  // Bonus for a team sweeping a round, 0 for none.
+    winnerSweep bool  // Whether to run a light sweep on the winning team's buzzers at the end of each round.
+    engine *Engine
+    scoreboard *Scoreboard
+}
+
+
+// Internals.
+
+// Fewest questions in a round for it to be swept.
+const SweepMinQuestions = 3
+
+// Winning team light sweep timings.
+const (
+    WinnerSweepStepTime = 120 * time.Millisecond  // How long each buzzer is lit for.
+    WinnerSweepPasses = 3  // How many times the light runs along the team's buzzers.
+)
+
+// Buzzing stats for a single team.
+type teamRoundStats struct {
+    buzzes int
+    correct int
+    incorrect int
+    steals int  // Correct answers after another team got it wrong.
+    gained Marks
+    lost Marks
+}
+
+
+// Add our details to the given game state.
+func (this *Rounds) reportState(state *GameState) {
+    state.Round = this.round
+    state.InRound = this.inRound
+
+    if this.inRound && (this.endTime > 0) { state.AddTimer("Round end", this.endTime, this.engine.Now()) }
+}
+
+
+// Event handler, carrying on from the round restored from a previous server.
+func (this *Rounds) event(event *Event) {
+    if event.Type != EventRestored { return }
+
+    this.round = event.Round
+    this.inRound = event.InRound
+    this.startEvent = len(this.engine.History())
+    this.endTime = 0
+
+    if this.inRound { fmt.Printf("Carrying on with round %d\n", this.round) }
+}
+
+
+// Close the current question and round, since the round's time budget has run out.
+func (this *Rounds) timeUp() {
+    fmt.Printf("Round %d time is up\n", this.round)
+    this.engine.TimeOutModal()
+    this.End()
+}
+
+
+// Convert the given duration to a user friendly string.
+func durationString(d time.Duration) string {
+    if d % time.Minute != 0 { return d.String() }
+
+    if d == time.Minute { return "1 minute" }
+    return fmt.Sprintf("%d minutes", d / time.Minute)
+}
+
+
+// Report the team that swept the current round, by winning marks on every question in it, <0 for none.
+func (this *Rounds) sweepTeam() int {
+    questions := 0
+    wins := make([]int, TeamCount)  // Questions each team won marks on.
+    open := false  // Whether a question is open, awaiting its result.
+
+    for _, event := range this.engine.History()[this.startEvent:] {
+        switch event.Type {
+        case EventQuestionOpened:
+            open = true
+
+        case EventResult:
+            // Results of modals that never opened a question, such as a question cancelled before it was armed,
+            // don't count.
+            if !open || (event.Result == nil) { continue }
+
+            open = false
+            if event.Result.Outcome == OutcomeCancelled { continue }
+
+            questions++
+            for team, marks := range event.Result.Awards {
+                if marks > 0 { wins[team]++ }
+            }
+        }
+    }
+
+    if questions < SweepMinQuestions { return -1 }
+
+    sweeper := -1
+    for team, count := range wins {
+        if count < questions { continue }
+        if sweeper >= 0 { return -1 }  // More than one team won every question, so no one swept it.
+
+        sweeper = team
+    }
+
+    return sweeper
+}
+
+
+// Report the team that gained the most marks in the current round, <0 for none or a tie.
+func (this *Rounds) roundWinner() int {
+    gained := make([]Marks, TeamCount)
+    for _, event := range this.engine.History()[this.startEvent:] {
+        if event.Type == EventScore { gained[event.Team] += event.Marks }
+    }
+
+    winner := 0
+    tied := false
+    for team, marks := range gained {
+        if marks > gained[winner] {
+            winner = team
+            tied = false
+        } else if (team != winner) && (marks == gained[winner]) {
+            tied = true
+        }
+    }
+
+    if tied || (gained[winner] <= 0) { return -1 }
+    return winner
+}
+
+
+// Run a light along the given team's buzzers a few times, then turn them all off.
+// Stops early if a new question starts, leaving the buzzers to it.
+func (this *Rounds) lightSweep(team int) {
+    buzzers := this.engine.TeamBuzzers(team)
+    if len(buzzers) == 0 { return }
+
+    steps := WinnerSweepPasses * len(buzzers)
+    for i := 0; i <= steps; i++ {
+        step := i
+        this.engine.After(time.Duration(step) * WinnerSweepStepTime, func() {
+            if this.engine.State().Mode != "" { return }  // Something else now owns the buzzers.
+
+            if step > 0 { this.engine.SetMode(buzzers[(step - 1) % len(buzzers)], false, false) }
+            if step < steps { this.engine.SetMode(buzzers[step % len(buzzers)], true, false) }
+        })
+    }
+}
+
+
+// Print buzzing stats for each team for the round just ended.
+func (this *Rounds) printStats() {
+    stats := make([]teamRoundStats, TeamCount)
+    wrongQuestion := -1  // Last question that someone got wrong.
+
+    for _, event := range this.engine.History()[this.startEvent:] {
+        switch event.Type {
+        case EventBuzz:
+            stats[event.Team].buzzes++
+
+        case EventJudged:
+            if !event.Correct {
+                stats[event.Team].incorrect++
+                wrongQuestion = event.Question
+            } else {
+                stats[event.Team].correct++
+                if wrongQuestion == event.Question { stats[event.Team].steals++ }
+            }
+
+        case EventScore:
+            if event.Marks > 0 {
+                stats[event.Team].gained += event.Marks
+            } else {
+                stats[event.Team].lost -= event.Marks
+            }
+        }
+    }
+
+    fmt.Printf("Team  Buzzes  Right  Wrong  Steals  Gained  Lost\n")
+    for team, s := range stats {
+        fmt.Printf("   %s  %6d  %5d  %5d  %6d  %6v  %4v\n", TeamIdToString(team), s.buzzes, s.correct, s.incorrect,
+            s.steals, s.gained, s.lost)
+    }
+}
+
+
+// Command handler for starting a new round.
+func (this *Rounds) commandStart(values []int) {
+    this.Start(budgetMinutes(values[0]))
+}
+
+
+// Command handler for starting a new catch-up round.
+func (this *Rounds) commandStartCatchUp(values []int) {
+    this.StartCatchUp(budgetMinutes(values[0]))
+}
+
+
+// Convert the given optional budget argument in minutes to a duration.
+func budgetMinutes(minutes int) time.Duration {
+    if minutes < 0 { return 0 }
+    return time.Duration(minutes) * time.Minute
+}
+
+
+// Command handler for ending the current round.
+func (this *Rounds) commandEnd([]int) {
+    this.End()
+}
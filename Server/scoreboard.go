@@ -1,68 +1,286 @@
 /* Functions to track quiz scores.
 
+Every score change is journalled to storage as it happens, along with every round starting and ending, so nothing is
+lost if the server crashes. The scores and round can then be restored from the journal, either at startup or with a
+command, by a new server that hasn't changed the scores yet. Restoring publishes an event, so anything else that
+follows the quiz's progress, such as the rounds and the quiz script, can catch up too.
+
+The journal keeps the entries of previous quizzes, so a new quiz marks where it starts, when it first writes to the
+journal. Only entries since the last such mark are restored.
+
+Anything interested in score changes, such as displays and logs, may register an observer with the scoreboard. Each
+observer is told about every change, with the team's old and new scores and the reason for the change. The scores are
+printed to the score log and published as events by observers registered here, so callers changing the scores need do
+nothing more.
+
+Every change must be given a reason, such as the game mode and the answer that won the marks, or manual adjustment.
+The reason is recorded with the change in the score log and the event history, which also gives the question number.
+
+For a catch-up round, trailing teams have the marks they're awarded for questions multiplied, based on how far they
+were behind the leader when the round started. A team on half the leader's score gets 1.5 times the marks, for
+example, up to a maximum of CatchUpMaxPercent. Marks given directly by the user are never multiplied.
+
+The final standings break ties between teams on the same score, using the tie-break policies given, see tiebreak.go.
+
+Marks, and so scores, are counted in half marks, so a question can be worth 2.5 marks, say. They're shown, and given
+in JSON, as the number of marks, with any half as .5.
+
+The scores can be hidden from the displays, and from the printed scores, for a dramatic reveal at the end. Scores are
+still tracked as normal while hidden, only what's shown changes. Either only each team's place is shown, or nothing at
+all, until the scores are revealed. Everything that shows scores takes them from the game state, so hiding them there
+hides them everywhere. The final standings only give places while the scores are hidden, and snapshots don't say what
+marks have been won, see state.go. Anything that needs the real scores while they're hidden must ask the scoreboard
+directly.
+
 */
 
 package main
 
+import "encoding/json"
 import "fmt"
 import "math"
 import "os"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
 
 
 // Create a scoreboard.
 func CreateScoreboard(engine *Engine) *Scoreboard {
     var p Scoreboard
-    p.scores = make([]int, 4)  // TODO: Remove embedded 4.
+    p.scores = make([]Marks, TeamCount)
+    p.handicaps = nil
+    p.engine = engine
 
     // Open log file.
-    logFile, err := os.Create(ScoreLogFile)
+    logPath := engine.LogPath(ScoreLogFile)
+    logFile, err := os.Create(logPath)
     if err == nil {
-        fmt.Printf("Writing scores to %s\n", ScoreLogFile)
+        fmt.Printf("Writing scores to %s\n", logPath)
         p.logFile = logFile
     } else {
-        fmt.Printf("Could not open %s for writing: %v\n", ScoreLogFile, err)
+        fmt.Printf("Could not open %s for writing: %v\n", logPath, err)
         p.logFile = os.Stdout
     }
 
+    p.AddObserver(p.publish)
+    p.AddObserver(p.logChange)
+    engine.AddStateReporter(p.reportState)
+    engine.Subscribe(p.event)
+
     engine.RegisterCmd(p.commandAdd, "Give points to a team", '+', ARG_TEAM, ARG_MARKS)
     engine.RegisterCmd(p.commandSub, "Deduct points from a team", '-', ARG_TEAM, ARG_MARKS)
+    engine.RegisterCmd(p.commandRestore, "Restore scores and round saved by a previous server", 'E')
+    engine.RegisterCmd(p.commandStandings, "Print final standings, breaking ties", 'D')
+    engine.RegisterCmd(p.commandHide, "Hide scores, or with 1 show only places", 'H', ARG_NUMBER | ARG_OPTIONAL)
+    engine.RegisterCmd(p.commandReveal, "Reveal hidden scores", 'I')
 
     return &p
 }
 
 
-// Add points to the specified team.
-func (this *Scoreboard) Add(team int, points int) {
-    this.scores[team] += points
+// Register the given function to be told about every score change.
+func (this *Scoreboard) AddObserver(observer ScoreObserver) {
+    this.observers = append(this.observers, observer)
+}
+
+// Function to be told about a score change.
+type ScoreObserver func (change *ScoreChange)
+
+// Details of a single score change.
+type ScoreChange struct {
+    Team int
+    Old Marks
+    New Marks
+    Reason string
+}
+
+
+// Marks or score, in half marks.
+type Marks int
+
+const HalfMark Marks = 1
+
+// Return the given number of whole marks.
+func WholeMarks(marks int) Marks {
+    return Marks(marks) * 2
+}
+
+
+// Return these marks as the user sees them, eg 2.5.
+func (this Marks) String() string {
+    sign := ""
+    if this < 0 {
+        sign = "-"
+        this = -this
+    }
+
+    s := sign + strconv.Itoa(int(this / 2))
+    if (this % 2) != 0 { s += ".5" }
+    return s
+}
+
+
+// Encode these marks as a JSON number of marks.
+func (this Marks) MarshalJSON() ([]byte, error) {
+    return []byte(this.String()), nil
+}
+
+
+// Decode these marks from a JSON number of marks, rounding to the nearest half mark.
+func (this *Marks) UnmarshalJSON(data []byte) error {
+    var marks float64
+    err := json.Unmarshal(data, &marks)
+    if err != nil { return err }
+
+    *this = Marks(math.Round(marks * 2))
+    return nil
+}
+
+
+// Restore the scores and round last saved to storage, eg by a previous server that crashed.
+// Only possible until we've changed the scores or rounds ourselves.
+// Returns false, leaving the scores as they were, if there are none to restore.
+func (this *Scoreboard) Restore() bool {
+    if this.journalled {
+        fmt.Printf("Scores or rounds already changed, too late to restore\n")
+        return false
+    }
+
+    entries := []scoreJournalEntry{}
+    found, err := this.engine.Storage().LoadJournal(ScoreJournal, &entries)
+    if err != nil { fmt.Printf("Could not load scores: %v\n", err) }
+    if !found || (err != nil) { return false }
+
+    // Skip the entries of previous quizzes.
+    for i, entry := range entries {
+        if entry.NewQuiz { entries = entries[i + 1:] }
+    }
+
+    if len(entries) == 0 { return false }
+
+    scores := make([]Marks, TeamCount)
+    round := 0
+    inRound := false
+    for _, entry := range entries {
+        if entry.Team >= TeamCount {
+            fmt.Printf("Saved scores are for more teams than we have, not restored\n")
+            return false
+        }
+
+        if entry.Team >= 0 { scores[entry.Team] = entry.New }
+        round = entry.Round
+        inRound = entry.InRound
+    }
+
+    // Carry on with the same quiz in the journal.
+    this.scores = scores
+    this.round = round
+    this.inRound = inRound
+    this.journalStarted = true
+    fmt.Printf("Restored saved scores, from round %d\n", round)
+
+    this.engine.Publish(Event{Type: EventRestored, Round: round, InRound: inRound})
+    return true
+}
+
+
+// Add points to the specified team, for the given reason.
+func (this *Scoreboard) Add(team int, points Marks, reason string) {
+    this.change(team, points, reason)
 }
 
 
-// Print out the current scores.
+// Award marks for a question to the specified team, for the given reason, applying any catch-up multiplier.
+// Returns the number of marks actually awarded.
+func (this *Scoreboard) Award(team int, marks Marks, reason string) Marks {
+    if this.handicaps != nil {
+        // Round to nearest half mark.
+        awarded := (marks * Marks(this.handicaps[team]) + 50) / 100
+
+        if awarded != marks {
+            fmt.Printf("Catch-up: team %s gets %v marks instead of %v\n", TeamIdToString(team), awarded, marks)
+            reason += fmt.Sprintf(", catch-up from %v", marks)
+            marks = awarded
+        }
+    }
+
+    this.change(team, marks, reason)
+    return marks
+}
+
+
+// Set catch-up multipliers for each team, based on the current standings.
+func (this *Scoreboard) StartCatchUp() {
+    leader := this.scores[this.highestIndex(this.scores)]
+    this.handicaps = make([]int, TeamCount)
+
+    s := ""
+    for team, score := range this.scores {
+        this.handicaps[team] = 100
+
+        if (leader > 0) && (score < leader) {
+            this.handicaps[team] += int((leader - score) * 100 / leader)
+            if this.handicaps[team] > CatchUpMaxPercent { this.handicaps[team] = CatchUpMaxPercent }
+        }
+
+        s += fmt.Sprintf("   %s:x%d.%02d", TeamIdToString(team), this.handicaps[team] / 100,
+            this.handicaps[team] % 100)
+    }
+
+    fmt.Printf("Catch-up multipliers:%s\n", s)
+}
+
+
+// Remove any catch-up multipliers.
+func (this *Scoreboard) EndCatchUp() {
+    if this.handicaps == nil { return }
+
+    this.handicaps = nil
+    fmt.Printf("Catch-up multipliers removed\n")
+}
+
+
+// Print out the current scores, as far as they're visible.
 func (this *Scoreboard) Print() {
-    // We want to find 1st, 2nd, etc places, allowing for ties.
+    fmt.Fprintf(this.logFile, "Scores:%s\n", this.scoresString(this.visibility))
+}
+
+
+// Report the current scores, indexed by team, whether or not they're hidden.
+func (this *Scoreboard) Scores() []Marks {
+    scores := make([]Marks, len(this.scores))
+    copy(scores, this.scores)
+    return scores
+}
+
+
+// Report each team's place, counting from 1, and whether it's tied with another team for that place.
+// Both are indexed by team.
+func (this *Scoreboard) Places() (places []int, tied []bool) {
     // Create a copy of the scores that we can destroy.
-    scores := make([]int, len(this.scores))
+    scores := make([]Marks, len(this.scores))
     copy(scores, this.scores)
 
-    places := make([]int, len(this.scores))
-    ties := make([]string, len(this.scores))
-    for i := range ties { ties[i] = " " }
+    places = make([]int, len(this.scores))
+    tied = make([]bool, len(this.scores))
 
     // Find the team in each place in turn.
-    lastScore := math.MaxInt
+    lastScore := Marks(math.MaxInt)
     lastTeam := -1
     for place := range scores {
         // Find the team in next highest place.
-        team := this.highestIntIndex(scores)
+        team := this.highestIndex(scores)
         places[team] = place + 1  // Places are reported 1 based.
         score := scores[team]
-        scores[team] = math.MinInt
+        scores[team] = Marks(math.MinInt)
 
         // Check for a tie.
         if score == lastScore {
             // This team ties with the previous.
-            ties[team] = "="
-            ties[lastTeam] = "="
+            tied[team] = true
+            tied[lastTeam] = true
             places[team] = places[lastTeam]
         }
 
@@ -70,46 +288,317 @@ func (this *Scoreboard) Print() {
         lastTeam = team
     }
 
-    // Stringify all teams' scores, so we can print ona  single line.
-    s := ""
-    for i := 0; i < 4; i++ {
-        s += fmt.Sprintf("   %s%s%d:%3d.", TeamIdToString(i), ties[i], places[i], this.scores[i])
-        // s += fmt.Sprintf("   %s%d %s %3d.", ties[i], places[i], TeamIdToString(i), this.scores[i])
+    return places, tied
+}
+
+
+// Set the policies to break ties in the final standings with, in the order to apply them.
+func (this *Scoreboard) SetTieBreaks(policies []TieBreak) {
+    this.tieBreaks = policies
+}
+
+
+// Report the final standings, best first, with ties broken as far as our tie-break policies allow.
+// While the scores are hidden only the places are given, with every score 0.
+func (this *Scoreboard) Standings() []Standing {
+    this.engine.CheckMainThread()
+
+    // Group the teams by score first.
+    teams := make([]int, TeamCount)
+    for team := range teams { teams[team] = team }
+    sort.SliceStable(teams, func(i, j int) bool { return this.scores[teams[i]] > this.scores[teams[j]] })
+
+    decidedBy := make([]string, TeamCount)
+    history := this.engine.History()
+    standings := []Standing{}
+    start := 0
+
+    for i := range teams {
+        if (i < len(teams) - 1) && (this.scores[teams[i + 1]] == this.scores[teams[i]]) { continue }
+
+        // Teams start to i all have the same score.
+        for _, group := range breakTies(teams[start:i + 1], this.tieBreaks, history, decidedBy) {
+            place := len(standings) + 1
+            for _, team := range group {
+                score := this.scores[team]
+                if this.visibility != ScoresShown { score = 0 }
+
+                standings = append(standings, Standing{Team: TeamIdToString(team), Score: score, Place: place,
+                    Tied: len(group) > 1, DecidedBy: decidedBy[team]})
+            }
+        }
+
+        start = i + 1
+    }
+
+    return standings
+}
+
+
+// Print out the final standings, to the score log as well.
+func (this *Scoreboard) PrintStandings() {
+    s := "Final standings:\n"
+
+    for _, standing := range this.Standings() {
+        tied := " "
+        if standing.Tied { tied = "=" }
+
+        decided := ""
+        if standing.DecidedBy != "" { decided = ", " + standing.DecidedBy }
+
+        s += fmt.Sprintf("  %s%d %s %3v%s\n", tied, standing.Place, standing.Team, standing.Score, decided)
+    }
+
+    fmt.Print(s)
+    if this.logFile != os.Stdout { fmt.Fprint(this.logFile, s) }
+}
+
+
+// Set how much of the scores to show.
+func (this *Scoreboard) SetVisibility(visibility ScoreVisibility) {
+    this.engine.CheckMainThread()
+
+    if visibility == this.visibility { return }
+
+    this.visibility = visibility
+    fmt.Printf("Scores %s\n", _visibilityDescriptions[visibility])
+    this.engine.Publish(Event{Type: EventVisibility, Note: _visibilityNames[visibility]})
+}
+
+
+// Report how much of the scores are shown.
+func (this *Scoreboard) Visibility() ScoreVisibility {
+    return this.visibility
+}
+
+
+// Reveal the scores, if hidden, printing them out.
+func (this *Scoreboard) Reveal() {
+    if this.visibility == ScoresShown {
+        fmt.Printf("Scores are not hidden\n")
+        return
+    }
+
+    this.SetVisibility(ScoresShown)
+    fmt.Printf("Scores:%s\n", this.scoresString(ScoresShown))
+    this.Print()
+}
+
+
+// Parse the given score visibility name.
+// May be called from any thread.
+func ParseScoreVisibility(name string) (ScoreVisibility, error) {
+    for visibility, n := range _visibilityNames {
+        if strings.EqualFold(name, n) { return ScoreVisibility(visibility), nil }
     }
 
-    // Finally we can print the scores.
-    fmt.Fprintf(this.logFile, "Scores:%s\n", s)
+    return ScoresShown, fmt.Errorf("unknown score visibility %q", name)
 }
 
 
+// How much of the scores are shown.
+const (
+    ScoresShown ScoreVisibility = iota
+    ScoresPlacesOnly  // Each team's place, but not its score.
+    ScoresHidden  // Nothing at all.
+)
+
+type ScoreVisibility int
+
+// Visibility names, as used in the game state and for parsing.
+var _visibilityNames = []string{"shown", "places", "hidden"}
+
+
 // Scoreboard object.
 type Scoreboard struct {
-    scores []int
+    scores []Marks
+    visibility ScoreVisibility
+    handicaps []int  // Catch-up multiplier percentage for each team, nil for none.
+    tieBreaks []TieBreak  // Policies for the final standings, in order.
+    round int  // Current or last round, as journalled.
+    inRound bool
+    journalStarted bool  // Whether the journal is marked with our quiz's start yet.
+    journalled bool  // Whether we've written anything to the journal ourselves.
+    observers []ScoreObserver
     logFile *os.File
+    engine *Engine
 }
 
 
 // Internals.
 
-const (ScoreLogFile string = "score.log")
+const (
+    ScoreLogFile string = "score.log"
+    ScoreJournal string = "scores"  // Storage journal name.
+    ManualReason string = "manual adjustment"
+)
+
+// Visibility descriptions, for reporting.
+var _visibilityDescriptions = []string{"shown", "hidden, showing only places", "hidden"}
+
+// Maximum catch-up multiplier, as a percentage.
+const CatchUpMaxPercent = 200
+
+// Single entry in the score journal.
+type scoreJournalEntry struct {
+    Time time.Time
+    NewQuiz bool  // Marks the start of a new quiz, earlier entries are for previous quizzes.
+    Round int
+    InRound bool
+    Team int  // Team whose score changed, <0 for none, eg a round starting.
+    Old Marks
+    New Marks
+    Reason string
+}
+
+
+// State reporter, adding the scores and places, as far as they're visible.
+func (this *Scoreboard) reportState(state *GameState) {
+    state.ScoresShown = _visibilityNames[this.visibility]
+    if this.visibility == ScoresHidden { return }
+
+    if this.visibility == ScoresShown { state.Scores = this.Scores() }
+
+    places, tied := this.Places()
+    state.Places = make([]string, len(places))
+    for team, place := range places {
+        state.Places[team] = strconv.Itoa(place)
+        if tied[team] { state.Places[team] = "=" + state.Places[team] }
+    }
+}
+
+
+// Stringify all teams' places and scores, as far as the given visibility allows, so we can print on a single line.
+func (this *Scoreboard) scoresString(visibility ScoreVisibility) string {
+    if visibility == ScoresHidden { return " hidden" }
+
+    places, tied := this.Places()
+    s := ""
+    for i := 0; i < TeamCount; i++ {
+        tie := " "
+        if tied[i] { tie = "=" }
+
+        if visibility == ScoresShown {
+            s += fmt.Sprintf("   %s%s%d:%3v.", TeamIdToString(i), tie, places[i], this.scores[i])
+        } else {
+            s += fmt.Sprintf("   %s%s%d.", TeamIdToString(i), tie, places[i])
+        }
+    }
+
+    return s
+}
+
+
+// Change the specified team's score by the given points, telling all observers.
+func (this *Scoreboard) change(team int, points Marks, reason string) {
+    this.engine.CheckMainThread()
+
+    change := ScoreChange{Team: team, Old: this.scores[team], New: this.scores[team] + points, Reason: reason}
+    this.scores[team] = change.New
+    this.journal(scoreJournalEntry{Team: team, Old: change.Old, New: change.New, Reason: reason})
+
+    for _, observer := range this.observers {
+        observer(&change)
+    }
+}
+
+
+// Observer publishing score changes as events.
+func (this *Scoreboard) publish(change *ScoreChange) {
+    this.engine.Publish(Event{Type: EventScore, Team: change.Team, Marks: change.New - change.Old, Note: change.Reason})
+}
+
+
+// Observer recording score changes, and the resulting scores, in the score log.
+func (this *Scoreboard) logChange(change *ScoreChange) {
+    fmt.Fprintf(this.logFile, "Team %s %s, %s\n", TeamIdToString(change.Team), signedMarks(change.New - change.Old),
+        change.Reason)
+    this.Print()
+}
+
+
+// Event handler, journalling rounds starting and ending.
+func (this *Scoreboard) event(event *Event) {
+    switch event.Type {
+    case EventRoundStarted:
+        this.round = event.Round
+        this.inRound = true
+        this.journal(scoreJournalEntry{Team: -1, Reason: fmt.Sprintf("round %d started", event.Round)})
+
+    case EventRoundEnded:
+        this.inRound = false
+        this.journal(scoreJournalEntry{Team: -1, Reason: fmt.Sprintf("round %d ended", event.Round)})
+    }
+}
+
+
+// Append the given entry to the score journal, filling in the time and round.
+func (this *Scoreboard) journal(entry scoreJournalEntry) {
+    storage := this.engine.Storage()
+    this.journalled = true
+
+    if !this.journalStarted {
+        this.journalStarted = true
+        err := storage.Append(ScoreJournal, scoreJournalEntry{Time: time.Now(), NewQuiz: true, Team: -1})
+        if err != nil { fmt.Printf("Could not save scores: %v\n", err) }
+    }
+
+    entry.Time = time.Now()
+    entry.Round = this.round
+    entry.InRound = this.inRound
+
+    err := storage.Append(ScoreJournal, entry)
+    if err != nil { fmt.Printf("Could not save scores: %v\n", err) }
+}
+
 
 // Command handler for adding points to the specified team.
 func (this *Scoreboard) commandAdd(values []int) {
-    this.Add(values[0], values[1])
-    this.Print()
+    this.Add(values[0], Marks(values[1]), ManualReason)
 }
 
 
 // Command handler for subtracting points from the specified team.
 func (this *Scoreboard) commandSub(values []int) {
-    this.Add(values[0], -values[1])
-    this.Print()
+    this.Add(values[0], -Marks(values[1]), ManualReason)
+}
+
+
+// Command handler for restoring saved scores.
+func (this *Scoreboard) commandRestore([]int) {
+    if this.Restore() {
+        this.Print()
+    } else if !this.journalled {
+        fmt.Printf("No saved scores to restore\n")
+    }
+}
+
+
+// Command handler for printing the final standings.
+func (this *Scoreboard) commandStandings([]int) {
+    this.PrintStandings()
+}
+
+
+// Command handler for hiding the scores.
+func (this *Scoreboard) commandHide(values []int) {
+    if values[0] == 1 {
+        this.SetVisibility(ScoresPlacesOnly)
+    } else {
+        this.SetVisibility(ScoresHidden)
+    }
+}
+
+
+// Command handler for revealing hidden scores.
+func (this *Scoreboard) commandReveal([]int) {
+    this.Reveal()
 }
 
 
 // Find the index of the highest value in the given list.
-func (this *Scoreboard) highestIntIndex(values []int) int {
-    maxValue := math.MinInt
+func (this *Scoreboard) highestIndex(values []Marks) int {
+    maxValue := Marks(math.MinInt)
     maxIndex := -1
 
     for i, v := range values {
@@ -121,3 +610,10 @@ func (this *Scoreboard) highestIntIndex(values []int) int {
 
     return maxIndex
 }
+
+
+// Return the given marks with an explicit sign, as for a score change, eg +2.5.
+func signedMarks(marks Marks) string {
+    if marks >= 0 { return "+" + marks.String() }
+    return marks.String()
+}
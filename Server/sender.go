@@ -0,0 +1,130 @@
+/* Functions to send messages to buzzers.
+
+Writing to a buzzer's socket can be slow, so sends are done by a fixed pool of worker Go routines, rather than by the
+swarm's central Go routine. Each buzzer is assigned to a single worker, so its messages are always sent in order, and a
+large fleet doesn't need a Go routine per buzzer.
+
+When a mode change is sent to every buzzer at once, eg to light the whole room, the sends are spread over the workers
+in parallel so the change looks simultaneous. The time taken for the last buzzer's message to be written is measured
+and reported to the swarm, so we can spot fan-outs slow enough to be visible.
+
+A buzzer that stops reading mustn't hold up the others sharing its worker, so each write must complete within
+SendTimeout, or the buzzer is disconnected. Nor may sending ever wait for a worker, since the swarm's Go routine sends
+and workers report back to it. If a worker's queue is full, the buzzer being sent to is disconnected instead.
+
+All sender methods may be called from any thread.
+
+*/
+
+package main
+
+import "sync/atomic"
+import "time"
+
+
+// Create a sender, with its worker Go routines, for the given swarm.
+func CreateSender(swarm *Swarm) *Sender {
+    var p Sender
+    p.swarm = swarm
+
+    for i := 0; i < SendWorkers; i++ {
+        queue := make(chan sendRequest, SendQueueLength)
+        p.queues = append(p.queues, queue)
+        go p.work(queue)
+    }
+
+    return &p
+}
+
+
+// Pick the worker to send all of a new buzzer's messages.
+func (this *Sender) Assign() int {
+    return int(atomic.AddUint32(&this.next, 1) % SendWorkers)
+}
+
+
+// Send the given bytes to the given buzzer, without waiting.
+// The fanOut parameter is the mode change fan-out this send is part of, nil for none.
+func (this *Sender) Send(buzzer *Buzzer, data []byte, fanOut *FanOut) {
+    select {
+    case this.queues[buzzer.worker] <- sendRequest{buzzer, data, fanOut}:
+    default:
+        // The worker's backed up, so give up on this buzzer. Closing its connection disconnects it.
+        this.swarm.Log("Send queue full, disconnecting buzzer %s\n", buzzer.ID())
+        buzzer.conn.Close()
+        if fanOut != nil { go fanOut.done() }  // The caller may be the swarm, which done() reports to.
+    }
+}
+
+
+// Report the number of sends waiting for each worker.
+func (this *Sender) QueueDepths() []int {
+    depths := make([]int, len(this.queues))
+    for i, queue := range this.queues { depths[i] = len(queue) }
+    return depths
+}
+
+
+// Start timing a fan-out of the given number of sends.
+// The duration is reported to the swarm once every send has been written, or has failed.
+func (this *Sender) StartFanOut(count int) *FanOut {
+    var p FanOut
+    p.swarm = this.swarm
+    p.count = count
+    p.remaining = int32(count)
+    p.start = time.Now()
+
+    return &p
+}
+
+
+// Sender of messages to buzzers.
+type Sender struct {
+    swarm *Swarm
+    queues []chan sendRequest  // One per worker.
+    next uint32  // Used to assign workers in turn. Only accessed atomically.
+}
+
+
+// A single message sent to many buzzers.
+type FanOut struct {
+    swarm *Swarm
+    count int
+    remaining int32  // Sends not yet done. Only accessed atomically.
+    start time.Time
+}
+
+
+// Internals.
+
+const (
+    SendWorkers = 8  // Number of Go routines writing to buzzer sockets.
+    SendQueueLength = 1000  // Sends each worker may have waiting.
+    SendTimeout = time.Second  // Longest a single write to a buzzer may take.
+)
+
+
+// A single send to a buzzer.
+type sendRequest struct {
+    buzzer *Buzzer
+    data []byte
+    fanOut *FanOut  // nil for none.
+}
+
+
+// Send each request from the given queue in turn.
+// Never returns. Should be called as a Go routine.
+func (this *Sender) work(queue chan sendRequest) {
+    for request := range queue {
+        request.buzzer.write(request.data)
+        if request.fanOut != nil { request.fanOut.done() }
+    }
+}
+
+
+// Note that one of our sends is done, reporting the time taken if it's the last one.
+func (this *FanOut) done() {
+    if atomic.AddInt32(&this.remaining, -1) == 0 {
+        this.swarm.FanOutDone(this.count, time.Since(this.start))
+    }
+}
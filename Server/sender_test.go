@@ -0,0 +1,57 @@
+package main
+
+import "net"
+import "testing"
+import "time"
+
+
+// Connect a simulated buzzer with the given ID that never reads anything sent to it.
+func (this *testHarness) connectStuck(id int) {
+    server, client := net.Pipe()
+    this.t.Cleanup(func() { client.Close() })
+    HandleNode(server, this.swarm)
+
+    buzzer := &testBuzzer{harness: this, conn: client}
+    if !buzzer.send(BuzzerExpectedVersion, 0x80 | byte(id)) { this.t.Fatalf("Handshake refused") }
+
+    // The swarm hears about the buzzer once its handshake has been read, which we can't tell from here.
+    for start := time.Now(); !this.connected(id); time.Sleep(time.Millisecond) {
+        if time.Since(start) > time.Second { this.t.Fatalf("Stuck buzzer never connected") }
+    }
+}
+
+
+// Check a buzzer that stops reading is disconnected without holding up the swarm or the buzzers sharing its worker.
+func TestStuckBuzzer(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.connectStuck(0x05)
+
+    var others []int
+    for id := 0x10; id < 0x10 + SendWorkers; id++ {
+        harness.connectId(id)
+        others = append(others, id)
+    }
+
+    // A write that doesn't complete in time disconnects the buzzer, letting the rest of the fan-out through.
+    harness.engine.SetModeAll(true, false)
+    harness.wait(SendTimeout + 100 * time.Millisecond)
+    harness.settle()
+
+    if harness.connected(0x05) { t.Fatalf("Stuck buzzer still connected") }
+    for _, id := range others {
+        if !harness.connected(id) { t.Fatalf("Buzzer 0x%02X disconnected along with stuck buzzer", id) }
+    }
+
+    response := make(chan time.Duration, 1)
+    harness.swarm.requests <- func() { response <- harness.swarm.lastFanOut }
+    if <-response == 0 { t.Fatalf("Mode change fan-out never completed") }
+
+    // Filling a stuck buzzer's queue disconnects it, rather than holding up the swarm.
+    harness.connectStuck(0x06)
+    start := time.Now()
+    for i := 0; i <= SendQueueLength; i++ { harness.swarm.SetMode(0x06, (i % 2) == 0, false) }
+    if taken := time.Since(start); taken > SendTimeout / 2 { t.Fatalf("Sending to stuck buzzer took %v", taken) }
+
+    harness.settle()
+    if harness.connected(0x06) { t.Fatalf("Stuck buzzer with full queue still connected") }
+}
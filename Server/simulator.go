@@ -0,0 +1,341 @@
+/* Functions to simulate a quiz script, so organisers can check its pacing before the night.
+
+Rather than running a quiz, the server may run a quiz script end to end with bot teams, see -simulate, then report how
+long the quiz can be expected to take, in total and for each round, and exit. Rounds are named by the questions that
+start them, see quiz_script.go.
+
+Each bot team has a skill, its chance of knowing the answer to any question, and a typical reaction time. Bots are given
+as a comma separated list of <team>=<skill>@<reaction>, eg B=0.8@1.5s,G=0.5@3s, and only the teams given play. By
+default every team plays, with DefaultBotSkill and DefaultBotReaction. Each reaction varies randomly, from half to one
+and a half times the bot's typical reaction time.
+
+Each question takes time to read out at ReadingRate, including its options and parts, and ChangeoverTime to move on
+from. Then, depending on its type:
+* quickfire, bots that know the answer buzz, as do some that don't, with GuessChance, in order of reaction. Each answer
+  takes JudgingTime to judge, for each part if it's in parts, until one is right, the attempts run out or every bot
+  has buzzed. Any bot that could have buzzed but didn't, or no bot at all, is waited for, for GiveUpTime.
+* choice, every bot chooses, taking its reaction time, and is right with its skill. The choices and the answer each
+  take RevealTime to show. As in multiple_choice.go, the question is only won by a team that's alone in being right.
+* parallel, every bot works on the challenge for ParallelWorkTime plus its reaction time, then each answer takes
+  JudgingTime to judge. The first right bot to finish wins.
+
+A bot that wins a question with bonuses takes BonusChoiceTime to choose a topic at random, which is then asked as in
+quiz_script.go.
+
+The quiz is simulated SimulationRuns times, from the same random seed, so changes to a script can be compared fairly.
+
+*/
+
+package main
+
+import "fmt"
+import "math/rand"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+
+// Create a simulator for the quiz script in the given file, with the given bot teams, blank for the default bots.
+func CreateSimulator(filename string, bots string) (*Simulator, error) {
+    if filename == "" { return nil, fmt.Errorf("no quiz script to simulate, see -script") }
+
+    var p Simulator
+    p.filename = filename
+    p.random = rand.New(rand.NewSource(SimulationSeed))
+
+    var script QuizScript
+    err := script.load(filename)
+    if err != nil { return nil, err }
+    p.questions = script.questions
+
+    err = p.parseBots(bots)
+    if err != nil { return nil, err }
+
+    // Questions before the first named round are in a round of their own.
+    for i := range p.questions {
+        name := p.questions[i].Round
+        if (name != "") || (len(p.rounds) == 0) {
+            if name == "" { name = "Round 1" }
+            p.rounds = append(p.rounds, simRound{name: name, first: i})
+        }
+
+        p.rounds[len(p.rounds) - 1].questions++
+        p.roundOf = append(p.roundOf, len(p.rounds) - 1)
+    }
+
+    return &p, nil
+}
+
+
+// Simulate the quiz the given number of times and print the expected length of each round and of the whole quiz.
+func (this *Simulator) Report(runs int) {
+    totals := make([]time.Duration, len(this.rounds))
+    var total, shortest, longest time.Duration
+
+    for run := 0; run < runs; run++ {
+        var quiz time.Duration
+        for round, length := range this.Run() {
+            totals[round] += length
+            quiz += length
+        }
+
+        total += quiz
+        if (run == 0) || (quiz < shortest) { shortest = quiz }
+        if quiz > longest { longest = quiz }
+    }
+
+    fmt.Printf("Simulated %s %d times, with bots:", this.filename, runs)
+    for team, bot := range this.bots {
+        if bot.skill >= 0 { fmt.Printf(" %s=%.2f@%v", TeamIdToString(team), bot.skill, bot.reaction) }
+    }
+
+    fmt.Printf("\n")
+    for round := range this.rounds {
+        questions := fmt.Sprintf("Q%d", this.rounds[round].first + 1)
+        if this.rounds[round].questions > 1 {
+            questions += fmt.Sprintf("-Q%d", this.rounds[round].first + this.rounds[round].questions)
+        }
+
+        fmt.Printf("  %-30s %-9s %v\n", this.rounds[round].name, questions,
+            (totals[round] / time.Duration(runs)).Round(time.Second))
+    }
+
+    fmt.Printf("Expected quiz duration %v, from %v to %v\n", (total / time.Duration(runs)).Round(time.Second),
+        shortest.Round(time.Second), longest.Round(time.Second))
+}
+
+
+// Simulate the quiz once, reporting the length of each round.
+func (this *Simulator) Run() []time.Duration {
+    lengths := make([]time.Duration, len(this.rounds))
+
+    for i := range this.questions {
+        question := &this.questions[i]
+        length, winner := this.ask(question, -1)
+
+        if (winner >= 0) && (len(question.Bonuses) > 0) {
+            bonus := &question.Bonuses[this.random.Intn(len(question.Bonuses))]
+            bonusLength, _ := this.ask(bonus, winner)
+            length += BonusChoiceTime + bonusLength
+        }
+
+        lengths[this.roundOf[i]] += length
+    }
+
+    return lengths
+}
+
+
+// Quiz script simulator.
+type Simulator struct {
+    filename string
+    questions []scriptQuestion
+    rounds []simRound
+    roundOf []int  // Index of each question's round.
+    bots []simBot  // Indexed by team.
+    random *rand.Rand
+}
+
+
+// Internals.
+
+// Bot team.
+type simBot struct {
+    skill float64  // Chance of knowing an answer, 0 to 1, or < 0 for a team that isn't playing.
+    reaction time.Duration  // Typical reaction time.
+}
+
+// Round of the quiz script.
+type simRound struct {
+    name string
+    first int  // Index of the round's first question.
+    questions int
+}
+
+// Bot buzz in a quick fire question.
+type simBuzz struct {
+    team int
+    knows bool  // Bot knows the answer, rather than guessing.
+    after time.Duration  // Time after the question is read out.
+}
+
+const (
+    SimulationRuns = 200
+    SimulationSeed = 1
+    DefaultBotSkill = 0.5
+    DefaultBotReaction = 3 * time.Second
+    GuessChance = 0.2  // Chance of a bot that doesn't know a quick fire answer buzzing anyway.
+    ReadingRate = 2.5  // Words a second.
+    JudgingTime = 5 * time.Second
+    RevealTime = 5 * time.Second
+    GiveUpTime = 10 * time.Second
+    ParallelWorkTime = time.Minute
+    BonusChoiceTime = 10 * time.Second
+    ChangeoverTime = 15 * time.Second
+)
+
+
+// Set up the bot teams from the given list, blank for the default bots.
+func (this *Simulator) parseBots(bots string) error {
+    this.bots = make([]simBot, TeamCount)
+
+    if bots == "" {
+        for team := range this.bots { this.bots[team] = simBot{skill: DefaultBotSkill, reaction: DefaultBotReaction} }
+        return nil
+    }
+
+    for team := range this.bots { this.bots[team].skill = -1 }
+
+    for _, field := range strings.Split(bots, ",") {
+        bot := strings.TrimSpace(field)
+        team, ok := 0, false
+        if (len(bot) > 2) && (bot[1] == '=') { team, ok = decodeTeam(bot[0]) }
+        if !ok { return fmt.Errorf("bad bot %q, expected <team>=<skill>@<reaction>", field) }
+
+        skill, reaction, ok := strings.Cut(bot[2:], "@")
+        if !ok { return fmt.Errorf("bad bot %q, expected <team>=<skill>@<reaction>", field) }
+
+        var err error
+        this.bots[team].skill, err = strconv.ParseFloat(skill, 64)
+        if (err != nil) || !((this.bots[team].skill >= 0) && (this.bots[team].skill <= 1)) {
+            return fmt.Errorf("bad bot %q, skill must be 0 to 1", field)
+        }
+
+        this.bots[team].reaction, err = time.ParseDuration(reaction)
+        if (err != nil) || (this.bots[team].reaction <= 0) {
+            return fmt.Errorf("bad bot %q, reaction must be a positive time, eg 2s", field)
+        }
+    }
+
+    return nil
+}
+
+
+// Simulate asking the given question, reporting how long it takes and the team that won it, -1 for none.
+// A team >= 0 is the team a bonus question is for.
+func (this *Simulator) ask(question *scriptQuestion, team int) (time.Duration, int) {
+    length := this.readingTime(question) + ChangeoverTime
+
+    // As in QuizScript, only a quick fire bonus is restricted to its team.
+    switch question.Type {
+    case "quickfire":
+        teams := question.Teams
+        if team >= 0 { teams = TeamIdToString(team) }
+
+        answering, winner := this.quickFire(question, teams)
+        return length + answering, winner
+
+    case "choice":
+        choosing, winner := this.choice()
+        return length + choosing, winner
+
+    default:
+        working, winner := this.parallel()
+        return length + working, winner
+    }
+}
+
+
+// Report how long the given question takes to read out.
+func (this *Simulator) readingTime(question *scriptQuestion) time.Duration {
+    words := len(strings.Fields(question.Text))
+    for _, option := range question.Options { words += len(strings.Fields(option)) }
+    for _, part := range question.Parts { words += len(strings.Fields(part.Text)) }
+
+    return time.Duration(float64(words) / ReadingRate * float64(time.Second))
+}
+
+
+// Simulate the buzzing and judging of a quick fire question open to the given teams, blank for all.
+func (this *Simulator) quickFire(question *scriptQuestion, teams string) (time.Duration, int) {
+    var buzzes []simBuzz
+    playing := 0
+
+    for team, bot := range this.bots {
+        if (bot.skill < 0) || ((teams != "") && !strings.Contains(teams, TeamIdToString(team))) { continue }
+
+        playing++
+        knows := this.random.Float64() < bot.skill
+        if !knows && (this.random.Float64() >= GuessChance) { continue }
+
+        buzzes = append(buzzes, simBuzz{team: team, knows: knows, after: this.reaction(bot)})
+    }
+
+    sort.Slice(buzzes, func(i, j int) bool { return buzzes[i].after < buzzes[j].after })
+
+    // Later buzzes may already be in by the time an answer is judged.
+    var elapsed time.Duration
+    for i, buzz := range buzzes {
+        if (question.Attempts > 0) && (i >= question.Attempts) { return elapsed, -1 }
+
+        if buzz.after > elapsed { elapsed = buzz.after }
+        right := buzz.knows
+
+        // An answer in parts is right if any part is.
+        if len(question.Parts) > 0 {
+            right = false
+            for range question.Parts {
+                elapsed += JudgingTime
+                if this.random.Float64() < this.bots[buzz.team].skill { right = true }
+            }
+        } else {
+            elapsed += JudgingTime
+        }
+
+        if right { return elapsed, buzz.team }
+    }
+
+    if (len(buzzes) < playing) || (playing == 0) { elapsed += GiveUpTime }
+    return elapsed, -1
+}
+
+
+// Simulate every bot choosing an answer to a multiple choice question.
+func (this *Simulator) choice() (time.Duration, int) {
+    var slowest time.Duration
+    winner := -1
+    correct := 0
+
+    for team, bot := range this.bots {
+        if bot.skill < 0 { continue }
+
+        if reaction := this.reaction(bot); reaction > slowest { slowest = reaction }
+        if this.random.Float64() < bot.skill {
+            winner = team
+            correct++
+        }
+    }
+
+    if correct != 1 { winner = -1 }
+    return slowest + (2 * RevealTime), winner
+}
+
+
+// Simulate every bot working on a parallel challenge, then having its answer judged.
+func (this *Simulator) parallel() (time.Duration, int) {
+    var slowest, fastestRight, judging time.Duration
+    winner := -1
+
+    for team, bot := range this.bots {
+        if bot.skill < 0 { continue }
+
+        finished := ParallelWorkTime + this.reaction(bot)
+        if finished > slowest { slowest = finished }
+
+        if (this.random.Float64() < bot.skill) && ((winner < 0) || (finished < fastestRight)) {
+            winner = team
+            fastestRight = finished
+        }
+
+        judging += JudgingTime
+    }
+
+    return slowest + judging, winner
+}
+
+
+// Pick a reaction time for the given bot.
+func (this *Simulator) reaction(bot simBot) time.Duration {
+    return time.Duration(float64(bot.reaction) * (0.5 + this.random.Float64()))
+}
@@ -0,0 +1,82 @@
+package main
+
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+
+// Create a simulator for the given script, with the given bots, failing the test if it can't be.
+func createTestSimulator(t *testing.T, script string, bots string) *Simulator {
+    filename := filepath.Join(t.TempDir(), "script.json")
+    err := os.WriteFile(filename, []byte(script), 0644)
+    if err != nil { t.Fatalf("Could not write script: %v", err) }
+
+    p, err := CreateSimulator(filename, bots)
+    if err != nil { t.Fatalf("Could not create simulator: %v", err) }
+
+    return p
+}
+
+
+// Check bad bot teams are refused, and that by default every team plays.
+func TestSimulatorBots(t *testing.T) {
+    script := `{"questions": [{"type": "quickfire", "text": "Why?", "marks": 1}]}`
+    filename := filepath.Join(t.TempDir(), "script.json")
+    err := os.WriteFile(filename, []byte(script), 0644)
+    if err != nil { t.Fatalf("Could not write script: %v", err) }
+
+    for _, bots := range []string{"B", "B=", "B=0.5", "Q=0.5@2s", "B=1.5@2s", "B=NaN@2s", "B=0.5@0s", "B=0.5@2",
+        "B=0.5@2s,"} {
+        if _, err := CreateSimulator(filename, bots); err == nil { t.Fatalf("Bots %q accepted", bots) }
+    }
+
+    simulator := createTestSimulator(t, script, "")
+    for team, bot := range simulator.bots {
+        if (bot.skill != DefaultBotSkill) || (bot.reaction != DefaultBotReaction) {
+            t.Fatalf("Team %s bot %+v, expected default", TeamIdToString(team), bot)
+        }
+    }
+
+    simulator = createTestSimulator(t, script, "g=1@1s, B=0@500ms")
+    if simulator.bots[2].skill >= 0 { t.Fatalf("Team R plays, though not given") }
+    if simulator.bots[1] != (simBot{skill: 1, reaction: time.Second}) { t.Fatalf("Team G bot %+v", simulator.bots[1]) }
+}
+
+
+// Check each round takes the time to read, answer and move on from each of its questions, including bonuses won.
+func TestSimulatorTiming(t *testing.T) {
+    simulator := createTestSimulator(t, `{"questions": [
+        {"round": "One", "type": "quickfire", "text": "a b c d e", "marks": 1},
+        {"type": "quickfire", "text": "a b c d e", "marks": 1, "teams": "G"},
+        {"round": "Two", "type": "choice", "text": "x", "options": ["p", "q"], "answer": "A", "marks": 1,
+            "bonuses": [{"topic": "T", "type": "quickfire", "text": "y", "marks": 1}]}
+    ]}`, "B=1@2s")
+
+    if len(simulator.rounds) != 2 { t.Fatalf("%d rounds, expected 2", len(simulator.rounds)) }
+    if simulator.rounds[1] != (simRound{name: "Two", first: 2, questions: 1}) {
+        t.Fatalf("Round %+v, expected Two from Q3", simulator.rounds[1])
+    }
+
+    // Reactions vary from 1s to 3s.
+    second := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+    reading := second(5 / ReadingRate)
+    unanswered := reading + ChangeoverTime + GiveUpTime
+    roundOne := []time.Duration{reading + ChangeoverTime + JudgingTime + unanswered + second(1),
+        reading + ChangeoverTime + JudgingTime + unanswered + second(3)}
+
+    bonus := second(1 / ReadingRate) + ChangeoverTime + JudgingTime + BonusChoiceTime
+    roundTwo := []time.Duration{second(3 / ReadingRate) + ChangeoverTime + (2 * RevealTime) + bonus + second(2),
+        second(3 / ReadingRate) + ChangeoverTime + (2 * RevealTime) + bonus + second(6)}
+
+    for run := 0; run < 50; run++ {
+        lengths := simulator.Run()
+        if (lengths[0] < roundOne[0]) || (lengths[0] > roundOne[1]) {
+            t.Fatalf("Round one took %v, expected %v to %v", lengths[0], roundOne[0], roundOne[1])
+        }
+
+        if (lengths[1] < roundTwo[0]) || (lengths[1] > roundTwo[1]) {
+            t.Fatalf("Round two took %v, expected %v to %v", lengths[1], roundTwo[0], roundTwo[1])
+        }
+    }
+}
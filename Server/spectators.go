@@ -0,0 +1,160 @@
+/* Functions to keep spectators up to date.
+
+Spectators, such as the audience projector or people following on their phones, watch a live scoreboard web page. It
+shows each team's score and place, where the quiz is up to and the latest score announcement. Rather than the page
+polling, the latest scoreboard is pushed to every page following it as a Server-Sent Event whenever a score changes, a
+round starts or ends, there's an announcement or the scores are restored, hidden or revealed. A page that connects
+part way through is sent the latest scoreboard straight away. While the scores are hidden, spectators are sent only
+each team's place, or nothing, so the page can't give them away.
+
+Score changes are followed through a scoreboard observer, rounds and announcements through the engine's events, so
+nothing else need tell us about them.
+
+All spectator functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "encoding/json"
+import "fmt"
+import "sync"
+
+
+// Create the spectator scoreboard manager.
+// Team names and colours are taken from the given theme.
+func CreateSpectators(engine *Engine, scoreboard *Scoreboard, theme *Theme) *Spectators {
+    var p Spectators
+    p.engine = engine
+    p.scoreboard = scoreboard
+    p.theme = theme
+    p.followers = make(map[chan []byte]bool)
+
+    scoreboard.AddObserver(p.scoreChanged)
+    engine.Subscribe(p.event)
+    p.update()
+
+    return &p
+}
+
+
+// Start following the scoreboard. The latest scoreboard is sent on the returned channel straight away, and again
+// whenever it changes. Followers that fall behind only get the latest scoreboard.
+// May be called from any thread.
+func (this *Spectators) Follow() chan []byte {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    follower := make(chan []byte, 1)
+    follower <- this.latest
+    this.followers[follower] = true
+    return follower
+}
+
+
+// Stop following the scoreboard.
+// May be called from any thread.
+func (this *Spectators) Unfollow(follower chan []byte) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    delete(this.followers, follower)
+}
+
+
+// Spectator scoreboard manager.
+type Spectators struct {
+    lock sync.Mutex  // Protects latest and followers.
+    latest []byte  // Latest scoreboard, as JSON.
+    followers map[chan []byte]bool  // Channels to send each new scoreboard to.
+    theme *Theme
+    scoreboard *Scoreboard
+    engine *Engine
+}
+
+
+// Scoreboard, as sent to spectators.
+type SpectatorBoard struct {
+    Round int  // Current or last round, counting from 1, 0 for none yet.
+    InRound bool
+    Question int  // Latest question number, counting from 1.
+    Announcement string  // Latest score announcement, blank for none.
+    ScoresShown string  // How much of the scores are shown: shown, places or hidden.
+    Teams []SpectatorTeam  // Indexed by team.
+}
+
+// A single team's standing, as sent to spectators.
+type SpectatorTeam struct {
+    Name string
+    Colour string  // CSS colour.
+    Score Marks  // 0 while hidden.
+    Place int  // Counting from 1, 0 while hidden.
+    Tied bool  // Shares its place with another team.
+}
+
+
+// Internals.
+
+// Send the latest scoreboard to all followers.
+func (this *Spectators) update() {
+    state := this.engine.State()
+    places, tied := this.scoreboard.Places()
+
+    var board SpectatorBoard
+    board.Round = state.Round
+    board.InRound = state.InRound
+    board.Question = state.Question
+    board.Announcement = state.Announcement
+
+    board.ScoresShown = state.ScoresShown
+
+    for team := 0; team < TeamCount; team++ {
+        theme := this.theme.Teams[team]
+        standing := SpectatorTeam{Name: theme.Name, Colour: theme.Colour}
+
+        // Hidden scores stay hidden from spectators too.
+        if state.Scores != nil { standing.Score = state.Scores[team] }
+        if state.Places != nil {
+            standing.Place = places[team]
+            standing.Tied = tied[team]
+        }
+
+        board.Teams = append(board.Teams, standing)
+    }
+
+    data, err := json.Marshal(board)
+    if err != nil {
+        fmt.Printf("Error encoding spectator scoreboard: %v\n", err)
+        return
+    }
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    this.latest = data
+    for follower := range this.followers {
+        // Replace anything the follower hasn't picked up yet, it's out of date.
+        select {
+        case <-follower:
+        default:
+        }
+
+        follower <- data
+    }
+}
+
+
+// Scoreboard observer.
+func (this *Spectators) scoreChanged(change *ScoreChange) {
+    this.update()
+}
+
+
+// Event handler.
+func (this *Spectators) event(event *Event) {
+    switch event.Type {
+    case EventRoundStarted, EventRoundEnded, EventQuestionOpened, EventAnnouncement, EventRestored,
+        EventVisibility:
+        this.update()
+    }
+}
@@ -0,0 +1,135 @@
+/* Functions for SQLite based persistent storage.
+
+SqliteStorage keeps everything in a single SQLite database file in the storage directory, which suits larger
+deployments better than a directory of files, eg to back up in one go or to query with other tools. Records are kept in
+one table, keyed by name, and journal entries in another, in the order they were appended. Values are stored as JSON,
+as FileStorage stores them, so both backends hold exactly the same data.
+
+SQLite commits each write to disk before returning, so a journal entry is on disk before Append() returns, as required.
+
+The SQLite driver is pure Go, so the server still builds without cgo.
+
+All SqliteStorage methods may be called from any thread.
+
+*/
+
+package main
+
+import "bytes"
+import "database/sql"
+import "encoding/json"
+import "errors"
+import "os"
+import "path/filepath"
+
+import _ "modernc.org/sqlite"
+
+
+// Create an SQLite based storage backend, keeping its database in the given directory.
+func CreateSqliteStorage(dir string) (*SqliteStorage, error) {
+    err := os.MkdirAll(dir, 0755)
+    if err != nil { return nil, err }
+
+    db, err := sql.Open("sqlite", filepath.Join(dir, SqliteFile))
+    if err != nil { return nil, err }
+
+    // A single connection serialises our writes, so SQLite never reports itself busy.
+    db.SetMaxOpenConns(1)
+
+    for _, statement := range _sqliteSchema {
+        _, err = db.Exec(statement)
+        if err != nil {
+            db.Close()
+            return nil, err
+        }
+    }
+
+    var p SqliteStorage
+    p.db = db
+    return &p, nil
+}
+
+
+// Save the given value as the named record, replacing any previous version.
+func (this *SqliteStorage) Save(name string, value interface{}) error {
+    data, err := json.Marshal(value)
+    if err != nil { return err }
+
+    _, err = this.db.Exec("INSERT INTO records (name, value) VALUES (?, ?) " +
+        "ON CONFLICT (name) DO UPDATE SET value = excluded.value", name, string(data))
+    return err
+}
+
+
+// Load the named record into the given value.
+func (this *SqliteStorage) Load(name string, value interface{}) (found bool, err error) {
+    var data string
+    err = this.db.QueryRow("SELECT value FROM records WHERE name = ?", name).Scan(&data)
+    if errors.Is(err, sql.ErrNoRows) { return false, nil }
+    if err != nil { return false, err }
+
+    err = json.Unmarshal([]byte(data), value)
+    if err != nil { return false, err }
+
+    return true, nil
+}
+
+
+// Append the given value to the named journal.
+func (this *SqliteStorage) Append(name string, value interface{}) error {
+    data, err := json.Marshal(value)
+    if err != nil { return err }
+
+    _, err = this.db.Exec("INSERT INTO journals (name, value) VALUES (?, ?)", name, string(data))
+    return err
+}
+
+
+// Load all the entries in the named journal into the given slice.
+func (this *SqliteStorage) LoadJournal(name string, entries interface{}) (found bool, err error) {
+    rows, err := this.db.Query("SELECT value FROM journals WHERE name = ? ORDER BY id", name)
+    if err != nil { return false, err }
+    defer rows.Close()
+
+    var lines [][]byte
+    for rows.Next() {
+        var data string
+        err = rows.Scan(&data)
+        if err != nil { return false, err }
+
+        lines = append(lines, []byte(data))
+    }
+
+    err = rows.Err()
+    if err != nil { return false, err }
+
+    if len(lines) == 0 { return false, nil }
+
+    // Load the entries as a single JSON list.
+    list := append([]byte("["), bytes.Join(lines, []byte(","))...)
+    list = append(list, ']')
+    err = json.Unmarshal(list, entries)
+    if err != nil { return false, err }
+
+    return true, nil
+}
+
+
+// SQLite based storage backend.
+type SqliteStorage struct {
+    db *sql.DB
+}
+
+
+// Internals.
+
+// Name of the database file, in the storage directory.
+const SqliteFile = "quiz.db"
+
+// Tables, created if the database doesn't have them yet.
+var _sqliteSchema = []string{
+    "CREATE TABLE IF NOT EXISTS records (name TEXT PRIMARY KEY, value TEXT NOT NULL)",
+    "CREATE TABLE IF NOT EXISTS journals " +
+        "(id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, value TEXT NOT NULL)",
+    "CREATE INDEX IF NOT EXISTS journals_by_name ON journals (name, id)",
+}
@@ -0,0 +1,222 @@
+/* Functions to report the current game state.
+
+At any time the user, or a display, can ask where we are: the game mode in operation, the open question, which teams
+can still answer, who is answering, who is queued up and any timers running. This is available both at the console
+and as JSON from the web server.
+
+A client that connects, or reconnects, part way through the quiz can get a snapshot, giving the state along with the
+most recent events, so it reflects reality straight away rather than only what happens from then on. The snapshot also
+gives the number of events so far, so a client following events knows where to carry on from. While the scores are
+hidden, see scoreboard.go, the snapshot's score changes say nothing but that they happened, and its results don't give
+the marks awarded.
+
+The engine fills in what it knows itself. Other components add their own details through state reporters. Game modes
+set a reporter for the duration of their modal, other components add one for the life of the program.
+
+All state functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "encoding/json"
+import "fmt"
+import "strings"
+import "time"
+
+
+// Snapshot of the current game state.
+// Buzzers and teams are given as strings, as the user would see them.
+type GameState struct {
+    Mode string  // Modal in operation, blank for none.
+    Question int  // Latest question number, counting from 1.
+    QuestionOpen bool
+    Marks Marks
+    Armed bool  // Whether presses currently count.
+    TeamsAllowed []string  // Teams that can still answer.
+    AckedPlayer string  // Player currently answering, blank for none.
+    DoubleTeam string  // Team playing double for the open question, blank for none.
+    Part string  // Part of the question the player is answering, eg "2/3", blank for a question in one part.
+    PendingPresses []string  // Players queued up to answer, in order.
+    Round int  // Current or last round, counting from 1, 0 for none yet.
+    InRound bool
+    PendingJudgement string  // Judgement awaiting confirmation, blank for none.
+    Timers []string  // Descriptions of running timers.
+    Scores []Marks  // Indexed by team, nil while hidden.
+    Places []string  // Each team's place, eg "=2" for a tie, indexed by team, nil while hidden.
+    ScoresShown string  // How much of the scores are shown: shown, places or hidden.
+    Buzz *BuzzState  // Buzz the audience display should show, nil for none.
+    Media *MediaState  // Media the audience display should show, nil for none.
+    ChoicesMade int  // Teams that have chosen a multiple choice answer, without saying what.
+    ChoicesExpected int  // Teams expected to choose, 0 if not choosing.
+    Choices []string  // Multiple choice answers revealed, indexed by team, blank for no answer, nil for none revealed.
+    CorrectChoice string  // Correct multiple choice answer, blank if not revealed.
+    Pot Marks  // Quick fire marks rolled over from unanswered questions.
+    Announcement string  // Latest score announcement for displays to show, blank for none.
+}
+
+
+// Function to add a component's details to a game state.
+type StateReporter func (state *GameState)
+
+
+// Add a state reporter, for the life of the program.
+func (this *Engine) AddStateReporter(reporter StateReporter) {
+    this.CheckMainThread()
+
+    this.stateReporters = append(this.stateReporters, reporter)
+}
+
+
+// Set the state reporter for the current modal command.
+// The reporter is removed when the modal completes.
+func (this *Engine) SetModalState(reporter StateReporter) {
+    this.CheckMainThread()
+
+    this.modalState = reporter
+}
+
+
+// Report the current game state.
+func (this *Engine) State() GameState {
+    this.CheckMainThread()
+
+    var state GameState
+    state.Mode = this.modalDesc
+    state.Question = this.questionCount
+    state.QuestionOpen = this.questionOpen
+    state.TeamsAllowed = []string{}
+    state.PendingPresses = []string{}
+    state.Timers = []string{}
+
+    for _, reporter := range this.stateReporters { reporter(&state) }
+    if this.modalState != nil { this.modalState(&state) }
+
+    return state
+}
+
+
+// Report the current game state as JSON.
+// May be called from any thread, except the main thread.
+func (this *Engine) StateJSON() ([]byte, error) {
+    var state GameState
+    this.CallAndWait(func() { state = this.State() })
+
+    return json.MarshalIndent(state, "", "  ")
+}
+
+
+// Report a snapshot of the quiz, for a client that's just connected.
+// May be called from any thread, except the main thread.
+func (this *Engine) Snapshot() Snapshot {
+    var snapshot Snapshot
+
+    this.CallAndWait(func() {
+        snapshot.State = this.State()
+        snapshot.EventCount = len(this.history)
+        snapshot.RecentEvents = []string{}
+
+        first := len(this.history) - SnapshotEventCount
+        if first < 0 { first = 0 }
+
+        shown := snapshot.State.ScoresShown
+        hidden := (shown != "") && (shown != _visibilityNames[ScoresShown])
+
+        for i := first; i < len(this.history); i++ {
+            event := &this.history[i]
+            desc := event.String()
+            if hidden { desc = hiddenScoreString(event) }
+
+            snapshot.RecentEvents = append(snapshot.RecentEvents, fmt.Sprintf("%9.3fs  %s", event.Time.Seconds(), desc))
+        }
+    })
+
+    return snapshot
+}
+
+
+// Snapshot of the quiz, for a client that's just connected.
+type Snapshot struct {
+    State GameState
+    EventCount int  // Events published so far.
+    RecentEvents []string  // Descriptions of the most recent events, oldest first.
+}
+
+
+// Add a timer, due at the given engine time, to the given state.
+func (this *GameState) AddTimer(desc string, due time.Duration, now time.Duration) {
+    this.Timers = append(this.Timers, fmt.Sprintf("%s in %v", desc, (due - now).Round(100 * time.Millisecond)))
+}
+
+
+// Internals.
+
+// Describe the given event without giving hidden scores away, leaving out score changes and marks awarded.
+func hiddenScoreString(event *Event) string {
+    switch {
+    case event.Type == EventScore:
+        return fmt.Sprintf("Q%d score hidden", event.Question)
+
+    case (event.Type == EventResult) && (event.Result != nil):
+        result := *event.Result
+        result.Awards = nil
+        return fmt.Sprintf("Q%d %s", event.Question, result.String())
+    }
+
+    return event.String()
+}
+
+
+// Number of recent events in a snapshot.
+const SnapshotEventCount = 10
+
+
+// Print the current game state for the user.
+func (this *Engine) printState() {
+    state := this.State()
+
+    mode := state.Mode
+    if mode == "" { mode = "none" }
+    fmt.Printf("Mode: %s\n", mode)
+
+    if state.QuestionOpen || (state.Mode != "") {
+        armed := "not armed"
+        if state.Armed { armed = "armed" }
+        fmt.Printf("Question %d for %v marks, %s\n", state.Question, state.Marks, armed)
+        fmt.Printf("Teams allowed: %s\n", strings.Join(state.TeamsAllowed, " "))
+    }
+
+    if state.DoubleTeam != "" { fmt.Printf("Playing double: %s\n", state.DoubleTeam) }
+
+    if state.AckedPlayer != "" { fmt.Printf("Answering: %s\n", state.AckedPlayer) }
+    if len(state.PendingPresses) > 0 { fmt.Printf("Queued: %s\n", strings.Join(state.PendingPresses, " ")) }
+
+    if state.Choices != nil {
+        s := ""
+        for team, choice := range state.Choices {
+            if choice == "" { choice = "-" }
+            s += fmt.Sprintf(" %s:%s", TeamIdToString(team), choice)
+        }
+
+        fmt.Printf("Showing choices:%s\n", s)
+    }
+
+    if state.CorrectChoice != "" { fmt.Printf("Showing answer: %s\n", state.CorrectChoice) }
+    if state.ChoicesExpected > 0 { fmt.Printf("Chosen: %d of %d teams\n", state.ChoicesMade, state.ChoicesExpected) }
+    if state.Pot > 0 { fmt.Printf("Rollover pot: %v marks\n", state.Pot) }
+    if state.PendingJudgement != "" { fmt.Printf("Awaiting confirmation: %s\n", state.PendingJudgement) }
+
+    if state.InRound {
+        fmt.Printf("In round %d\n", state.Round)
+    } else if state.Round > 0 {
+        fmt.Printf("Round %d ended\n", state.Round)
+    }
+
+    for _, timer := range state.Timers { fmt.Printf("Timer: %s\n", timer) }
+}
+
+
+// Command handler for printing the current state.
+func (this *Engine) commandState([]int) {
+    this.printState()
+}
@@ -0,0 +1,178 @@
+/* Functions to run game mode state machines.
+
+Each game mode moves through a series of states as a question progresses, eg waiting for a button press, then waiting
+for the user to judge the answer. Which commands, and whether button presses, make sense depends on that state.
+
+A state machine is given the legal transitions between its states, and the states in which each of its commands and
+its button handler apply. The handlers for the current state are registered against an engine scope, which is closed
+on every change of state, so no handler can be left registered after its state has gone. Illegal transitions are
+reported and refused, leaving the state unchanged.
+
+All state machines start in, and are expected to return to, an idle state, in which nothing is registered.
+
+All state machine functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+
+// Create a state machine, in the idle state.
+// The desc parameter is used for error reporting.
+func CreateStateMachine(engine *Engine, desc string) *StateMachine {
+    var p StateMachine
+    p.desc = desc
+    p.state = StateIdle
+    p.transitions = make(map[string]map[string]bool)
+    p.scope = engine.CreateScope()
+
+    engine.AddPanicHandler(p.reset)
+
+    return &p
+}
+
+
+// States common to all state machines.
+const (
+    StateIdle = "idle"  // All state machines start in this state.
+    StateOpen = "open"  // For modes that have no states within a question.
+)
+
+
+// Allow transitions from the given state to each of the given states.
+func (this *StateMachine) AllowTransitions(from string, to ...string) {
+    if this.transitions[from] == nil { this.transitions[from] = make(map[string]bool) }
+
+    for _, state := range to {
+        this.transitions[from][state] = true
+    }
+}
+
+
+// Register the given command handler, to be active only in the given states.
+// Arguments are as for Engine.RegisterCmd().
+func (this *StateMachine) RegisterCmd(states []string, handler CmdHandler, help string, cmd byte, args ...ArgType) {
+    this.cmds = append(this.cmds, stateCmd{states, handler, help, cmd, args})
+}
+
+
+// Register the given button press handler, to be active only in the given states.
+func (this *StateMachine) RegisterButtons(states []string, handler ButtonHandler) {
+    this.buttonStates = states
+    this.buttonHandler = handler
+}
+
+
+// Register the given button press handler, which is also given guest presses, to be active only in the given states.
+func (this *StateMachine) RegisterGuestButtons(states []string, handler ButtonHandler) {
+    this.RegisterButtons(states, handler)
+    this.buttonGuests = true
+}
+
+
+// Register the given gesture handler, to be active only in the given states.
+func (this *StateMachine) RegisterGestures(states []string, handler GestureHandler) {
+    this.gestureStates = states
+    this.gestureHandler = handler
+}
+
+
+// Register the given release handler, to be active only in the given states.
+func (this *StateMachine) RegisterReleases(states []string, handler ReleaseHandler) {
+    this.releaseStates = states
+    this.releaseHandler = handler
+}
+
+
+// Report the current state.
+func (this *StateMachine) State() string {
+    return this.state
+}
+
+
+// Report whether we're in any of the given states.
+func (this *StateMachine) In(states ...string) bool {
+    return stateIn(this.state, states)
+}
+
+
+// Change to the given state, registering and deregistering handlers as needed.
+// Returns false, and leaves the state unchanged, if the transition is not allowed.
+func (this *StateMachine) Change(state string) bool {
+    if !this.transitions[this.state][state] {
+        this.scope.engine.Errorf("Error: Illegal %s transition from %s to %s", this.desc, this.state, state)
+        return false
+    }
+
+    // Deregister everything from the old state before registering anything new, in case characters are shared.
+    this.state = state
+    this.scope.Close()
+
+    for _, cmd := range this.cmds {
+        if stateIn(state, cmd.states) { this.scope.RegisterCmd(cmd.handler, cmd.help, cmd.cmd, cmd.args...) }
+    }
+
+    if (this.buttonHandler != nil) && stateIn(state, this.buttonStates) {
+        if this.buttonGuests {
+            this.scope.RegisterGuestButtons(this.buttonHandler)
+        } else {
+            this.scope.RegisterButtons(this.buttonHandler)
+        }
+    }
+
+    if (this.gestureHandler != nil) && stateIn(state, this.gestureStates) {
+        this.scope.RegisterGestures(this.gestureHandler)
+    }
+
+    if (this.releaseHandler != nil) && stateIn(state, this.releaseStates) {
+        this.scope.RegisterReleases(this.releaseHandler)
+    }
+
+    return true
+}
+
+
+// Game mode state machine.
+type StateMachine struct {
+    desc string
+    state string
+    transitions map[string]map[string]bool  // Indexed by from state, then to state.
+    cmds []stateCmd
+    buttonStates []string
+    buttonHandler ButtonHandler  // nil for none.
+    buttonGuests bool  // Handler also takes guest presses.
+    gestureStates []string
+    gestureHandler GestureHandler  // nil for none.
+    releaseStates []string
+    releaseHandler ReleaseHandler  // nil for none.
+    scope *Scope  // Handlers registered for current state.
+}
+
+
+// Internals.
+
+// A command that's only active in some states.
+type stateCmd struct {
+    states []string
+    handler CmdHandler
+    help string
+    cmd byte
+    args []ArgType
+}
+
+
+// Go straight back to idle, deregistering everything, because the user has panicked.
+func (this *StateMachine) reset() {
+    this.scope.Close()
+    this.state = StateIdle
+}
+
+
+// Report whether the given state is in the given list.
+func stateIn(state string, states []string) bool {
+    for _, s := range states {
+        if s == state { return true }
+    }
+
+    return false
+}
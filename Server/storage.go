@@ -0,0 +1,175 @@
+/* Functions for persistent storage.
+
+Anything that needs to outlive the program, such as scores and stats, is saved through a Storage. This hides the
+storage backend from the rest of the quiz, so a different backend can be dropped in without touching any game logic.
+
+A Storage holds named records, each of which is a single value that can be marshalled to JSON. Saving a record
+replaces any previous version of it.
+
+A Storage also holds named journals, each of which is a list of entries that can be marshalled to JSON. Entries are only
+ever appended, and each is on disk before Append() returns, so a journal loses nothing if we crash.
+
+Two backends are provided, chosen at startup. FileStorage, the default, keeps each record in its own JSON file in a
+directory, and each journal in its own file with one JSON entry per line. SqliteStorage keeps everything in a single
+SQLite database, see sqlite_storage.go.
+
+All Storage methods may be called from any thread.
+
+*/
+
+package main
+
+import "bytes"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "os"
+import "path/filepath"
+import "sync"
+
+
+// Persistent storage backend.
+type Storage interface {
+    // Save the given value as the named record, replacing any previous version.
+    Save(name string, value interface{}) error
+
+    // Load the named record into the given value.
+    // Returns false, with no error, if the record has never been saved.
+    Load(name string, value interface{}) (found bool, err error)
+
+    // Append the given value to the named journal.
+    Append(name string, value interface{}) error
+
+    // Load all the entries in the named journal into the given slice, which must be a pointer.
+    // Returns false, with no error, if nothing has ever been appended.
+    LoadJournal(name string, entries interface{}) (found bool, err error)
+}
+
+
+// Create the named storage backend, file or sqlite, keeping its data in the given directory.
+func CreateStorage(backend string, dir string) (Storage, error) {
+    switch backend {
+    case "file":
+        storage, err := CreateFileStorage(dir)
+        if err != nil { return nil, err }
+        return storage, nil
+
+    case "sqlite":
+        storage, err := CreateSqliteStorage(dir)
+        if err != nil { return nil, err }
+        return storage, nil
+    }
+
+    return nil, fmt.Errorf("unknown storage backend \"%s\", expected file or sqlite", backend)
+}
+
+
+// Create a file based storage backend, keeping records in the given directory.
+func CreateFileStorage(dir string) (*FileStorage, error) {
+    err := os.MkdirAll(dir, 0755)
+    if err != nil { return nil, err }
+
+    var p FileStorage
+    p.dir = dir
+    return &p, nil
+}
+
+
+// Save the given value as the named record, replacing any previous version.
+func (this *FileStorage) Save(name string, value interface{}) error {
+    data, err := json.MarshalIndent(value, "", "  ")
+    if err != nil { return err }
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    // Write to a temporary file first, so a crash mid write can't lose the previous version.
+    path := this.path(name)
+    err = os.WriteFile(path + ".tmp", data, 0644)
+    if err != nil { return err }
+
+    return os.Rename(path + ".tmp", path)
+}
+
+
+// Load the named record into the given value.
+func (this *FileStorage) Load(name string, value interface{}) (found bool, err error) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    data, err := os.ReadFile(this.path(name))
+    if errors.Is(err, os.ErrNotExist) { return false, nil }
+    if err != nil { return false, err }
+
+    err = json.Unmarshal(data, value)
+    if err != nil { return false, err }
+
+    return true, nil
+}
+
+
+// Append the given value to the named journal.
+func (this *FileStorage) Append(name string, value interface{}) error {
+    data, err := json.Marshal(value)
+    if err != nil { return err }
+
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    file, err := os.OpenFile(this.journalPath(name), os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil { return err }
+    defer file.Close()
+
+    _, err = file.Write(append(data, '\n'))
+    if err != nil { return err }
+
+    // Make sure the entry's on disk, it's no use in the page cache if we crash.
+    return file.Sync()
+}
+
+
+// Load all the entries in the named journal into the given slice.
+func (this *FileStorage) LoadJournal(name string, entries interface{}) (found bool, err error) {
+    this.lock.Lock()
+    defer this.lock.Unlock()
+
+    data, err := os.ReadFile(this.journalPath(name))
+    if errors.Is(err, os.ErrNotExist) { return false, nil }
+    if err != nil { return false, err }
+
+    // A crash mid append can leave a partial last line, which we drop.
+    lines := bytes.Split(data, []byte("\n"))
+    var good [][]byte
+    for _, line := range lines {
+        if json.Valid(line) { good = append(good, line) }
+    }
+
+    // Load the entries as a single JSON list.
+    list := append([]byte("["), bytes.Join(good, []byte(","))...)
+    list = append(list, ']')
+    err = json.Unmarshal(list, entries)
+    if err != nil { return false, err }
+
+    return true, nil
+}
+
+
+// File based storage backend.
+type FileStorage struct {
+    dir string
+    lock sync.Mutex  // Protects our files.
+}
+
+
+// Internals.
+
+// Return the path of the file for the named record.
+func (this *FileStorage) path(name string) string {
+    return filepath.Join(this.dir, name + ".json")
+}
+
+
+// Return the path of the file for the named journal.
+func (this *FileStorage) journalPath(name string) string {
+    return filepath.Join(this.dir, name + ".jsonl")
+}
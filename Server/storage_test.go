@@ -0,0 +1,60 @@
+package main
+
+import "reflect"
+import "testing"
+
+
+// Check every storage backend saves and loads records and journals the same way.
+func TestStorageBackends(t *testing.T) {
+    for _, backend := range []string{"file", "sqlite"} {
+        t.Run(backend, func(t *testing.T) {
+            dir := t.TempDir()
+            storage, err := CreateStorage(backend, dir)
+            if err != nil { t.Fatalf("Could not create storage: %v", err) }
+
+            // Records.
+            var scores []Marks
+            found, err := storage.Load("scores", &scores)
+            if found || (err != nil) { t.Fatalf("Load of unsaved record gave %v, %v", found, err) }
+
+            for _, saved := range [][]Marks{{1, 2, 3, 4}, {5, 6, 7, 8}} {
+                err = storage.Save("scores", saved)
+                if err != nil { t.Fatalf("Save failed: %v", err) }
+            }
+
+            found, err = storage.Load("scores", &scores)
+            if !found || (err != nil) { t.Fatalf("Load gave %v, %v", found, err) }
+            if !reflect.DeepEqual(scores, []Marks{5, 6, 7, 8}) { t.Fatalf("Loaded %v, expected latest save", scores) }
+
+            // Journals.
+            var entries []string
+            found, err = storage.LoadJournal("events", &entries)
+            if found || (err != nil) { t.Fatalf("Load of empty journal gave %v, %v", found, err) }
+
+            for _, entry := range []string{"one", "two", "three"} {
+                err = storage.Append("events", entry)
+                if err != nil { t.Fatalf("Append failed: %v", err) }
+            }
+
+            err = storage.Append("other", "elsewhere")
+            if err != nil { t.Fatalf("Append failed: %v", err) }
+
+            found, err = storage.LoadJournal("events", &entries)
+            if !found || (err != nil) { t.Fatalf("Load journal gave %v, %v", found, err) }
+            if !reflect.DeepEqual(entries, []string{"one", "two", "three"}) {
+                t.Fatalf("Loaded journal %v, expected entries in order", entries)
+            }
+
+            // A fresh backend on the same directory sees everything, as after a restart.
+            storage, err = CreateStorage(backend, dir)
+            if err != nil { t.Fatalf("Could not reopen storage: %v", err) }
+
+            entries = nil
+            found, err = storage.LoadJournal("events", &entries)
+            if !found || (err != nil) || (len(entries) != 3) { t.Fatalf("Reopened journal gave %v, %v", entries, err) }
+        })
+    }
+
+    _, err := CreateStorage("floppy", t.TempDir())
+    if err == nil { t.Fatalf("Unknown backend accepted") }
+}
@@ -2,17 +2,112 @@
 
 For each known buzzer we record timing stats, to spot any latency issues.
 
-We record for both the current connection session and the total duration of this program. This is intended to allow
-checking whether a power cycle fixes a buzzer that's having problems. To enable this, we do not delete our record for
-a buzzer when it disconnects.
+We record for both the current connection session and in total. This is intended to allow checking whether a power
+cycle fixes a buzzer that's having problems. To enable this, we do not delete our record for a buzzer when it
+disconnects. Totals, which also count disconnections and errors, are saved to storage, so long term reliability trends
+survive restarts.
+
+We also count each buzzer's presses, for this run of the server and in total. A buzzer that's heartbeating fine but
+hasn't been pressed once, while the rest of the fleet has, probably has a broken button, so the stats flag it. Presses
+for this run are not reset by reconnects, so a blip doesn't make a working button look dead.
+
+We also record the mode each buzzer should be in, including those that aren't currently connected. This allows a buzzer
+that reconnects after a network blip to be restored to the right state for the current question.
+
+A misbehaving buzzer, such as one with a stuck button, can be quarantined. We ignore its presses and send it nothing, but
+keep its connection and stats, so we can see if it recovers.
+
+A buzzer can be forcibly disconnected, eg to make it reconnect. A buzzer that shouldn't be here at all, such as a prank
+client in the audience pretending to be a buzzer, can be banned for the rest of the session, by its ID or by the IP
+address it's connected from. Banned buzzers are disconnected and turned away whenever they connect again. Bans aren't
+saved, so a buzzer banned by mistake only needs the server restarting.
+
+A spare buzzer can be swapped in to replace a dead one mid-quiz. The spare then stands in for the dead buzzer's ID, so
+the game modes see its presses as coming from the dead buzzer and it's put into whatever mode the dead buzzer should be
+in. The dead buzzer is quarantined, in case it comes back to life. Internally we therefore distinguish between the IDs
+physical buzzers report and the logical IDs the rest of the quiz sees. Our stats and admin operations, such as muting,
+use physical IDs, everything the engine sees uses logical IDs.
+
+Buzzers may be given any number of labels, such as "spare" or "table 3", to help manage a large fleet. Stats can be
+filtered by label, and all buzzers with a label can be muted together. Labels are matched case insensitively and are
+saved to storage, so they survive restarts.
+
+Presses may also arrive over UDP, see udp.go. Each such press is sent over both UDP and TCP with the same sequence
+number, so we act on the first copy to arrive and discard the other. We count which path wins, so the stats show whether
+UDP is helping.
+
+An inventory may be given, recording the hardware behind each physical buzzer, such as serial numbers and repairs. It
+is shown with the stats, so a troublesome buzzer can be traced to the hardware.
+
+Trace logging can be restricted to particular buzzers or teams, to avoid flooding the log in a big room. The trace
+level selects how much is traced, from just significant events such as presses, to every message.
+
+When a question opens, a one line summary of fleet health is printed, so the quizmaster can pause to sort out a dead
+buzzer rather than running the question without it.
+
+A buzzer we haven't heard from for too long is disconnected. Since some venues have congested WiFi, giving bursty
+delays, how long is too long is adapted to each buzzer's recent gaps between messages, up to a limit. A buzzer we've
+had to disconnect is also given longer after it reconnects, until it's been stable for a while, so it doesn't flap.
+
+During long rounds with no buzzing, such as written rounds, we may send a buzzer nothing for many minutes, and some
+venue NATs drop such idle connections even though heartbeats are still arriving. To keep connections warm we ping every
+buzzer periodically, or for firmware too old to answer pings, resend the mode of any that we haven't sent anything to
+since the last time. A buzzer that doesn't answer a ping promptly is disconnected, so it reconnects. When a round
+starts, we ping every buzzer and report which answered, so any dead connections are re-established before the buzzing.
+
+Connection changes may also be reported on the console. To avoid spamming the user during network blips, we only
+report a buzzer's state once it has settled.
+
+A buzzer that keeps disconnecting and reconnecting is marked as unstable. We alert the user once, then stop tracing and
+reporting its connection changes until it settles down. Optionally, a buzzer that flaps too often is quarantined.
+
+Each press is also classified as a gesture, once it's complete, and the gesture reported to the engine. A second
+press within DoublePressGap makes a double press. A long press message from the buzzer, while we're still waiting to
+see if there's a second press, makes a long press. Otherwise it's a short press. Buzzers too old to send long press
+messages never make long presses, so we needn't wait for one.
+
+Buzzers from version 9 report when their button is released, and we pass each release on to the engine with how long
+the button was held down for. A button held down for longer than StuckButtonTime is probably stuck, eg jammed or
+shorted, so we warn the user once, suggesting the buzzer is quarantined before it spoils a question.
+
+One buzzer may be designated as a control buzzer, for the quizmaster to drive the quiz with. Its presses are never
+passed on as ordinary presses. Instead we detect patterns of presses, such as a double press, and report each pattern
+when it's complete, see control.go. Older buzzers only report presses, not releases, so patterns are told apart by the
+number of presses in quick succession.
+
+Buzzers from version 10 report telemetry, their battery level and WiFi signal strength, every so often. The latest of
+each is shown with the stats, and the health summary names any buzzers with low batteries. When a buzzer's battery
+first drops to LowBatteryPercent we warn the user, so it can be swapped before it dies mid-quiz. Telemetry is kept
+over reconnects, since a buzzer that keeps dropping out may well have a dying battery.
+
+Heartbeat gaps only show gross stalls. Sustained latency, which makes buzzing races unfair, is measured by sending each
+buzzer from version 11 a timed ping every LatencyPingTime, carrying a nonce that its pong echoes, so a late pong can't
+be mistaken for the answer to a later ping. Each round trip time is counted in a histogram, kept for this run of the
+server over reconnects, and shown with the stats along with pings that went unanswered. The health summary names any
+buzzer whose recent round trips are typically slower than SlowRttTime.
+
+Optionally, presses are compensated for latency, so a player on a slow link isn't beaten by one who pressed later on a
+fast link. Each press is passed to the engine as made half the buzzer's median recent round trip time before we
+received it, up to MaxLatencyCompensation, so that a single wild measurement can't give a buzzer a big head start. A
+buzzer without enough recent round trips measured, such as one that's just connected, isn't compensated. Only the
+press times the engine sees are compensated, the event log still records when each press was received.
+
+Mode changes sent to every buzzer at once are timed, from the request until the last buzzer's message is written. The
+last and worst times are shown with the stats, and any slow enough to be visible in the room are logged.
+
+With several rooms, see rooms.go, each room has its own swarm. Every buzzer connects to the first, which hands it on to
+its room's swarm once it's told us its ID.
 
 */
 
 package main
 
 import "fmt"
+import "net"
 import "os"
 import "sort"
+import "strings"
+import "sync"
 import "time"
 
 
@@ -22,27 +117,68 @@ import "time"
 func CreateSwarm(engine *Engine) *Swarm {
     var p Swarm
     p.buzzers = make(map[int]*buzzerRecord)
+    p.modes = make(map[int]buzzerMode)
+    p.labels = make(map[int][]string)
+    p.aliases = make(map[int]int)
+    p.bannedIds = make(map[int]bool)
+    p.bannedIps = make(map[string]bool)
     p.engine = engine
     p.requests = make(chan func(), 1000)
+    p.pressFlash = true
+    p.traceBuzzer = -1
+    p.traceTeams = AllTeamsMask
+    p.disconnectTime = DefaultDisconnectTime
+    p.sender = CreateSender(&p)
 
     // Open log file.
-    logFile, err := os.Create(BuzzersLogFile)
+    logPath := engine.LogPath(BuzzersLogFile)
+    logFile, err := os.Create(logPath)
     if err == nil {
-        fmt.Printf("Writing buzzer connections to %s\n", BuzzersLogFile)
+        fmt.Printf("Writing buzzer connections to %s\n", logPath)
         p.logFile = logFile
     } else {
-        fmt.Printf("Could not open %s for writing: %v\n", BuzzersLogFile, err)
+        fmt.Printf("Could not open %s for writing: %v\n", logPath, err)
         p.logFile = os.Stdout
     }
 
-    engine.RegisterCmd(p.printStats, "Print buzzer stats", 'Z')
+    // Load totals from previous runs.
+    p.savedTotals = make(map[int]buzzerTotals)
+    _, err = engine.Storage().Load(TotalsRecord, &p.savedTotals)
+    if err != nil { fmt.Printf("Could not load buzzer totals: %v\n", err) }
+
+    // Load buzzer labels.
+    _, err = engine.Storage().Load(LabelsRecord, &p.labels)
+    if err != nil { fmt.Printf("Could not load buzzer labels: %v\n", err) }
+
+    engine.RegisterTextCmd(p.printStats, "Print buzzer stats, optionally only for those with a label", 'Z',
+        ARG_TEXT | ARG_OPTIONAL)
     engine.RegisterCmd(p.commandOn, "Enable outputs on 1 buzzer", 'N', ARG_BUZ_ID)
     engine.RegisterCmd(p.commandOff, "Disable outputs on 1 buzzer", 'F', ARG_BUZ_ID)
     engine.RegisterCmd(p.commandOffAll, "Disable outputs on all buzzers", 'G')
-    engine.RegisterCmd(p.commandTraceToggle, "Toggle button trace logging", 'T')
+    engine.RegisterCmd(p.commandTraceToggle, "Toggle button trace logging, or set trace level", 'T',
+        ARG_NUMBER | ARG_OPTIONAL)
+    engine.RegisterCmd(p.commandTail, "Show the last lines of the buzzer log, default 20", 'l',
+        ARG_NUMBER | ARG_OPTIONAL)
+    engine.RegisterCmd(p.commandTraceBuzzer, "Trace only 1 buzzer", 'B', ARG_BUZ_ID)
+    engine.RegisterCmd(p.commandTraceTeams, "Trace only given teams, or all buzzers", 'J', ARG_TEAMS)
+    engine.RegisterCmd(p.commandReportsToggle, "Toggle buzzer connection reports on console", 'C')
+    engine.RegisterCmd(p.commandPressFlashToggle, "Toggle LED flash acknowledging button presses", 'A')
     engine.RegisterCmd(p.commandMute, "Mute 1 buzzer", 'M', ARG_BUZ_ID)
     engine.RegisterCmd(p.commandUnmute, "Unmute 1 buzzer", 'U', ARG_BUZ_ID)
     engine.RegisterCmd(p.commandUnmuteAll, "Unmute all buzzers", 'V')
+    engine.RegisterCmd(p.commandQuarantine, "Quarantine 1 buzzer, ignoring it completely", 'Q', ARG_BUZ_ID)
+    engine.RegisterCmd(p.commandRelease, "Release 1 buzzer from quarantine", 'R', ARG_BUZ_ID)
+    engine.RegisterCmd(p.commandDisconnect, "Disconnect 1 buzzer, which may reconnect", 'X', ARG_BUZ_ID)
+    engine.RegisterCmd(p.commandBan, "Ban 1 buzzer's ID for the rest of the session", 'Y', ARG_BUZ_ID)
+    engine.RegisterCmd(p.commandBanIp, "Ban the IP address 1 buzzer is connected from for the rest of the session", '@',
+        ARG_BUZ_ID)
+    engine.RegisterCmd(p.commandSwap, "Swap a spare buzzer in to replace a dead one, or a buzzer for itself to undo",
+        'S', ARG_BUZ_ID, ARG_BUZ_ID)
+    engine.RegisterTextCmd(p.commandLabel, "Add a label to 1 buzzer", 'L', ARG_BUZ_ID, ARG_TEXT)
+    engine.RegisterTextCmd(p.commandUnlabel, "Remove a label, or all labels, from 1 buzzer", 'K', ARG_BUZ_ID,
+        ARG_TEXT | ARG_OPTIONAL)
+    engine.RegisterTextCmd(p.commandMuteLabelled, "Mute all buzzers with a label", 'O', ARG_TEXT)
+    engine.RegisterTextCmd(p.commandUnmuteLabelled, "Unmute all buzzers with a label", 'P', ARG_TEXT)
 
     go p.run()
     return &p
@@ -50,8 +186,21 @@ func CreateSwarm(engine *Engine) *Swarm {
 
 
 // Report discovery of a new buzzer.
-func (this *Swarm) NewBuzzer(id int, buzzer *Buzzer) {
+// A virtual buzzer may not claim an ID that's connected, has ever been used by a physical buzzer, is a guest or is the
+// control buzzer, so it can't knock a real buzzer off or take over the quiz. Returns false, doing nothing else, if the
+// buzzer is refused, in which case it should be disconnected.
+// Must not be called from the swarm's own Go routine.
+func (this *Swarm) NewBuzzer(id int, buzzer *Buzzer) bool {
+    response := make(chan bool, 1)
     this.requests <- func() {
+        if buzzer.virtual {
+            if reason := this.virtualRefusal(id); reason != "" {
+                this.Log("Turning away virtual buzzer %s, %s\n", BuzzerIdToNamedString(id), reason)
+                response <- false
+                return
+            }
+        }
+
         // Lookup buzzer.
         p, ok := this.buzzers[id]
 
@@ -59,21 +208,59 @@ func (this *Swarm) NewBuzzer(id int, buzzer *Buzzer) {
             // Record not found for new buzzer, create one.
             var rec buzzerRecord
             rec.id = id
+            totals := this.savedTotals[id]
+            rec.slow2sCountTotal = totals.Slow2s
+            rec.slow3sCountTotal = totals.Slow3s
+            rec.disconnectsTotal = totals.Disconnects
+            rec.errorsTotal = totals.Errors
+            rec.pressesTotal = totals.Presses
+            rec.battery = -1
+            rec.rtts = make([]int, len(LatencyBuckets) + 1)
             p = &rec
             this.buzzers[id] = p
 
-            this.Trace("Buzzer %s connected\n", BuzzerIdToString(id))
-        } else {
-            this.Trace("Buzzer %s reconnected\n", BuzzerIdToString(id))
+            this.Trace(id, TraceEvents, "Buzzer %s connected\n", BuzzerIdToNamedString(id))
+        } else if !p.unstable {
+            this.Trace(id, TraceEvents, "Buzzer %s reconnected\n", BuzzerIdToNamedString(id))
+        }
+
+        if (p.buzzer != nil) && (p.buzzer != buzzer) {
+            // The old connection must be stale, eg the buzzer has rebooted, or something is impersonating it.
+            this.Log("Buzzer %s connected again, closing old connection\n", BuzzerIdToNamedString(id))
+            p.buzzer.conn.Close()
         }
 
         p.buzzer = buzzer
+        if !buzzer.virtual { p.physical = true }
+        p.lastChangeTime = time.Now()
+        p.lastPressSeq = -1
+        if this.eventLog != nil { this.eventLog.Connected(id, buzzer.IP(), buzzer.buzzerVersion) }
+        p.pingTime = time.Time{}
+        p.latencyPingTime = time.Time{}
+        p.held = false
 
         // Clear sessions stats.
         p.lastMsgTime = time.Now()
         p.slow2sCountSession = 0
         p.slow3sCountSession = 0
+        p.worstGapSession = 0
+
+        // Give each team its own pitch, so the quizmaster can tell who buzzed by ear.
+        logicalTeam, _ := BuzzerIdToTeam(this.logicalId(id))
+        buzzer.SetTone(logicalTeam)
+        if this.udpEnabled { buzzer.OfferUdp() }
+        buzzer.OfferFrames()
+
+        // Put the buzzer in the mode it's supposed to be in.
+        if this.currentMode(this.logicalId(id)).ledOn {
+            this.restoreMode(id)
+            this.Log("Restored buzzer %s LED\n", BuzzerIdToNamedString(id))
+        }
+
+        response <- true
     }
+
+    return <-response
 }
 
 
@@ -90,7 +277,12 @@ func (this *Swarm) Disconnected(id int, buzzer *Buzzer) {
         // We've found the specified buzzer. Ditch it.
         // We keep the record for stats purposes.
         rec.buzzer = nil
-        this.Trace("Buzzer %s disconnected\n", BuzzerIdToString(id))
+        rec.lastChangeTime = time.Now()
+        rec.disconnectsTotal++
+        if this.eventLog != nil { this.eventLog.Disconnected(id) }
+        this.totalsChanged = true
+        this.checkFlapping(rec)
+        if !rec.unstable { this.Trace(id, TraceEvents, "Buzzer %s disconnected\n", BuzzerIdToNamedString(id)) }
     }
 }
 
@@ -102,11 +294,18 @@ func (this *Swarm) Received(id int) {
         rec, ok := this.buzzers[id]
         if !ok { return }  // Buzzer not found, nothing to do.
 
+        this.Trace(id, TraceMessages, "Message from %s\n", BuzzerIdToNamedString(id))
+
         now := time.Now()
         gap := now.Sub(rec.lastMsgTime)
         rec.lastMsgTime = now
         slow := false
 
+        if gap > rec.worstGapSession { rec.worstGapSession = gap }
+
+        rec.recentGaps = append(rec.recentGaps, gap)
+        if len(rec.recentGaps) > RecentGapCount { rec.recentGaps = rec.recentGaps[1:] }
+
         if gap > (3 * time.Second) {
             rec.slow3sCountSession++
             rec.slow3sCountTotal++
@@ -117,6 +316,8 @@ func (this *Swarm) Received(id int) {
             slow = true
         }
 
+        if slow { this.totalsChanged = true }
+
         if slow {
             this.Log("Slow message %v\n", gap)
         }
@@ -124,278 +325,1937 @@ func (this *Swarm) Received(id int) {
 }
 
 
-// Handle the given button press event.
-func (this *Swarm) ButtonPress(buzzerId int) {
-    // Just log this and pass it on to our engine.
-    this.Trace("Buzzer %s pressed\n", BuzzerIdToString(buzzerId))
-    this.engine.ButtonPress(buzzerId)
-}
+// Report that a pong, answering our ping, has been received from a buzzer.
+func (this *Swarm) Pong(id int, buzzer *Buzzer) {
+    this.requests <- func() {
+        rec, ok := this.buzzers[id]
+        if !ok || (rec.buzzer != buzzer) { return }
 
+        this.Trace(id, TraceMessages, "Pong from %s after %v\n", BuzzerIdToNamedString(id),
+            time.Since(rec.pingTime).Round(time.Millisecond))
+        rec.pingTime = time.Time{}
+    }
+}
 
-// Send a mode message to the specified buzzer.
-// Returns false if the specified buzzer cannot be found.
-func (this *Swarm) SetMode(buzzerId int, ledOn bool, buzzerOn bool) bool {
-    // Create channel to get response.
-    response := make(chan bool, 1)
 
+// Handle the given timed pong from a buzzer, echoing the given nonce, received at the given time.
+func (this *Swarm) TimedPong(id int, buzzer *Buzzer, nonce byte, received time.Time) {
     this.requests <- func() {
-        // Lookup buzzer.
-        rec, ok := this.buzzers[buzzerId]
-        if !ok || (rec.buzzer == nil) {
-            // Buzzer not found.
-            response <- false
+        rec, ok := this.buzzers[id]
+        if !ok || (rec.buzzer != buzzer) { return }
+
+        if rec.latencyPingTime.IsZero() || (nonce != rec.latencyNonce) {
+            this.Trace(id, TraceMessages, "Stale timed pong from %s\n", BuzzerIdToNamedString(id))
             return
         }
 
-        // Check if the buzzer is muted.
-        if rec.muted { buzzerOn = false }
+        rtt := received.Sub(rec.latencyPingTime)
+        rec.latencyPingTime = time.Time{}
+        this.Trace(id, TraceMessages, "Round trip to %s %v\n", BuzzerIdToNamedString(id), rtt.Round(time.Millisecond))
 
-        // Sending can be slow, so use a fresh Go routine.
-        rec.buzzer.SetMode(ledOn, buzzerOn)
-        response <- true
+        bucket := 0
+        for (bucket < len(LatencyBuckets)) && (rtt > LatencyBuckets[bucket]) { bucket++ }
+        rec.rtts[bucket]++
+        rec.rttTotal += rtt
+
+        rec.recentRtts = append(rec.recentRtts, rtt)
+        if len(rec.recentRtts) > RecentRttCount { rec.recentRtts = rec.recentRtts[1:] }
     }
+}
 
-    // Wait for response.
-    return <-response
+
+// Handle the given long press message from a buzzer, whose button is still held down after its last press.
+func (this *Swarm) LongPress(id int, buzzer *Buzzer) {
+    this.requests <- func() {
+        rec, ok := this.buzzers[id]
+        if !ok || (rec.buzzer != buzzer) || rec.quarantined { return }
+
+        // Only the first press of a gesture can be long.
+        if rec.gesturePresses == 1 { this.reportGesture(rec, GestureLong) }
+    }
 }
 
 
-// Send a mode message to all connected buzzers.
-func (this *Swarm) SetModeAll(ledOn bool, buzzerOn bool) {
+// Handle the given release message from a buzzer, received at the given engine time.
+func (this *Swarm) Release(id int, buzzer *Buzzer, releaseTime time.Duration) {
     this.requests <- func() {
-        // Run through each buzzer in turn.
-        for _, buzzer := range this.buzzers {
-            if buzzer.buzzer != nil {
-                // Check if the buzzer is muted.
-                b := buzzerOn
-                if buzzer.muted { b = false }
+        rec, ok := this.buzzers[id]
+        if !ok || (rec.buzzer != buzzer) || rec.quarantined || !rec.held { return }
 
-                buzzer.buzzer.SetMode(ledOn, b)
-            }
+        held := releaseTime - rec.pressedAt
+        rec.held = false
+        this.Trace(id, TraceEvents, "Buzzer %s released after %dms\n", BuzzerIdToNamedString(id), held.Milliseconds())
+
+        if rec.stuckReported {
+            fmt.Printf("Buzzer %s button released, no longer stuck\n", BuzzerIdToNamedString(id))
+            this.Log("Buzzer %s button released after %v stuck down\n", BuzzerIdToNamedString(id), held)
         }
+
+        logicalId := this.logicalId(id)
+        if (this.controlHandler != nil) && (logicalId == this.controlId) { return }
+
+        this.engine.ButtonRelease(logicalId, held)
     }
 }
 
 
-// Mute or unmute specified buzzer.
-func (this *Swarm) Mute(buzzerId int, mute bool) {
+// Handle the given battery level report from a buzzer, as a percentage.
+func (this *Swarm) Battery(id int, buzzer *Buzzer, percent int) {
     this.requests <- func() {
-        un := ""
-        if !mute { un = "un" }
+        rec, ok := this.buzzers[id]
+        if !ok || (rec.buzzer != buzzer) { return }
 
-        // Lookup buzzer.
-        rec, ok := this.buzzers[buzzerId]
-        if !ok {
-            // Buzzer not found.
-            fmt.Printf("Cannot %smute buzzer %s, not found\n", un, BuzzerIdToString(buzzerId))
+        this.Trace(id, TraceMessages, "Buzzer %s battery %d%%\n", BuzzerIdToNamedString(id), percent)
+        rec.battery = percent
+
+        if percent > LowBatteryPercent {
+            rec.lowBatteryReported = false
             return
         }
 
-        if rec.muted == mute {
-            this.Trace("Buzzer %s already %smuted\n", BuzzerIdToString(buzzerId), un)
-        } else {
-            this.Trace("Buzzer %s %smuted\n", BuzzerIdToString(buzzerId), un)
+        if !rec.lowBatteryReported {
+            rec.lowBatteryReported = true
+            fmt.Printf("Buzzer %s battery low, %d%%, consider swapping it\n", BuzzerIdToNamedString(id), percent)
+            this.Log("Buzzer %s battery low, %d%%\n", BuzzerIdToNamedString(id), percent)
         }
-
-        rec.muted = mute
     }
 }
 
 
-// Unmute all buzzers.
-func (this *Swarm) UnmuteAll() {
+// Handle the given WiFi signal strength report from a buzzer, in dBm.
+func (this *Swarm) Rssi(id int, buzzer *Buzzer, rssi int) {
     this.requests <- func() {
-        // Run through all known buzzers.
-        for id, rec := range this.buzzers {
-            if rec.muted {
-                this.Trace("Buzzer %s unmuted\n", BuzzerIdToString(id))
-            }
+        rec, ok := this.buzzers[id]
+        if !ok || (rec.buzzer != buzzer) { return }
 
-            rec.muted = false
-        }
+        this.Trace(id, TraceMessages, "Buzzer %s signal %ddBm\n", BuzzerIdToNamedString(id), rssi)
+        rec.rssi = rssi
     }
 }
 
 
-// Log to the buzzers log.
-func (this *Swarm) Log(format string, args ...interface{}) {
-    fmt.Fprintf(this.logFile, format, args...)
+// Report that an error message, or an unrecognised message, has been received from a buzzer.
+func (this *Swarm) Error(id int) {
+    this.requests <- func() {
+        rec, ok := this.buzzers[id]
+        if !ok { return }
+
+        rec.errorsTotal++
+        this.totalsChanged = true
+    }
 }
 
 
-// Log to the buzzers trace log.
-func (this *Swarm) Trace(format string, args ...interface{}) {
-    if this.trace {
-        fmt.Fprintf(this.logFile, format, args...)
+// Handle the given button press event, received at the given engine time.
+func (this *Swarm) ButtonPress(buzzerId int, pressTime time.Duration) {
+    this.requests <- func() {
+        this.buttonPress(buzzerId, pressTime)
     }
 }
 
 
-// Object to represent a physical buzzer with which we're communicating.
-type Swarm struct {
-    buzzers map[int]*buzzerRecord  // Indexed by ID.
-    engine *Engine
-    trace bool
-    logFile *os.File
-    requests chan func()  // All requests are handling in the central Go routine.
+// Handle the given button press event, received over TCP with a sequence number at the given engine time.
+func (this *Swarm) SequencedPress(buzzerId int, buzzer *Buzzer, seq byte, pressTime time.Duration) {
+    this.requests <- func() {
+        rec, ok := this.buzzers[buzzerId]
+        if !ok || (rec.buzzer != buzzer) { return }  // Stale connection, ignore it.
+
+        this.sequencedPress(rec, seq, false, pressTime)
+    }
 }
 
 
-// Internals.
+// Handle the given button press event, received over UDP from the given address at the given engine time.
+// May be called from any thread.
+func (this *Swarm) UdpPress(buzzerId int, ip net.IP, seq byte, pressTime time.Duration) {
+    this.requests <- func() {
+        // Only believe datagrams from where the buzzer is connected, anyone could send them.
+        rec, ok := this.buzzers[buzzerId]
+        if !ok || (rec.buzzer == nil) || !rec.buzzer.IP().Equal(ip) {
+            this.Log("Ignoring UDP press for %s from %s\n", BuzzerIdToNamedString(buzzerId), ip)
+            return
+        }
 
-// Info we need to store per buzzer.
-type buzzerRecord struct {
-    buzzer *Buzzer  // nil if disconnected.
-    id int
-    muted bool
-    lastMsgTime time.Time
-    slow2sCountSession int
-    slow3sCountSession int
-    slow2sCountTotal int
-    slow3sCountTotal int
+        this.sequencedPress(rec, seq, true, pressTime)
+    }
 }
 
-const (BuzzersLogFile string = "buzzer.log")
 
+// Designate the specified buzzer, by logical ID, as the control buzzer, whose press patterns are given to the handler.
+// The handler is called in the main thread.
+// May be called from any thread.
+func (this *Swarm) SetControl(buzzerId int, handler ControlHandler) {
+    this.requests <- func() {
+        this.controlId = buzzerId
+        this.controlHandler = handler
+    }
+}
 
-// Handles requests in a single thread.
-// Never returns. Should be called as a Go routine.
-func (this *Swarm) run() {
-    // Setup a tick for checking for dead connections.
-    ticker := time.NewTicker(time.Second)
+// Function to handle a complete press pattern on the control buzzer, given the number of presses in it.
+type ControlHandler func (presses int)
 
-    // Process incoming messages forever.
-    for {
-        select {
-        case request := <-this.requests:
-            request()
 
-        case <-ticker.C:
-            this.checkDisconnects()
-        }
+// Note that UDP presses are being listened for, so capable buzzers can be offered it.
+// May be called from any thread.
+func (this *Swarm) EnableUdp() {
+    this.requests <- func() {
+        this.udpEnabled = true
     }
 }
 
 
-// Check if any buzzers have disappeared.
-func (this *Swarm) checkDisconnects() {
-    now := time.Now()
+// Send a mode message to the specified buzzer, by logical ID.
+// Returns false if the specified buzzer cannot be found.
+func (this *Swarm) SetMode(buzzerId int, ledOn bool, buzzerOn bool) bool {
+    // Create channel to get response.
+    response := make(chan bool, 1)
 
-    // Check each buzzer in turn.
-    for id, buzzer := range this.buzzers {
-        if buzzer.buzzer != nil {
+    this.requests <- func() {
+        // Record the requested mode, even if we can't send it now.
+        this.modes[buzzerId] = buzzerMode{ledOn, buzzerOn}
 
-            age := now.Sub(buzzer.lastMsgTime)
+        // Lookup buzzer.
+        rec, ok := this.physicalRecord(buzzerId)
+        if ok { rec.modeChanges++ }
 
-            if age > (5 * time.Second) {
-                // We've not heard from this buzzer for too long, disconnect it.
-                this.Log("Buzzer %s quiet for >5s, disconnecting\n", BuzzerIdToString(id))
+        if !ok || (rec.buzzer == nil) {
+            // Buzzer not found.
+            response <- false
+            return
+        }
 
-                // We don't need to adjust our records now, since the buzzer will tell us it's disconnected.
-                buzzer.buzzer.Disconnect()
-            }
+        if rec.quarantined {
+            // Pretend we sent it.
+            response <- true
+            return
         }
+
+        // Check if the buzzer is muted.
+        if rec.muted { buzzerOn = false }
+
+        // Sending can be slow, so use a fresh Go routine.
+        this.traceMode(rec.id, ledOn, buzzerOn)
+        if this.eventLog != nil { this.eventLog.ModeChanged(rec.id, ledOn, buzzerOn) }
+        rec.buzzer.SetMode(ledOn, buzzerOn, nil)
+        response <- true
     }
+
+    // Wait for response.
+    return <-response
 }
 
 
-// Command handler for turning on outputs on a specified buzzer.
-func (this *Swarm) commandOn(values []int) {
-    this.SetMode(values[0], true, true)
+// Set the inventory of buzzer hardware, to show with the stats.
+// May be called from any thread.
+func (this *Swarm) SetInventory(inventory Inventory) {
+    this.requests <- func() {
+        this.inventory = inventory
+    }
 }
 
 
-// Command handler for turning off outputs on a specified buzzer.
-func (this *Swarm) commandOff(values []int) {
-    this.SetMode(values[0], false, false)
+// Set the rooms the buzzers are partitioned into, so each buzzer is handed to its own room's swarm.
+// Must be called before any buzzers connect.
+func (this *Swarm) SetRooms(rooms *Rooms) {
+    this.rooms = rooms
 }
 
 
-// Command handler for turning off outputs on all buzzers.
-func (this *Swarm) commandOffAll([]int) {
-    this.SetModeAll(false, false)
+// Set the event log to record connections, presses and mode changes in.
+// Must be called before any buzzers connect.
+func (this *Swarm) SetEventLog(eventLog *EventLog) {
+    this.eventLog = eventLog
 }
 
 
-// Command handler for muting a specified buzzer.
-func (this *Swarm) commandMute(values []int) {
-    this.Mute(values[0], true)
+// Report the swarm that should handle the specified buzzer, ourself unless it's in another room.
+// May be called from any thread.
+func (this *Swarm) RoomSwarm(buzzerId int) *Swarm {
+    if this.rooms == nil { return this }
+
+    return this.rooms.Swarm(buzzerId)
 }
 
 
-// Command handler for unmuting a specified buzzer.
-func (this *Swarm) commandUnmute(values []int) {
-    this.Mute(values[0], false)
+// Set the base time after which we disconnect a buzzer we haven't heard from.
+// May be called from any thread.
+func (this *Swarm) SetDisconnectTime(timeout time.Duration) {
+    this.requests <- func() {
+        this.disconnectTime = timeout
+    }
 }
 
 
-// Command handler for unmuting all buzzers.
-func (this *Swarm) commandUnmuteAll(values []int) {
-    this.UnmuteAll()
+// Set how many disconnects in a minute cause a buzzer to be quarantined, 0 for never.
+// May be called from any thread.
+func (this *Swarm) SetFlapQuarantine(count int) {
+    this.requests <- func() {
+        this.flapQuarantine = count
+    }
 }
 
 
-// Command handler for toggling trace logging.
-func (this *Swarm) commandTraceToggle([]int) {
+// Set whether to compensate press times for each buzzer's recent round trip times.
+// May be called from any thread.
+func (this *Swarm) SetLatencyCompensation(compensate bool) {
     this.requests <- func() {
-        this.trace = !this.trace
+        this.compensate = compensate
+    }
+}
 
-        if this.trace {
-            this.Log("Trace logging on\n")
-        } else {
-            this.Log("Trace logging off\n")
+
+// Set how often to keep buzzer connections warm, 0 for never.
+// May be called from any thread.
+func (this *Swarm) SetKeepWarm(interval time.Duration) {
+    this.requests <- func() {
+        this.keepWarmTime = interval
+        this.lastKeepWarm = time.Now()
+    }
+}
+
+
+// Check all connected buzzers are alive, by pinging them, and report the result on the console once they've had time
+// to answer. Any that don't answer are disconnected, so they reconnect.
+// Buzzers with firmware too old to answer pings are counted as alive if we hear anything from them meanwhile.
+// May be called from any thread.
+func (this *Swarm) CheckLiveness() {
+    this.requests <- func() {
+        start := time.Now()
+        checked := make(map[int]livenessCheck)
+
+        for id, rec := range this.buzzers {
+            if (rec.buzzer == nil) || rec.quarantined { continue }
+
+            checked[id] = livenessCheck{rec.buzzer, this.ping(rec)}
         }
+
+        if len(checked) == 0 { return }
+
+        this.after(PingTimeout, func() { this.reportLiveness(start, checked) })
     }
 }
 
 
-// Print out stats for all known buzzers.
-func (this *Swarm) printStats([]int) {
+// Send a mode message to all connected buzzers.
+func (this *Swarm) SetModeAll(ledOn bool, buzzerOn bool) {
     this.requests <- func() {
-        // Run through all buzzers.
-        sumSlow2sCountSession := 0
-        sumSlow3sCountSession := 0
-        sumSlow2sCountTotal := 0
-        sumSlow3sCountTotal := 0
-        okCount := 0
-        mutedCount := 0
+        // This is now the mode for all buzzers, including those we haven't seen yet.
+        this.modes = make(map[int]buzzerMode)
+        this.defaultMode = buzzerMode{ledOn, buzzerOn}
+        if this.eventLog != nil { this.eventLog.ModeChanged(-1, ledOn, buzzerOn) }
+
+        // Find the buzzers to send to first, so we can time the whole fan-out.
+        var targets []*buzzerRecord
+        for _, buzzer := range this.buzzers {
+            buzzer.modeChanges++
+            if (buzzer.buzzer != nil) && !buzzer.quarantined { targets = append(targets, buzzer) }
+        }
 
-        this.Log("             >2s >3s (>2s >3s)\n")
+        if len(targets) == 0 { return }
 
-        // First get and sort the buzzer IDs.
-        ids := make([]int, 0, len(this.buzzers))
-        for id := range this.buzzers {
-            ids = append(ids, id)
+        fanOut := this.sender.StartFanOut(len(targets))
+        for _, buzzer := range targets {
+            // Check if the buzzer is muted.
+            b := buzzerOn
+            if buzzer.muted { b = false }
+
+            this.traceMode(buzzer.id, ledOn, b)
+            buzzer.buzzer.SetMode(ledOn, b, fanOut)
         }
-        sort.Ints(ids)
+    }
+}
+
+
+// Record the time taken to send a mode change to the given number of buzzers.
+// May be called from any thread.
+func (this *Swarm) FanOutDone(count int, duration time.Duration) {
+    this.requests <- func() {
+        this.lastFanOut = duration
+        if duration > this.worstFanOut { this.worstFanOut = duration }
+
+        if duration > SlowFanOutTime {
+            this.Log("Slow mode change, took %v to send to %d buzzers\n", duration.Round(time.Millisecond), count)
+        }
+    }
+}
+
+
+// Report the number of requests waiting for the swarm's Go routine.
+// May be called from any thread.
+func (this *Swarm) QueueDepth() int {
+    return len(this.requests)
+}
+
+
+// Report the logical IDs of all currently connected buzzers, in ID order.
+func (this *Swarm) ConnectedBuzzers() []int {
+    // Create channel to get response.
+    response := make(chan []int, 1)
 
-        // Now run through the buzzers in ID order.
-        for _, id := range ids {
-            buzzer, _ := this.buzzers[id]
-            status := "Missing"
-            if buzzer.buzzer != nil {
-                status = "OK     "
-                okCount++
+    this.requests <- func() {
+        // A dead buzzer may have come back, as well as the spare standing in for it.
+        found := make(map[int]bool)
+        ids := []int{}
+        for id, rec := range this.buzzers {
+            logicalId := this.logicalId(id)
+            if (rec.buzzer != nil) && !found[logicalId] {
+                found[logicalId] = true
+                ids = append(ids, logicalId)
             }
+        }
+
+        sort.Ints(ids)
+        response <- ids
+    }
+
+    // Wait for response.
+    return <-response
+}
+
+
+// Report the logical IDs of the currently connected buzzers in the specified team, in ID order.
+func (this *Swarm) TeamBuzzers(team int) []int {
+    ids := []int{}
+
+    for _, id := range this.ConnectedBuzzers() {
+        t, _ := BuzzerIdToTeam(id)
+        if t == team { ids = append(ids, id) }
+    }
+
+    return ids
+}
+
+
+// Report a one line summary of fleet health, eg "31/32 connected, worst recent gap 1.8s (R4), missing Y6".
+// Quarantined buzzers aren't counted, since we're ignoring them anyway.
+// May be called from any thread.
+func (this *Swarm) HealthSummary() string {
+    // Create channel to get response.
+    response := make(chan string, 1)
+
+    this.requests <- func() {
+        total := 0
+        connected := 0
+        worstGap := time.Duration(0)
+        worstId := -1
+        var missing []int
+        var lowBattery []int
+        var slowRtt []int
+
+        for id, rec := range this.buzzers {
+            if rec.quarantined { continue }
 
-            muted := ""
-            if buzzer.muted {
-                muted = " muted"
-                mutedCount++
+            total++
+            if rec.buzzer == nil {
+                missing = append(missing, id)
+                continue
             }
 
-            this.Log("%3s: %s %3d %3d (%3d %3d)%s\n", BuzzerIdToString(buzzer.id), status,
-                buzzer.slow2sCountSession, buzzer.slow3sCountSession,
-                buzzer.slow2sCountTotal, buzzer.slow3sCountTotal, muted)
+            connected++
+            if (rec.battery >= 0) && (rec.battery <= LowBatteryPercent) { lowBattery = append(lowBattery, id) }
+            if this.recentRtt(rec) > SlowRttTime { slowRtt = append(slowRtt, id) }
+
+            for _, gap := range rec.recentGaps {
+                if gap > worstGap {
+                    worstGap = gap
+                    worstId = id
+                }
+            }
+        }
+
+        summary := fmt.Sprintf("Buzzers: %d/%d connected", connected, total)
+        if worstId >= 0 {
+            summary += fmt.Sprintf(", worst recent gap %.1fs (%s)", worstGap.Seconds(), BuzzerIdToNamedString(worstId))
+        }
+
+        if len(missing) > 0 {
+            sort.Ints(missing)
+            summary += ", missing"
+            for i, id := range missing {
+                if i == HealthMissingLimit {
+                    summary += fmt.Sprintf(" and %d more", len(missing) - i)
+                    break
+                }
+
+                summary += " " + BuzzerIdToNamedString(id)
+            }
+        }
+
+        if len(lowBattery) > 0 {
+            sort.Ints(lowBattery)
+            summary += ", low battery"
+            for _, id := range lowBattery {
+                summary += fmt.Sprintf(" %s (%d%%)", BuzzerIdToNamedString(id), this.buzzers[id].battery)
+            }
+        }
+
+        if len(slowRtt) > 0 {
+            sort.Ints(slowRtt)
+            summary += ", slow round trips"
+            for _, id := range slowRtt {
+                summary += fmt.Sprintf(" %s (%v)", BuzzerIdToNamedString(id),
+                    this.recentRtt(this.buzzers[id]).Round(time.Millisecond))
+            }
+        }
+
+        response <- summary
+    }
+
+    // Wait for response.
+    return <-response
+}
+
+
+// Report stats for all known buzzers, in ID order.
+func (this *Swarm) Stats() []BuzzerStats {
+    // Create channel to get response.
+    response := make(chan []BuzzerStats, 1)
+
+    this.requests <- func() {
+        now := time.Now()
+        stats := []BuzzerStats{}
+
+        for id, rec := range this.buzzers {
+            var s BuzzerStats
+            s.Id = id
+            s.Connected = (rec.buzzer != nil)
+            s.Muted = rec.muted
+            s.Quarantined = rec.quarantined
+            s.Unstable = rec.unstable
+            s.Labels = this.labels[id]
+            s.StandingInFor = -1
+            if logicalId := this.logicalId(id); logicalId != id { s.StandingInFor = logicalId }
+            s.LastHeard = now.Sub(rec.lastMsgTime)
+            s.Slow2sSession = rec.slow2sCountSession
+            s.Slow3sSession = rec.slow3sCountSession
+            s.Slow2sTotal = rec.slow2sCountTotal
+            s.Slow3sTotal = rec.slow3sCountTotal
+            s.DisconnectsTotal = rec.disconnectsTotal
+            s.ErrorsTotal = rec.errorsTotal
+            s.PressesRun = rec.pressesRun
+            s.PressesTotal = rec.pressesTotal
+            s.NoPresses = this.noPresses(rec)
+            s.Battery = rec.battery
+            s.Rssi = rec.rssi
+            s.Rtts = make([]int, len(rec.rtts))
+            copy(s.Rtts, rec.rtts)
+            s.RttsLost = rec.rttsLost
+            s.RttTotal = rec.rttTotal
+            s.RecentRtt = this.recentRtt(rec)
+            s.Inventory, s.InInventory = this.inventory[id]
+            stats = append(stats, s)
+        }
+
+        sort.Slice(stats, func(i, j int) bool {
+            return stats[i].Id < stats[j].Id
+        })
+
+        response <- stats
+    }
+
+    // Wait for response.
+    return <-response
+}
+
+// Stats for a single buzzer.
+type BuzzerStats struct {
+    Id int
+    Connected bool
+    Muted bool
+    Quarantined bool
+    Unstable bool  // Connection flapping.
+    Labels []string
+    StandingInFor int  // Logical ID of dead buzzer this one replaces, -1 for none.
+    LastHeard time.Duration  // Time since last message.
+    Slow2sSession int
+    Slow3sSession int
+    Slow2sTotal int
+    Slow3sTotal int
+    DisconnectsTotal int
+    ErrorsTotal int
+    PressesRun int  // Since the server started.
+    PressesTotal int
+    NoPresses bool  // Never pressed this run, though the rest of the fleet has been.
+    Battery int  // Latest battery level, as a percentage, -1 if never reported.
+    Rssi int  // Latest WiFi signal strength, in dBm, 0 if never reported.
+    Rtts []int  // Round trip times this run, counted in LatencyBuckets, then a final bucket for anything slower.
+    RttsLost int  // Timed pings unanswered this run.
+    RttTotal time.Duration  // Sum of all round trip times this run.
+    RecentRtt time.Duration  // Median recent round trip time, 0 if not enough measured.
+    Inventory InventoryItem  // Only valid if InInventory.
+    InInventory bool
+}
+
+
+// Flash the LED on the specified buzzer, so it can be found, then restore its mode.
+func (this *Swarm) Identify(buzzerId int) {
+    for i := 0; i < 4; i++ {
+        ledOn := (i % 2) == 0
+        this.after(time.Duration(i) * IdentifyFlashTime, func() {
+            this.sendMode(buzzerId, buzzerMode{ledOn, false})
+        })
+    }
+
+    this.after(4 * IdentifyFlashTime, func() { this.restoreMode(buzzerId) })
+}
+
+
+// Turn on the LED and sounder on the specified buzzer briefly, then restore its mode.
+func (this *Swarm) Test(buzzerId int) {
+    this.requests <- func() {
+        this.sendMode(buzzerId, buzzerMode{true, true})
+    }
+
+    this.after(TestTime, func() { this.restoreMode(buzzerId) })
+}
+
+
+// Force disconnection from the specified buzzer.
+// It will presumably reconnect.
+func (this *Swarm) Disconnect(buzzerId int) {
+    this.requests <- func() {
+        rec, ok := this.buzzers[buzzerId]
+        if ok && (rec.buzzer != nil) {
+            this.Log("Forcing disconnection from %s\n", BuzzerIdToNamedString(buzzerId))
+            rec.buzzer.Disconnect()
+        }
+    }
+}
+
+
+// Ban the specified buzzer's ID for the rest of the session, disconnecting it if it's connected.
+func (this *Swarm) Ban(buzzerId int) {
+    this.requests <- func() {
+        this.bannedIds[buzzerId] = true
+        this.Log("Buzzer %s banned\n", BuzzerIdToNamedString(buzzerId))
+
+        rec, ok := this.buzzers[buzzerId]
+        if ok && (rec.buzzer != nil) { rec.buzzer.Disconnect() }
+    }
+}
+
+
+// Ban the IP address the specified buzzer is connected from for the rest of the session, disconnecting every buzzer
+// connected from it.
+func (this *Swarm) BanIp(buzzerId int) {
+    this.requests <- func() {
+        rec, ok := this.buzzers[buzzerId]
+        if !ok || (rec.buzzer == nil) || (rec.buzzer.IP() == nil) {
+            fmt.Printf("Cannot ban address of buzzer %s, not connected\n", BuzzerIdToNamedString(buzzerId))
+            return
+        }
+
+        ip := rec.buzzer.IP()
+        this.bannedIps[ip.String()] = true
+        this.Log("Address %s of buzzer %s banned\n", ip, BuzzerIdToNamedString(buzzerId))
+
+        for _, other := range this.buzzers {
+            if (other.buzzer != nil) && other.buzzer.IP().Equal(ip) { other.buzzer.Disconnect() }
+        }
+    }
+}
+
+
+// Report whether a buzzer with the given ID, connecting from the given IP address, is banned.
+// May be called from any thread.
+func (this *Swarm) Banned(buzzerId int, ip net.IP) bool {
+    response := make(chan bool, 1)
+    this.requests <- func() {
+        response <- this.bannedIds[buzzerId] || ((ip != nil) && this.bannedIps[ip.String()])
+    }
+
+    return <-response
+}
+
+
+// Mute or unmute specified buzzer.
+func (this *Swarm) Mute(buzzerId int, mute bool) {
+    this.requests <- func() {
+        un := ""
+        if !mute { un = "un" }
+
+        // Lookup buzzer.
+        rec, ok := this.buzzers[buzzerId]
+        if !ok {
+            // Buzzer not found.
+            fmt.Printf("Cannot %smute buzzer %s, not found\n", un, BuzzerIdToNamedString(buzzerId))
+            return
+        }
+
+        if rec.muted == mute {
+            this.Trace(buzzerId, TraceEvents, "Buzzer %s already %smuted\n", BuzzerIdToNamedString(buzzerId), un)
+        } else {
+            this.Trace(buzzerId, TraceEvents, "Buzzer %s %smuted\n", BuzzerIdToNamedString(buzzerId), un)
+        }
+
+        rec.muted = mute
+    }
+}
+
+
+// Quarantine or release specified buzzer.
+func (this *Swarm) Quarantine(buzzerId int, quarantine bool) {
+    this.requests <- func() {
+        // Lookup buzzer.
+        rec, ok := this.buzzers[buzzerId]
+        if !ok {
+            // Buzzer not found.
+            fmt.Printf("Cannot quarantine or release buzzer %s, not found\n", BuzzerIdToNamedString(buzzerId))
+            return
+        }
+
+        if rec.quarantined == quarantine { return }
+
+        if quarantine {
+            // Turn the buzzer off before we stop talking to it.
+            this.sendMode(buzzerId, buzzerMode{false, false})
+            rec.quarantined = true
+            this.Log("Buzzer %s quarantined\n", BuzzerIdToNamedString(buzzerId))
+        } else {
+            rec.quarantined = false
+            this.restoreMode(buzzerId)
+            this.Log("Buzzer %s released from quarantine\n", BuzzerIdToNamedString(buzzerId))
+        }
+    }
+}
+
+
+// Unmute all buzzers.
+func (this *Swarm) UnmuteAll() {
+    this.requests <- func() {
+        // Run through all known buzzers.
+        for id, rec := range this.buzzers {
+            if rec.muted {
+                this.Trace(id, TraceEvents, "Buzzer %s unmuted\n", BuzzerIdToNamedString(id))
+            }
+
+            rec.muted = false
+        }
+    }
+}
+
+
+// Swap the specified spare buzzer in to replace the specified dead one.
+// The spare takes over the dead buzzer's ID, mode and mute state. The dead buzzer is quarantined.
+// Swapping a buzzer in for itself undoes any swap for it and releases it from quarantine.
+func (this *Swarm) Swap(spareId int, deadId int) {
+    this.requests <- func() {
+        // Remove any previous swap for the dead buzzer.
+        for phys, logicalId := range this.aliases {
+            if logicalId != deadId { continue }
+
+            delete(this.aliases, phys)
+            this.restoreMode(phys)
+
+            if rec, ok := this.buzzers[phys]; ok && (rec.buzzer != nil) {
+                team, _ := BuzzerIdToTeam(phys)
+                rec.buzzer.SetTone(team)
+            }
+
+            this.Log("Buzzer %s no longer standing in for %s\n", BuzzerIdToNamedString(phys),
+                BuzzerIdToNamedString(deadId))
+        }
+
+        dead, deadFound := this.buzzers[deadId]
+
+        if spareId == deadId {
+            // Just undoing previous swap.
+            if deadFound && dead.quarantined {
+                dead.quarantined = false
+                this.restoreMode(deadId)
+                this.Log("Buzzer %s released from quarantine\n", BuzzerIdToNamedString(deadId))
+            }
+
+            return
+        }
+
+        spare, ok := this.buzzers[spareId]
+        if !ok || (spare.buzzer == nil) {
+            fmt.Printf("Cannot swap in buzzer %s, not connected\n", BuzzerIdToNamedString(spareId))
+            return
+        }
+
+        if deadFound {
+            spare.muted = dead.muted
+            this.sendMode(deadId, buzzerMode{false, false})
+            dead.quarantined = true
+        }
+
+        this.aliases[spareId] = deadId
+        deadTeam, _ := BuzzerIdToTeam(deadId)
+        spare.buzzer.SetTone(deadTeam)
+        spare.modeChanges++
+        this.restoreMode(spareId)
+        this.Log("Buzzer %s now standing in for %s, which is quarantined\n", BuzzerIdToNamedString(spareId),
+            BuzzerIdToNamedString(deadId))
+    }
+}
+
+
+// Add the given label to the specified buzzer.
+func (this *Swarm) AddLabel(buzzerId int, label string) {
+    this.requests <- func() {
+        if this.hasLabel(buzzerId, label) { return }
+
+        this.labels[buzzerId] = append(this.labels[buzzerId], label)
+        this.saveLabels()
+    }
+}
+
+
+// Remove the given label from the specified buzzer.
+// A blank label removes all labels.
+func (this *Swarm) RemoveLabel(buzzerId int, label string) {
+    this.requests <- func() {
+        labels := []string{}
+        for _, l := range this.labels[buzzerId] {
+            if (label != "") && !strings.EqualFold(l, label) { labels = append(labels, l) }
+        }
+
+        if len(labels) == 0 {
+            delete(this.labels, buzzerId)
+        } else {
+            this.labels[buzzerId] = labels
+        }
+
+        this.saveLabels()
+    }
+}
+
+
+// Mute or unmute all known buzzers with the given label.
+func (this *Swarm) MuteLabelled(label string, mute bool) {
+    this.requests <- func() {
+        count := 0
+        for id, rec := range this.buzzers {
+            if this.hasLabel(id, label) {
+                rec.muted = mute
+                count++
+            }
+        }
+
+        un := ""
+        if !mute { un = "un" }
+        fmt.Printf("%d buzzers labelled \"%s\" %smuted\n", count, label, un)
+    }
+}
+
+
+// Log to the buzzers log.
+// May be called from any thread.
+func (this *Swarm) Log(format string, args ...interface{}) {
+    line := fmt.Sprintf(format, args...)
+
+    this.logLock.Lock()
+    defer this.logLock.Unlock()
+
+    fmt.Fprint(this.logFile, line)
+
+    // Keep the most recent lines for the user to view.
+    this.recentLog = append(this.recentLog, strings.TrimRight(line, "\n"))
+    if len(this.recentLog) > RecentLogLines { this.recentLog = this.recentLog[len(this.recentLog) - RecentLogLines:] }
+}
+
+
+// Report the last count lines written to the buzzers log, oldest first.
+// May be called from any thread.
+func (this *Swarm) Tail(count int) []string {
+    this.logLock.Lock()
+    defer this.logLock.Unlock()
+
+    if count > len(this.recentLog) { count = len(this.recentLog) }
+
+    lines := make([]string, count)
+    copy(lines, this.recentLog[len(this.recentLog) - count:])
+    return lines
+}
+
+
+// Log to the buzzers trace log, if the given level and buzzer are being traced.
+// Specify a buzzer ID of -1 for trace that isn't about a single buzzer.
+func (this *Swarm) Trace(buzzerId int, level int, format string, args ...interface{}) {
+    if level > this.traceLevel { return }
+
+    if buzzerId >= 0 {
+        if (this.traceBuzzer >= 0) && (buzzerId != this.traceBuzzer) { return }
+
+        team, _ := BuzzerIdToTeam(buzzerId)
+        if (this.traceTeams & (1 << team)) == 0 { return }
+    }
+
+    this.Log(format, args...)
+}
+
+
+// Trace levels.
+const (
+    TraceOff = iota
+    TraceEvents  // Connections, presses and the like.
+    TraceMessages  // Every message sent or received.
+)
+
+
+// Object to represent a physical buzzer with which we're communicating.
+type Swarm struct {
+    buzzers map[int]*buzzerRecord  // Indexed by ID.
+    modes map[int]buzzerMode  // Mode each buzzer should be in, indexed by ID.
+    labels map[int][]string  // Indexed by ID, includes buzzers we haven't seen.
+    aliases map[int]int  // Logical IDs of swapped in spares, indexed by physical ID.
+    bannedIds map[int]bool  // Indexed by physical ID.
+    bannedIps map[string]bool  // Indexed by IP address string.
+    savedTotals map[int]buzzerTotals  // Totals as last saved, indexed by ID.
+    totalsChanged bool  // Totals need saving.
+    defaultMode buzzerMode  // Mode for buzzers not in modes.
+    engine *Engine
+    rooms *Rooms  // Nil for a single room. Only set before buzzers connect.
+    eventLog *EventLog  // Nil for none. Only set before buzzers connect.
+    traceLevel int
+    traceBuzzer int  // Only buzzer to trace, -1 for all.
+    traceTeams int  // Bit mask of teams to trace.
+    consoleReports bool
+    pressFlash bool  // Whether to flash LEDs to acknowledge button presses.
+    compensate bool  // Whether to compensate press times for latency.
+    controlId int  // Logical ID of control buzzer, only if we have a control handler.
+    controlHandler ControlHandler  // nil for no control buzzer.
+    controlPresses int  // Presses in the control buzzer's current pattern.
+    controlSeq int  // Count of control presses, so stale pattern ends can be skipped.
+    disconnectTime time.Duration  // Base time to disconnect quiet buzzers after.
+    sender *Sender
+    lastFanOut time.Duration  // Time taken by the last mode change sent to all buzzers.
+    worstFanOut time.Duration  // Time taken by the slowest mode change sent to all buzzers.
+    flapQuarantine int  // Disconnects in FlapTime to quarantine a buzzer after, 0 for never.
+    inventory Inventory  // Indexed by physical ID, nil for none.
+    udpEnabled bool  // Whether to offer buzzers the UDP press channel.
+    udpFirst int  // Sequenced presses that arrived over UDP first.
+    tcpFirst int  // Sequenced presses that arrived over TCP first.
+    pressesRun int  // Presses of all buzzers since the server started.
+    keepWarmTime time.Duration  // How often to keep connections warm, 0 for never.
+    lastKeepWarm time.Time
+    lastLatencyPing time.Time  // When we last sent timed pings.
+    logFile *os.File
+    logLock sync.Mutex  // Protects log file and recentLog.
+    recentLog []string  // Recent lines written to log file.
+    requests chan func()  // All requests are handling in the central Go routine.
+}
+
+
+// Internals.
+
+// Info we need to store per buzzer.
+type buzzerRecord struct {
+    buzzer *Buzzer  // nil if disconnected.
+    id int
+    muted bool
+    quarantined bool
+    modeChanges int  // Count of mode changes requested, so stale restores can be skipped.
+    lastPressSeq int  // Sequence number of the last sequenced press on this connection, -1 for none.
+    gesturePresses int  // Presses in the current gesture, 0 for none in progress.
+    gestureStart time.Duration  // Engine time of the first press of the current gesture.
+    gestureSeq int  // Count of gestures reported, so stale classifications can be skipped.
+    physical bool  // Whether a physical buzzer has ever connected with this ID, so virtual ones may not claim it.
+    held bool  // Button pressed and not yet released, only meaningful for buzzers that send releases.
+    pressedAt time.Duration  // Engine time of the last press.
+    stuckReported bool  // Whether we've warned the user the button's stuck down since it was pressed.
+    pingTime time.Time  // When our unanswered ping was sent, zero if none.
+    keepWarmChanges int  // Mode changes requested as of the last keep warm.
+    reportedOnline bool  // Connection state last reported on the console.
+    reportedEver bool  // Whether we've ever reported this buzzer online.
+    lastChangeTime time.Time  // Time of last connection state change.
+    lastMsgTime time.Time
+    slow2sCountSession int
+    slow3sCountSession int
+    worstGapSession time.Duration  // Longest gap between messages.
+    recentGaps []time.Duration  // Recent gaps between messages, oldest first, kept over reconnects.
+    quietDisconnects int  // Times we've disconnected this buzzer for being quiet, since it was last stable.
+    recentDisconnects []time.Time  // Disconnects within the last FlapTime, oldest first.
+    unstable bool  // Connection flapping.
+    slow2sCountTotal int
+    slow3sCountTotal int
+    disconnectsTotal int
+    errorsTotal int
+    pressesRun int  // Presses since the server started.
+    pressesTotal int
+    battery int  // Latest battery level, as a percentage, -1 if never reported.
+    rssi int  // Latest WiFi signal strength, in dBm, 0 if never reported.
+    lowBatteryReported bool  // Whether we've warned the user the battery is low since it was last above the limit.
+    latencyNonce byte  // Nonce of our latest timed ping.
+    latencyPingTime time.Time  // When our unanswered timed ping was sent, zero if none.
+    rtts []int  // Round trip times this run, counted in LatencyBuckets, then a final bucket for anything slower.
+    rttsLost int  // Timed pings unanswered this run.
+    rttTotal time.Duration  // Sum of all round trip times this run.
+    recentRtts []time.Duration  // Recent round trip times, oldest first.
+}
+
+// Totals for a single buzzer, as saved to storage.
+type buzzerTotals struct {
+    Slow2s int
+    Slow3s int
+    Disconnects int
+    Errors int
+    Presses int
+}
+
+// Mode a buzzer should be in.
+type buzzerMode struct {
+    ledOn bool
+    buzzerOn bool
+}
+
+const (
+    BuzzersLogFile string = "buzzer.log"
+    RecentLogLines = 500  // Number of log lines kept for the user to view.
+    LabelsRecord string = "labels"  // Storage record names.
+    TotalsRecord string = "buzzer_totals"
+)
+
+// Number of log lines to show if the user doesn't say.
+const DefaultTailLines = 20
+
+// How long a buzzer's connection state must be stable for before we report it on the console.
+const ConnectionSettleTime = 3 * time.Second
+
+// Disconnection of quiet buzzers.
+const (
+    DefaultDisconnectTime = 5 * time.Second  // Base time.
+    DisconnectMaxFactor = 3  // Maximum time allowed, as a multiple of the base time.
+    DisconnectJitterPercent = 150  // Time allowed, as a percentage of the buzzer's longest recent gap.
+    DisconnectStableTime = time.Minute  // How long a buzzer must stay connected to lose any extra time.
+    RecentGapCount = 60  // Number of gaps between messages to consider.
+)
+
+// Mode changes to all buzzers taking longer than this are noticeably not simultaneous.
+const SlowFanOutTime = 50 * time.Millisecond
+
+// Fleet presses after which buzzers that haven't been pressed at all are flagged.
+const NoPressWarnAfter = 20
+
+// Maximum number of missing buzzers to list in a health summary.
+const HealthMissingLimit = 5
+
+// A buzzer connection being checked for liveness.
+type livenessCheck struct {
+    buzzer *Buzzer
+    pinged bool  // False if its firmware is too old to answer pings.
+}
+
+// Keeping connections warm.
+const (
+    DefaultKeepWarmTime = 30 * time.Second
+    PingTimeout = 3 * time.Second  // Time a buzzer has to answer a ping before we disconnect it.
+)
+
+// How far behind the last press sequence number we still treat presses as duplicates.
+const SequenceWindow = 16
+
+// Detection of buzzers flapping, ie repeatedly disconnecting and reconnecting.
+const (
+    FlapTime = time.Minute  // Period to count disconnects over.
+    FlapCount = 3  // Disconnects in FlapTime that mark a buzzer as unstable.
+)
+
+
+// Handles requests in a single thread.
+// Never returns. Should be called as a Go routine.
+func (this *Swarm) run() {
+    // Setup a tick for checking for dead connections.
+    ticker := time.NewTicker(time.Second)
+
+    // Process incoming messages forever.
+    for {
+        select {
+        case request := <-this.requests:
+            request()
+
+        case <-ticker.C:
+            this.checkDisconnects()
+            this.reportConnections()
+            this.checkKeepWarm()
+            this.checkStuck()
+            this.checkLatency()
+            if this.totalsChanged { this.saveTotals() }
+        }
+    }
+}
+
+
+// How long each LED flash lasts when identifying a buzzer.
+const IdentifyFlashTime = 250 * time.Millisecond
+
+// How long to turn on a buzzer for when testing it.
+const TestTime = time.Second
+
+// How long to flash a buzzer's LED for to acknowledge a button press.
+const PressFlashTime = 150 * time.Millisecond
+
+// Gesture classification.
+const (
+    DoublePressGap = 400 * time.Millisecond  // Most time between the presses of a double press.
+    LongPressWait = 1200 * time.Millisecond  // Time to wait for a long press message, which buzzers send after 800ms.
+)
+
+// How long a button may be held down before we warn it's stuck.
+const StuckButtonTime = 10 * time.Second
+
+// Battery level, as a percentage, at or below which we warn the user.
+const LowBatteryPercent = 20
+
+// Round trip time measurement.
+const (
+    LatencyPingTime = 5 * time.Second  // Time between timed pings.
+    RecentRttCount = 12  // Number of recent round trip times kept.
+    RecentRttMinimum = 3  // Recent round trip times needed before we judge a buzzer slow.
+    SlowRttTime = 100 * time.Millisecond  // Typical round trip time above which a buzzer is at a disadvantage.
+    MaxLatencyCompensation = 100 * time.Millisecond  // Most a press time is ever compensated by.
+)
+
+// Upper bounds of the round trip time histogram buckets, below a final bucket for anything slower.
+var LatencyBuckets = []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+    100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond}
+
+// Gap after the last press of the control buzzer that completes its press pattern.
+const ControlPatternGap = 400 * time.Millisecond
+
+
+// Run the given request in our central Go routine after the given delay.
+// May be called from any thread.
+func (this *Swarm) after(delay time.Duration, request func()) {
+    time.AfterFunc(delay, func() {
+        this.requests <- request
+    })
+}
+
+
+// Report the logical ID for the specified physical buzzer.
+func (this *Swarm) logicalId(buzzerId int) int {
+    logicalId, ok := this.aliases[buzzerId]
+    if ok { return logicalId }
+
+    return buzzerId
+}
+
+
+// Report why a virtual buzzer may not claim the specified ID, blank if it may.
+func (this *Swarm) virtualRefusal(id int) string {
+    if IsGuestBuzzer(id) { return "guest IDs are for physical buzzers" }
+
+    if (this.controlHandler != nil) && ((id == this.controlId) || (this.logicalId(id) == this.controlId)) {
+        return "ID is the control buzzer"
+    }
+
+    if _, ok := this.inventory[id]; ok { return "ID belongs to a physical buzzer" }
+
+    rec, ok := this.buzzers[id]
+    if !ok { return "" }
+    if rec.buzzer != nil { return "ID already connected" }
+    if rec.physical { return "ID belongs to a physical buzzer" }
+
+    return ""
+}
+
+
+// Find the record for the physical buzzer with the specified logical ID.
+func (this *Swarm) physicalRecord(logicalId int) (*buzzerRecord, bool) {
+    for phys, l := range this.aliases {
+        if l == logicalId {
+            rec, ok := this.buzzers[phys]
+            return rec, ok
+        }
+    }
+
+    rec, ok := this.buzzers[logicalId]
+    return rec, ok
+}
+
+
+// Send the given mode to the specified physical buzzer, if it's connected, without recording it as the buzzer's mode.
+func (this *Swarm) sendMode(buzzerId int, mode buzzerMode) {
+    rec, ok := this.buzzers[buzzerId]
+    if !ok || (rec.buzzer == nil) || rec.quarantined { return }
+
+    if rec.muted { mode.buzzerOn = false }
+    this.traceMode(buzzerId, mode.ledOn, mode.buzzerOn)
+    rec.buzzer.SetMode(mode.ledOn, mode.buzzerOn, nil)
+}
+
+
+// Trace a mode message sent to the specified physical buzzer.
+func (this *Swarm) traceMode(buzzerId int, ledOn bool, buzzerOn bool) {
+    this.Trace(buzzerId, TraceMessages, "Mode to %s, led:%v buzzer:%v\n", BuzzerIdToNamedString(buzzerId), ledOn,
+        buzzerOn)
+}
+
+
+// Report the mode the specified logical buzzer is supposed to be in.
+func (this *Swarm) currentMode(buzzerId int) buzzerMode {
+    mode, ok := this.modes[buzzerId]
+    if !ok { mode = this.defaultMode }
+
+    return mode
+}
+
+
+// Send the specified physical buzzer the mode it's supposed to be in.
+// We never restart the sounder, since that's been and gone by now.
+func (this *Swarm) restoreMode(buzzerId int) {
+    mode := this.currentMode(this.logicalId(buzzerId))
+    this.sendMode(buzzerId, buzzerMode{mode.ledOn, false})
+}
+
+
+// Handle a press of the specified buzzer, by physical ID, received at the given engine time.
+// Must be called in our central Go routine.
+func (this *Swarm) buttonPress(buzzerId int, pressTime time.Duration) {
+    rec, ok := this.buzzers[buzzerId]
+    logicalId := this.logicalId(buzzerId)
+
+    if this.eventLog != nil {
+        ignored := ""
+        if ok && rec.quarantined { ignored = "quarantined" }
+        this.eventLog.Pressed(buzzerId, logicalId, pressTime, ignored)
+    }
+
+    if ok && rec.quarantined {
+        this.Trace(buzzerId, TraceEvents, "Buzzer %s pressed, ignored as quarantined\n",
+            BuzzerIdToNamedString(buzzerId))
+        return
+    }
+
+    if ok {
+        rec.pressesRun++
+        rec.pressesTotal++
+        this.pressesRun++
+        this.totalsChanged = true
+        rec.held = true
+        rec.pressedAt = pressTime
+        rec.stuckReported = false
+    }
+
+    // Log this, let the player know we got it and pass it on to our engine.
+    if logicalId != buzzerId {
+        this.Trace(buzzerId, TraceEvents, "Buzzer %s pressed, standing in for %s\n", BuzzerIdToNamedString(buzzerId),
+            BuzzerIdToNamedString(logicalId))
+    } else {
+        this.Trace(buzzerId, TraceEvents, "Buzzer %s pressed\n", BuzzerIdToNamedString(buzzerId))
+    }
+
+    this.flashPress(buzzerId)
+
+    if (this.controlHandler != nil) && (logicalId == this.controlId) {
+        this.controlPress()
+        return
+    }
+
+    this.engine.ButtonPress(logicalId, this.compensatedTime(buzzerId, pressTime))
+    if ok { this.gesturePress(rec, pressTime) }
+}
+
+
+// Work out when the specified buzzer, by physical ID, was pressed, given the engine time we received the press at.
+// If we're not compensating for latency, or don't know the buzzer's, that's when we received it.
+// Must be called in our central Go routine.
+func (this *Swarm) compensatedTime(buzzerId int, pressTime time.Duration) time.Duration {
+    rec, ok := this.buzzers[buzzerId]
+    if !this.compensate || !ok { return pressTime }
+
+    compensation := this.recentRtt(rec) / 2
+    if compensation > MaxLatencyCompensation { compensation = MaxLatencyCompensation }
+    if compensation == 0 { return pressTime }
+
+    this.Trace(buzzerId, TraceEvents, "Buzzer %s press compensated by %v\n", BuzzerIdToNamedString(buzzerId),
+        compensation.Round(time.Millisecond))
+    return pressTime - compensation
+}
+
+
+// Classify the given press of the given buzzer, received at the given engine time, as part of a gesture.
+// Must be called in our central Go routine.
+func (this *Swarm) gesturePress(rec *buzzerRecord, pressTime time.Duration) {
+    if rec.gesturePresses == 1 {
+        if pressTime - rec.gestureStart <= DoublePressGap {
+            this.reportGesture(rec, GestureDouble)
+            return
+        }
+
+        // The last press was on its own, and this one starts a new gesture.
+        this.reportGesture(rec, GestureShort)
+    }
+
+    rec.gesturePresses = 1
+    rec.gestureStart = pressTime
+    seq := rec.gestureSeq
+
+    // Wait for a second press and, for buzzers that can send one, a long press message.
+    wait := DoublePressGap
+    if (rec.buzzer != nil) && (rec.buzzer.buzzerVersion >= BuzzerLongPressVersion) { wait = LongPressWait }
+
+    this.after(wait, func() {
+        if (rec.gestureSeq == seq) && (rec.gesturePresses == 1) { this.reportGesture(rec, GestureShort) }
+    })
+}
+
+
+// Report the given gesture, completing the given buzzer's current gesture.
+// Must be called in our central Go routine.
+func (this *Swarm) reportGesture(rec *buzzerRecord, gesture Gesture) {
+    rec.gesturePresses = 0
+    rec.gestureSeq++
+
+    logicalId := this.logicalId(rec.id)
+    this.Trace(rec.id, TraceEvents, "Buzzer %s %s\n", BuzzerIdToNamedString(rec.id), _gestureNames[gesture])
+    this.engine.ButtonGesture(logicalId, gesture)
+}
+
+
+// Handle a press of the control buzzer, reporting the pattern once there's a long enough gap after the last press.
+// Must be called in our central Go routine.
+func (this *Swarm) controlPress() {
+    this.controlPresses++
+    this.controlSeq++
+    seq := this.controlSeq
+
+    this.after(ControlPatternGap, func() {
+        if this.controlSeq != seq { return }  // Pattern's still going.
+
+        presses := this.controlPresses
+        handler := this.controlHandler
+        this.controlPresses = 0
+        this.Trace(this.controlId, TraceEvents, "Control buzzer pattern of %d presses\n", presses)
+        this.engine.After(0, func() { handler(presses) })
+    })
+}
+
+
+// Handle a press of the given buzzer with the given sequence number, discarding it if we've already had it.
+// Must be called in our central Go routine.
+func (this *Swarm) sequencedPress(rec *buzzerRecord, seq byte, viaUdp bool, pressTime time.Duration) {
+    // Sequence numbers wrap, so anything a little behind the last one we had must be a late duplicate.
+    if (rec.lastPressSeq >= 0) && (byte(rec.lastPressSeq) - seq < SequenceWindow) {
+        this.Trace(rec.id, TraceMessages, "Duplicate press %d from %s\n", seq, BuzzerIdToNamedString(rec.id))
+        return
+    }
+
+    rec.lastPressSeq = int(seq)
+    if viaUdp { this.udpFirst++ } else { this.tcpFirst++ }
+
+    this.buttonPress(rec.id, pressTime)
+}
+
+
+// Briefly flash the LED of the specified buzzer, so the player knows their press was received.
+// If the buzzer's mode is changed in the meantime, eg because the player won the buzz, we leave it alone.
+func (this *Swarm) flashPress(buzzerId int) {
+    if !this.pressFlash { return }
+
+    rec, ok := this.buzzers[buzzerId]
+    if !ok || this.currentMode(this.logicalId(buzzerId)).ledOn { return }
+
+    this.sendMode(buzzerId, buzzerMode{true, false})
+
+    modeChanges := rec.modeChanges
+    this.after(PressFlashTime, func() {
+        if rec.modeChanges == modeChanges { this.restoreMode(buzzerId) }
+    })
+}
+
+
+// Report whether the specified buzzer has the given label.
+func (this *Swarm) hasLabel(buzzerId int, label string) bool {
+    for _, l := range this.labels[buzzerId] {
+        if strings.EqualFold(l, label) { return true }
+    }
+
+    return false
+}
+
+
+// Report whether the given buzzer should be flagged as never pressed.
+// Nothing is flagged until the fleet has seen enough presses, so everything isn't flagged at the start of the quiz.
+func (this *Swarm) noPresses(rec *buzzerRecord) bool {
+    // Guests are rarely pressed, so that's not worth a warning.
+    if IsGuestBuzzer(rec.id) { return false }
+
+    return (rec.pressesRun == 0) && (rec.buzzer != nil) && !rec.quarantined && (this.pressesRun >= NoPressWarnAfter)
+}
+
+
+// Save all buzzer totals to storage.
+// Totals for buzzers we haven't seen this run are kept.
+func (this *Swarm) saveTotals() {
+    for id, rec := range this.buzzers {
+        this.savedTotals[id] = buzzerTotals{rec.slow2sCountTotal, rec.slow3sCountTotal, rec.disconnectsTotal,
+            rec.errorsTotal, rec.pressesTotal}
+    }
+
+    this.totalsChanged = false
+    err := this.engine.Storage().Save(TotalsRecord, this.savedTotals)
+    if err != nil {
+        fmt.Printf("Could not save buzzer totals: %v\n", err)
+    }
+}
+
+
+// Save all buzzer labels to storage.
+func (this *Swarm) saveLabels() {
+    err := this.engine.Storage().Save(LabelsRecord, this.labels)
+    if err != nil {
+        fmt.Printf("Could not save buzzer labels: %v\n", err)
+    }
+}
+
+
+// Check if any buzzers have disappeared.
+func (this *Swarm) checkDisconnects() {
+    now := time.Now()
+
+    // Check each buzzer in turn.
+    for id, buzzer := range this.buzzers {
+        if buzzer.buzzer != nil {
+            if buzzer.unstable && (now.Sub(buzzer.recentDisconnects[len(buzzer.recentDisconnects) - 1]) > FlapTime) {
+                buzzer.unstable = false
+                buzzer.recentDisconnects = nil
+                this.Log("Buzzer %s stable again\n", BuzzerIdToNamedString(id))
+            }
+
+            if (buzzer.quietDisconnects > 0) && (now.Sub(buzzer.lastChangeTime) > DisconnectStableTime) {
+                // Buzzer has settled down.
+                buzzer.quietDisconnects = 0
+            }
+
+            if !buzzer.pingTime.IsZero() && (now.Sub(buzzer.pingTime) > PingTimeout) {
+                // The connection has died without telling us, or at least the direction to the buzzer has.
+                this.Log("Buzzer %s didn't answer ping, disconnecting\n", BuzzerIdToNamedString(id))
+                buzzer.pingTime = time.Time{}
+                buzzer.buzzer.Disconnect()
+                continue
+            }
+
+            age := now.Sub(buzzer.lastMsgTime)
+            timeout := this.disconnectTimeFor(buzzer)
+
+            if age > timeout {
+                // We've not heard from this buzzer for too long, disconnect it.
+                this.Log("Buzzer %s quiet for >%v, disconnecting\n", BuzzerIdToNamedString(id),
+                    timeout.Round(100 * time.Millisecond))
+                buzzer.quietDisconnects++
+
+                // We don't need to adjust our records now, since the buzzer will tell us it's disconnected.
+                buzzer.buzzer.Disconnect()
+            }
+        }
+    }
+}
+
+
+// Keep buzzer connections warm, if it's time to.
+func (this *Swarm) checkKeepWarm() {
+    if (this.keepWarmTime <= 0) || (time.Since(this.lastKeepWarm) < this.keepWarmTime) { return }
+
+    this.lastKeepWarm = time.Now()
+
+    for id, rec := range this.buzzers {
+        if (rec.buzzer == nil) || rec.quarantined { continue }
+
+        // Resending the mode to an old buzzer could cut off its sounder, so only do that if it's been left alone.
+        if !this.ping(rec) && (rec.modeChanges == rec.keepWarmChanges) {
+            this.Trace(id, TraceMessages, "Keeping %s warm\n", BuzzerIdToNamedString(id))
+            this.restoreMode(id)
+        }
+
+        rec.keepWarmChanges = rec.modeChanges
+    }
+}
+
+
+// Warn the user of any buttons held down for too long, once each.
+func (this *Swarm) checkStuck() {
+    now := this.engine.Now()
+
+    for id, rec := range this.buzzers {
+        if (rec.buzzer == nil) || rec.quarantined || !rec.held || rec.stuckReported { continue }
+        if (rec.buzzer.buzzerVersion < BuzzerReleaseVersion) || (now - rec.pressedAt < StuckButtonTime) { continue }
+
+        rec.stuckReported = true
+        fmt.Printf("Buzzer %s button stuck down for %ds, consider quarantining it\n", BuzzerIdToNamedString(id),
+            int((now - rec.pressedAt).Seconds()))
+        this.Log("Buzzer %s button stuck down\n", BuzzerIdToNamedString(id))
+    }
+}
+
+
+// Send timed pings to all connected buzzers, if it's time to, counting any previous ping that went unanswered.
+func (this *Swarm) checkLatency() {
+    if time.Since(this.lastLatencyPing) < LatencyPingTime { return }
+
+    this.lastLatencyPing = time.Now()
+
+    for _, rec := range this.buzzers {
+        if (rec.buzzer == nil) || rec.quarantined { continue }
+
+        if !rec.latencyPingTime.IsZero() { rec.rttsLost++ }
+
+        rec.latencyNonce++
+        rec.latencyPingTime = time.Time{}
+        if rec.buzzer.TimedPing(rec.latencyNonce) { rec.latencyPingTime = time.Now() }
+    }
+}
+
+
+// Report the median of the given buzzer's recent round trip times, 0 if there aren't enough to go on.
+func (this *Swarm) recentRtt(rec *buzzerRecord) time.Duration {
+    if len(rec.recentRtts) < RecentRttMinimum { return 0 }
+
+    sorted := make([]time.Duration, len(rec.recentRtts))
+    copy(sorted, rec.recentRtts)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    return sorted[len(sorted) / 2]
+}
+
+
+// Describe the given round trip time histogram, eg "<10ms:5 <20ms:2 >500ms:1", blank if it's empty.
+func rttHistogramString(rtts []int) string {
+    var parts []string
+    for bucket, count := range rtts {
+        if count == 0 { continue }
+
+        if bucket < len(LatencyBuckets) {
+            parts = append(parts, fmt.Sprintf("<%v:%d", LatencyBuckets[bucket], count))
+        } else {
+            parts = append(parts, fmt.Sprintf(">%v:%d", LatencyBuckets[bucket - 1], count))
+        }
+    }
+
+    return strings.Join(parts, " ")
+}
+
+
+// Ping the given connected buzzer, unless it already has a ping outstanding.
+// Returns false if the buzzer's firmware is too old to answer pings.
+func (this *Swarm) ping(rec *buzzerRecord) bool {
+    if !rec.pingTime.IsZero() { return true }
+    if !rec.buzzer.Ping() { return false }
+
+    this.Trace(rec.id, TraceMessages, "Ping to %s\n", BuzzerIdToNamedString(rec.id))
+    rec.pingTime = time.Now()
+    return true
+}
+
+
+// Report the result of a liveness check started at the given time, of the given buzzers, indexed by ID.
+func (this *Swarm) reportLiveness(start time.Time, checked map[int]livenessCheck) {
+    var dead []int
+
+    for id, check := range checked {
+        rec := this.buzzers[id]
+        if rec.buzzer != check.buzzer {
+            // It's disconnected, or reconnected on a new connection, since the check started.
+            dead = append(dead, id)
+            continue
+        }
+
+        if (check.pinged && !rec.pingTime.IsZero()) || (!check.pinged && !rec.lastMsgTime.After(start)) {
+            dead = append(dead, id)
+            rec.pingTime = time.Time{}
+            rec.buzzer.Disconnect()
+        }
+    }
+
+    if len(dead) == 0 {
+        fmt.Printf("Liveness check: all %d buzzers answered\n", len(checked))
+        return
+    }
+
+    sort.Ints(dead)
+    s := ""
+    for _, id := range dead { s += " " + BuzzerIdToNamedString(id) }
+
+    fmt.Printf("Liveness check: %d/%d buzzers answered, reconnecting%s\n", len(checked) - len(dead), len(checked), s)
+    this.Log("Liveness check failed for%s\n", s)
+}
+
+
+// Check whether the given buzzer, which has just disconnected, is flapping.
+func (this *Swarm) checkFlapping(rec *buzzerRecord) {
+    now := time.Now()
+
+    // Forget disconnects that are too old to count.
+    recent := []time.Time{}
+    for _, t := range rec.recentDisconnects {
+        if now.Sub(t) <= FlapTime { recent = append(recent, t) }
+    }
+
+    rec.recentDisconnects = append(recent, now)
+    count := len(rec.recentDisconnects)
+
+    if !rec.unstable && (count >= FlapCount) {
+        // Tell the user once, rather than every time it comes and goes.
+        rec.unstable = true
+        this.Log("Buzzer %s unstable, %d disconnects in %v\n", BuzzerIdToNamedString(rec.id), count, FlapTime)
+        fmt.Printf("Buzzer %s unstable, connection reports suppressed\n", BuzzerIdToNamedString(rec.id))
+    }
+
+    if (this.flapQuarantine > 0) && (count >= this.flapQuarantine) && !rec.quarantined {
+        // It's disconnected, so there's no need to turn it off.
+        rec.quarantined = true
+        this.Log("Buzzer %s quarantined for flapping\n", BuzzerIdToNamedString(rec.id))
+        fmt.Printf("Buzzer %s quarantined for flapping\n", BuzzerIdToNamedString(rec.id))
+    }
+}
+
+
+// Report how long we allow the given buzzer to be quiet for before disconnecting it.
+func (this *Swarm) disconnectTimeFor(rec *buzzerRecord) time.Duration {
+    timeout := this.disconnectTime
+
+    // Allow for the jitter this buzzer has been seeing.
+    worst := time.Duration(0)
+    for _, gap := range rec.recentGaps {
+        if gap > worst { worst = gap }
+    }
+
+    jitterTime := worst * DisconnectJitterPercent / 100
+    if jitterTime > timeout { timeout = jitterTime }
+
+    // Give a buzzer we've had to disconnect more time, so it doesn't flap.
+    if rec.quietDisconnects > 0 { timeout *= 2 }
+
+    maxTimeout := this.disconnectTime * DisconnectMaxFactor
+    if timeout > maxTimeout { timeout = maxTimeout }
+
+    return timeout
+}
+
+
+// Report any settled buzzer connection state changes on the console.
+// Changes are tracked even when reports are off, so turning them on doesn't dump stale history.
+func (this *Swarm) reportConnections() {
+    now := time.Now()
+    online := ""
+    backOnline := ""
+    offline := ""
+
+    // Run through the buzzers in ID order, so the reports are tidy.
+    ids := make([]int, 0, len(this.buzzers))
+    for id := range this.buzzers {
+        ids = append(ids, id)
+    }
+    sort.Ints(ids)
+
+    for _, id := range ids {
+        rec := this.buzzers[id]
+        isOnline := (rec.buzzer != nil)
+
+        if rec.unstable {
+            // Already reported, we'll report its state once it's stable again.
+            continue
+        }
+
+        if (isOnline == rec.reportedOnline) || (now.Sub(rec.lastChangeTime) < ConnectionSettleTime) {
+            // Nothing new to report, or not settled yet.
+            continue
+        }
+
+        name := " " + BuzzerIdToColourString(id)
+
+        switch {
+        case !isOnline:         offline += name
+        case rec.reportedEver:  backOnline += name
+        default:                online += name
+        }
+
+        rec.reportedOnline = isOnline
+        if isOnline { rec.reportedEver = true }
+    }
+
+    if !this.consoleReports { return }
+
+    if online != "" { fmt.Printf("Buzzers online:%s\n", online) }
+    if backOnline != "" { fmt.Printf("Buzzers back online:%s\n", backOnline) }
+    if offline != "" { fmt.Printf("Buzzers offline:%s\n", offline) }
+}
+
+
+// Command handler for turning on outputs on a specified buzzer.
+func (this *Swarm) commandOn(values []int) {
+    this.SetMode(values[0], true, true)
+}
+
+
+// Command handler for turning off outputs on a specified buzzer.
+func (this *Swarm) commandOff(values []int) {
+    this.SetMode(values[0], false, false)
+}
+
+
+// Command handler for turning off outputs on all buzzers.
+func (this *Swarm) commandOffAll([]int) {
+    this.SetModeAll(false, false)
+}
+
+
+// Command handler for muting a specified buzzer.
+func (this *Swarm) commandMute(values []int) {
+    this.Mute(values[0], true)
+}
+
+
+// Command handler for unmuting a specified buzzer.
+func (this *Swarm) commandUnmute(values []int) {
+    this.Mute(values[0], false)
+}
+
+
+// Command handler for disconnecting a specified buzzer.
+func (this *Swarm) commandDisconnect(values []int) {
+    this.Disconnect(values[0])
+}
+
+
+// Command handler for banning a specified buzzer.
+func (this *Swarm) commandBan(values []int) {
+    this.Ban(values[0])
+}
+
+
+// Command handler for banning the address a specified buzzer is connected from.
+func (this *Swarm) commandBanIp(values []int) {
+    this.BanIp(values[0])
+}
+
+
+// Command handler for quarantining a specified buzzer.
+func (this *Swarm) commandQuarantine(values []int) {
+    this.Quarantine(values[0], true)
+}
+
+
+// Command handler for releasing a specified buzzer from quarantine.
+func (this *Swarm) commandRelease(values []int) {
+    this.Quarantine(values[0], false)
+}
+
+
+// Command handler for unmuting all buzzers.
+func (this *Swarm) commandUnmuteAll(values []int) {
+    this.UnmuteAll()
+}
+
+
+// Command handler for toggling trace logging, or setting the trace level.
+func (this *Swarm) commandTraceToggle(values []int) {
+    this.requests <- func() {
+        level := values[0]
+        if level < 0 {
+            // Toggle.
+            level = TraceEvents
+            if this.traceLevel != TraceOff { level = TraceOff }
+        }
+
+        if level > TraceMessages { level = TraceMessages }
+        this.traceLevel = level
+
+        if level == TraceOff {
+            this.Log("Trace logging off\n")
+        } else {
+            this.Log("Trace logging on, level %d\n", level)
+        }
+    }
+}
+
+
+// Command handler for showing the end of the buzzer log.
+func (this *Swarm) commandTail(values []int) {
+    count := values[0]
+    if count < 0 { count = DefaultTailLines }
+
+    for _, line := range this.Tail(count) {
+        fmt.Printf("%s\n", line)
+    }
+}
+
+
+// Command handler for tracing a single buzzer.
+func (this *Swarm) commandTraceBuzzer(values []int) {
+    this.requests <- func() {
+        this.traceBuzzer = values[0]
+        this.traceTeams = AllTeamsMask
+        this.Log("Tracing only buzzer %s\n", BuzzerIdToNamedString(values[0]))
+    }
+}
+
+
+// Command handler for tracing only the given teams.
+func (this *Swarm) commandTraceTeams(values []int) {
+    this.requests <- func() {
+        this.traceBuzzer = -1
+        this.traceTeams = values[0]
+        this.Log("Tracing teams%s\n", TeamMaskToString(values[0]))
+    }
+}
+
+
+// Command handler for toggling connection reports on the console.
+func (this *Swarm) commandReportsToggle([]int) {
+    this.requests <- func() {
+        this.consoleReports = !this.consoleReports
+
+        if this.consoleReports {
+            fmt.Printf("Buzzer connection reports on\n")
+        } else {
+            fmt.Printf("Buzzer connection reports off\n")
+        }
+    }
+}
+
+
+// Command handler for swapping in a spare buzzer.
+func (this *Swarm) commandSwap(values []int) {
+    this.Swap(values[0], values[1])
+}
+
+
+// Command handler for labelling a buzzer.
+func (this *Swarm) commandLabel(values []int, text string) {
+    this.AddLabel(values[0], text)
+}
+
+
+// Command handler for removing labels from a buzzer.
+func (this *Swarm) commandUnlabel(values []int, text string) {
+    this.RemoveLabel(values[0], text)
+}
+
+
+// Command handler for muting all buzzers with a label.
+func (this *Swarm) commandMuteLabelled(values []int, text string) {
+    this.MuteLabelled(text, true)
+}
+
+
+// Command handler for unmuting all buzzers with a label.
+func (this *Swarm) commandUnmuteLabelled(values []int, text string) {
+    this.MuteLabelled(text, false)
+}
+
+
+// Command handler for toggling press acknowledgement flashes.
+func (this *Swarm) commandPressFlashToggle([]int) {
+    this.requests <- func() {
+        this.pressFlash = !this.pressFlash
+
+        if this.pressFlash {
+            fmt.Printf("Button press flashes on\n")
+        } else {
+            fmt.Printf("Button press flashes off\n")
+        }
+    }
+}
+
+
+// Print out stats for all known buzzers, or only those with the given label.
+// Buzzers are grouped by team, with a summary for each team.
+func (this *Swarm) printStats(values []int, label string) {
+    this.requests <- func() {
+        // Run through all buzzers.
+        sumSlow2sCountSession := 0
+        sumSlow3sCountSession := 0
+        sumSlow2sCountTotal := 0
+        sumSlow3sCountTotal := 0
+        okCount := 0
+        mutedCount := 0
+
+        this.Log("             >2s >3s (>2s >3s)  worst  (dis err)  presses\n")
+
+        // First get and sort the buzzer IDs.
+        ids := make([]int, 0, len(this.buzzers))
+        for id := range this.buzzers {
+            if (label == "") || this.hasLabel(id, label) { ids = append(ids, id) }
+        }
+        sort.Ints(ids)
+
+        // Now run through the buzzers in ID order, which groups them by team, with any guests last.
+        for team := 0; team <= GuestTeam; team++ {
+            if (team >= TeamCount) && (team != GuestTeam) { continue }
+
+            teamCount := 0
+            teamOkCount := 0
+            teamMutedCount := 0
+            teamNoPressCount := 0
+            var teamWorstGap time.Duration
+
+            for _, id := range ids {
+                if t, _ := BuzzerIdToTeam(id); t != team { continue }
+
+                buzzer, _ := this.buzzers[id]
+                teamCount++
+                status := "Missing"
+                if buzzer.buzzer != nil {
+                    status = "OK     "
+                    teamOkCount++
+                }
+
+                muted := ""
+                if buzzer.muted {
+                    muted = " muted"
+                    teamMutedCount++
+                }
+
+                if this.noPresses(buzzer) {
+                    muted += " NO PRESSES"
+                    teamNoPressCount++
+                }
+
+                if buzzer.battery >= 0 { muted += fmt.Sprintf(" batt %d%%", buzzer.battery) }
+                if (buzzer.battery >= 0) && (buzzer.battery <= LowBatteryPercent) { muted += " LOW" }
+                if buzzer.rssi != 0 { muted += fmt.Sprintf(" %ddBm", buzzer.rssi) }
+                if buzzer.quarantined { muted += " quarantined" }
+                if buzzer.unstable { muted += " unstable" }
+                if logicalId := this.logicalId(id); logicalId != id { muted += " for " + BuzzerIdToString(logicalId) }
+                if name := RosterName(id); name != "" { muted += " " + name }
+                if len(this.labels[id]) > 0 { muted += " [" + strings.Join(this.labels[id], ", ") + "]" }
+                if item, ok := this.inventory[id]; ok {
+                    muted += " " + item.Serial
+                    if item.Notes != "" { muted += " (" + item.Notes + ")" }
+                }
+
+                if buzzer.worstGapSession > teamWorstGap { teamWorstGap = buzzer.worstGapSession }
+
+                this.Log("%3s: %s %3d %3d (%3d %3d) %5.1fs  (%3d %3d)  %3d (%4d)%s\n", BuzzerIdToString(buzzer.id),
+                    status, buzzer.slow2sCountSession, buzzer.slow3sCountSession,
+                    buzzer.slow2sCountTotal, buzzer.slow3sCountTotal, buzzer.worstGapSession.Seconds(),
+                    buzzer.disconnectsTotal, buzzer.errorsTotal, buzzer.pressesRun, buzzer.pressesTotal, muted)
+
+                if histogram := rttHistogramString(buzzer.rtts); (histogram != "") || (buzzer.rttsLost > 0) {
+                    this.Log("     round trips %s, %d lost\n", histogram, buzzer.rttsLost)
+                }
+
+                sumSlow2sCountSession += buzzer.slow2sCountSession
+                sumSlow3sCountSession += buzzer.slow3sCountSession
+                sumSlow2sCountTotal += buzzer.slow2sCountTotal
+                sumSlow3sCountTotal += buzzer.slow3sCountTotal
+            }
+
+            // When filtering, teams with no matching buzzers are irrelevant. Most quizzes have no guests.
+            if ((label != "") || (team == GuestTeam)) && (teamCount == 0) { continue }
+
+            warning := ""
+            if teamOkCount == 0 { warning = ", NO WORKING BUZZERS" }
+            if teamNoPressCount > 0 { warning += fmt.Sprintf(", %d never pressed", teamNoPressCount) }
+
+            name := "Team " + TeamIdToString(team)
+            if team == GuestTeam { name = "Guests" }
+
+            this.Log("%s: %d/%d OK, worst gap %.1fs, %d muted%s\n\n", name, teamOkCount, teamCount,
+                teamWorstGap.Seconds(), teamMutedCount, warning)
 
-            sumSlow2sCountSession += buzzer.slow2sCountSession
-            sumSlow3sCountSession += buzzer.slow3sCountSession
-            sumSlow2sCountTotal += buzzer.slow2sCountTotal
-            sumSlow3sCountTotal += buzzer.slow3sCountTotal
+            okCount += teamOkCount
+            mutedCount += teamMutedCount
         }
 
         this.Log("Sum: %2d OK   %3d %3d (%3d %3d)  %d muted\n", okCount,
             sumSlow2sCountSession, sumSlow3sCountSession,
             sumSlow2sCountTotal, sumSlow3sCountTotal, mutedCount)
+        this.Log("Mode change to all buzzers took %v, worst %v\n", this.lastFanOut.Round(time.Microsecond),
+            this.worstFanOut.Round(time.Microsecond))
+        if this.udpEnabled { this.Log("Presses arriving first by UDP %d, by TCP %d\n", this.udpFirst, this.tcpFirst) }
     }
 }
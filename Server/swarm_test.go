@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+import "time"
+
+
+// Check presses are compensated by half each buzzer's median recent round trip, within limits.
+func TestLatencyCompensation(t *testing.T) {
+    harness := createTestHarness(t)
+    var presses []Press
+    harness.engine.RegisterButtons(func(press Press) { presses = append(presses, press) })
+    harness.swarm.SetLatencyCompensation(true)
+
+    // Expected compensation for each buzzer, given its recent round trips.
+    rtts := map[int][]time.Duration{
+        0x00: nil,
+        0x01: {60 * time.Millisecond, 300 * time.Millisecond, 80 * time.Millisecond},
+        0x02: {time.Second, time.Second, time.Second},
+    }
+    expected := map[int]time.Duration{0x00: 0, 0x01: 40 * time.Millisecond, 0x02: MaxLatencyCompensation}
+
+    var buzzers []*testBuzzer
+    for id := 0; id < len(rtts); id++ { buzzers = append(buzzers, harness.connectId(id)) }
+
+    harness.swarm.requests <- func() {
+        for id, measured := range rtts { harness.swarm.buzzers[id].recentRtts = measured }
+    }
+
+    before := harness.engine.Now()
+    for _, buzzer := range buzzers { buzzer.send(0x30) }
+    harness.settle()
+    after := harness.engine.Now()
+
+    if len(presses) != len(rtts) { t.Fatalf("Got %d presses, expected %d", len(presses), len(rtts)) }
+
+    for _, press := range presses {
+        received := press.Time + expected[press.Buzzer]
+        if (received < before) || (received > after) {
+            t.Fatalf("Buzzer 0x%02X press at %v, expected %v earlier than received between %v and %v",
+                press.Buzzer, press.Time, expected[press.Buzzer], before, after)
+        }
+    }
+}
+
+
+// Check virtual buzzers can't take over IDs in use, or used, by physical buzzers, nor guest or control IDs.
+func TestVirtualBuzzerClaims(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.swarm.SetControl(0x71, func(presses int) {})
+    physical := harness.connectId(0x00)
+    harness.connectId(0x01).conn.Close()
+    harness.settle()
+
+    for _, id := range []int{0x00, 0x01, 0x70, 0x71} {
+        virtual := harness.connectVirtualId(id)
+        harness.settle()
+        if (virtual != nil) && virtual.send(0x31) { t.Fatalf("Virtual buzzer allowed to claim 0x%02X", id) }
+    }
+
+    if !harness.connected(0x00) || !physical.send(0x31) { t.Fatalf("Physical buzzer knocked off by virtual one") }
+    if harness.connected(0x01) || harness.connected(0x71) { t.Fatalf("Refused virtual buzzer connected") }
+
+    // A free ID may be claimed, after which it's in use.
+    virtual := harness.connectVirtualId(0x10)
+    if (virtual == nil) || !harness.connected(0x10) { t.Fatalf("Virtual buzzer refused a free ID") }
+
+    harness.connectVirtualId(0x10)
+    if !virtual.send(0x31) { t.Fatalf("Virtual buzzer knocked off by another") }
+    harness.checkErrors()
+}
@@ -0,0 +1,227 @@
+/* Functions to define the teams.
+
+By default there are 4 teams, blue, green, red and yellow. Other quizzes need more teams, or different ones, so the
+teams may instead be read from a text file at startup, with one team per line. Lines starting with # are comments. For
+example:
+
+    # Six team quiz.
+    B blue Blue
+    G green Green
+    R red Red
+    Y yellow Yellow
+    P magenta Purple
+    W white White
+
+Each line gives the team's letter, its colour and its name. The letter is used to refer to the team in commands and
+when printing buzzer IDs, so must be a letter and must be different for each team. The colour must be one of those in
+_teamPalette. The name is the rest of the line.
+
+Each buzzer's team is set by the top 3 bits of its ID links, so teams are listed in order of those, up to 7 teams. The
+Nth team listed has buzzers N*16 to N*16+15. Buzzers with a team number beyond the teams listed are quarantined.
+
+The last team number is reserved for guest buzzers, such as a celebrity guest's or the quizmaster's own. Guests are
+outside the team structure, so never score, but their presses can be used in test mode and trigger guest hooks, see
+Engine.AddGuestHook(). Guest buzzers are shown with the letter X, eg X1, so no team may use that letter.
+
+Teams are set only at startup, before anything else is created, so may be used from any thread.
+
+*/
+
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "strings"
+import "unicode"
+
+
+// Read team definitions from the given file, replacing the default teams.
+// Must be called before anything that uses the teams is created.
+func LoadTeams(filename string) error {
+    file, err := os.Open(filename)
+    if err != nil { return err }
+    defer file.Close()
+
+    var letters []string
+    var colours []string
+    var names []string
+    scanner := bufio.NewScanner(file)
+    lineNo := 0
+
+    for scanner.Scan() {
+        lineNo++
+        line := strings.TrimSpace(scanner.Text())
+        if (line == "") || (line[0] == '#') { continue }
+
+        fields := strings.SplitN(line, " ", 3)
+        if len(fields) < 3 { return fmt.Errorf("%s:%d: expected letter, colour and name", filename, lineNo) }
+
+        letter := strings.ToUpper(fields[0])
+        if (len(letter) != 1) || !unicode.IsLetter(rune(letter[0])) {
+            return fmt.Errorf("%s:%d: team letter must be a single letter, not %q", filename, lineNo, fields[0])
+        }
+
+        if letter == _guestLetter {
+            return fmt.Errorf("%s:%d: team letter %s is reserved for guest buzzers", filename, lineNo, letter)
+        }
+
+        for _, l := range letters {
+            if l == letter { return fmt.Errorf("%s:%d: team letter %s used twice", filename, lineNo, letter) }
+        }
+
+        colour := strings.ToLower(fields[1])
+        if _, ok := _teamPalette[colour]; !ok {
+            return fmt.Errorf("%s:%d: unknown colour %q", filename, lineNo, fields[1])
+        }
+
+        letters = append(letters, letter)
+        colours = append(colours, colour)
+        names = append(names, strings.TrimSpace(fields[2]))
+    }
+
+    err = scanner.Err()
+    if err != nil { return err }
+
+    if (len(letters) == 0) || (len(letters) > MaxTeamCount) {
+        return fmt.Errorf("%s: expected 1 to %d teams, found %d", filename, MaxTeamCount, len(letters))
+    }
+
+    setTeams(letters, colours, names)
+    fmt.Printf("Read %d teams from %s\n", TeamCount, filename)
+    return nil
+}
+
+
+// Number of teams in the quiz, and a mask of all of them.
+// Only changed at startup.
+var TeamCount int
+var AllTeamsMask int
+
+// Maximum number of teams, limited by the team bits in buzzer IDs, with the last team number reserved for guests.
+const (
+    MaxTeamCount = 7
+    GuestTeam = 7
+)
+
+
+// Report whether the specified buzzer is a guest, outside the team structure.
+func IsGuestBuzzer(id int) bool {
+    team, _ := BuzzerIdToTeam(id)
+    return team == GuestTeam
+}
+
+
+// Convert the given team ID to a string.
+func TeamIdToString(id int) string {
+    return _teamLetters[id]
+}
+
+
+// Convert the given team mask to a string listing the teams.
+func TeamMaskToString(mask int) string {
+    s := ""
+
+    for team := 0; team < TeamCount; team++ {
+        if (mask & (1 << team)) != 0 {
+            s += " " + TeamIdToString(team)
+        }
+    }
+
+    return s
+}
+
+
+// Report the name of the given team, eg "Blue".
+func TeamName(team int) string {
+    return _teamNames[team]
+}
+
+
+// Report the colour of the given team, for web pages.
+func TeamCssColour(team int) string {
+    return _teamPalette[_teamColourNames[team]].css
+}
+
+
+// Internals.
+
+// Team letters for printing buzzer IDs, for all possible teams. Unsupported teams are shown as x.
+var _teamLetters []string
+
+const _guestLetter = "X"
+
+// Team details, indexed by team.
+var _teamNames []string
+var _teamColourNames []string
+
+// Colours teams may be, giving the ANSI terminal colour and the web page colour for each.
+var _teamPalette = map[string]teamColour{
+    "blue":     {"\033[94m", "#2060ff"},
+    "green":    {"\033[92m", "#20a040"},
+    "red":      {"\033[91m", "#e02020"},
+    "yellow":   {"\033[93m", "#e0c000"},
+    "magenta":  {"\033[95m", "#c020c0"},
+    "cyan":     {"\033[96m", "#20c0c0"},
+    "white":    {"\033[97m", "#e0e0e0"},
+    "orange":   {"\033[33m", "#ff8000"},
+}
+
+const _colourReset = "\033[0m"
+
+// Colour of a team.
+type teamColour struct {
+    terminal string  // ANSI escape sequence.
+    css string
+}
+
+
+// Set up the default teams.
+func init() {
+    setTeams([]string{"B", "G", "R", "Y"}, []string{"blue", "green", "red", "yellow"},
+        []string{"Blue", "Green", "Red", "Yellow"})
+}
+
+
+// Set the teams to those given, indexed by team.
+func setTeams(letters []string, colours []string, names []string) {
+    TeamCount = len(letters)
+    AllTeamsMask = (1 << TeamCount) - 1
+    _teamNames = names
+    _teamColourNames = colours
+
+    _teamLetters = make([]string, GuestTeam + 1)
+    for team := range _teamLetters {
+        _teamLetters[team] = "x"
+        if team < TeamCount { _teamLetters[team] = letters[team] }
+    }
+
+    _teamLetters[GuestTeam] = _guestLetter
+}
+
+
+// Report the ANSI terminal colour of the given team, blank for unsupported teams.
+func teamTerminalColour(team int) string {
+    if team >= TeamCount { return "" }
+
+    return _teamPalette[_teamColourNames[team]].terminal
+}
+
+
+// Decode the given character into a team number.
+func decodeTeam(id byte) (team int, ok bool) {
+    for team := 0; team < TeamCount; team++ {
+        if strings.EqualFold(string(id), _teamLetters[team]) { return team, true }
+    }
+
+    // Unrecognised team ID.
+    return 0, false
+}
+
+
+// Decode the given character into the team number of a buzzer ID, which may be a guest.
+func decodeBuzzerTeam(id byte) (team int, ok bool) {
+    if strings.EqualFold(string(id), _guestLetter) { return GuestTeam, true }
+
+    return decodeTeam(id)
+}
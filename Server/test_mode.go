@@ -4,7 +4,8 @@ A test mode controller lives for arbitrarily many occurrences of test mode.
 
 Operation is as follows:
 1. When we enter test mode all buzzers are de-illuminated.
-2. Each press of a buzzer toggles whether it is illuminated and buzzing.
+2. Each press of a buzzer, including guest buzzers, toggles whether it is illuminated and buzzing.
+   Buzzers that report releases also report how long their button was held down, to check the button is clean.
 3. On exit from test mode all buzzers are de-illuminated.
 
 All test mode functions and methods must be called only in the main thread, unless otherwise stated.
@@ -14,6 +15,7 @@ All test mode functions and methods must be called only in the main thread, unle
 package main
 
 import "fmt"
+import "time"
 
 
 // Create a test mode controller.
@@ -21,6 +23,13 @@ func CreateTestMode(engine *Engine) *TestMode {
     var p TestMode
     p.engine = engine
 
+    p.states = CreateStateMachine(engine, "test mode")
+    p.states.AllowTransitions(StateIdle, StateOpen)
+    p.states.AllowTransitions(StateOpen, StateIdle)
+    p.states.RegisterCmd([]string{StateOpen}, p.commandExit, "Exit test mode", 'q')
+    p.states.RegisterGuestButtons([]string{StateOpen}, p.button)
+    p.states.RegisterReleases([]string{StateOpen}, p.release)
+
     engine.RegisterModal(p.commandEnterTestMode, "test mode", "Enter test mode", 't')
 
     return &p
@@ -34,8 +43,8 @@ func (this *TestMode) EnterTestMode() {
     this.engine.SetModeAll(false, false)
 
     // Register for needed inputs for duration of question.
-    this.engine.RegisterCmd(this.commandExit, "Exit test mode", 'q')
-    this.engine.RegisterButtons(this.button)
+    this.states.Change(StateOpen)
+    this.engine.SetModalCancel(func() { this.commandExit(nil) })
 
     fmt.Printf("Entering test mode\n")
 }
@@ -44,6 +53,7 @@ func (this *TestMode) EnterTestMode() {
 // Test mode controller.
 type TestMode struct {
     buzzersOn map[int]bool  // Indexed by buzzer ID.
+    states *StateMachine
     engine *Engine
 }
 
@@ -51,7 +61,9 @@ type TestMode struct {
 // Internals.
 
 // Button press handler.
-func (this *TestMode) button(id int) {
+func (this *TestMode) button(press Press) {
+    id := press.Buzzer
+
     // Check is buzzer is currently on.
     on, ok := this.buzzersOn[id]
 
@@ -67,6 +79,12 @@ func (this *TestMode) button(id int) {
 }
 
 
+// Release handler.
+func (this *TestMode) release(buzzerId int, held time.Duration) {
+    fmt.Printf("Buzzer %s held for %.1fs\n", BuzzerIdToColourString(buzzerId), held.Seconds())
+}
+
+
 // Command handler for starting a new question.
 func (this *TestMode) commandEnterTestMode([]int) {
     this.EnterTestMode()
@@ -76,9 +94,8 @@ func (this *TestMode) commandEnterTestMode([]int) {
 // Command handler for exiting test mode.
 func (this *TestMode) commandExit(values []int) {
     // Unregister everything we temporarily registered.
-    this.engine.DeregisterCmd(this.commandExit, 'q')
-    this.engine.DeregisterButtons(this.button)
-    this.engine.ModalComplete()
+    this.states.Change(StateIdle)
+    this.engine.ModalComplete(CreateModalResult(OutcomeCompleted))
 
     // De-illuminate all buzzers.
     this.engine.SetModeAll(false, false)
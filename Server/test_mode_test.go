@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+
+// Check test mode starts with everything off, toggles each buzzer pressed, including guests, and turns everything off
+// when it ends.
+func TestTestMode(t *testing.T) {
+    harness := createTestHarness(t)
+    CreateTestMode(harness.engine)
+    blue := harness.connectId(0x00)
+    guest := harness.connectId(0x70)
+    harness.connectId(0x10)
+
+    harness.engine.SetModeAll(true, false)
+    harness.engine.processCommand("t")
+    harness.checkMode("test mode", "q")
+    harness.checkLeds(false, 0x00, 0x70, 0x10)
+
+    harness.press(blue)
+    harness.press(guest)
+    harness.checkLeds(true, 0x00, 0x70)
+    harness.checkLeds(false, 0x10)
+
+    blue.send(0x35)  // Releases are reported, not toggles.
+    harness.press(blue)
+    harness.checkLeds(false, 0x00)
+    harness.checkLeds(true, 0x70)
+
+    harness.engine.processCommand("q")
+    harness.checkMode("", "q")
+    harness.checkLeds(false, 0x00, 0x70, 0x10)
+    harness.checkErrors()
+    harness.checkLog()
+}
+
+
+// Check one game mode after another each registers its commands only while in operation, leaving nothing behind.
+func TestModeSwitching(t *testing.T) {
+    harness := createTestHarness(t)
+    harness.createQuickFire()
+    harness.createMultipleChoice()
+    CreateTestMode(harness.engine)
+    blue := harness.connectId(0x00)
+
+    for i := 0; i < 2; i++ {
+        harness.startQuickFire("2")
+        harness.checkMode("quick fire", "qx")
+        harness.press(blue)
+        harness.checkMode("quick fire", "qxyn")
+        harness.engine.processCommand("y")
+        harness.checkMode("", "qxyn")
+
+        harness.engine.processCommand("mA1")
+        harness.checkMode("multiple choice", "yqu")
+        harness.engine.processCommand("q")
+        harness.checkMode("", "yqu")
+
+        harness.engine.processCommand("t")
+        harness.checkMode("test mode", "q")
+        harness.engine.processCommand("q")
+        harness.checkMode("", "q")
+    }
+
+    harness.checkScores(WholeMarks(4))
+    harness.checkErrors()
+    harness.checkLog()
+}
@@ -0,0 +1,139 @@
+/* Functions to brand the audience display for an event.
+
+The same server is used for many different events, each of which wants the display in its own colours, with its own
+team names and maybe a sponsor's banner. Rather than editing the display page, a theme is read from a text file, with
+one setting per line. Lines starting with # are comments. For example:
+
+    # Crown quiz night.
+    title Crown Quiz Night
+    font Georgia, serif
+    background #101020
+    banner Sponsored by Acme Ales
+    bannerimage acme.png
+    team B Sharks #1060ff sharks.png
+    team R Dragons #e02020
+
+Each team line gives the team, a single word name, a colour and optionally a logo. Teams not mentioned keep their
+name and colour from the team definitions, see teams.go. Images are given relative to the theme file's directory, from
+which the web server serves them.
+
+Themes are read only at startup, so may be used from any thread.
+
+*/
+
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "path/filepath"
+import "strings"
+
+
+// Create the default theme, used if no theme file is given.
+func DefaultTheme() *Theme {
+    var p Theme
+    p.Title = "QuizTronic"
+    p.Font = "sans-serif"
+    p.Background = "#000000"
+    p.Teams = make([]ThemeTeam, TeamCount)
+
+    for team := range p.Teams {
+        p.Teams[team] = ThemeTeam{Letter: TeamIdToString(team), Name: TeamName(team), Colour: TeamCssColour(team)}
+    }
+
+    return &p
+}
+
+
+// Read a theme from the given file.
+func LoadTheme(filename string) (*Theme, error) {
+    p := DefaultTheme()
+    p.Dir = filepath.Dir(filename)
+
+    err := p.load(filename)
+    if err != nil { return nil, err }
+
+    fmt.Printf("Read display theme \"%s\" from %s\n", p.Title, filename)
+    return p, nil
+}
+
+
+// Display theme.
+type Theme struct {
+    Title string
+    Font string  // CSS font family.
+    Background string  // CSS colour.
+    Banner string  // Sponsor text, blank for none.
+    BannerImage string  // Sponsor image, blank for none.
+    Teams []ThemeTeam  // Indexed by team.
+    Dir string  // Directory images are found in, blank for none.
+}
+
+// Theme for a single team.
+type ThemeTeam struct {
+    Letter string  // As the user would see it, eg "B".
+    Name string
+    Colour string  // CSS colour.
+    Logo string  // Image, blank for none.
+}
+
+
+// Internals.
+
+// Read theme settings from the given file.
+func (this *Theme) load(filename string) error {
+    file, err := os.Open(filename)
+    if err != nil { return err }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    lineNo := 0
+
+    for scanner.Scan() {
+        lineNo++
+        line := strings.TrimSpace(scanner.Text())
+        if (line == "") || (line[0] == '#') { continue }
+
+        fields := strings.SplitN(line, " ", 2)
+        if len(fields) < 2 { return fmt.Errorf("%s:%d: expected setting and value", filename, lineNo) }
+
+        value := strings.TrimSpace(fields[1])
+
+        switch fields[0] {
+        case "title":           this.Title = value
+        case "font":            this.Font = value
+        case "background":      this.Background = value
+        case "banner":          this.Banner = value
+        case "bannerimage":     this.BannerImage = value
+
+        case "team":
+            err := this.loadTeam(value)
+            if err != nil { return fmt.Errorf("%s:%d: %v", filename, lineNo, err) }
+
+        default:
+            return fmt.Errorf("%s:%d: unknown setting %q", filename, lineNo, fields[0])
+        }
+    }
+
+    return scanner.Err()
+}
+
+
+// Set a team's theme from the given team line value, eg "B Sharks #1060ff sharks.png".
+func (this *Theme) loadTeam(value string) error {
+    fields := strings.Fields(value)
+    if (len(fields) < 3) || (len(fields) > 4) || (len(fields[0]) != 1) {
+        return fmt.Errorf("expected team, name, colour and optional logo")
+    }
+
+    team, ok := decodeTeam(fields[0][0])
+    if !ok { return fmt.Errorf("bad team %q", fields[0]) }
+
+    theme := &this.Teams[team]
+    theme.Name = fields[1]
+    theme.Colour = fields[2]
+    if len(fields) > 3 { theme.Logo = fields[3] }
+
+    return nil
+}
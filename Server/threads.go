@@ -0,0 +1,102 @@
+/* Functions to check that thread confined APIs are called in the right thread.
+
+Most of the quiz must only be used in the main thread, which is documented on each API, but nothing stops a careless
+caller, eg a web handler or a timer, using them from elsewhere. Such bugs only show up as rare and confusing races.
+
+For development a debug mode can be enabled, in which thread confined APIs check which thread they're called in. A
+call from the wrong thread is either logged, with a stack trace, or panics, so the bug is found straight away. By
+default no checks are done, so there's no cost during a real quiz.
+
+Go deliberately doesn't give Go routines an identity, so we dig the ID of the current Go routine out of its stack trace.
+This is slow, which is why it's only done in debug mode.
+
+*/
+
+package main
+
+import "bytes"
+import "fmt"
+import "runtime"
+import "runtime/debug"
+import "strconv"
+
+
+// Set what to do when a thread confined API is called in the wrong thread.
+// Must be called in the main thread, before anything else is started.
+func (this *Engine) SetThreadChecks(check ThreadCheck) {
+    this.threadCheck = check
+    this.mainThread = goroutineId()
+}
+
+
+// What to do when a thread confined API is called in the wrong thread.
+type ThreadCheck int
+
+const (
+    ThreadCheckOff ThreadCheck = iota  // Don't check.
+    ThreadCheckLog  // Log the error, with a stack trace, and carry on.
+    ThreadCheckPanic  // Panic.
+)
+
+
+// Parse the given thread check name.
+func ParseThreadCheck(name string) (ThreadCheck, error) {
+    switch name {
+    case "off":     return ThreadCheckOff, nil
+    case "log":     return ThreadCheckLog, nil
+    case "panic":   return ThreadCheckPanic, nil
+    }
+
+    return ThreadCheckOff, fmt.Errorf("unknown thread check \"%s\", expected off, log or panic", name)
+}
+
+
+// Check that we're in the main thread, if checks are enabled.
+// Should be called at the start of every API that must only be called in the main thread.
+// May be called from any thread.
+func (this *Engine) CheckMainThread() {
+    if this.threadCheck == ThreadCheckOff { return }
+
+    if goroutineId() != this.mainThread { this.threadError("only be called in the main thread") }
+}
+
+
+// Check that we're not in the main thread, if checks are enabled.
+// Should be called at the start of every API that would deadlock if called in the main thread.
+// May be called from any thread.
+func (this *Engine) CheckNotMainThread() {
+    if this.threadCheck == ThreadCheckOff { return }
+
+    if goroutineId() == this.mainThread { this.threadError("not be called in the main thread") }
+}
+
+
+// Internals.
+
+// Report a thread confined API called in the wrong thread, which must be as described.
+func (this *Engine) threadError(rule string) {
+    // Skip ourselves and the check function, to find the API that was called.
+    name := "unknown"
+    pc, _, _, ok := runtime.Caller(2)
+    if ok { name = runtime.FuncForPC(pc).Name() }
+
+    msg := fmt.Sprintf("Thread error: %s must %s", name, rule)
+    if this.threadCheck == ThreadCheckPanic { panic(msg) }
+
+    fmt.Printf("Error: %s\n%s", msg, debug.Stack())
+}
+
+
+// Report the ID of the current Go routine.
+// Slow, only use for debugging.
+func goroutineId() uint64 {
+    // The stack trace starts "goroutine 123 [running]:".
+    buf := make([]byte, 64)
+    buf = buf[:runtime.Stack(buf, false)]
+    buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+    end := bytes.IndexByte(buf, ' ')
+    if end < 0 { return 0 }
+
+    id, _ := strconv.ParseUint(string(buf[:end]), 10, 64)
+    return id
+}
@@ -0,0 +1,165 @@
+/* Functions to break ties in the final standings.
+
+During the quiz, teams on the same score share a place, see Scoreboard.Places(). At the end, the final standings may
+break those ties with a list of tie-break policies, applied in order. Each policy splits a group of tied teams by some
+measure of how they played, taken from the event history, and any teams still tied are passed on to the next policy.
+The policies are:
+* headtohead, most buzz contests won against the other tied teams. A team wins a contest by buzzing before another
+  team on the same question.
+* correct, most correct answers.
+* speed, fastest average buzz, from the question opening. Teams that never buzzed come last.
+* suddendeath, no split, the teams must play a sudden death question. Later policies are never used.
+
+Teams still tied once the policies run out share their place. Each team's standing says which policy placed it, so
+everyone can see how a tie was settled.
+
+All tie-break functions and methods must be called only in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "fmt"
+import "sort"
+import "strings"
+import "time"
+
+
+// Parse the given comma separated list of tie-break policy names.
+// May be called from any thread.
+func ParseTieBreaks(s string) ([]TieBreak, error) {
+    policies := []TieBreak{}
+    if s == "" { return policies, nil }
+
+    for _, name := range strings.Split(s, ",") {
+        policy, ok := _tieBreakPolicies[strings.ToLower(strings.TrimSpace(name))]
+        if !ok { return nil, fmt.Errorf("unknown tie-break %q", name) }
+
+        policies = append(policies, policy)
+    }
+
+    return policies, nil
+}
+
+
+// Tie-break policies.
+const (
+    TieBreakHeadToHead TieBreak = iota
+    TieBreakCorrect
+    TieBreakSpeed
+    TieBreakSuddenDeath
+)
+
+type TieBreak int
+
+// Policy descriptions, for reporting.
+var _tieBreakNames = []string{"head to head", "correct answers", "buzz speed", "sudden death"}
+
+const DefaultTieBreaks = "headtohead,correct,speed"
+
+
+// A single team's final standing.
+type Standing struct {
+    Team string  // As the user would see it.
+    Score Marks  // 0 while scores are hidden.
+    Place int  // Counting from 1.
+    Tied bool
+    DecidedBy string  // Tie-break policy that placed this team, blank for its score alone.
+}
+
+
+// Internals.
+
+// Policies by name.
+var _tieBreakPolicies = map[string]TieBreak{
+    "headtohead": TieBreakHeadToHead,
+    "correct": TieBreakCorrect,
+    "speed": TieBreakSpeed,
+    "suddendeath": TieBreakSuddenDeath,
+}
+
+
+// Split the given group of teams, all tied on score, using the given policies in turn.
+// Returns the resulting groups, best first, teams in the same group still being tied. The policy that split each team
+// off from the others, if any, is recorded in decidedBy, indexed by team.
+func breakTies(group []int, policies []TieBreak, history []Event, decidedBy []string) [][]int {
+    if (len(group) < 2) || (len(policies) == 0) { return [][]int{group} }
+
+    policy := policies[0]
+    if policy == TieBreakSuddenDeath {
+        for _, team := range group { decidedBy[team] = "sudden death needed" }
+        return [][]int{group}
+    }
+
+    // Sort by the policy's measure, higher being better, then split into groups with the same measure.
+    measures := tieBreakMeasures(policy, group, history)
+    sorted := make([]int, len(group))
+    copy(sorted, group)
+    sort.SliceStable(sorted, func(i, j int) bool { return measures[sorted[i]] > measures[sorted[j]] })
+
+    var splits [][]int
+    for i, team := range sorted {
+        if (i == 0) || (measures[team] != measures[sorted[i - 1]]) { splits = append(splits, nil) }
+        splits[len(splits) - 1] = append(splits[len(splits) - 1], team)
+    }
+
+    if len(splits) > 1 {
+        for _, split := range splits {
+            if len(split) == 1 { decidedBy[split[0]] = _tieBreakNames[policy] }
+        }
+    }
+
+    // Anyone still tied goes on to the next policy.
+    var groups [][]int
+    for _, split := range splits {
+        groups = append(groups, breakTies(split, policies[1:], history, decidedBy)...)
+    }
+
+    return groups
+}
+
+
+// Measure each of the given teams for the given policy, higher being better. Indexed by team.
+func tieBreakMeasures(policy TieBreak, group []int, history []Event) map[int]float64 {
+    measures := make(map[int]float64)
+    inGroup := make(map[int]bool)
+    for _, team := range group { inGroup[team] = true }
+
+    switch policy {
+    case TieBreakHeadToHead:
+        // Buzzes are in order, so a team beats every tied team that buzzes after it on the same question.
+        buzzed := make(map[int][]int)  // Tied teams that have buzzed, indexed by question.
+        for _, event := range history {
+            if (event.Type != EventBuzz) || !inGroup[event.Team] { continue }
+
+            for _, earlier := range buzzed[event.Question] {
+                if earlier != event.Team { measures[earlier]++ }
+            }
+
+            buzzed[event.Question] = append(buzzed[event.Question], event.Team)
+        }
+
+    case TieBreakCorrect:
+        for _, event := range history {
+            if (event.Type == EventJudged) && event.Correct && inGroup[event.Team] { measures[event.Team]++ }
+        }
+
+    case TieBreakSpeed:
+        total := make(map[int]time.Duration)
+        count := make(map[int]int)
+        for _, event := range history {
+            if (event.Type != EventBuzz) || !inGroup[event.Team] { continue }
+
+            total[event.Team] += event.Duration
+            count[event.Team]++
+        }
+
+        // Faster is better, so measure by negative average, with no buzzes worst of all.
+        for _, team := range group {
+            measures[team] = -time.Hour.Seconds()
+            if count[team] > 0 { measures[team] = -(total[team] / time.Duration(count[team])).Seconds() }
+        }
+    }
+
+    return measures
+}
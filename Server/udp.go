@@ -0,0 +1,76 @@
+/* Functions for receiving button presses over UDP.
+
+On lossy venue WiFi a single dropped TCP segment can hold up a button press for hundreds of milliseconds while it's
+retransmitted, and presses are exactly the messages where latency matters most. So buzzers that support it may also send
+their presses as UDP datagrams, which are never held up behind anything.
+
+The UDP path is negotiated during the handshake. If UDP is enabled, buzzers with firmware new enough to support it are
+sent a UDP offer once they've identified themselves. From then on each press carries a sequence number, and is sent both
+as a datagram to our UDP port and, as before, over TCP. Whichever copy arrives first is acted on and the other is
+discarded as a duplicate. If the datagram is lost, the TCP copy still gets through, so presses are never lost, they're
+just no faster than they would have been without UDP.
+
+Each datagram is 3 bytes: the buzzer's ID message, the sequenced press message and the sequence number. Datagrams are
+only accepted from the address the buzzer is connected from over TCP.
+
+The UDP listener runs in its own Go routine and reports everything to the swarm, or with several rooms, see rooms.go,
+to the swarm of each buzzer's room.
+
+*/
+
+package main
+
+import "fmt"
+import "net"
+
+
+// Start listening for UDP button presses at the given address.
+// May be called from any thread.
+func ListenUdp(address string, rooms *Rooms) error {
+    addr, err := net.ResolveUDPAddr("udp", address)
+    if err != nil { return err }
+
+    conn, err := net.ListenUDP("udp", addr)
+    if err != nil { return err }
+
+    for _, swarm := range rooms.Swarms() { swarm.EnableUdp() }
+    go receiveUdp(conn, rooms)
+
+    fmt.Printf("Listening for UDP button presses\n")
+    return nil
+}
+
+
+// Internals.
+
+// Size of a UDP press datagram.
+const UdpPressSize = 3
+
+
+// Receive UDP datagrams from buzzers forever.
+// Should be called as a Go routine.
+func receiveUdp(conn *net.UDPConn, rooms *Rooms) {
+    swarm := rooms.Lobby()
+
+    // Allow for bigger datagrams than we expect, so we can spot them.
+    buffer := make([]byte, 64)
+
+    for {
+        n, addr, err := conn.ReadFromUDP(buffer)
+        if err != nil {
+            swarm.Log("UDP receive failed, no more UDP presses: %v\n", err)
+            return
+        }
+
+        // Anything can be sent to us, so be careful what we accept.
+        if (n != UdpPressSize) || ((buffer[0] & 0x80) == 0) || (buffer[1] != 0x32) {
+            swarm.Log("Ignoring bad UDP datagram of %d bytes from %s\n", n, addr)
+            continue
+        }
+
+        // Each room has its own clock.
+        id := int(buffer[0] & 0x7F)
+        roomSwarm := rooms.Swarm(id)
+        roomSwarm.UdpPress(id, addr.IP, buffer[2], roomSwarm.engine.Now())
+    }
+}
@@ -0,0 +1,279 @@
+/* Functions to place buzzers in the venue and report buzzing by table.
+
+A venue layout may be given, placing each buzzer at a table. It is read from a text file with one buzzer per line,
+giving the buzzer ID, the row and column of its table, counting from 1 with row 1 nearest the stage, and optionally the
+table's name. Lines starting with # are comments. For example:
+
+    # Village hall.
+    B1 1 1 Front left
+    B2 1 1
+    G1 1 2 Front right
+    R1 2 1
+    Y1 2 2 By the bar
+
+Buzzers with the same row and column share a table, which takes its name from any of their lines.
+
+The heatmap reports, for each table, the questions in which its players pressed while the question was open and the
+questions in which they won the buzz, by buzzing first. Its win rate is the share of the questions it pressed in that
+it won. Correct answers and the average time to a winning buzz are given too. Each row is totalled, so a systematic
+advantage for the tables nearest the stage, eg from hearing the question a moment sooner, stands out. The heatmap is
+compiled from the event history, so follows every game mode, and can be printed on the console or seen on the web.
+
+Layouts are read only at startup, so may be used from any thread. All heatmap functions and methods must be called only
+in the main thread, unless otherwise stated.
+
+*/
+
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+
+// Read a venue layout from the given file.
+func LoadVenue(filename string) (Venue, error) {
+    venue := make(Venue)
+
+    file, err := os.Open(filename)
+    if err != nil { return nil, err }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    lineNo := 0
+
+    for scanner.Scan() {
+        lineNo++
+        line := strings.TrimSpace(scanner.Text())
+        if (line == "") || (line[0] == '#') { continue }
+
+        fields := strings.SplitN(line, " ", 4)
+        if len(fields) < 3 { return nil, fmt.Errorf("%s:%d: expected buzzer, row and column", filename, lineNo) }
+
+        id, err := parseBuzzerId(fields[0])
+        if err != nil { return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err) }
+
+        if _, ok := venue[id]; ok {
+            return nil, fmt.Errorf("%s:%d: buzzer %s placed twice", filename, lineNo, BuzzerIdToString(id))
+        }
+
+        var place VenuePlace
+        place.Row, err = strconv.Atoi(fields[1])
+        if (err != nil) || (place.Row < 1) { return nil, fmt.Errorf("%s:%d: bad row %q", filename, lineNo, fields[1]) }
+
+        place.Column, err = strconv.Atoi(fields[2])
+        if (err != nil) || (place.Column < 1) {
+            return nil, fmt.Errorf("%s:%d: bad column %q", filename, lineNo, fields[2])
+        }
+
+        if len(fields) > 3 { place.Name = strings.TrimSpace(fields[3]) }
+
+        venue[id] = place
+    }
+
+    err = scanner.Err()
+    if err != nil { return nil, err }
+
+    fmt.Printf("Read venue layout of %d buzzers from %s\n", len(venue), filename)
+    return venue, nil
+}
+
+
+// Venue layout, indexed by buzzer ID.
+type Venue map[int]VenuePlace
+
+// Where a buzzer is in the venue.
+type VenuePlace struct {
+    Row int  // Counting from 1, nearest the stage.
+    Column int  // Counting from 1.
+    Name string  // Of the table, blank for none.
+}
+
+
+// Create a heatmap of buzzing by table, for the given venue layout.
+func CreateHeatmap(engine *Engine, venue Venue) *Heatmap {
+    var p Heatmap
+    p.engine = engine
+    p.venue = venue
+
+    engine.RegisterCmd(p.commandPrint, "Print heatmap of buzzing by table", '%')
+
+    return &p
+}
+
+
+// Report the buzzing at each table so far, in row then column order.
+func (this *Heatmap) Tables() []HeatmapTable {
+    tables := make(map[tableKey]*HeatmapTable)
+    for id, place := range this.venue {
+        key := tableKey{place.Row, place.Column}
+        table, ok := tables[key]
+        if !ok {
+            table = &HeatmapTable{Row: place.Row, Column: place.Column}
+            tables[key] = table
+        }
+
+        if place.Name != "" { table.Name = place.Name }
+        table.Buzzers = append(table.Buzzers, BuzzerIdToString(id))
+    }
+
+    // Walk the history, counting the questions each table pressed in and won.
+    pressed := make(map[tableKey]map[int]bool)  // Indexed by table, then question.
+    won := make(map[int]bool)  // Questions whose buzz has been won, indexed by question.
+    buzzTimes := make(map[tableKey]time.Duration)  // Total time to winning buzzes.
+    open := false
+
+    for _, event := range this.engine.History() {
+        switch event.Type {
+        case EventQuestionOpened:
+            open = true
+
+        case EventQuestionClosed:
+            open = false
+        }
+
+        place, ok := this.venue[event.Buzzer]
+        if !ok { continue }
+
+        key := tableKey{place.Row, place.Column}
+        table := tables[key]
+
+        switch event.Type {
+        case EventPress:
+            if !open { continue }
+            if pressed[key] == nil { pressed[key] = make(map[int]bool) }
+
+            if !pressed[key][event.Question] {
+                pressed[key][event.Question] = true
+                table.Pressed++
+            }
+
+        case EventBuzz:
+            // Later buzzes in the same question, after a wrong answer, weren't races.
+            if won[event.Question] { continue }
+
+            won[event.Question] = true
+            table.Won++
+            buzzTimes[key] += event.Duration
+
+        case EventJudged:
+            if event.Correct { table.Correct++ }
+        }
+    }
+
+    list := []HeatmapTable{}
+    for key, table := range tables {
+        if table.Won > 0 { table.BuzzTime = buzzTimes[key] / time.Duration(table.Won) }
+        sort.Strings(table.Buzzers)
+        list = append(list, *table)
+    }
+
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].Row != list[j].Row { return list[i].Row < list[j].Row }
+        return list[i].Column < list[j].Column
+    })
+
+    return list
+}
+
+
+// Print out the heatmap, table by table, with each row's totals.
+func (this *Heatmap) Print() {
+    tables := this.Tables()
+
+    fmt.Printf("Buzzing by table, row 1 nearest the stage:\n")
+    fmt.Printf("Table                 Pressed  Won  Win rate  Correct  Avg buzz  Buzzers\n")
+
+    for i, table := range tables {
+        fmt.Printf("%-20s  %s  %s\n", table.Title(), table.statsString(), strings.Join(table.Buzzers, " "))
+
+        if (i == len(tables) - 1) || (tables[i + 1].Row != table.Row) {
+            total := rowTotal(tables, table.Row)
+            fmt.Printf("%-20s  %s\n", total.Title(), total.statsString())
+        }
+    }
+}
+
+
+// Heatmap of buzzing by table.
+type Heatmap struct {
+    venue Venue
+    engine *Engine
+}
+
+// Buzzing at a single table, or a whole row.
+type HeatmapTable struct {
+    Row int
+    Column int  // 0 for a whole row.
+    Name string  // Blank for none.
+    Buzzers []string
+    Pressed int  // Questions its players pressed in while open.
+    Won int  // Questions its players won the buzz in.
+    Correct int  // Correct answers.
+    BuzzTime time.Duration  // Average time from the question opening to a winning buzz, 0 for no wins.
+}
+
+
+// Report the title of this table, or row, as the user would see it.
+func (this *HeatmapTable) Title() string {
+    if this.Column == 0 { return fmt.Sprintf("Row %d", this.Row) }
+
+    s := fmt.Sprintf("%d/%d", this.Row, this.Column)
+    if this.Name != "" { s += " " + this.Name }
+    return s
+}
+
+
+// Report the percentage of the questions this table pressed in that it won, 0 if it never pressed.
+func (this *HeatmapTable) WinRate() int {
+    if this.Pressed == 0 { return 0 }
+
+    return (this.Won * 100) / this.Pressed
+}
+
+
+// Internals.
+
+// Row and column of a table.
+type tableKey struct {
+    row int
+    column int
+}
+
+
+// Total up the given tables in the given row.
+func rowTotal(tables []HeatmapTable, row int) HeatmapTable {
+    total := HeatmapTable{Row: row}
+    var buzzTime time.Duration
+
+    for _, table := range tables {
+        if table.Row != row { continue }
+
+        total.Pressed += table.Pressed
+        total.Won += table.Won
+        total.Correct += table.Correct
+        buzzTime += table.BuzzTime * time.Duration(table.Won)
+    }
+
+    if total.Won > 0 { total.BuzzTime = buzzTime / time.Duration(total.Won) }
+    return total
+}
+
+
+// Convert this table's stats to a string, in columns.
+func (this *HeatmapTable) statsString() string {
+    buzzTime := "-"
+    if this.Won > 0 { buzzTime = fmt.Sprintf("%.2fs", this.BuzzTime.Seconds()) }
+
+    return fmt.Sprintf("%7d  %3d  %7d%%  %7d  %8s", this.Pressed, this.Won, this.WinRate(), this.Correct, buzzTime)
+}
+
+
+// Command handler for printing the heatmap.
+func (this *Heatmap) commandPrint([]int) {
+    this.Print()
+}
@@ -0,0 +1,190 @@
+/* Functions to let players without a physical buzzer buzz from their phones.
+
+A virtual buzzer is a web page, see web.go, that connects back to us over a WebSocket. The page speaks exactly the same
+single byte messages as a physical buzzer, see Protocol.txt, each carried in its own binary WebSocket message. So we
+wrap the WebSocket up as an ordinary connection and hand it to a Buzzer, just as if it had connected over TCP. The swarm
+never knows the difference, virtual buzzers share the physical buzzers' IDs, stats and mode changes, and physical and
+virtual buzzers can be mixed freely.
+
+A virtual buzzer may only use an ID no other buzzer is using, or has used from a physical buzzer, and never a guest or
+control buzzer ID, since anyone who can reach the page could otherwise knock a player's buzzer off or take over the
+quiz. A refused virtual buzzer is disconnected, leaving the buzzer holding the ID alone.
+
+The page shows the buzzer's mode on screen. The LED being on lights the screen up in the team's colour, and the
+sounder being on vibrates the phone, where it can.
+
+Only as much of the WebSocket protocol as a browser needs is supported. Messages from the page must be masked, as
+browsers always do, and are limited to VirtualMaxMessage bytes, since all buzzer messages are tiny. Pings are answered
+and a close ends the connection.
+
+Each virtual buzzer connection is run by its Buzzer's Go routines, so may only use thread safe APIs.
+
+*/
+
+package main
+
+import "bufio"
+import "crypto/sha1"
+import "encoding/base64"
+import "encoding/binary"
+import "errors"
+import "io"
+import "net"
+import "net/http"
+import "strings"
+import "sync"
+
+
+// Accept a virtual buzzer connecting with the given WebSocket request, handing it to the given swarm as a buzzer.
+// Returns once the connection has been taken over, or with an error if it isn't a WebSocket request we can accept, in
+// which case nothing has been written to the response.
+// May be called from any thread.
+func AcceptVirtualBuzzer(w http.ResponseWriter, r *http.Request, swarm *Swarm) error {
+    key := r.Header.Get("Sec-WebSocket-Key")
+    if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || (key == "") {
+        return errors.New("not a WebSocket request")
+    }
+
+    hijacker, ok := w.(http.Hijacker)
+    if !ok { return errors.New("connection cannot be taken over") }
+
+    conn, rw, err := hijacker.Hijack()
+    if err != nil { return err }
+
+    hash := sha1.Sum([]byte(key + WebSocketGuid))
+    rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+    rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n")
+    err = rw.Flush()
+    if err != nil {
+        conn.Close()
+        return err
+    }
+
+    swarm.Log("Virtual buzzer connecting from %v\n", conn.RemoteAddr())
+    HandleVirtualNode(&virtualConn{Conn: conn, reader: rw.Reader}, swarm)
+    return nil
+}
+
+
+// Internals.
+
+// Fixed GUID used in the WebSocket handshake.
+const WebSocketGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Largest WebSocket message we accept from a virtual buzzer.
+const VirtualMaxMessage = 125
+
+// WebSocket opcodes.
+const (
+    wsOpContinuation = 0x0
+    wsOpText = 0x1
+    wsOpBinary = 0x2
+    wsOpClose = 0x8
+    wsOpPing = 0x9
+    wsOpPong = 0xA
+)
+
+// Connection to a virtual buzzer, carrying buzzer messages over a WebSocket.
+// Everything but reading and writing is as for the underlying connection.
+type virtualConn struct {
+    net.Conn
+    reader *bufio.Reader  // Reads from the underlying connection, may already hold data.
+    pending []byte  // Data received but not yet read.
+    writeLock sync.Mutex  // Pongs are written by the reader, everything else by our buzzer's sender.
+}
+
+
+// Read buzzer message bytes from the connection, as net.Conn.
+func (this *virtualConn) Read(b []byte) (int, error) {
+    for len(this.pending) == 0 {
+        err := this.readMessage()
+        if err != nil { return 0, err }
+    }
+
+    n := copy(b, this.pending)
+    this.pending = this.pending[n:]
+    return n, nil
+}
+
+
+// Write buzzer message bytes to the connection, as a single binary WebSocket message, as net.Conn.
+func (this *virtualConn) Write(b []byte) (int, error) {
+    err := this.writeFrame(wsOpBinary, b)
+    if err != nil { return 0, err }
+
+    return len(b), nil
+}
+
+
+// Read the next WebSocket frame, adding any data in it to our pending data and handling control frames.
+func (this *virtualConn) readMessage() error {
+    var header [2]byte
+    _, err := io.ReadFull(this.reader, header[:])
+    if err != nil { return err }
+
+    opcode := header[0] & 0x0F
+    masked := (header[1] & 0x80) != 0
+    length := uint64(header[1] & 0x7F)
+
+    switch length {
+    case 126:
+        var ext [2]byte
+        _, err = io.ReadFull(this.reader, ext[:])
+        length = uint64(binary.BigEndian.Uint16(ext[:]))
+
+    case 127:
+        var ext [8]byte
+        _, err = io.ReadFull(this.reader, ext[:])
+        length = binary.BigEndian.Uint64(ext[:])
+    }
+
+    if err != nil { return err }
+    if !masked { return errors.New("unmasked WebSocket message") }
+    if length > VirtualMaxMessage { return errors.New("WebSocket message too long") }
+
+    var mask [4]byte
+    _, err = io.ReadFull(this.reader, mask[:])
+    if err != nil { return err }
+
+    payload := make([]byte, length)
+    _, err = io.ReadFull(this.reader, payload)
+    if err != nil { return err }
+
+    for i := range payload { payload[i] ^= mask[i % 4] }
+
+    switch opcode {
+    case wsOpContinuation, wsOpText, wsOpBinary:
+        this.pending = append(this.pending, payload...)
+
+    case wsOpPing:
+        return this.writeFrame(wsOpPong, payload)
+
+    case wsOpClose:
+        this.writeFrame(wsOpClose, nil)
+        return io.EOF
+    }
+
+    // Anything else, such as a pong, is ignored.
+    return nil
+}
+
+
+// Write a single, final, WebSocket frame of the given opcode and payload.
+// May be called from any thread.
+func (this *virtualConn) writeFrame(opcode byte, payload []byte) error {
+    frame := []byte{0x80 | opcode}
+
+    if len(payload) < 126 {
+        frame = append(frame, byte(len(payload)))
+    } else {
+        frame = append(frame, 126, byte(len(payload) >> 8), byte(len(payload)))
+    }
+
+    frame = append(frame, payload...)
+
+    this.writeLock.Lock()
+    defer this.writeLock.Unlock()
+
+    _, err := this.Conn.Write(frame)
+    return err
+}
@@ -0,0 +1,1092 @@
+/* Functions to serve web pages.
+
+The web server provides pages for anyone who needs to see what's going on without using the console, such as an admin
+looking after the buzzers.
+
+Pages:
+  /admin    Status of every buzzer, with buttons to act on each one.
+  /api/     Commands and a stream of quiz events, for other programs, see api.go.
+  /buzzer   Virtual buzzer, for players without a physical one, if enabled. Connects back to /buzzer/ws, see virtual.go.
+  /display  Audience display, showing the scores, or who buzzed, branded by the theme.
+  /heatmap  Buzzing by table, if a venue layout is given, see venue.go.
+  /host     Question to read out, with its answer and the running timers, if running a quiz script, see quiz_script.go.
+  /judge    For a second judge to confirm or reject judgements.
+  /media/   Pictures, audio and video shown by quiz script questions, see quiz_script.go.
+  /metrics  Server and buzzer metrics, for Prometheus, see metrics.go.
+  /scoreboard
+            Live scoreboard for spectators, pushed to the page as Server-Sent Events from /scoreboard/events.
+  /snapshot Current game state with recent events, as JSON, for clients that have just connected.
+  /standings
+            Final standings, with ties broken, as JSON.
+  /state    Current game state, as JSON.
+  /theme/   Images used by the theme.
+
+With several rooms, see rooms.go, each room has its own web server, on its own port.
+
+The web server is open to anyone on the venue network, so pages that can change the quiz are protected by a password,
+which the browser asks for, with any user name, using HTTP basic authentication. The admin page, and the actions it
+posts, need the admin password. The second judge page, and its actions, need the judge password, which is checked on
+every action, not just when the page is loaded. The host page shows the answers, so needs the host password. A password
+that isn't configured is generated at startup and shown on the console, though the judge password only if there's a
+second judge, and the host password only if there's a quiz script.
+
+Web handlers run in their own Go routines, so may only use thread safe APIs.
+
+*/
+
+package main
+
+import "crypto/rand"
+import "crypto/subtle"
+import "encoding/hex"
+import "encoding/json"
+import "fmt"
+import "html/template"
+import "net/http"
+import "strconv"
+import "strings"
+import "time"
+
+
+// Create a web server and start serving pages on the given port.
+// The display is branded with the given theme. Protected pages need the given passwords.
+func CreateWebServer(engine *Engine, swarm *Swarm, scoreboard *Scoreboard, judge *Judge, spectators *Spectators,
+    theme *Theme, passwords WebPasswords, port int) *WebServer {
+    var p WebServer
+    p.address = fmt.Sprintf(":%d", port)
+    p.engine = engine
+    p.swarm = swarm
+    p.scoreboard = scoreboard
+    p.judge = judge
+    p.spectators = spectators
+    p.theme = theme
+    p.passwords = passwords
+    p.apiFeed = CreateApiFeed(engine)
+    p.mux = http.NewServeMux()
+
+    p.mux.HandleFunc("/admin", requirePassword(passwords.Admin, "QuizTronic admin", p.admin))
+    p.mux.HandleFunc("/admin/action", requirePassword(passwords.Admin, "QuizTronic admin", p.adminAction))
+    p.mux.HandleFunc("/api/command", requirePassword(passwords.Admin, "QuizTronic admin", p.apiCommand))
+    p.mux.HandleFunc("/api/events", requirePassword(passwords.Admin, "QuizTronic admin", p.apiEvents))
+    p.mux.HandleFunc("/display", p.display)
+    p.mux.HandleFunc("/judge", requirePassword(passwords.Judge, "QuizTronic judge", p.judgePage))
+    p.mux.HandleFunc("/judge/action", requirePassword(passwords.Judge, "QuizTronic judge", p.judgeAction))
+    p.mux.HandleFunc("/metrics", p.metrics)
+    p.mux.HandleFunc("/scoreboard", p.scoreboardPage)
+    p.mux.HandleFunc("/scoreboard/events", p.scoreboardEvents)
+    p.mux.HandleFunc("/snapshot", p.snapshot)
+    p.mux.HandleFunc("/standings", p.standings)
+    p.mux.HandleFunc("/state", p.state)
+
+    if theme.Dir != "" {
+        p.mux.Handle("/theme/", http.StripPrefix("/theme/", http.FileServer(http.Dir(theme.Dir))))
+    }
+
+    go p.serve()
+    return &p
+}
+
+
+// Let players buzz from their phones, with the virtual buzzer page.
+// May be called from any thread.
+func (this *WebServer) EnableVirtualBuzzers() {
+    this.mux.HandleFunc("/buzzer", this.buzzerPage)
+    this.mux.HandleFunc("/buzzer/ws", this.buzzerSocket)
+    fmt.Printf("Virtual buzzers at /buzzer\n")
+}
+
+
+// Show the given heatmap of buzzing by table, with the heatmap page.
+// May be called from any thread.
+func (this *WebServer) SetHeatmap(heatmap *Heatmap) {
+    this.heatmap = heatmap
+    this.mux.HandleFunc("/heatmap", this.heatmapPage)
+}
+
+
+// Show the questions asked by the given quiz script, with the host page, and serve their media.
+// May be called from any thread.
+func (this *WebServer) SetQuizScript(script *QuizScript) {
+    this.script = script
+    this.mux.HandleFunc("/host", requirePassword(this.passwords.Host, "QuizTronic host", this.hostPage))
+    this.mux.Handle("/media/", http.StripPrefix("/media/", http.FileServer(http.Dir(script.MediaDir()))))
+}
+
+
+// Generate a random password, for a protected page whose password isn't configured.
+func RandomPassword() string {
+    data := make([]byte, RandomPasswordLength)
+    _, err := rand.Read(data)
+    if err != nil { panic(err) }  // Only fails if the system can't give us randomness, so nothing else is safe.
+
+    return hex.EncodeToString(data)
+}
+
+
+// Passwords for protected pages. A blank password refuses everyone.
+type WebPasswords struct {
+    Admin string  // For the admin page.
+    Judge string  // For the second judge page.
+    Host string  // For the host page.
+}
+
+
+// Web server.
+type WebServer struct {
+    engine *Engine
+    swarm *Swarm
+    scoreboard *Scoreboard
+    judge *Judge
+    spectators *Spectators
+    apiFeed *ApiFeed
+    theme *Theme
+    heatmap *Heatmap  // Nil for none.
+    script *QuizScript  // Nil for none.
+    passwords WebPasswords
+    mux *http.ServeMux
+    address string  // To serve on, eg ":8080".
+}
+
+
+// Internals.
+
+const DefaultWebPort = 8080
+
+// How often to send something to idle scoreboard pages, so proxies don't drop them.
+const ScoreboardKeepAliveTime = 15 * time.Second
+
+// Bytes of randomness in generated passwords, each shown as 2 hex digits.
+const RandomPasswordLength = 6
+
+
+// Serve pages.
+// Only returns on error. Should be called as a Go routine.
+func (this *WebServer) serve() {
+    fmt.Printf("Serving web pages on %s\n", this.address)
+
+    err := http.ListenAndServe(this.address, this.mux)
+    fmt.Printf("Error serving web pages: %v\n", err)
+    fmt.Printf("Web pages unavailable, something else may be using port %s\n", this.address)
+}
+
+
+// Wrap the given handler so it's only served to users giving the given password, asking for it in the given realm.
+// Any user name is accepted.
+func requirePassword(password string, realm string, handler http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        _, given, ok := r.BasicAuth()
+        if !ok || (password == "") || (subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1) {
+            w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+            http.Error(w, "Password required", http.StatusUnauthorized)
+            return
+        }
+
+        handler(w, r)
+    }
+}
+
+
+// Handler for admin page.
+func (this *WebServer) admin(w http.ResponseWriter, r *http.Request) {
+    var rows []adminRow
+
+    for _, stats := range this.swarm.Stats() {
+        var row adminRow
+        row.Id = stats.Id
+        row.Name = BuzzerIdToString(stats.Id)
+        row.Connected = stats.Connected
+        row.Muted = stats.Muted
+        row.Quarantined = stats.Quarantined
+        row.Unstable = stats.Unstable
+        row.Labels = strings.Join(stats.Labels, ", ")
+        if stats.StandingInFor >= 0 { row.StandingInFor = BuzzerIdToString(stats.StandingInFor) }
+        row.LastHeard = stats.LastHeard.Round(time.Millisecond).String()
+        row.Slow = fmt.Sprintf("%d / %d (%d / %d)", stats.Slow2sSession, stats.Slow3sSession,
+            stats.Slow2sTotal, stats.Slow3sTotal)
+        row.Failures = fmt.Sprintf("%d / %d", stats.DisconnectsTotal, stats.ErrorsTotal)
+        row.Presses = fmt.Sprintf("%d (%d)", stats.PressesRun, stats.PressesTotal)
+        row.NoPresses = stats.NoPresses
+        row.Battery = "-"
+        if stats.Battery >= 0 { row.Battery = fmt.Sprintf("%d%%", stats.Battery) }
+        row.LowBattery = (stats.Battery >= 0) && (stats.Battery <= LowBatteryPercent)
+        row.Signal = "-"
+        if stats.Rssi != 0 { row.Signal = fmt.Sprintf("%ddBm", stats.Rssi) }
+        row.RoundTrip = "-"
+        if stats.RecentRtt > 0 {
+            row.RoundTrip = fmt.Sprintf("%.1fms (%d)", float64(stats.RecentRtt) / float64(time.Millisecond),
+                stats.RttsLost)
+        }
+        if stats.InInventory {
+            row.Serial = stats.Inventory.Serial
+            row.Purchased = stats.Inventory.Purchased.Format("2006-01-02")
+            row.Notes = stats.Inventory.Notes
+        }
+        rows = append(rows, row)
+    }
+
+    // Show where the quiz is up to as well, so an admin reconnecting mid-quiz is up to date straight away.
+    var page adminPage
+    page.Rows = rows
+    page.Snapshot = this.engine.Snapshot()
+    page.Mode = page.Snapshot.State.Mode
+    if page.Mode == "" { page.Mode = "none" }
+
+    for team, score := range page.Snapshot.State.Scores {
+        page.Scores += fmt.Sprintf(" %s:%v", TeamIdToString(team), score)
+    }
+
+    if page.Snapshot.State.Scores == nil { page.Scores = " " + page.Snapshot.State.ScoresShown }
+
+    err := _adminTemplate.Execute(w, page)
+    if err != nil {
+        fmt.Printf("Error rendering admin page: %v\n", err)
+    }
+}
+
+
+// Handler for admin page actions.
+func (this *WebServer) adminAction(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "POST required", http.StatusMethodNotAllowed)
+        return
+    }
+
+    id, err := strconv.Atoi(r.FormValue("id"))
+    if err != nil {
+        http.Error(w, "Bad buzzer ID", http.StatusBadRequest)
+        return
+    }
+
+    switch r.FormValue("action") {
+    case "mute":        this.swarm.Mute(id, true)
+    case "unmute":      this.swarm.Mute(id, false)
+    case "quarantine":  this.swarm.Quarantine(id, true)
+    case "release":     this.swarm.Quarantine(id, false)
+    case "identify":    this.swarm.Identify(id)
+    case "test":        this.swarm.Test(id)
+    case "disconnect":  this.swarm.Disconnect(id)
+    case "ban":         this.swarm.Ban(id)
+
+    default:
+        http.Error(w, "Bad action", http.StatusBadRequest)
+        return
+    }
+
+    http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+
+// Handler for audience display page.
+func (this *WebServer) display(w http.ResponseWriter, r *http.Request) {
+    err := _displayTemplate.Execute(w, this.theme)
+    if err != nil {
+        fmt.Printf("Error rendering display page: %v\n", err)
+    }
+}
+
+
+// Handler for heatmap page.
+// Tables are laid out as they are in the venue, shaded by win rate.
+func (this *WebServer) heatmapPage(w http.ResponseWriter, r *http.Request) {
+    var tables []HeatmapTable
+    this.engine.CallAndWait(func() { tables = this.heatmap.Tables() })
+
+    var page heatmapPage
+    for i := range tables {
+        table := &tables[i]
+        for len(page.Rows) < table.Row { page.Rows = append(page.Rows, heatmapRow{}) }
+
+        row := &page.Rows[table.Row - 1]
+        for len(row.Cells) < table.Column { row.Cells = append(row.Cells, heatmapCell{}) }
+
+        // Hotter, redder tables win more of the questions they press in.
+        row.Cells[table.Column - 1] = heatmapCell{Table: table,
+            Colour: template.CSS(fmt.Sprintf("hsl(0, 90%%, %d%%)", 95 - (table.WinRate() * 45) / 100))}
+    }
+
+    for i := range page.Rows {
+        page.Rows[i].Total = rowTotal(tables, i + 1)
+    }
+
+    err := _heatmapTemplate.Execute(w, page)
+    if err != nil {
+        fmt.Printf("Error rendering heatmap page: %v\n", err)
+    }
+}
+
+
+// Handler for host page.
+func (this *WebServer) hostPage(w http.ResponseWriter, r *http.Request) {
+    var page hostPage
+    this.engine.CallAndWait(func() {
+        page.Prompt = this.script.HostPrompt()
+        page.State = this.engine.State()
+    })
+
+    err := _hostTemplate.Execute(w, page)
+    if err != nil {
+        fmt.Printf("Error rendering host page: %v\n", err)
+    }
+}
+
+
+// Handler for virtual buzzer page.
+// Without a buzzer ID, or with a bad one, the page asks for one.
+func (this *WebServer) buzzerPage(w http.ResponseWriter, r *http.Request) {
+    page := buzzerPage{Theme: this.theme, Id: -1, Version: BuzzerExpectedVersion, Colour: "white"}
+
+    if s := r.FormValue("id"); s != "" {
+        id, err := parseBuzzerId(strings.ToUpper(s))
+        if err != nil {
+            page.Error = err.Error()
+        } else {
+            page.Id = id
+            page.Name = BuzzerIdToString(id)
+            team, _ := BuzzerIdToTeam(id)
+            if team < len(this.theme.Teams) { page.Colour = this.theme.Teams[team].Colour }
+        }
+    }
+
+    err := _buzzerTemplate.Execute(w, page)
+    if err != nil {
+        fmt.Printf("Error rendering buzzer page: %v\n", err)
+    }
+}
+
+
+// Handler for virtual buzzer connections.
+func (this *WebServer) buzzerSocket(w http.ResponseWriter, r *http.Request) {
+    err := AcceptVirtualBuzzer(w, r, this.swarm)
+    if err != nil { http.Error(w, err.Error(), http.StatusBadRequest) }
+}
+
+
+// Handler for second judge page.
+func (this *WebServer) judgePage(w http.ResponseWriter, r *http.Request) {
+    err := _judgeTemplate.Execute(w, this.judge.PendingDesc())
+    if err != nil {
+        fmt.Printf("Error rendering judge page: %v\n", err)
+    }
+}
+
+
+// Handler for second judge actions.
+func (this *WebServer) judgeAction(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "POST required", http.StatusMethodNotAllowed)
+        return
+    }
+
+    switch r.FormValue("action") {
+    case "confirm":     this.judge.Resolve(true)
+    case "reject":      this.judge.Resolve(false)
+
+    default:
+        http.Error(w, "Bad action", http.StatusBadRequest)
+        return
+    }
+
+    http.Redirect(w, r, "/judge", http.StatusSeeOther)
+}
+
+
+// Handler for spectator scoreboard page.
+func (this *WebServer) scoreboardPage(w http.ResponseWriter, r *http.Request) {
+    err := _scoreboardTemplate.Execute(w, this.theme)
+    if err != nil {
+        fmt.Printf("Error rendering scoreboard page: %v\n", err)
+    }
+}
+
+
+// Handler for the stream of scoreboard updates. Only returns when the page goes away.
+func (this *WebServer) scoreboardEvents(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+
+    follower := this.spectators.Follow()
+    defer this.spectators.Unfollow(follower)
+
+    keepAlive := time.NewTicker(ScoreboardKeepAliveTime)
+    defer keepAlive.Stop()
+
+    for {
+        select {
+        case data := <-follower:
+            fmt.Fprintf(w, "data: %s\n\n", data)
+
+        case <-keepAlive.C:
+            fmt.Fprintf(w, ": keep alive\n\n")
+
+        case <-r.Context().Done():
+            return
+        }
+
+        flusher.Flush()
+    }
+}
+
+
+// Handler for API commands.
+func (this *WebServer) apiCommand(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "POST required", http.StatusMethodNotAllowed)
+        return
+    }
+
+    cmdLine := strings.TrimSpace(r.FormValue("cmd"))
+    if cmdLine == "" {
+        http.Error(w, "No command", http.StatusBadRequest)
+        return
+    }
+
+    // The engine publishes a command event for each command it accepts.
+    ran := false
+    this.engine.CallAndWait(func() {
+        before := len(this.engine.History())
+        this.engine.RunCommand(cmdLine)
+
+        for _, event := range this.engine.History()[before:] {
+            if (event.Type == EventCommand) && (event.Note == cmdLine) { ran = true }
+        }
+    })
+
+    if !ran {
+        http.Error(w, "Command refused", http.StatusBadRequest)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+
+// Handler for the stream of API events. Only returns when the client goes away or falls behind.
+func (this *WebServer) apiEvents(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+
+    follower := this.apiFeed.Follow()
+    defer this.apiFeed.Unfollow(follower)
+
+    keepAlive := time.NewTicker(ScoreboardKeepAliveTime)
+    defer keepAlive.Stop()
+
+    // Let the client know it's following before the first event.
+    fmt.Fprintf(w, ": following\n\n")
+    flusher.Flush()
+
+    for {
+        select {
+        case data, ok := <-follower:
+            if !ok { return }
+            fmt.Fprintf(w, "data: %s\n\n", data)
+
+        case <-keepAlive.C:
+            fmt.Fprintf(w, ": keep alive\n\n")
+
+        case <-r.Context().Done():
+            return
+        }
+
+        flusher.Flush()
+    }
+}
+
+
+// Handler for game state.
+func (this *WebServer) state(w http.ResponseWriter, r *http.Request) {
+    data, err := this.engine.StateJSON()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(data)
+}
+
+
+// Handler for snapshot requests.
+func (this *WebServer) snapshot(w http.ResponseWriter, r *http.Request) {
+    data, err := json.MarshalIndent(this.engine.Snapshot(), "", "  ")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(data)
+}
+
+
+// Handler for metrics, in the Prometheus text format.
+func (this *WebServer) metrics(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    WriteMetrics(w, this.engine, this.swarm)
+}
+
+
+// Handler for final standings, as JSON.
+func (this *WebServer) standings(w http.ResponseWriter, r *http.Request) {
+    var standings []Standing
+    this.engine.CallAndWait(func() { standings = this.scoreboard.Standings() })
+
+    data, err := json.MarshalIndent(standings, "", "  ")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(data)
+}
+
+
+// Info for the admin page.
+type adminPage struct {
+    Rows []adminRow
+    Snapshot Snapshot
+    Mode string
+    Scores string
+}
+
+
+// Info for the heatmap page.
+type heatmapPage struct {
+    Rows []heatmapRow  // Nearest the stage first.
+}
+
+
+// Info for one row of tables on the heatmap page.
+type heatmapRow struct {
+    Cells []heatmapCell
+    Total HeatmapTable
+}
+
+
+// Info for one table on the heatmap page.
+type heatmapCell struct {
+    Table *HeatmapTable  // Nil for no table there.
+    Colour template.CSS
+}
+
+
+// Info for the host page.
+type hostPage struct {
+    Prompt HostPrompt
+    State GameState
+}
+
+
+// Info for the virtual buzzer page.
+type buzzerPage struct {
+    Theme *Theme
+    Id int  // <0 for none chosen yet.
+    Name string
+    Colour string  // CSS colour to light up in.
+    Version byte  // Firmware version to claim.
+    Error string  // Problem with the chosen ID, blank for none.
+}
+
+
+// Info for one row of the admin page.
+type adminRow struct {
+    Id int
+    Name string
+    Connected bool
+    Muted bool
+    Quarantined bool
+    Unstable bool
+    Labels string
+    StandingInFor string
+    LastHeard string
+    Slow string
+    Failures string
+    Presses string
+    NoPresses bool  // Never pressed, though the rest of the fleet has been.
+    Battery string  // "-" if never reported.
+    LowBattery bool
+    Signal string  // "-" if never reported.
+    RoundTrip string  // Recent median and pings lost, "-" if not measured.
+    Serial string  // Blank if not in inventory.
+    Purchased string
+    Notes string
+}
+
+
+var _adminTemplate = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>QuizTronic buzzers</title>
+<meta http-equiv="refresh" content="2">
+<style>
+body { font-family: sans-serif; }
+td, th { padding: 2px 8px; }
+.missing { color: red; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<h1>Quiz</h1>
+<p>Mode: {{.Mode}}{{if .Snapshot.State.QuestionOpen}}, question {{.Snapshot.State.Question}} open{{end}}{{if .Snapshot.State.InRound}}, round {{.Snapshot.State.Round}}{{end}}</p>
+<p>Scores:{{.Scores}}</p>
+<pre>{{range .Snapshot.RecentEvents}}{{.}}
+{{end}}</pre>
+<h1>Buzzers</h1>
+<table>
+<tr><th>Buzzer</th><th>Labels</th><th>Status</th><th>Last heard</th><th>Slow &gt;2s / &gt;3s (total)</th><th>Disconnects / errors (total)</th><th>Presses (total)</th><th>Battery</th><th>Signal</th><th>Round trip (lost)</th><th>Serial</th><th>Purchased</th><th>Notes</th><th></th></tr>
+{{range .Rows}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.Labels}}</td>
+<td>{{if .Connected}}OK{{else}}<span class="missing">Missing</span>{{end}}{{if .Muted}}, muted{{end}}{{if .Quarantined}}, quarantined{{end}}{{if .Unstable}}, unstable{{end}}{{if .StandingInFor}}, for {{.StandingInFor}}{{end}}</td>
+<td>{{.LastHeard}}</td>
+<td>{{.Slow}}</td>
+<td>{{.Failures}}</td>
+<td>{{if .NoPresses}}<span class="missing">{{.Presses}} never pressed</span>{{else}}{{.Presses}}{{end}}</td>
+<td>{{if .LowBattery}}<span class="missing">{{.Battery}}</span>{{else}}{{.Battery}}{{end}}</td>
+<td>{{.Signal}}</td>
+<td>{{.RoundTrip}}</td>
+<td>{{.Serial}}</td>
+<td>{{.Purchased}}</td>
+<td>{{.Notes}}</td>
+<td>
+{{$id := .Id}}
+{{if .Muted}}
+<form method="post" action="/admin/action"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="unmute">Unmute</button></form>
+{{else}}
+<form method="post" action="/admin/action"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="mute">Mute</button></form>
+{{end}}
+{{if .Quarantined}}
+<form method="post" action="/admin/action"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="release">Release</button></form>
+{{else}}
+<form method="post" action="/admin/action"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="quarantine">Quarantine</button></form>
+{{end}}
+<form method="post" action="/admin/action"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="identify">Identify</button></form>
+<form method="post" action="/admin/action"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="test">Test</button></form>
+<form method="post" action="/admin/action"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="disconnect">Disconnect</button></form>
+<form method="post" action="/admin/action" onsubmit="return confirm('Ban this buzzer for the rest of the session?')"><input type="hidden" name="id" value="{{$id}}"><button name="action" value="ban">Ban</button></form>
+</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+
+var _judgeTemplate = template.Must(template.New("judge").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>QuizTronic judge</title>
+<meta http-equiv="refresh" content="1">
+<style>
+body { font-family: sans-serif; }
+button { font-size: 200%; margin: 8px; }
+</style>
+</head>
+<body>
+<h1>Second judge</h1>
+{{if .}}
+<p>Awaiting confirmation: <b>{{.}}</b></p>
+<form method="post" action="/judge/action">
+<button name="action" value="confirm">Confirm</button>
+<button name="action" value="reject">Reject</button>
+</form>
+{{else}}
+<p>Nothing to confirm</p>
+{{end}}
+</body>
+</html>
+`))
+
+
+var _hostTemplate = template.Must(template.New("host").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>QuizTronic host</title>
+<meta http-equiv="refresh" content="1">
+<style>
+body { font-family: sans-serif; font-size: 150%; }
+.answer { color: darkgreen; font-weight: bold; }
+.timers { color: darkred; }
+</style>
+</head>
+<body>
+{{with .Prompt}}
+{{if .Label}}
+<h1>{{.Label}}</h1>
+<p>{{.Text}}</p>
+{{if .Options}}
+<ol type="A">
+{{range .Options}}<li>{{.}}</li>
+{{end}}
+</ol>
+{{end}}
+<p>Answer: <span class="answer">{{.Answer}}</span></p>
+{{if .Alternatives}}
+<p>Also accept: {{range $i, $alt := .Alternatives}}{{if $i}}, {{end}}{{$alt}}{{end}}</p>
+{{end}}
+{{with .Media}}<p>Showing {{.Kind}}: <a href="{{.Url}}">{{.Url}}</a></p>{{end}}
+{{if .Parts}}
+<ol>
+{{range .Parts}}<li>{{.Text}} ({{.Marks}} marks) <span class="answer">{{.Answer}}</span></li>
+{{end}}
+</ol>
+{{end}}
+{{else}}
+<p>No question asked yet</p>
+{{end}}
+{{end}}
+{{with .State}}
+<p>{{if .Mode}}{{.Mode}}{{if .QuestionOpen}}, question open{{end}}{{else}}Between questions{{end}}</p>
+{{if .AckedPlayer}}<p>{{.AckedPlayer}} answering{{if .Part}}, part {{.Part}}{{end}}</p>{{end}}
+{{if .Timers}}
+<ul class="timers">
+{{range .Timers}}<li>{{.}}</li>
+{{end}}
+</ul>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+
+var _heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>QuizTronic heatmap</title>
+<meta http-equiv="refresh" content="5">
+<style>
+body { font-family: sans-serif; }
+td { padding: 6px 10px; border: 1px solid #ccc; vertical-align: top; }
+.total { background: #eee; }
+.small { font-size: 80%; color: #555; }
+</style>
+</head>
+<body>
+<h1>Buzzing by table</h1>
+<p>Stage</p>
+<table>
+{{range .Rows}}
+<tr>
+{{range .Cells}}
+{{if .Table}}
+<td style="background: {{.Colour}}">
+<b>{{.Table.Title}}</b><br>
+Won {{.Table.Won}} of {{.Table.Pressed}} ({{.Table.WinRate}}%)<br>
+Correct {{.Table.Correct}}<br>
+<span class="small">{{range .Table.Buzzers}}{{.}} {{end}}</span>
+</td>
+{{else}}
+<td></td>
+{{end}}
+{{end}}
+<td class="total">
+<b>{{.Total.Title}}</b><br>
+Won {{.Total.Won}} of {{.Total.Pressed}} ({{.Total.WinRate}}%)<br>
+Correct {{.Total.Correct}}
+</td>
+</tr>
+{{end}}
+</table>
+<p>Won: questions a table buzzed first in, of those its players pressed in while the question was open.</p>
+</body>
+</html>
+`))
+
+
+// The virtual buzzer speaks the buzzer protocol over a WebSocket, reconnecting if it's lost.
+var _buzzerTemplate = template.Must(template.New("buzzer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Theme.Title}} buzzer</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, user-scalable=no">
+<style>
+body { font-family: {{.Theme.Font}}; background: {{.Theme.Background}}; color: white; margin: 0; text-align: center; }
+h1 { font-size: 200%; margin: 16px; }
+#button { width: 80vmin; height: 80vmin; margin: 16px auto; border-radius: 50%; background: #404040;
+  border: 8px solid {{.Colour}}; font-size: 300%; line-height: 80vmin; user-select: none; touch-action: none; }
+#lost { color: #ff8080; min-height: 1.5em; }
+.error { color: #ff8080; }
+input { font-size: 200%; width: 4em; text-align: center; }
+</style>
+</head>
+<body>
+<h1>{{.Theme.Title}}</h1>
+{{if lt .Id 0}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="get" action="/buzzer">
+<p>Buzzer <input name="id" autocomplete="off"> <input type="submit" value="Go"></p>
+</form>
+{{else}}
+<div id="button">{{.Name}}</div>
+<div id="lost">Connecting...</div>
+<script>
+var id = {{.Id}};
+var version = {{.Version}};
+var colour = {{.Colour}};
+var button = document.getElementById("button");
+var socket = null;
+var held = false;
+var longTimer = null;
+var timedPing = false;  // Whether the next byte is a timed ping's nonce.
+
+function send(b) {
+  if (socket && (socket.readyState == WebSocket.OPEN)) { socket.send(new Uint8Array([b])); }
+}
+
+function setMode(led, buzz) {
+  button.style.background = led ? colour : "#404040";
+  if (navigator.vibrate) { navigator.vibrate(buzz ? [300, 100, 300, 100, 300] : 0); }
+}
+
+function receive(b) {
+  if (timedPing) {
+    timedPing = false;
+    send(0x37);
+    send(b);
+  } else if ((b & 0xFC) == 0x20) {
+    setMode((b & 1) != 0, (b & 2) != 0);
+  } else if (b == 0x51) {
+    send(0x33);
+  } else if (b == 0x53) {
+    timedPing = true;
+  } else if (((b & 0xF8) != 0x40) && (b != 0x50) && (b != 0x52)) {
+    send(0x7F);
+  }
+}
+
+function connect() {
+  socket = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/buzzer/ws");
+  socket.binaryType = "arraybuffer";
+  socket.onopen = function() {
+    document.getElementById("lost").textContent = "";
+    timedPing = false;
+    send(version);
+    send(0x80 | id);
+  };
+  socket.onmessage = function(e) { new Uint8Array(e.data).forEach(receive); };
+  socket.onclose = function() {
+    document.getElementById("lost").textContent = "Reconnecting...";
+    setMode(false, false);
+    setTimeout(connect, 2000);
+  };
+}
+
+button.addEventListener("pointerdown", function(e) {
+  e.preventDefault();
+  held = true;
+  send(0x30);
+  longTimer = setTimeout(function() { send(0x34); }, 800);
+});
+
+function release() {
+  if (!held) { return; }
+  held = false;
+  clearTimeout(longTimer);
+  send(0x35);
+}
+
+button.addEventListener("pointerup", release);
+button.addEventListener("pointercancel", release);
+button.addEventListener("pointerleave", release);
+setInterval(function() { send(0x31); }, 1000);
+connect();
+</script>
+{{end}}
+</body>
+</html>
+`))
+
+
+// The display polls the game state, rather than refreshing, so it doesn't flicker.
+var _displayTemplate = template.Must(template.New("display").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Title}}</title>
+<style>
+body { font-family: {{.Font}}; background: {{.Background}}; color: white; margin: 0; text-align: center; }
+h1 { font-size: 400%; margin: 16px; }
+#status { font-size: 200%; min-height: 1.5em; }
+#announcement { font-size: 300%; font-weight: bold; min-height: 1.2em; }
+.teams { display: flex; justify-content: center; flex-wrap: wrap; }
+.team { margin: 16px; padding: 16px; min-width: 20%; border-radius: 16px; }
+.team img { max-height: 120px; }
+.name { font-size: 250%; }
+.score { font-size: 600%; font-weight: bold; }
+.banner { position: fixed; bottom: 0; width: 100%; padding: 8px; font-size: 200%; background: rgba(0, 0, 0, 0.5); }
+.banner img { max-height: 80px; vertical-align: middle; }
+#tally { display: none; font-size: 200%; margin: 16px auto; width: 60%; border: 2px solid white; }
+#tallyBar { background: white; height: 16px; width: 0; }
+.choice { font-size: 400%; font-weight: bold; min-height: 1.2em; }
+.wrong { opacity: 0.3; }
+#media img, #media video { max-width: 80%; max-height: 50vh; margin: 16px; }
+#buzz { display: none; position: fixed; top: 0; left: 0; width: 100%; height: 100%; padding-top: 15%; }
+#buzzPlayer { font-size: 1000%; font-weight: bold; }
+#buzzTime { font-size: 400%; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div id="status"></div>
+<div id="announcement"></div>
+<div id="tally"><div id="tallyText"></div><div id="tallyBar"></div></div>
+<div id="media"></div>
+<div class="teams">
+{{range $team, $theme := .Teams}}
+<div class="team" id="team{{$team}}" style="background: {{$theme.Colour}}">
+{{if $theme.Logo}}<img src="/theme/{{$theme.Logo}}" alt=""><br>{{end}}
+<span class="name">{{$theme.Name}}</span><br>
+<span class="score" id="score{{$team}}">0</span><br>
+<span class="choice" id="choice{{$team}}"></span>
+</div>
+{{end}}
+</div>
+{{if or .Banner .BannerImage}}
+<div class="banner">{{if .BannerImage}}<img src="/theme/{{.BannerImage}}" alt=""> {{end}}{{.Banner}}</div>
+{{end}}
+<div id="buzz"><div id="buzzPlayer"></div><div id="buzzTime"></div></div>
+<script>
+function update() {
+  fetch("/state").then(function(r) { return r.json(); }).then(function(state) {
+    // Hidden scores show only places, or nothing.
+    for (var team = 0; document.getElementById("score" + team); team++) {
+      var text = "?";
+      if (state.Scores) { text = state.Scores[team]; } else if (state.Places) { text = "Place " + state.Places[team]; }
+      document.getElementById("score" + team).textContent = text;
+    }
+
+    var status = "";
+    if (state.InRound) { status = "Round " + state.Round; }
+    if (state.QuestionOpen) { status += (status ? ", question " : "Question ") + state.Question; }
+    if (state.DoubleTeam) { status += (status ? ", " : "") + "team " + state.DoubleTeam + " playing double"; }
+    document.getElementById("status").textContent = status;
+
+    // Show each team's choice, once revealed, then which were right.
+    for (var team = 0; document.getElementById("choice" + team); team++) {
+      var e = document.getElementById("choice" + team);
+      var choice = state.Choices ? state.Choices[team] : "";
+      e.textContent = choice;
+      e.className = "choice" + ((state.CorrectChoice && (choice != state.CorrectChoice)) ? " wrong" : "");
+    }
+    if (state.CorrectChoice) { status += " Answer: " + state.CorrectChoice; }
+    if (state.Pot > 0) { status += (status ? ", pot " : "Pot ") + state.Pot + " marks"; }
+    document.getElementById("status").textContent = status;
+    document.getElementById("announcement").textContent = state.Announcement;
+
+    // Show how many teams have chosen, but not what.
+    var tally = document.getElementById("tally");
+    if (state.ChoicesExpected > 0) {
+      document.getElementById("tallyText").textContent =
+        state.ChoicesMade + " of " + state.ChoicesExpected + " teams have answered";
+      document.getElementById("tallyBar").style.width = (100 * state.ChoicesMade / state.ChoicesExpected) + "%";
+      tally.style.display = "block";
+    } else {
+      tally.style.display = "none";
+    }
+
+    // Show the question's picture, audio or video, only replacing it when it changes, so playback isn't restarted.
+    var media = document.getElementById("media");
+    var url = state.Media ? state.Media.Url : "";
+    if (media.dataset.url != url) {
+      media.dataset.url = url;
+      media.innerHTML = "";
+      if (state.Media) {
+        var e = document.createElement((state.Media.Kind == "image") ? "img" : state.Media.Kind);
+        e.src = url;
+        if (state.Media.Kind != "image") { e.controls = true; e.autoplay = true; }
+        media.appendChild(e);
+      }
+    }
+
+    // Someone buzzing takes over the whole screen, in their team's colour.
+    var buzz = document.getElementById("buzz");
+    if (state.Buzz) {
+      buzz.style.background = document.getElementById("team" + state.Buzz.Team).style.background;
+      document.getElementById("buzzPlayer").textContent = state.Buzz.Player;
+      document.getElementById("buzzTime").textContent = (state.Buzz.ReactionMs / 1000).toFixed(2) + "s";
+      buzz.style.display = "block";
+    } else {
+      buzz.style.display = "none";
+    }
+  }).catch(function() {});
+}
+
+update();
+setInterval(update, 500);
+</script>
+</body>
+</html>
+`))
+
+
+// The scoreboard is pushed to the page, which reorders the teams by place as it changes.
+var _scoreboardTemplate = template.Must(template.New("scoreboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Title}} scoreboard</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+body { font-family: {{.Font}}; background: {{.Background}}; color: white; margin: 0; text-align: center; }
+h1 { font-size: 300%; margin: 16px; }
+#status { font-size: 150%; min-height: 1.5em; }
+#announcement { font-size: 200%; font-weight: bold; min-height: 1.2em; }
+#lost { display: none; color: #ff8080; }
+table { margin: 16px auto; border-collapse: separate; border-spacing: 0 8px; font-size: 250%; }
+td { padding: 8px 24px; }
+td:first-child { border-radius: 16px 0 0 16px; }
+td:last-child { border-radius: 0 16px 16px 0; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div id="status"></div>
+<div id="announcement"></div>
+<div id="lost">Reconnecting...</div>
+<table id="teams"></table>
+<script>
+function show(board) {
+  var status = "";
+  if (board.Round > 0) { status = "Round " + board.Round + (board.InRound ? "" : " finished"); }
+  if (board.Question > 0) { status += (status ? ", question " : "Question ") + board.Question; }
+  document.getElementById("status").textContent = status;
+  document.getElementById("announcement").textContent = board.Announcement;
+
+  // While the scores are hidden, places are 0, so the teams stay in order.
+  var teams = board.Teams.slice().sort(function(a, b) { return a.Place - b.Place; });
+  var table = document.getElementById("teams");
+  table.textContent = "";
+  teams.forEach(function(team) {
+    var row = table.insertRow();
+    row.style.background = team.Colour;
+    row.insertCell().textContent = (board.ScoresShown == "hidden") ? "?" : (team.Tied ? "=" : "") + team.Place;
+    row.insertCell().textContent = team.Name;
+    row.insertCell().textContent = (board.ScoresShown == "shown") ? team.Score : "?";
+  });
+}
+
+// The browser reconnects by itself if the server goes away, and we're sent the latest scoreboard when it does.
+var source = new EventSource("/scoreboard/events");
+source.onmessage = function(e) {
+  document.getElementById("lost").style.display = "none";
+  show(JSON.parse(e.data));
+};
+source.onerror = function() { document.getElementById("lost").style.display = "block"; };
+</script>
+</body>
+</html>
+`))
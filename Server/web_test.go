@@ -0,0 +1,318 @@
+package main
+
+import "context"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "net/url"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+
+// Create a web server for the given harness, with the given passwords, on a port of the system's choosing.
+func createTestWebServer(harness *testHarness, passwords WebPasswords) *WebServer {
+    harness.createQuickFire()
+    spectators := CreateSpectators(harness.engine, harness.scoreboard, DefaultTheme())
+    return CreateWebServer(harness.engine, harness.swarm, harness.scoreboard, harness.judge, spectators,
+        DefaultTheme(), passwords, 0)
+}
+
+
+// Make the given request of the given web server, with the given password, blank for none.
+// The request is a POST of the given form, or a GET if the form is nil.
+func testRequest(web *WebServer, path string, password string, form url.Values) *httptest.ResponseRecorder {
+    request := httptest.NewRequest(http.MethodGet, path, nil)
+    if form != nil {
+        request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+        request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    }
+
+    if password != "" { request.SetBasicAuth("anyone", password) }
+
+    response := httptest.NewRecorder()
+    web.mux.ServeHTTP(response, request)
+    return response
+}
+
+
+// Make the given request, as testRequest does, for a page that needs the engine's main thread, running that meanwhile.
+func testMainRequest(harness *testHarness, web *WebServer, path string, password string,
+    form url.Values) *httptest.ResponseRecorder {
+    var response *httptest.ResponseRecorder
+    harness.runMainWhile(func() { response = testRequest(web, path, password, form) })
+    return response
+}
+
+
+// Create a quiz script from the given JSON, for the given harness's engine.
+func createTestScript(harness *testHarness, script string) *QuizScript {
+    filename := filepath.Join(harness.t.TempDir(), "script.json")
+    err := os.WriteFile(filename, []byte(script), 0644)
+    if err != nil { harness.t.Fatalf("Could not write script: %v", err) }
+
+    p, err := CreateQuizScript(harness.engine, harness.quickFire, filename)
+    if err != nil { harness.t.Fatalf("Could not create script: %v", err) }
+
+    return p
+}
+
+
+// Check admin actions can only be taken with the admin password.
+func TestAdminPassword(t *testing.T) {
+    harness := createTestHarness(t)
+    web := createTestWebServer(harness, WebPasswords{Admin: "secret"})
+    harness.connectId(0x13)
+    ban := url.Values{"id": {"19"}, "action": {"ban"}}
+
+    for _, password := range []string{"", "wrong", "secre"} {
+        for _, path := range []string{"/admin", "/admin/action"} {
+            response := testRequest(web, path, password, ban)
+            if response.Code != http.StatusUnauthorized {
+                t.Fatalf("%s with password %q gave %d", path, password, response.Code)
+            }
+        }
+    }
+
+    if !harness.connected(0x13) { t.Fatalf("Buzzer disconnected without the password") }
+
+    response := testRequest(web, "/admin/action", "secret", url.Values{"id": {"19"}, "action": {"mute"}})
+    if response.Code != http.StatusSeeOther { t.Fatalf("Admin action with password gave %d", response.Code) }
+
+    muted := false
+    for _, stats := range harness.swarm.Stats() {
+        if stats.Id == 0x13 { muted = stats.Muted }
+    }
+
+    if !muted { t.Fatalf("Admin action with password not taken") }
+
+    // With no password configured, no one gets in.
+    web = createTestWebServer(createTestHarness(t), WebPasswords{})
+    response = testRequest(web, "/admin/action", "", ban)
+    if response.Code != http.StatusUnauthorized { t.Fatalf("Admin action with no password set gave %d", response.Code) }
+}
+
+
+// Check second judge pages and actions can only be used with the judge password.
+func TestJudgePassword(t *testing.T) {
+    harness := createTestHarness(t)
+    web := createTestWebServer(harness, WebPasswords{Admin: "admin", Judge: "secret"})
+    harness.judge.SetConfirmation(time.Minute)
+
+    committed := false
+    harness.judge.Submit("B1 correct", func() { committed = true })
+    confirm := url.Values{"action": {"confirm"}}
+
+    for _, password := range []string{"", "wrong", "admin"} {
+        for _, path := range []string{"/judge", "/judge/action"} {
+            response := testRequest(web, path, password, confirm)
+            if response.Code != http.StatusUnauthorized {
+                t.Fatalf("%s with password %q gave %d", path, password, response.Code)
+            }
+        }
+    }
+
+    harness.wait(10 * time.Millisecond)
+    if committed { t.Fatalf("Judgement confirmed without the password") }
+
+    response := testRequest(web, "/judge/action", "secret", confirm)
+    if response.Code != http.StatusSeeOther { t.Fatalf("Judge action with password gave %d", response.Code) }
+
+    harness.wait(10 * time.Millisecond)
+    if !committed { t.Fatalf("Judgement not confirmed with the password") }
+}
+
+
+// Check the host page shows the question asked, with its answers, only with the host password.
+func TestHostPage(t *testing.T) {
+    harness := createTestHarness(t)
+    web := createTestWebServer(harness, WebPasswords{Admin: "admin", Host: "secret"})
+    script := createTestScript(harness, `{"questions": [{"type": "quickfire", "text": "Capital of France?",
+        "answer": "Paris", "alternatives": ["Paree", "Lutetia"], "marks": 2}]}`)
+    web.SetQuizScript(script)
+
+    for _, password := range []string{"", "admin"} {
+        response := testRequest(web, "/host", password, nil)
+        if response.Code != http.StatusUnauthorized {
+            t.Fatalf("Host page with password %q gave %d", password, response.Code)
+        }
+    }
+
+    response := testMainRequest(harness, web, "/host", "secret", nil)
+    if !strings.Contains(response.Body.String(), "No question asked yet") {
+        t.Fatalf("Host page before the first question:\n%s", response.Body.String())
+    }
+
+    script.Continue()
+    response = testMainRequest(harness, web, "/host", "secret", nil)
+    if response.Code != http.StatusOK { t.Fatalf("Host page with password gave %d", response.Code) }
+
+    for _, expected := range []string{"Q1", "Capital of France?", "Paris", "Paree, Lutetia", "quick fire"} {
+        if !strings.Contains(response.Body.String(), expected) {
+            t.Fatalf("Host page has no %q:\n%s", expected, response.Body.String())
+        }
+    }
+}
+
+
+// Check a question's media is shown only while the question is open, and served from the media directory.
+func TestQuestionMedia(t *testing.T) {
+    harness := createTestHarness(t)
+    web := createTestWebServer(harness, WebPasswords{})
+    script := createTestScript(harness, `{"questions": [
+        {"type": "quickfire", "text": "Whose tower?", "answer": "Eiffel", "marks": 1, "media": "tower.JPG"},
+        {"type": "quickfire", "text": "Name that tune", "answer": "Waterloo", "marks": 1,
+            "media": "https://example.com/waterloo.mp3"}]}`)
+    web.SetQuizScript(script)
+
+    err := os.MkdirAll(script.MediaDir(), 0755)
+    if err == nil { err = os.WriteFile(filepath.Join(script.MediaDir(), "tower.JPG"), []byte("picture"), 0644) }
+    if err != nil { t.Fatalf("Could not write media: %v", err) }
+
+    expected := []MediaState{{Url: "/media/tower.JPG", Kind: "image"},
+        {Url: "https://example.com/waterloo.mp3", Kind: "audio"}}
+
+    for _, media := range expected {
+        script.Continue()
+        if harness.engine.State().Media != nil { t.Fatalf("Media shown before question opened") }
+
+        harness.engine.processCommand("g")
+        shown := harness.engine.State().Media
+        if (shown == nil) || (*shown != media) { t.Fatalf("Showing %v, expected %v", shown, media) }
+
+        harness.engine.processCommand("q")
+        if harness.engine.State().Media != nil { t.Fatalf("Media still shown after question closed") }
+    }
+
+    response := testRequest(web, "/media/tower.JPG", "", nil)
+    if (response.Code != http.StatusOK) || (response.Body.String() != "picture") {
+        t.Fatalf("Media served as %d %q", response.Code, response.Body.String())
+    }
+
+    // Media of an unknown kind is refused when the script is read.
+    filename := filepath.Join(t.TempDir(), "script.json")
+    os.WriteFile(filename, []byte(`{"questions": [{"type": "quickfire", "marks": 1, "media": "notes.txt"}]}`), 0644)
+    _, err = CreateQuizScript(harness.engine, harness.quickFire, filename)
+    if err == nil { t.Fatalf("Script with unknown media kind accepted") }
+}
+
+
+// Check hidden scores aren't given away by the standings or the snapshot, until they're revealed.
+func TestHiddenScores(t *testing.T) {
+    harness := createTestHarness(t)
+    web := createTestWebServer(harness, WebPasswords{})
+    blue := harness.connectId(0x00)
+    harness.scoreboard.SetVisibility(ScoresPlacesOnly)
+
+    harness.startQuickFire("7")
+    harness.press(blue)
+    harness.engine.processCommand("y")
+    harness.checkScores(WholeMarks(7))
+
+    for _, path := range []string{"/standings", "/snapshot"} {
+        body := testMainRequest(harness, web, path, "", nil).Body.String()
+        if strings.Contains(body, "+7") || strings.Contains(body, ":7") || strings.Contains(body, "Score\": 7") {
+            t.Fatalf("%s gives hidden score away:\n%s", path, body)
+        }
+    }
+
+    snapshot := testMainRequest(harness, web, "/snapshot", "", nil).Body.String()
+    if !strings.Contains(snapshot, "Q1 score hidden") { t.Fatalf("Snapshot has no hidden score change:\n%s", snapshot) }
+
+    standings := testMainRequest(harness, web, "/standings", "", nil).Body.String()
+    if !strings.Contains(standings, `"Place": 1`) { t.Fatalf("Standings have no places:\n%s", standings) }
+
+    harness.scoreboard.SetVisibility(ScoresShown)
+    for _, path := range []string{"/standings", "/snapshot"} {
+        body := testMainRequest(harness, web, path, "", nil).Body.String()
+        if !strings.Contains(body, "+7") && !strings.Contains(body, "Score\": 7") {
+            t.Fatalf("%s has no score once revealed:\n%s", path, body)
+        }
+    }
+}
+
+
+// Check API commands need the admin password, and are run if the engine accepts them and refused if not.
+func TestApiCommand(t *testing.T) {
+    harness := createTestHarness(t)
+    web := createTestWebServer(harness, WebPasswords{Admin: "secret"})
+
+    if response := testRequest(web, "/api/command", "", url.Values{"cmd": {"f2"}}); response.Code != 401 {
+        t.Fatalf("Command without password gave %d", response.Code)
+    }
+
+    if response := testRequest(web, "/api/command", "secret", nil); response.Code != 405 {
+        t.Fatalf("Command by GET gave %d", response.Code)
+    }
+
+    response := testMainRequest(harness, web, "/api/command", "secret", url.Values{"cmd": {"f2"}})
+    if response.Code != 204 { t.Fatalf("Command gave %d: %s", response.Code, response.Body.String()) }
+    if mode := harness.engine.State().Mode; mode != "quick fire" { t.Fatalf("In %q after command", mode) }
+
+    // Another question can't start until this one's over.
+    for _, cmd := range []string{"", "f2", "f"} {
+        response = testMainRequest(harness, web, "/api/command", "secret", url.Values{"cmd": {cmd}})
+        if response.Code != 400 { t.Fatalf("Command %q gave %d", cmd, response.Code) }
+    }
+
+    if errors := harness.takeErrors(); len(errors) != 2 { t.Fatalf("Errors reported %v, expected 2", errors) }
+    harness.checkLog()
+}
+
+
+// Check API clients are streamed each event, and are dropped if they fall behind rather than holding up the quiz.
+func TestApiEvents(t *testing.T) {
+    harness := createTestHarness(t)
+    web := createTestWebServer(harness, WebPasswords{Admin: "secret"})
+
+    if response := testRequest(web, "/api/events", "wrong", nil); response.Code != 401 {
+        t.Fatalf("Events with wrong password gave %d", response.Code)
+    }
+
+    // Stream events until we've seen the command, which comes once the stream is followed.
+    request := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+    request.SetBasicAuth("anyone", "secret")
+    ctx, cancel := context.WithCancel(request.Context())
+    response := httptest.NewRecorder()
+    done := make(chan bool)
+    go func() {
+        web.mux.ServeHTTP(response, request.WithContext(ctx))
+        done <- true
+    }()
+
+    for start := time.Now(); ; harness.wait(time.Millisecond) {
+        web.apiFeed.lock.Lock()
+        following := len(web.apiFeed.followers)
+        web.apiFeed.lock.Unlock()
+
+        if following == 1 { break }
+        if time.Since(start) > time.Second { t.Fatalf("Events never followed") }
+    }
+
+    harness.engine.processCommand("f2")
+    harness.wait(10 * time.Millisecond)
+    cancel()
+    <-done
+
+    body := response.Body.String()
+    if !strings.Contains(body, `data: {"Type":"command","Desc":`) || !strings.Contains(body, `"Note":"f2"`) {
+        t.Fatalf("No command event streamed:\n%s", body)
+    }
+
+    // A follower that never reads is dropped once its buffer is full.
+    follower := web.apiFeed.Follow()
+    for i := 0; i <= ApiEventBuffer; i++ { harness.engine.Publish(Event{Type: EventAnnouncement, Note: "Hello"}) }
+
+    for range ApiEventBuffer {
+        var event ApiEvent
+        if err := json.Unmarshal(<-follower, &event); (err != nil) || (event.Type != "announce") {
+            t.Fatalf("Event %+v, %v, expected announcement", event, err)
+        }
+    }
+
+    if _, ok := <-follower; ok { t.Fatalf("Follower not dropped once behind") }
+    web.apiFeed.Unfollow(follower)
+    harness.checkErrors()
+}